@@ -1,17 +1,32 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/internal/collection"
 	"github.com/Zipklas/anime-site-backend/internal/comment"
 	"github.com/Zipklas/anime-site-backend/internal/kodik"
+	"github.com/Zipklas/anime-site-backend/internal/listexport"
+	"github.com/Zipklas/anime-site-backend/internal/listimport"
+	"github.com/Zipklas/anime-site-backend/internal/lockout"
+	"github.com/Zipklas/anime-site-backend/internal/moderation"
+	"github.com/Zipklas/anime-site-backend/internal/notification"
+	"github.com/Zipklas/anime-site-backend/internal/rating"
+	"github.com/Zipklas/anime-site-backend/internal/recommendation"
+	"github.com/Zipklas/anime-site-backend/internal/review"
+	"github.com/Zipklas/anime-site-backend/internal/schedule"
 	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/shikisync"
+	"github.com/Zipklas/anime-site-backend/internal/stats"
 	"github.com/Zipklas/anime-site-backend/internal/user"
 	"github.com/Zipklas/anime-site-backend/pkg/database"
+	"github.com/Zipklas/anime-site-backend/pkg/mailer"
+	"github.com/Zipklas/anime-site-backend/pkg/secret"
 	"github.com/joho/godotenv"
-	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -22,12 +37,72 @@ func main() {
 	db := database.InitPostgres()
 
 	shikimoriService := shikimori.NewService()
-	shikimoriHandler := shikimori.NewHandler(shikimoriService)
+	ratingService := rating.NewService(rating.NewRepository(db))
+	go ratingService.Run(context.Background())
+	shikimoriHandler := shikimori.NewHandler(shikimoriService, ratingService)
+	statsService := stats.NewService(stats.NewRepository(db), shikimoriService)
 	userRepo := user.NewRepository(db)
-	userService := user.NewService(userRepo, shikimoriService)
-	userHandler := user.NewHandler(userService)
+	tokenManager := auth.NewTokenManager(os.Getenv("JWT_SECRET"))
+	var loginAttempts lockout.Store
+	if os.Getenv("LOGIN_GUARD_STORE") == "postgres" {
+		loginAttempts = lockout.NewPostgresStore(db)
+	} else {
+		loginAttempts = lockout.NewMemoryStore()
+	}
+	loginGuard := lockout.NewGuard(loginAttempts, lockout.DefaultAccountPolicy, lockout.DefaultIPPolicy)
+	go loginGuard.Run(context.Background())
+	mail, err := mailer.NewFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure mailer: ", err)
+	}
+	tokenBox, err := secret.NewFromEnv(os.Getenv("JWT_SECRET"))
+	if err != nil {
+		log.Fatal("Failed to configure token encryption: ", err)
+	}
+	if err := user.EncryptShikimoriTokens(db, tokenBox); err != nil {
+		log.Fatal("Failed to encrypt Shikimori tokens: ", err)
+	}
+	if err := user.EncryptTOTPSecrets(db, tokenBox); err != nil {
+		log.Fatal("Failed to encrypt TOTP secrets: ", err)
+	}
+	userService := user.NewService(userRepo, shikimoriService, tokenManager, mail, loginGuard,
+		shikimori.NewOAuthClient(shikimori.OAuthConfigFromEnv()), tokenBox, ratingService, statsService)
+	authenticator := auth.NewAuthenticator(tokenManager, userService, userService)
+
+	if adminEmail := os.Getenv("ADMIN_EMAIL"); adminEmail != "" {
+		if err := userService.EnsureAdmin(adminEmail); err != nil {
+			log.Printf("Failed to bootstrap admin %s: %v", adminEmail, err)
+		}
+	}
+
+	importService := listimport.NewService(listimport.NewRepository(db), userService, shikimoriService)
+	importHandler := listimport.NewHandler(importService)
+	go importService.Run(context.Background())
+	exportHandler := listexport.NewHandler(listexport.NewService(userService, shikimoriService))
+	syncService := shikisync.NewService(shikisync.NewRepository(db), userService, shikimori.RatesClientFromEnv())
+	syncHandler := shikisync.NewHandler(syncService)
+	go syncService.Run(context.Background())
+
+	kodikService := kodik.NewService("None")
+	notificationService := notification.NewService(notification.NewRepository(db), shikimoriService, kodikService)
+	notificationHandler := notification.NewHandler(notificationService)
+	go notificationService.Run(context.Background())
+
+	recommendationService := recommendation.NewService(recommendation.NewRepository(db), userService, shikimoriService)
+	recommendationHandler := recommendation.NewHandler(recommendationService)
+	go recommendationService.Run(context.Background())
+
+	scheduleHandler := schedule.NewHandler(schedule.NewService(schedule.NewRepository(db), shikimoriService, userService))
+
+	userHandler := user.NewHandler(userService, syncService, notificationService)
+	statsHandler := stats.NewHandler(statsService)
 
 	e := echo.New()
+	ipExtractor, err := auth.IPExtractor(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal("Failed to configure trusted proxies: ", err)
+	}
+	e.IPExtractor = ipExtractor
 
 	e.GET("/kodik.txt", func(c echo.Context) error {
 		return c.File("kodik.txt")
@@ -38,59 +113,161 @@ func main() {
 	e.Static("/uploads", "uploads")
 	e.POST("/register", userHandler.Register)
 	e.POST("/login", userHandler.Login)
+	e.POST("/login/2fa", userHandler.LoginTwoFactor)
+	e.GET("/auth/shikimori", userHandler.ShikimoriLogin)
+	e.GET("/auth/shikimori/callback", userHandler.ShikimoriCallback)
+	e.POST("/token/refresh", userHandler.RefreshToken)
+	e.POST("/logout", userHandler.Logout)
+	e.GET("/verify-email", userHandler.VerifyEmail)
+	e.POST("/password/forgot", userHandler.ForgotPassword)
+	e.GET("/password/reset", userHandler.PasswordResetForm)
+	e.POST("/password/reset", userHandler.ResetPassword)
 	e.POST("/api/shikimori/search", shikimoriHandler.SearchAnime)
 	e.GET("/api/shikimori/top", shikimoriHandler.GetTopAnime)
 	e.GET("/api/shikimori/anime/:id", shikimoriHandler.GetAnimeByID)
 	e.GET("/api/shikimori/new", shikimoriHandler.GetNewReleases)
+	e.GET("/api/seasons", shikimoriHandler.GetSeasons)
+	e.GET("/api/seasons/next", shikimoriHandler.GetNextSeason)
+	e.GET("/api/seasons/:season_year", shikimoriHandler.GetSeason)
+	e.GET("/api/anime/:id/similar", recommendationHandler.GetSimilar)
+	e.GET("/api/schedule", scheduleHandler.GetSchedule, authenticator.Optional())
+	e.GET("/calendar/:file", scheduleHandler.Feed)
 
+	moderationClient := moderation.NewFromEnv()
 	commentRepo := comment.NewRepository(db)
-	commentService := comment.NewService(commentRepo)
+	commentService := comment.NewService(commentRepo, moderationClient)
 	commentHandler := comment.NewHandler(commentService)
 
 	commentGroup := e.Group("/api/comments")
-	commentGroup.Use(echojwt.WithConfig(echojwt.Config{
-		SigningKey: []byte(os.Getenv("JWT_SECRET")),
-	}))
+	commentGroup.GET("/:anime_id", commentHandler.GetComments, authenticator.Optional())
 
-	commentGroup.POST("/:anime_id", commentHandler.CreateComment)
-	commentGroup.GET("/:anime_id", commentHandler.GetComments)
-	commentGroup.DELETE("/:comment_id", commentHandler.DeleteComment)
-	commentGroup.PUT("/:comment_id", commentHandler.UpdateComment)
+	commentWrite := authenticator.Required(auth.ScopeCommentsWrite)
+	commentGroup.POST("/:anime_id", commentHandler.CreateComment, commentWrite, auth.RequireVerifiedEmail())
+	commentGroup.DELETE("/:comment_id", commentHandler.DeleteComment, commentWrite)
+	commentGroup.PUT("/:comment_id", commentHandler.UpdateComment, commentWrite, auth.RequireVerifiedEmail())
 
-	commentGroup.PUT("/:comment_id/vote", commentHandler.VoteComment)
-	commentGroup.DELETE("/:comment_id/vote", commentHandler.RemoveVote)
+	commentGroup.PUT("/:comment_id/vote", commentHandler.VoteComment, commentWrite)
+	commentGroup.DELETE("/:comment_id/vote", commentHandler.RemoveVote, commentWrite)
+
+	reviewHandler := review.NewHandler(review.NewService(review.NewRepository(db), moderationClient))
+
+	reviewGroup := e.Group("/api/reviews")
+	reviewGroup.GET("/anime/:anime_id", reviewHandler.List, authenticator.Optional())
+	reviewGroup.GET("/:id", reviewHandler.Get, authenticator.Optional())
+
+	reviewWrite := authenticator.Required()
+	reviewGroup.POST("/anime/:anime_id", reviewHandler.Create, reviewWrite, auth.RequireVerifiedEmail())
+	reviewGroup.PUT("/:id", reviewHandler.Update, reviewWrite, auth.RequireVerifiedEmail())
+	reviewGroup.DELETE("/:id", reviewHandler.Delete, reviewWrite)
+	reviewGroup.PUT("/:id/vote", reviewHandler.Vote, reviewWrite)
+	reviewGroup.DELETE("/:id/vote", reviewHandler.RemoveVote, reviewWrite)
 
-	kodikService := kodik.NewService("None")
 	kodikHandler := kodik.NewHandler(kodikService)
 
 	e.GET("/api/kodik/search", kodikHandler.SearchVideos)
 	e.GET("/api/kodik/videos/:shikimori_id", kodikHandler.GetVideoOptions)
 
 	playerGroup := e.Group("/player")
-	playerGroup.Use(echojwt.WithConfig(echojwt.Config{
-		SigningKey: []byte(os.Getenv("JWT_SECRET")),
-	}))
+	playerGroup.Use(authenticator.Required())
 	playerGroup.GET("/:video_id", func(c echo.Context) error {
 
 		return c.JSON(http.StatusOK, echo.Map{"status": "under construction"})
 	})
 
+	// Маршруты профиля, доступные и персональным API-токенам с нужными scopes
+	profileAPI := e.Group("/profile")
+	profileAPI.GET("", userHandler.Profile, authenticator.Required(auth.ScopeProfileRead))
+	profileAPI.POST("/watched/:anime_id", userHandler.AddWatched, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.POST("/favorite/:anime_id", userHandler.AddFavorite, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.DELETE("/watched", userHandler.RemoveWatched, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.DELETE("/watched/:anime_id", userHandler.RemoveWatched, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.DELETE("/favorite", userHandler.RemoveFavorite, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.DELETE("/favorite/:anime_id", userHandler.RemoveFavorite, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.PUT("/favorite/:anime_id/position", userHandler.MoveFavorite, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.GET("/list", userHandler.GetAnimeList, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.POST("/list", userHandler.CreateAnimeEntry, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.GET("/list/:anime_id", userHandler.GetAnimeEntry, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.PUT("/list/:anime_id", userHandler.SaveAnimeEntry, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.DELETE("/list/:anime_id", userHandler.DeleteAnimeEntry, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.GET("/watched", userHandler.GetWatchedAnime, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.GET("/favorite", userHandler.GetFavouriteAnime, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.POST("/progress/:anime_id", userHandler.RecordProgress, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.GET("/progress/:anime_id", userHandler.GetEpisodeProgress, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.GET("/continue-watching", userHandler.ContinueWatching, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.POST("/import", importHandler.StartImport, authenticator.Required(auth.ScopeListsWrite))
+	profileAPI.GET("/import", importHandler.ListJobs, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.GET("/import/:job_id", importHandler.GetJob, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.GET("/export", exportHandler.Export, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.GET("/stats", statsHandler.GetStats, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.GET("/recommendations", recommendationHandler.GetRecommendations, authenticator.Required(auth.ScopeListsRead))
+	profileAPI.GET("/recommendations/content", recommendationHandler.GetContentRecommendations, authenticator.Required(auth.ScopeListsRead))
+
+	// Остальные маршруты профиля - только для сессий
 	r := e.Group("/profile")
-	r.Use(echojwt.WithConfig(echojwt.Config{
-		SigningKey: []byte(os.Getenv("JWT_SECRET")),
-		ErrorHandler: func(c echo.Context, err error) error {
-			log.Printf("Error validating JWT token: %v", err)
-			return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
-		},
-	}))
-
-	r.GET("", userHandler.Profile)
-	r.POST("/watched/:anime_id", userHandler.AddWatched)
-	r.POST("/favorite/:anime_id", userHandler.AddFavorite)
-	r.GET("/watched", userHandler.GetWatchedAnime)
-	r.GET("/favorite", userHandler.GetFavouriteAnime)
+	r.Use(authenticator.Required())
+
 	r.POST("/nickname", userHandler.UpdateNickname)
 	r.POST("/avatar", userHandler.UploadAvatar)
+	r.POST("/verify-email/resend", userHandler.ResendVerification)
+	r.GET("/shikimori", userHandler.GetShikimoriAccount)
+	r.POST("/shikimori/link", userHandler.LinkShikimori)
+	r.DELETE("/shikimori/link", userHandler.UnlinkShikimori)
+	r.GET("/shikimori/sync", syncHandler.GetState)
+	r.PUT("/shikimori/sync", syncHandler.SetEnabled)
+	r.POST("/shikimori/sync/run", syncHandler.SyncNow)
+	r.POST("/2fa/enroll", userHandler.EnrollTwoFactor)
+	r.POST("/2fa/confirm", userHandler.ConfirmTwoFactor)
+	r.POST("/2fa/disable", userHandler.DisableTwoFactor)
+	r.POST("/2fa/recovery-codes", userHandler.RegenerateRecoveryCodes)
+	r.GET("/tokens", userHandler.ListAPITokens)
+	r.POST("/tokens", userHandler.CreateAPIToken)
+	r.DELETE("/tokens/:token_id", userHandler.RevokeAPIToken)
+
+	collectionHandler := collection.NewHandler(collection.NewService(collection.NewRepository(db), shikimoriService))
+	r.GET("/collections", collectionHandler.ListMine)
+	r.GET("/calendar", scheduleHandler.GetFeed)
+	r.POST("/calendar", scheduleHandler.RotateFeed)
+	r.DELETE("/calendar", scheduleHandler.DisableFeed)
+	r.GET("/notifications", notificationHandler.List)
+	r.PUT("/notifications/:id/read", notificationHandler.MarkRead)
+	r.POST("/notifications/read-all", notificationHandler.MarkAllRead)
+	r.GET("/notifications/mutes", notificationHandler.ListMutes)
+	r.PUT("/notifications/mutes/:anime_id", notificationHandler.Mute)
+	r.DELETE("/notifications/mutes/:anime_id", notificationHandler.Unmute)
+	r.GET("/reviews", reviewHandler.ListMine)
+
+	collectionGroup := e.Group("/api/collections")
+	collectionGroup.GET("", collectionHandler.ListPublic)
+	collectionGroup.GET("/shared/:token", collectionHandler.GetShared, authenticator.Optional())
+	collectionGroup.GET("/:id", collectionHandler.Get, authenticator.Optional())
+
+	collectionWrite := authenticator.Required()
+	collectionGroup.POST("", collectionHandler.Create, collectionWrite)
+	collectionGroup.PUT("/:id", collectionHandler.Update, collectionWrite)
+	collectionGroup.DELETE("/:id", collectionHandler.Delete, collectionWrite)
+	collectionGroup.POST("/:id/share-token", collectionHandler.RotateShareToken, collectionWrite)
+	collectionGroup.POST("/:id/items", collectionHandler.AddItem, collectionWrite)
+	collectionGroup.PUT("/:id/items/:anime_id", collectionHandler.UpdateItem, collectionWrite)
+	collectionGroup.DELETE("/:id/items/:anime_id", collectionHandler.RemoveItem, collectionWrite)
+	collectionGroup.PUT("/:id/order", collectionHandler.Reorder, collectionWrite)
+	collectionGroup.PUT("/:id/like", collectionHandler.Like, collectionWrite)
+	collectionGroup.DELETE("/:id/like", collectionHandler.Unlike, collectionWrite)
+	collectionGroup.POST("/:id/fork", collectionHandler.Fork, collectionWrite)
+
+	moderatorGroup := e.Group("/api/moderation")
+	moderatorGroup.Use(authenticator.Required())
+	moderatorGroup.Use(auth.RequireRole(auth.RoleModerator))
+	moderatorGroup.DELETE("/comments/:comment_id", commentHandler.ModerateDeleteComment)
+	moderatorGroup.DELETE("/reviews/:id", reviewHandler.ModerateDelete)
+
+	adminGroup := e.Group("/api/admin")
+	adminGroup.Use(authenticator.Required())
+	adminGroup.Use(auth.RequireRole(auth.RoleAdmin))
+	adminGroup.GET("/users", userHandler.ListUsers)
+	adminGroup.PUT("/users/:user_id/role", userHandler.UpdateRole)
+	adminGroup.DELETE("/users/:user_id/lockout", userHandler.UnlockAccount)
+	adminGroup.GET("/settings/2fa", userHandler.GetTwoFactorPolicy)
+	adminGroup.PUT("/settings/2fa", userHandler.SetTwoFactorPolicy)
 
 	log.Fatal(e.Start(":8080"))
 }
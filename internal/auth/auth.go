@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// roleRank задает иерархию ролей: старшая роль включает права младших
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// HasRole сообщает, достаточно ли роли role для доступа, требующего required.
+func HasRole(role, required string) bool {
+	return roleRank[role] >= roleRank[required] && roleRank[role] > 0
+}
+
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// Права персональных API-токенов
+const (
+	ScopeProfileRead   = "profile:read"
+	ScopeListsRead     = "lists:read"
+	ScopeListsWrite    = "lists:write"
+	ScopeCommentsWrite = "comments:write"
+)
+
+var knownScopes = map[string]bool{
+	ScopeProfileRead:   true,
+	ScopeListsRead:     true,
+	ScopeListsWrite:    true,
+	ScopeCommentsWrite: true,
+}
+
+func IsValidScope(scope string) bool {
+	return knownScopes[scope]
+}
+
+// Principal - аутентифицированный пользователь текущего запроса.
+type Principal struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Role      string    `json:"role"`
+	SessionID uuid.UUID `json:"session_id"`
+
+	EmailVerified bool `json:"email_verified"`
+	// Политика требует 2FA для роли, но сессия открыта без второго фактора
+	TwoFactorPending bool `json:"two_factor_pending"`
+
+	// Заполнено только для запросов с персональным API-токеном
+	APITokenID *uuid.UUID `json:"api_token_id,omitempty"`
+	Scopes     []string   `json:"scopes,omitempty"`
+}
+
+// IsAPIToken сообщает, что запрос пришел с персональным API-токеном, а не из сессии.
+func (p *Principal) IsAPIToken() bool {
+	return p.APITokenID != nil
+}
+
+// HasScopes проверяет права API-токена. Сессиям доступно все.
+func (p *Principal) HasScopes(scopes ...string) bool {
+	if !p.IsAPIToken() {
+		return true
+	}
+	for _, scope := range scopes {
+		found := false
+		for _, granted := range p.Scopes {
+			if granted == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+const principalKey = "auth.principal"
+
+// FromContext возвращает пользователя, если запрос прошел аутентификацию.
+// Для публичных маршрутов с Optional() результат может отсутствовать.
+func FromContext(c echo.Context) (*Principal, bool) {
+	principal, ok := c.Get(principalKey).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// CurrentUser - то же, что FromContext, но возвращает готовую 401 ошибку.
+func CurrentUser(c echo.Context) (*Principal, error) {
+	principal, ok := FromContext(c)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	return principal, nil
+}
+
+func setPrincipal(c echo.Context, principal *Principal) {
+	c.Set(principalKey, principal)
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// APITokenPrefix отличает персональные API-токены от JWT в заголовке Authorization.
+const APITokenPrefix = "ast_"
+
+// SessionChecker проверяет, что серверная сессия не отозвана.
+type SessionChecker interface {
+	IsSessionActive(sessionID uuid.UUID) (bool, error)
+}
+
+// APITokenResolver находит пользователя по персональному API-токену.
+type APITokenResolver interface {
+	ResolveAPIToken(token string) (*Principal, error)
+}
+
+// Authenticator проверяет Bearer-токен один раз и кладет Principal в контекст.
+type Authenticator struct {
+	tokens    *TokenManager
+	sessions  SessionChecker
+	apiTokens APITokenResolver
+}
+
+func NewAuthenticator(tokens *TokenManager, sessions SessionChecker, apiTokens APITokenResolver) *Authenticator {
+	return &Authenticator{
+		tokens:    tokens,
+		sessions:  sessions,
+		apiTokens: apiTokens,
+	}
+}
+
+// Required отклоняет запросы без действительного токена. Персональные
+// API-токены принимаются, только если маршрут перечисляет нужные scopes
+// и у токена они все есть; без scopes маршрут доступен только сессиям.
+func (a *Authenticator) Required(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tokenString := bearerToken(c)
+			if tokenString == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing token")
+			}
+
+			principal, err := a.authenticate(tokenString)
+			if err != nil {
+				return err
+			}
+			if principal.IsAPIToken() {
+				if len(scopes) == 0 {
+					return echo.NewHTTPError(http.StatusForbidden, "API tokens are not allowed for this endpoint")
+				}
+				if !principal.HasScopes(scopes...) {
+					return echo.NewHTTPError(http.StatusForbidden, "API token is missing required scope")
+				}
+			}
+
+			setPrincipal(c, principal)
+			return next(c)
+		}
+	}
+}
+
+// Optional пропускает анонимные запросы, но если токен действителен,
+// кладет Principal в контекст, чтобы ответ можно было персонализировать.
+func (a *Authenticator) Optional() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if tokenString := bearerToken(c); tokenString != "" {
+				if principal, err := a.authenticate(tokenString); err == nil {
+					setPrincipal(c, principal)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+func (a *Authenticator) authenticate(tokenString string) (*Principal, error) {
+	if strings.HasPrefix(tokenString, APITokenPrefix) {
+		principal, err := a.apiTokens.ResolveAPIToken(tokenString)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+		}
+		return principal, nil
+	}
+
+	principal, err := a.tokens.ParseAccessToken(tokenString)
+	if err != nil {
+		log.Printf("Error validating JWT token: %v", err)
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+	}
+
+	active, err := a.sessions.IsSessionActive(principal.SessionID)
+	if err != nil {
+		log.Printf("Error checking session %s: %v", principal.SessionID, err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to check session")
+	}
+	if !active {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "session revoked")
+	}
+
+	return principal, nil
+}
+
+// RequireRole пропускает только пользователей с ролью не ниже required.
+// Должен стоять после Required().
+func RequireRole(required string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, err := CurrentUser(c)
+			if err != nil {
+				return err
+			}
+			if !HasRole(principal.Role, required) {
+				return echo.NewHTTPError(http.StatusForbidden, "insufficient permissions")
+			}
+			if required != RoleUser && principal.TwoFactorPending {
+				return echo.NewHTTPError(http.StatusForbidden, "two-factor authentication required")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireVerifiedEmail запрещает действие пользователям с неподтвержденной почтой.
+// Должен стоять после Required().
+func RequireVerifiedEmail() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, err := CurrentUser(c)
+			if err != nil {
+				return err
+			}
+			if !principal.EmailVerified {
+				return echo.NewHTTPError(http.StatusForbidden, "email is not verified")
+			}
+			return next(c)
+		}
+	}
+}
+
+func bearerToken(c echo.Context) string {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// IPExtractor определяет адрес клиента для c.RealIP. Без доверенных прокси
+// берется адрес соединения: X-Forwarded-For от самого клиента подделывается,
+// и лимиты по IP перестают работать. trustedProxies - CIDR через запятую,
+// заголовок принимается только от этих адресов.
+func IPExtractor(trustedProxies string) (echo.IPExtractor, error) {
+	if strings.TrimSpace(trustedProxies) == "" {
+		return echo.ExtractIPDirect(), nil
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range strings.Split(trustedProxies, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// fakeSessions считает активными только перечисленные сессии.
+type fakeSessions map[uuid.UUID]bool
+
+func (s fakeSessions) IsSessionActive(sessionID uuid.UUID) (bool, error) {
+	return s[sessionID], nil
+}
+
+type fakeAPITokens map[string]*Principal
+
+func (t fakeAPITokens) ResolveAPIToken(token string) (*Principal, error) {
+	principal, ok := t[token]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return principal, nil
+}
+
+// serve прогоняет запрос с токеном через middlewares и возвращает статус и
+// пользователя, которого увидел обработчик.
+func serve(t *testing.T, token string, middlewares ...echo.MiddlewareFunc) (int, *Principal) {
+	t.Helper()
+	var seen *Principal
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		seen, _ = FromContext(c)
+		return c.NoContent(http.StatusOK)
+	}, middlewares...)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code, seen
+}
+
+func TestAuthenticatorChecksTokenAndSession(t *testing.T) {
+	tokens := NewTokenManager("test-secret")
+	active, revoked := uuid.New(), uuid.New()
+	authenticator := NewAuthenticator(tokens, fakeSessions{active: true}, fakeAPITokens{})
+
+	issue := func(sessionID uuid.UUID, role string) string {
+		t.Helper()
+		token, err := tokens.IssueAccessToken(Principal{UserID: uuid.New(), Role: role, SessionID: sessionID}, "", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := issue(active, RoleUser)
+	expired, _ := tokens.IssueAccessToken(Principal{UserID: uuid.New(), Role: RoleUser, SessionID: active}, "", -time.Minute)
+	foreign, _ := NewTokenManager("other-secret").IssueAccessToken(Principal{UserID: uuid.New(), SessionID: active}, "", time.Minute)
+
+	for _, tc := range []struct {
+		name     string
+		token    string
+		required int
+		optional bool // Optional видит пользователя
+	}{
+		{"valid", valid, http.StatusOK, true},
+		{"no token", "", http.StatusUnauthorized, false},
+		{"revoked session", issue(revoked, RoleUser), http.StatusUnauthorized, false},
+		{"expired", expired, http.StatusUnauthorized, false},
+		{"foreign secret", foreign, http.StatusUnauthorized, false},
+		{"garbage", "not-a-jwt", http.StatusUnauthorized, false},
+	} {
+		status, principal := serve(t, tc.token, authenticator.Required())
+		if status != tc.required {
+			t.Errorf("%s: Required status %d, want %d", tc.name, status, tc.required)
+		}
+		if status == http.StatusOK && (principal == nil || principal.SessionID != active) {
+			t.Errorf("%s: principal %+v", tc.name, principal)
+		}
+
+		status, principal = serve(t, tc.token, authenticator.Optional())
+		if status != http.StatusOK || (principal != nil) != tc.optional {
+			t.Errorf("%s: Optional status %d, principal %+v", tc.name, status, principal)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tokens := NewTokenManager("test-secret")
+	session := uuid.New()
+	authenticator := NewAuthenticator(tokens, fakeSessions{session: true}, fakeAPITokens{})
+
+	for _, tc := range []struct {
+		principal Principal
+		required  string
+		status    int
+	}{
+		{Principal{Role: RoleUser}, RoleUser, http.StatusOK},
+		{Principal{Role: RoleUser}, RoleModerator, http.StatusForbidden},
+		{Principal{Role: RoleModerator}, RoleModerator, http.StatusOK},
+		{Principal{Role: RoleAdmin}, RoleModerator, http.StatusOK},
+		{Principal{Role: RoleModerator}, RoleAdmin, http.StatusForbidden},
+		{Principal{Role: "superuser"}, RoleUser, http.StatusForbidden},
+		// без второго фактора старшие роли работают как обычный пользователь
+		{Principal{Role: RoleAdmin, TwoFactorPending: true}, RoleModerator, http.StatusForbidden},
+		{Principal{Role: RoleAdmin, TwoFactorPending: true}, RoleUser, http.StatusOK},
+	} {
+		tc.principal.UserID, tc.principal.SessionID = uuid.New(), session
+		token, err := tokens.IssueAccessToken(tc.principal, "", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status, _ := serve(t, token, authenticator.Required(), RequireRole(tc.required)); status != tc.status {
+			t.Errorf("%+v for %s: status %d, want %d", tc.principal, tc.required, status, tc.status)
+		}
+	}
+
+	if status, _ := serve(t, "", RequireRole(RoleUser)); status != http.StatusUnauthorized {
+		t.Errorf("RequireRole without authentication: status %d", status)
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	tokens := NewTokenManager("test-secret")
+	session := uuid.New()
+	authenticator := NewAuthenticator(tokens, fakeSessions{session: true}, fakeAPITokens{})
+
+	for verified, want := range map[bool]int{true: http.StatusOK, false: http.StatusForbidden} {
+		token, _ := tokens.IssueAccessToken(Principal{UserID: uuid.New(), Role: RoleUser, SessionID: session, EmailVerified: verified}, "", time.Minute)
+		if status, _ := serve(t, token, authenticator.Required(), RequireVerifiedEmail()); status != want {
+			t.Errorf("verified %v: status %d, want %d", verified, status, want)
+		}
+	}
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	tokenID := uuid.New()
+	authenticator := NewAuthenticator(NewTokenManager("test-secret"), fakeSessions{}, fakeAPITokens{
+		APITokenPrefix + "lists": {UserID: uuid.New(), Role: RoleUser, APITokenID: &tokenID,
+			Scopes: []string{ScopeListsRead, ScopeListsWrite}},
+	})
+	token := APITokenPrefix + "lists"
+
+	for _, tc := range []struct {
+		name   string
+		scopes []string
+		status int
+	}{
+		{"granted scope", []string{ScopeListsRead}, http.StatusOK},
+		{"all granted scopes", []string{ScopeListsRead, ScopeListsWrite}, http.StatusOK},
+		{"missing scope", []string{ScopeListsRead, ScopeProfileRead}, http.StatusForbidden},
+		{"session-only route", nil, http.StatusForbidden},
+	} {
+		status, principal := serve(t, token, authenticator.Required(tc.scopes...))
+		if status != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, status, tc.status)
+		}
+		if status == http.StatusOK && !principal.IsAPIToken() {
+			t.Errorf("%s: principal is not marked as an API token", tc.name)
+		}
+	}
+
+	if status, _ := serve(t, APITokenPrefix+"revoked", authenticator.Required(ScopeListsRead)); status != http.StatusUnauthorized {
+		t.Errorf("unknown API token: status %d", status)
+	}
+	// сессии scopes не ограничивают
+	if !(&Principal{}).HasScopes(ScopeProfileRead) {
+		t.Error("session principal lacks a scope")
+	}
+}
+
+func TestIPExtractor(t *testing.T) {
+	request := func(remote string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote + ":1234"
+		req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+		return req
+	}
+
+	direct, err := IPExtractor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := direct(request("10.0.0.2")); ip != "10.0.0.2" {
+		t.Errorf("without trusted proxies: %s, want the connection address", ip)
+	}
+
+	proxied, err := IPExtractor("192.0.2.0/24, 198.51.100.1/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for remote, want := range map[string]string{
+		"192.0.2.10":    "203.0.113.7",
+		"198.51.100.1":  "203.0.113.7",
+		"10.0.0.2":      "10.0.0.2",
+		"127.0.0.1":     "127.0.0.1",
+		"198.51.100.99": "198.51.100.99",
+	} {
+		if ip := proxied(request(remote)); ip != want {
+			t.Errorf("request from %s: %s, want %s", remote, ip, want)
+		}
+	}
+
+	if _, err := IPExtractor("192.0.2.0"); err == nil {
+		t.Error("address without a mask is accepted")
+	}
+}
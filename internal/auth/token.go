@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const PurposeAccess = "access"
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims - claims access-токена. Purpose отличает access-токены от других
+// подписанных тем же ключом токенов, чтобы их нельзя было подменить друг другом.
+type Claims struct {
+	UserID    string `json:"user_id"`
+	Email     string `json:"email,omitempty"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	Purpose   string `json:"purpose"`
+
+	EmailVerified    bool `json:"email_verified"`
+	TwoFactorPending bool `json:"mfa_pending,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenManager подписывает и проверяет JWT (HS256).
+type TokenManager struct {
+	secret []byte
+}
+
+func NewTokenManager(secret string) *TokenManager {
+	return &TokenManager{secret: []byte(secret)}
+}
+
+func (m *TokenManager) IssueAccessToken(principal Principal, email string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return m.Sign(&Claims{
+		UserID:    principal.UserID.String(),
+		Email:     email,
+		Role:      principal.Role,
+		SessionID: principal.SessionID.String(),
+		Purpose:   PurposeAccess,
+
+		EmailVerified:    principal.EmailVerified,
+		TwoFactorPending: principal.TwoFactorPending,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	})
+}
+
+func (m *TokenManager) ParseAccessToken(tokenString string) (*Principal, error) {
+	var claims Claims
+	if err := m.Parse(tokenString, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposeAccess {
+		return nil, ErrInvalidToken
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &Principal{
+		UserID:    userID,
+		Role:      claims.Role,
+		SessionID: sessionID,
+
+		EmailVerified:    claims.EmailVerified,
+		TwoFactorPending: claims.TwoFactorPending,
+	}, nil
+}
+
+// Sign подписывает произвольные claims секретом сервера.
+func (m *TokenManager) Sign(claims jwt.Claims) (string, error) {
+	if len(m.secret) == 0 {
+		return "", errors.New("JWT secret is not set")
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+}
+
+// Parse проверяет подпись и срок действия токена и заполняет claims.
+func (m *TokenManager) Parse(tokenString string, claims jwt.Claims) error {
+	if len(m.secret) == 0 {
+		return errors.New("JWT secret is not set")
+	}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return m.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
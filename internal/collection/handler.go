@@ -0,0 +1,309 @@
+package collection
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/pkg/pagination"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// viewer - пользователь публичного маршрута, nil для анонимов.
+func viewer(c echo.Context) *uuid.UUID {
+	if principal, ok := auth.FromContext(c); ok {
+		return &principal.UserID
+	}
+	return nil
+}
+
+func collectionID(c echo.Context) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return uuid.Nil, ErrNotFound
+	}
+	return id, nil
+}
+
+func (h *Handler) ListPublic(c echo.Context) error {
+	page, limit := pagination.FromQuery(c, 20, 100)
+
+	collections, total, err := h.service.ListPublic(c.QueryParam("q"), c.QueryParam("sort"), page, limit)
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"collections": collections,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+	})
+}
+
+func (h *Handler) ListMine(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	collections, err := h.service.ListMine(principal.UserID)
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, collections)
+}
+
+func (h *Handler) Get(c echo.Context) error {
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	details, err := h.service.Get(c.Request().Context(), viewer(c), id)
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, details)
+}
+
+func (h *Handler) GetShared(c echo.Context) error {
+	details, err := h.service.GetShared(c.Request().Context(), viewer(c), c.Param("token"))
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, details)
+}
+
+func (h *Handler) Create(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req Input
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	collection, err := h.service.Create(principal.UserID, req)
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusCreated, collection)
+}
+
+func (h *Handler) Update(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	var req Input
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	collection, err := h.service.Update(principal.UserID, id, req)
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, collection)
+}
+
+func (h *Handler) Delete(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	if err := h.service.Delete(principal.UserID, id); err != nil {
+		return collectionError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) RotateShareToken(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	token, err := h.service.RotateShareToken(principal.UserID, id)
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"share_token": token})
+}
+
+func (h *Handler) AddItem(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	var req struct {
+		AnimeID string `json:"anime_id"`
+		Note    string `json:"note"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := h.service.AddItem(principal.UserID, id, req.AnimeID, req.Note); err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusCreated, echo.Map{"anime_id": req.AnimeID})
+}
+
+func (h *Handler) UpdateItem(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	var req struct {
+		Note     *string `json:"note"`
+		Position *int    `json:"position"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := h.service.UpdateItem(principal.UserID, id, c.Param("anime_id"), req.Note, req.Position); err != nil {
+		return collectionError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) RemoveItem(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	if err := h.service.RemoveItem(principal.UserID, id, c.Param("anime_id")); err != nil {
+		return collectionError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) Reorder(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	var req struct {
+		AnimeIDs []string `json:"anime_ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := h.service.Reorder(principal.UserID, id, req.AnimeIDs); err != nil {
+		return collectionError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) Like(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	if err := h.service.Like(principal.UserID, id); err != nil {
+		return collectionError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) Unlike(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	if err := h.service.Unlike(principal.UserID, id); err != nil {
+		return collectionError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) Fork(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := collectionID(c)
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	fork, err := h.service.Fork(principal.UserID, id)
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusCreated, fork)
+}
+
+func collectionError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrItemNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrItemExists):
+		status = http.StatusConflict
+	case errors.Is(err, ErrNotPublic):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrInvalidCollection), errors.Is(err, ErrInvalidAnimeID), errors.Is(err, ErrTooManyItems),
+		errors.Is(err, ErrTooManyCollections), errors.Is(err, ErrOrderMismatch):
+		status = http.StatusBadRequest
+	}
+	return c.JSON(status, echo.Map{"error": err.Error()})
+}
@@ -0,0 +1,78 @@
+package collection
+
+import (
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/google/uuid"
+)
+
+const (
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted" // доступна только по секретной ссылке
+	VisibilityPublic   = "public"
+)
+
+// Collection - пользовательская подборка аниме.
+type Collection struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID       uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	Title        string     `gorm:"size:100;not null" json:"title"`
+	Description  string     `gorm:"type:text" json:"description"`
+	Visibility   string     `gorm:"size:16;not null;index" json:"visibility"`
+	ShareToken   string     `gorm:"size:32;uniqueIndex" json:"-"`
+	ForkedFromID *uuid.UUID `gorm:"type:uuid;index" json:"forked_from_id,omitempty"`
+	ItemsCount   int        `gorm:"not null;default:0" json:"items_count"`
+	LikesCount   int        `gorm:"not null;default:0;index" json:"likes_count"`
+	ForksCount   int        `gorm:"not null;default:0" json:"forks_count"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	// заполняется запросами с join на users
+	AuthorNickname string `gorm:"->;-:migration" json:"author_nickname,omitempty"`
+}
+
+// Item - аниме в подборке. Position начинается с 1.
+type Item struct {
+	CollectionID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	AnimeID      string    `gorm:"size:32;primaryKey" json:"anime_id"`
+	Position     int       `gorm:"not null" json:"position"`
+	Note         string    `gorm:"type:text" json:"note"`
+	CreatedAt    time.Time `json:"added_at"`
+}
+
+func (Item) TableName() string {
+	return "collection_items"
+}
+
+type Like struct {
+	CollectionID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID       uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+	CreatedAt    time.Time
+}
+
+func (Like) TableName() string {
+	return "collection_likes"
+}
+
+// Input - поля подборки при создании и изменении. nil-поля не меняются.
+type Input struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+}
+
+type ItemDetails struct {
+	Item
+	Anime *shikimori.Anime `json:"anime,omitempty"`
+}
+
+// Details - подборка с элементами и данными Shikimori. ShareToken
+// показывается только владельцу.
+type Details struct {
+	*Collection
+	ShareToken string        `json:"share_token,omitempty"`
+	LikedByMe  bool          `json:"liked_by_me"`
+	Items      []ItemDetails `json:"items"`
+	FailedIDs  []string      `json:"failed_ids"`
+}
@@ -0,0 +1,214 @@
+package collection
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	SortPopular = "popular"
+	SortNew     = "new"
+)
+
+type Repository interface {
+	Create(collection *Collection) error
+	FindByID(id uuid.UUID) (*Collection, error)
+	FindByShareToken(token string) (*Collection, error)
+	FindByUser(userID uuid.UUID) ([]Collection, error)
+	FindPublic(search, sort string, limit, offset int) ([]Collection, int64, error)
+	CountByUser(userID uuid.UUID) (int64, error)
+	Update(collection *Collection) error
+	Delete(id uuid.UUID) error
+	FindItems(collectionID uuid.UUID) ([]Item, error)
+	UpdateItems(collectionID uuid.UUID, fn func(items []Item) ([]Item, error)) error
+	Fork(sourceID uuid.UUID, fork *Collection) error
+	Like(collectionID, userID uuid.UUID) error
+	Unlike(collectionID, userID uuid.UUID) error
+	IsLiked(collectionID, userID uuid.UUID) (bool, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// withAuthor добавляет к подборкам ник автора.
+func (r *repository) withAuthor() *gorm.DB {
+	return r.db.Model(&Collection{}).
+		Select("collections.*, users.nickname AS author_nickname").
+		Joins("JOIN users ON users.id = collections.user_id")
+}
+
+func (r *repository) Create(collection *Collection) error {
+	return r.db.Create(collection).Error
+}
+
+func (r *repository) FindByID(id uuid.UUID) (*Collection, error) {
+	var collection Collection
+	if err := r.withAuthor().First(&collection, "collections.id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+func (r *repository) FindByShareToken(token string) (*Collection, error) {
+	var collection Collection
+	if err := r.withAuthor().First(&collection, "collections.share_token = ?", token).Error; err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+func (r *repository) FindByUser(userID uuid.UUID) ([]Collection, error) {
+	var collections []Collection
+	err := r.withAuthor().Where("collections.user_id = ?", userID).Order("collections.updated_at DESC").Find(&collections).Error
+	return collections, err
+}
+
+func (r *repository) FindPublic(search, sort string, limit, offset int) ([]Collection, int64, error) {
+	query := r.withAuthor().Where("collections.visibility = ? AND collections.items_count > 0", VisibilityPublic)
+	if search != "" {
+		query = query.Where("collections.title ILIKE ?", "%"+search+"%")
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	order := "collections.likes_count DESC, collections.created_at DESC"
+	if sort == SortNew {
+		order = "collections.created_at DESC"
+	}
+	var collections []Collection
+	err := query.Order(order).Limit(limit).Offset(offset).Find(&collections).Error
+	return collections, total, err
+}
+
+func (r *repository) CountByUser(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&Collection{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *repository) Update(collection *Collection) error {
+	return r.db.Model(collection).Select("title", "description", "visibility", "share_token", "updated_at").Updates(collection).Error
+}
+
+func (r *repository) Delete(id uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", id).Delete(&Item{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("collection_id = ?", id).Delete(&Like{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Collection{}, "id = ?", id).Error
+	})
+}
+
+func (r *repository) FindItems(collectionID uuid.UUID) ([]Item, error) {
+	var items []Item
+	err := r.db.Where("collection_id = ?", collectionID).Order("position").Find(&items).Error
+	return items, err
+}
+
+// UpdateItems - единая точка изменения элементов подборки: fn получает
+// элементы по порядку и возвращает новый список, который записывается
+// целиком с позициями 1..n. Подборка блокируется на время изменения.
+func (r *repository) UpdateItems(collectionID uuid.UUID, fn func(items []Item) ([]Item, error)) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var collection Collection
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&collection, "id = ?", collectionID).Error; err != nil {
+			return err
+		}
+		var items []Item
+		if err := tx.Where("collection_id = ?", collectionID).Order("position").Find(&items).Error; err != nil {
+			return err
+		}
+
+		items, err := fn(items)
+		if err != nil {
+			return err
+		}
+		return replaceItems(tx, collectionID, items)
+	})
+}
+
+func replaceItems(tx *gorm.DB, collectionID uuid.UUID, items []Item) error {
+	if err := tx.Where("collection_id = ?", collectionID).Delete(&Item{}).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range items {
+		items[i].CollectionID = collectionID
+		items[i].Position = i + 1
+		if items[i].CreatedAt.IsZero() {
+			items[i].CreatedAt = now
+		}
+	}
+	if len(items) > 0 {
+		if err := tx.CreateInBatches(items, 100).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&Collection{}).Where("id = ?", collectionID).
+		Updates(map[string]interface{}{"items_count": len(items), "updated_at": now}).Error
+}
+
+// Fork создает копию подборки вместе с элементами и заметками.
+func (r *repository) Fork(sourceID uuid.UUID, fork *Collection) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var items []Item
+		if err := tx.Where("collection_id = ?", sourceID).Order("position").Find(&items).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(fork).Error; err != nil {
+			return err
+		}
+		for i := range items {
+			items[i].CreatedAt = time.Time{}
+		}
+		if err := replaceItems(tx, fork.ID, items); err != nil {
+			return err
+		}
+		fork.ItemsCount = len(items)
+		return tx.Model(&Collection{}).Where("id = ?", sourceID).
+			UpdateColumn("forks_count", gorm.Expr("forks_count + 1")).Error
+	})
+}
+
+// Like и Unlike идемпотентны, счетчик меняется только при реальном изменении.
+func (r *repository) Like(collectionID, userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Like{CollectionID: collectionID, UserID: userID})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return tx.Model(&Collection{}).Where("id = ?", collectionID).
+			UpdateColumn("likes_count", gorm.Expr("likes_count + 1")).Error
+	})
+}
+
+func (r *repository) Unlike(collectionID, userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("collection_id = ? AND user_id = ?", collectionID, userID).Delete(&Like{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return tx.Model(&Collection{}).Where("id = ?", collectionID).
+			UpdateColumn("likes_count", gorm.Expr("likes_count - 1")).Error
+	})
+}
+
+func (r *repository) IsLiked(collectionID, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.Model(&Like{}).Where("collection_id = ? AND user_id = ?", collectionID, userID).Count(&count).Error
+	return count > 0, err
+}
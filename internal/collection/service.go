@@ -0,0 +1,439 @@
+package collection
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxCollectionsPerUser = 100
+	maxItemsPerCollection = 500
+	maxTitleLength        = 100
+	maxDescriptionLength  = 2000
+	maxNoteLength         = 500
+)
+
+var (
+	ErrNotFound           = errors.New("collection not found")
+	ErrItemNotFound       = errors.New("anime is not in this collection")
+	ErrItemExists         = errors.New("anime is already in this collection")
+	ErrInvalidCollection  = errors.New("invalid collection")
+	ErrInvalidAnimeID     = errors.New("invalid anime id")
+	ErrTooManyItems       = fmt.Errorf("collection cannot have more than %d anime", maxItemsPerCollection)
+	ErrTooManyCollections = fmt.Errorf("you cannot have more than %d collections", maxCollectionsPerUser)
+	ErrOrderMismatch      = errors.New("anime_ids must list every anime in the collection exactly once")
+	ErrNotPublic          = errors.New("only public collections can be liked or forked")
+)
+
+type Service interface {
+	Create(userID uuid.UUID, input Input) (*Collection, error)
+	Update(userID, id uuid.UUID, input Input) (*Collection, error)
+	Delete(userID, id uuid.UUID) error
+	ListMine(userID uuid.UUID) ([]Collection, error)
+	ListPublic(search, sort string, page, limit int) ([]Collection, int64, error)
+	Get(ctx context.Context, viewer *uuid.UUID, id uuid.UUID) (*Details, error)
+	GetShared(ctx context.Context, viewer *uuid.UUID, token string) (*Details, error)
+	RotateShareToken(userID, id uuid.UUID) (string, error)
+	AddItem(userID, id uuid.UUID, animeID, note string) error
+	UpdateItem(userID, id uuid.UUID, animeID string, note *string, position *int) error
+	RemoveItem(userID, id uuid.UUID, animeID string) error
+	Reorder(userID, id uuid.UUID, animeIDs []string) error
+	Like(userID, id uuid.UUID) error
+	Unlike(userID, id uuid.UUID) error
+	Fork(userID, id uuid.UUID) (*Collection, error)
+}
+
+type service struct {
+	repo             Repository
+	shikimoriService *shikimori.Service
+}
+
+func NewService(repo Repository, shikimoriService *shikimori.Service) Service {
+	return &service{repo: repo, shikimoriService: shikimoriService}
+}
+
+func (s *service) Create(userID uuid.UUID, input Input) (*Collection, error) {
+	count, err := s.repo.CountByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxCollectionsPerUser {
+		return nil, ErrTooManyCollections
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	collection := &Collection{
+		ID:         uuid.New(),
+		UserID:     userID,
+		Visibility: VisibilityPrivate,
+		ShareToken: token,
+	}
+	if input.Title == nil {
+		return nil, fmt.Errorf("%w: title is required", ErrInvalidCollection)
+	}
+	if err := applyInput(collection, input); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(collection); err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+func (s *service) Update(userID, id uuid.UUID, input Input) (*Collection, error) {
+	collection, err := s.owned(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyInput(collection, input); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Update(collection); err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+func applyInput(collection *Collection, input Input) error {
+	if input.Title != nil {
+		title := strings.TrimSpace(*input.Title)
+		if title == "" || len([]rune(title)) > maxTitleLength {
+			return fmt.Errorf("%w: title must be 1-%d characters", ErrInvalidCollection, maxTitleLength)
+		}
+		collection.Title = title
+	}
+	if input.Description != nil {
+		description := strings.TrimSpace(*input.Description)
+		if len([]rune(description)) > maxDescriptionLength {
+			return fmt.Errorf("%w: description is longer than %d characters", ErrInvalidCollection, maxDescriptionLength)
+		}
+		collection.Description = description
+	}
+	if input.Visibility != nil {
+		switch *input.Visibility {
+		case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+			collection.Visibility = *input.Visibility
+		default:
+			return fmt.Errorf("%w: visibility must be private, unlisted or public", ErrInvalidCollection)
+		}
+	}
+	return nil
+}
+
+func (s *service) Delete(userID, id uuid.UUID) error {
+	if _, err := s.owned(userID, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(id)
+}
+
+func (s *service) ListMine(userID uuid.UUID) ([]Collection, error) {
+	collections, err := s.repo.FindByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if collections == nil {
+		collections = []Collection{}
+	}
+	return collections, nil
+}
+
+func (s *service) ListPublic(search, sort string, page, limit int) ([]Collection, int64, error) {
+	collections, total, err := s.repo.FindPublic(strings.TrimSpace(search), sort, limit, (page-1)*limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	if collections == nil {
+		collections = []Collection{}
+	}
+	return collections, total, nil
+}
+
+// Get открывает подборку по ID: свою любую, чужую - только публичную.
+// Скрытые подборки отвечают 404, чтобы не выдавать их существование.
+func (s *service) Get(ctx context.Context, viewer *uuid.UUID, id uuid.UUID) (*Details, error) {
+	collection, err := s.find(id)
+	if err != nil {
+		return nil, err
+	}
+	isOwner := viewer != nil && *viewer == collection.UserID
+	if !isOwner && collection.Visibility != VisibilityPublic {
+		return nil, ErrNotFound
+	}
+	return s.details(ctx, viewer, collection)
+}
+
+// GetShared открывает подборку по секретной ссылке (unlisted или public).
+func (s *service) GetShared(ctx context.Context, viewer *uuid.UUID, token string) (*Details, error) {
+	collection, err := s.repo.FindByShareToken(token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	isOwner := viewer != nil && *viewer == collection.UserID
+	if !isOwner && collection.Visibility == VisibilityPrivate {
+		return nil, ErrNotFound
+	}
+	return s.details(ctx, viewer, collection)
+}
+
+func (s *service) details(ctx context.Context, viewer *uuid.UUID, collection *Collection) (*Details, error) {
+	items, err := s.repo.FindItems(collection.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &Details{
+		Collection: collection,
+		Items:      make([]ItemDetails, len(items)),
+		FailedIDs:  []string{},
+	}
+	if viewer != nil {
+		if *viewer == collection.UserID {
+			details.ShareToken = collection.ShareToken
+		}
+		if details.LikedByMe, err = s.repo.IsLiked(collection.ID, *viewer); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.AnimeID
+	}
+	found, failed := s.shikimoriService.FetchAnimesByIDs(ctx, ids)
+	for i, item := range items {
+		details.Items[i] = ItemDetails{Item: item}
+		if anime, ok := found[item.AnimeID]; ok {
+			details.Items[i].Anime = &anime
+		}
+	}
+	details.FailedIDs = failed
+	return details, nil
+}
+
+// RotateShareToken выдает новую секретную ссылку, старая перестает работать.
+func (s *service) RotateShareToken(userID, id uuid.UUID) (string, error) {
+	collection, err := s.owned(userID, id)
+	if err != nil {
+		return "", err
+	}
+	if collection.ShareToken, err = newShareToken(); err != nil {
+		return "", err
+	}
+	if err := s.repo.Update(collection); err != nil {
+		return "", err
+	}
+	return collection.ShareToken, nil
+}
+
+func (s *service) AddItem(userID, id uuid.UUID, animeID, note string) error {
+	if !shikimori.IsValidAnimeID(animeID) {
+		return ErrInvalidAnimeID
+	}
+	note, err := validNote(note)
+	if err != nil {
+		return err
+	}
+	return s.updateItems(userID, id, func(items []Item) ([]Item, error) {
+		if indexOf(items, animeID) >= 0 {
+			return nil, ErrItemExists
+		}
+		if len(items) >= maxItemsPerCollection {
+			return nil, ErrTooManyItems
+		}
+		return append(items, Item{AnimeID: animeID, Note: note}), nil
+	})
+}
+
+// UpdateItem меняет заметку и/или позицию элемента. Позиция за концом
+// списка означает "в конец".
+func (s *service) UpdateItem(userID, id uuid.UUID, animeID string, note *string, position *int) error {
+	if note != nil {
+		cleaned, err := validNote(*note)
+		if err != nil {
+			return err
+		}
+		note = &cleaned
+	}
+	if position != nil && *position < 1 {
+		return fmt.Errorf("%w: position must be a positive number", ErrInvalidCollection)
+	}
+	return s.updateItems(userID, id, func(items []Item) ([]Item, error) {
+		i := indexOf(items, animeID)
+		if i < 0 {
+			return nil, ErrItemNotFound
+		}
+		item := items[i]
+		if note != nil {
+			item.Note = *note
+		}
+		if position == nil {
+			items[i] = item
+			return items, nil
+		}
+		items = append(items[:i], items[i+1:]...)
+		to := min(*position-1, len(items))
+		return append(items[:to], append([]Item{item}, items[to:]...)...), nil
+	})
+}
+
+func (s *service) RemoveItem(userID, id uuid.UUID, animeID string) error {
+	return s.updateItems(userID, id, func(items []Item) ([]Item, error) {
+		i := indexOf(items, animeID)
+		if i < 0 {
+			return nil, ErrItemNotFound
+		}
+		return append(items[:i], items[i+1:]...), nil
+	})
+}
+
+// Reorder задает полный порядок элементов подборки.
+func (s *service) Reorder(userID, id uuid.UUID, animeIDs []string) error {
+	return s.updateItems(userID, id, func(items []Item) ([]Item, error) {
+		if len(animeIDs) != len(items) {
+			return nil, ErrOrderMismatch
+		}
+		byID := make(map[string]Item, len(items))
+		for _, item := range items {
+			byID[item.AnimeID] = item
+		}
+		ordered := make([]Item, 0, len(items))
+		for _, animeID := range animeIDs {
+			item, ok := byID[animeID]
+			if !ok {
+				return nil, ErrOrderMismatch
+			}
+			delete(byID, animeID)
+			ordered = append(ordered, item)
+		}
+		return ordered, nil
+	})
+}
+
+func (s *service) updateItems(userID, id uuid.UUID, fn func(items []Item) ([]Item, error)) error {
+	if _, err := s.owned(userID, id); err != nil {
+		return err
+	}
+	return s.repo.UpdateItems(id, fn)
+}
+
+func (s *service) Like(userID, id uuid.UUID) error {
+	if _, err := s.public(userID, id); err != nil {
+		return err
+	}
+	return s.repo.Like(id, userID)
+}
+
+func (s *service) Unlike(userID, id uuid.UUID) error {
+	if _, err := s.find(id); err != nil {
+		return err
+	}
+	return s.repo.Unlike(id, userID)
+}
+
+// Fork копирует публичную подборку себе как приватную.
+func (s *service) Fork(userID, id uuid.UUID) (*Collection, error) {
+	source, err := s.public(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	count, err := s.repo.CountByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxCollectionsPerUser {
+		return nil, ErrTooManyCollections
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	fork := &Collection{
+		ID:           uuid.New(),
+		UserID:       userID,
+		Title:        source.Title,
+		Description:  source.Description,
+		Visibility:   VisibilityPrivate,
+		ShareToken:   token,
+		ForkedFromID: &source.ID,
+	}
+	if err := s.repo.Fork(source.ID, fork); err != nil {
+		return nil, err
+	}
+	return fork, nil
+}
+
+func (s *service) find(id uuid.UUID) (*Collection, error) {
+	collection, err := s.repo.FindByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	return collection, err
+}
+
+// owned возвращает подборку, только если она принадлежит пользователю.
+func (s *service) owned(userID, id uuid.UUID) (*Collection, error) {
+	collection, err := s.find(id)
+	if err != nil {
+		return nil, err
+	}
+	if collection.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return collection, nil
+}
+
+// public возвращает подборку, которую пользователь может лайкнуть или форкнуть.
+func (s *service) public(userID, id uuid.UUID) (*Collection, error) {
+	collection, err := s.find(id)
+	if err != nil {
+		return nil, err
+	}
+	if collection.Visibility != VisibilityPublic {
+		if collection.UserID != userID {
+			return nil, ErrNotFound
+		}
+		return nil, ErrNotPublic
+	}
+	return collection, nil
+}
+
+func validNote(note string) (string, error) {
+	note = strings.TrimSpace(note)
+	if len([]rune(note)) > maxNoteLength {
+		return "", fmt.Errorf("%w: note is longer than %d characters", ErrInvalidCollection, maxNoteLength)
+	}
+	return note, nil
+}
+
+func indexOf(items []Item, animeID string) int {
+	for i, item := range items {
+		if item.AnimeID == animeID {
+			return i
+		}
+	}
+	return -1
+}
+
+func newShareToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,296 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// fakeRepository хранит подборки в памяти.
+type fakeRepository struct {
+	Repository
+
+	collections map[uuid.UUID]*Collection
+	items       map[uuid.UUID][]Item
+	likes       map[uuid.UUID]map[uuid.UUID]bool
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{
+		collections: map[uuid.UUID]*Collection{},
+		items:       map[uuid.UUID][]Item{},
+		likes:       map[uuid.UUID]map[uuid.UUID]bool{},
+	}
+}
+
+func (r *fakeRepository) Create(collection *Collection) error {
+	r.collections[collection.ID] = collection
+	return nil
+}
+
+func (r *fakeRepository) FindByID(id uuid.UUID) (*Collection, error) {
+	collection, ok := r.collections[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *collection
+	return &copied, nil
+}
+
+func (r *fakeRepository) FindByShareToken(token string) (*Collection, error) {
+	for id, collection := range r.collections {
+		if collection.ShareToken == token {
+			return r.FindByID(id)
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) CountByUser(userID uuid.UUID) (int64, error) {
+	var count int64
+	for _, collection := range r.collections {
+		if collection.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeRepository) Update(collection *Collection) error {
+	copied := *collection
+	r.collections[collection.ID] = &copied
+	return nil
+}
+
+func (r *fakeRepository) FindItems(collectionID uuid.UUID) ([]Item, error) {
+	return r.items[collectionID], nil
+}
+
+func (r *fakeRepository) UpdateItems(collectionID uuid.UUID, fn func(items []Item) ([]Item, error)) error {
+	items, err := fn(append([]Item(nil), r.items[collectionID]...))
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].Position = i + 1
+	}
+	r.items[collectionID] = items
+	return nil
+}
+
+func (r *fakeRepository) Fork(sourceID uuid.UUID, fork *Collection) error {
+	r.collections[fork.ID] = fork
+	r.items[fork.ID] = append([]Item(nil), r.items[sourceID]...)
+	return nil
+}
+
+func (r *fakeRepository) Like(collectionID, userID uuid.UUID) error {
+	if r.likes[collectionID] == nil {
+		r.likes[collectionID] = map[uuid.UUID]bool{}
+	}
+	r.likes[collectionID][userID] = true
+	return nil
+}
+
+func (r *fakeRepository) IsLiked(collectionID, userID uuid.UUID) (bool, error) {
+	return r.likes[collectionID][userID], nil
+}
+
+func createCollection(t *testing.T, s Service, owner uuid.UUID, visibility string) *Collection {
+	t.Helper()
+	title := "Лучшее за сезон"
+	collection, err := s.Create(owner, Input{Title: &title, Visibility: &visibility})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collection
+}
+
+func TestCreateValidatesInput(t *testing.T) {
+	s := NewService(newFakeRepository(), nil)
+	owner := uuid.New()
+	text := func(v string) *string { return &v }
+
+	for _, tc := range []struct {
+		name  string
+		input Input
+	}{
+		{"no title", Input{}},
+		{"blank title", Input{Title: text("   ")}},
+		{"long title", Input{Title: text(strings.Repeat("я", maxTitleLength+1))}},
+		{"long description", Input{Title: text("ok"), Description: text(strings.Repeat("a", maxDescriptionLength+1))}},
+		{"unknown visibility", Input{Title: text("ok"), Visibility: text("friends")}},
+	} {
+		if _, err := s.Create(owner, tc.input); !errors.Is(err, ErrInvalidCollection) {
+			t.Errorf("%s: error %v, want %v", tc.name, err, ErrInvalidCollection)
+		}
+	}
+
+	collection, err := s.Create(owner, Input{Title: text("  Исекаи  ")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collection.Title != "Исекаи" || collection.Visibility != VisibilityPrivate || collection.ShareToken == "" {
+		t.Errorf("created %+v, want a trimmed private collection with a share token", collection)
+	}
+}
+
+func TestCollectionVisibility(t *testing.T) {
+	s := NewService(newFakeRepository(), nil)
+	ctx, owner, stranger := context.Background(), uuid.New(), uuid.New()
+
+	for _, tc := range []struct {
+		visibility       string
+		public, byLinkOK bool
+	}{
+		{VisibilityPrivate, false, false},
+		{VisibilityUnlisted, false, true},
+		{VisibilityPublic, true, true},
+	} {
+		collection := createCollection(t, s, owner, tc.visibility)
+
+		details, err := s.Get(ctx, &owner, collection.ID)
+		if err != nil {
+			t.Fatalf("%s: owner: %v", tc.visibility, err)
+		}
+		if details.ShareToken != collection.ShareToken {
+			t.Errorf("%s: share token is hidden from the owner", tc.visibility)
+		}
+
+		for _, viewer := range []*uuid.UUID{&stranger, nil} {
+			details, err := s.Get(ctx, viewer, collection.ID)
+			if tc.public != (err == nil) || (err != nil && !errors.Is(err, ErrNotFound)) {
+				t.Errorf("%s: Get by %v: %v", tc.visibility, viewer, err)
+			}
+			if err == nil && details.ShareToken != "" {
+				t.Errorf("%s: share token shown to %v", tc.visibility, viewer)
+			}
+
+			_, err = s.GetShared(ctx, viewer, collection.ShareToken)
+			if tc.byLinkOK != (err == nil) || (err != nil && !errors.Is(err, ErrNotFound)) {
+				t.Errorf("%s: GetShared by %v: %v", tc.visibility, viewer, err)
+			}
+		}
+	}
+
+	// после смены ссылки старая не работает
+	collection := createCollection(t, s, owner, VisibilityUnlisted)
+	token, err := s.RotateShareToken(owner, collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetShared(ctx, nil, collection.ShareToken); !errors.Is(err, ErrNotFound) {
+		t.Errorf("old share link: %v", err)
+	}
+	if _, err := s.GetShared(ctx, nil, token); err != nil {
+		t.Errorf("new share link: %v", err)
+	}
+	if _, err := s.RotateShareToken(stranger, collection.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("stranger rotated the share link: %v", err)
+	}
+}
+
+func TestCollectionItems(t *testing.T) {
+	repo := newFakeRepository()
+	s := NewService(repo, nil)
+	owner := uuid.New()
+	id := createCollection(t, s, owner, VisibilityPrivate).ID
+
+	order := func() string {
+		ids := make([]string, len(repo.items[id]))
+		for i, item := range repo.items[id] {
+			ids[i] = item.AnimeID
+		}
+		return strings.Join(ids, ",")
+	}
+
+	for _, animeID := range []string{"1", "2", "3"} {
+		if err := s.AddItem(owner, id, animeID, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.AddItem(owner, id, "2", ""); !errors.Is(err, ErrItemExists) {
+		t.Errorf("duplicate anime: %v", err)
+	}
+	if err := s.AddItem(owner, id, "abc", ""); !errors.Is(err, ErrInvalidAnimeID) {
+		t.Errorf("invalid anime ID: %v", err)
+	}
+	if err := s.AddItem(uuid.New(), id, "4", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("item added by a stranger: %v", err)
+	}
+
+	note, first, beyond := "  пересмотреть  ", 1, 100
+	if err := s.UpdateItem(owner, id, "3", &note, &first); err != nil {
+		t.Fatal(err)
+	}
+	if got := order(); got != "3,1,2" || repo.items[id][0].Note != "пересмотреть" {
+		t.Errorf("after moving 3 to the top: %s, note %q", got, repo.items[id][0].Note)
+	}
+	if err := s.UpdateItem(owner, id, "3", nil, &beyond); err != nil {
+		t.Fatal(err)
+	}
+	if got := order(); got != "1,2,3" {
+		t.Errorf("after moving 3 beyond the end: %s", got)
+	}
+
+	for _, ids := range [][]string{{"1", "2"}, {"1", "2", "2"}, {"1", "2", "4"}} {
+		if err := s.Reorder(owner, id, ids); !errors.Is(err, ErrOrderMismatch) {
+			t.Errorf("reorder %v: %v", ids, err)
+		}
+	}
+	if err := s.Reorder(owner, id, []string{"2", "3", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveItem(owner, id, "3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := order(); got != "2,1" {
+		t.Errorf("after reorder and removal: %s", got)
+	}
+	if err := s.RemoveItem(owner, id, "3"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("removing a missing anime: %v", err)
+	}
+}
+
+func TestLikeAndForkRequirePublic(t *testing.T) {
+	repo := newFakeRepository()
+	s := NewService(repo, nil)
+	owner, reader := uuid.New(), uuid.New()
+
+	private := createCollection(t, s, owner, VisibilityUnlisted)
+	if err := s.Like(owner, private.ID); !errors.Is(err, ErrNotPublic) {
+		t.Errorf("owner likes an unlisted collection: %v", err)
+	}
+	if _, err := s.Fork(reader, private.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("stranger forks an unlisted collection: %v", err)
+	}
+
+	public := createCollection(t, s, owner, VisibilityPublic)
+	if err := s.Like(reader, public.ID); err != nil {
+		t.Fatal(err)
+	}
+	if details, err := s.Get(context.Background(), &reader, public.ID); err != nil || !details.LikedByMe {
+		t.Errorf("details for the reader: %+v, %v", details, err)
+	}
+	if err := s.AddItem(owner, public.ID, "1", "заметка"); err != nil {
+		t.Fatal(err)
+	}
+
+	fork, err := s.Fork(reader, public.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.UserID != reader || fork.Visibility != VisibilityPrivate || fork.ForkedFromID == nil || *fork.ForkedFromID != public.ID {
+		t.Errorf("fork %+v", fork)
+	}
+	if fork.ShareToken == public.ShareToken {
+		t.Error("fork shares the source's share link")
+	}
+	if items := repo.items[fork.ID]; len(items) != 1 || items[0].Note != "заметка" {
+		t.Errorf("fork items %+v", items)
+	}
+}
@@ -3,7 +3,7 @@ package comment
 import (
 	"net/http"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/Zipklas/anime-site-backend/internal/auth"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
@@ -30,10 +30,11 @@ func (h *Handler) CreateComment(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	userID, err := getUserIDFromToken(c)
+	principal, err := auth.CurrentUser(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return err
 	}
+	userID := principal.UserID
 
 	comment, err := h.service.CreateComment(c.Request().Context(), animeID, req.Content, userID, req.ParentID)
 	if err != nil {
@@ -56,10 +57,11 @@ func (h *Handler) VoteComment(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	userID, err := getUserIDFromToken(c)
+	principal, err := auth.CurrentUser(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return err
 	}
+	userID := principal.UserID
 
 	if err := h.service.VoteComment(c.Request().Context(), commentID, userID, req.IsUpvote); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
@@ -74,10 +76,11 @@ func (h *Handler) RemoveVote(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
 	}
 
-	userID, err := getUserIDFromToken(c)
+	principal, err := auth.CurrentUser(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return err
 	}
+	userID := principal.UserID
 
 	if err := h.service.RemoveVote(c.Request().Context(), commentID, userID); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
@@ -92,7 +95,11 @@ func (h *Handler) GetComments(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "anime_id is required")
 	}
 
-	userID, _ := getUserIDFromToken(c) // Ошибка не критична - просто не будет user_vote
+	// Маршрут публичный: для анонимов просто не будет user_vote
+	userID := uuid.Nil
+	if principal, ok := auth.FromContext(c); ok {
+		userID = principal.UserID
+	}
 
 	comments, err := h.service.GetComments(c.Request().Context(), animeID, userID)
 	if err != nil {
@@ -108,10 +115,11 @@ func (h *Handler) DeleteComment(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
 	}
 
-	userID, err := getUserIDFromToken(c)
+	principal, err := auth.CurrentUser(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return err
 	}
+	userID := principal.UserID
 
 	if err := h.service.DeleteComment(c.Request().Context(), commentID, userID); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
@@ -120,6 +128,19 @@ func (h *Handler) DeleteComment(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
+func (h *Handler) ModerateDeleteComment(c echo.Context) error {
+	commentID, err := uuid.Parse(c.Param("comment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
+	}
+
+	if err := h.service.ModerateDeleteComment(c.Request().Context(), commentID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
 func (h *Handler) UpdateComment(c echo.Context) error {
 	commentID, err := uuid.Parse(c.Param("comment_id"))
 	if err != nil {
@@ -133,10 +154,11 @@ func (h *Handler) UpdateComment(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	userID, err := getUserIDFromToken(c)
+	principal, err := auth.CurrentUser(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return err
 	}
+	userID := principal.UserID
 
 	if err := h.service.UpdateComment(c.Request().Context(), commentID, userID, req.Content); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
@@ -144,10 +166,3 @@ func (h *Handler) UpdateComment(c echo.Context) error {
 
 	return c.NoContent(http.StatusNoContent)
 }
-
-func getUserIDFromToken(c echo.Context) (uuid.UUID, error) {
-	userToken := c.Get("user").(*jwt.Token)
-	claims := userToken.Claims.(jwt.MapClaims)
-	userIDStr := claims["user_id"].(string)
-	return uuid.Parse(userIDStr)
-}
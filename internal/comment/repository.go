@@ -11,6 +11,7 @@ type Repository interface {
 	Create(comment *Comment) error
 	GetByAnimeID(animeID string, userID uuid.UUID) ([]CommentWithUser, error)
 	Delete(commentID uuid.UUID, userID uuid.UUID) error
+	DeleteByID(commentID uuid.UUID) error
 	Update(comment *Comment) error
 	GetUserVote(commentID uuid.UUID, userID uuid.UUID) (*bool, error)
 	GetVotes(commentID uuid.UUID) (upvotes, downvotes int, err error)
@@ -115,6 +116,11 @@ func (r *repository) Delete(commentID uuid.UUID, userID uuid.UUID) error {
 	return r.db.Where("id = ? AND user_id = ?", commentID, userID).Delete(&Comment{}).Error
 }
 
+// DeleteByID удаляет комментарий независимо от автора (для модераторов)
+func (r *repository) DeleteByID(commentID uuid.UUID) error {
+	return r.db.Where("id = ?", commentID).Delete(&Comment{}).Error
+}
+
 func (r *repository) Update(comment *Comment) error {
 	return r.db.Model(comment).Where("id = ? AND user_id = ?", comment.ID, comment.UserID).Updates(comment).Error
 }
@@ -40,11 +40,13 @@ type KodikResponse struct {
 			Title string `json:"title"`
 			ID    int    `json:"id"`
 		} `json:"translation"`
-		Link      string `json:"link"`
-		Quality   string `json:"quality"`
-		Duration  int    `json:"duration"`
-		Thumbnail string `json:"thumbnail"`
-		Seasons   map[string]struct {
+		Link          string `json:"link"`
+		Quality       string `json:"quality"`
+		LastEpisode   int    `json:"last_episode"`
+		EpisodesCount int    `json:"episodes_count"`
+		Duration      int    `json:"duration"`
+		Thumbnail     string `json:"thumbnail"`
+		Seasons       map[string]struct {
 			Link     string            `json:"link"`
 			Episodes map[string]string `json:"episodes"`
 		} `json:"seasons"`
@@ -175,3 +177,59 @@ func (s *Service) GetVideoByShikimoriID(
 
 	return videos, nil
 }
+
+// TranslationEpisode - последняя вышедшая серия в озвучке.
+type TranslationEpisode struct {
+	TranslationID    int
+	TranslationTitle string
+	LastEpisode      int
+}
+
+// LatestEpisodes возвращает последнюю вышедшую серию каждой озвучки аниме.
+func (s *Service) LatestEpisodes(ctx context.Context, shikimoriID string) ([]TranslationEpisode, error) {
+	query := url.Values{}
+	query.Set("token", s.apiKey)
+	query.Set("shikimori_id", shikimoriID)
+	query.Set("types", "anime-serial")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/search?%s", s.baseURL, query.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kodik search failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kodik search failed: status %d", resp.StatusCode)
+	}
+
+	var apiResponse KodikResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode kodik response: %w", err)
+	}
+
+	// одна озвучка может прийти несколькими результатами (сезоны, качество)
+	latest := make(map[int]*TranslationEpisode)
+	var episodes []TranslationEpisode
+	for _, result := range apiResponse.Results {
+		id := result.Translation.ID
+		if entry, ok := latest[id]; ok {
+			entry.LastEpisode = max(entry.LastEpisode, result.LastEpisode)
+			continue
+		}
+		latest[id] = &TranslationEpisode{
+			TranslationID:    id,
+			TranslationTitle: result.Translation.Title,
+			LastEpisode:      result.LastEpisode,
+		}
+	}
+	for _, entry := range latest {
+		episodes = append(episodes, *entry)
+	}
+	sort.Slice(episodes, func(i, j int) bool {
+		return episodes[i].TranslationID < episodes[j].TranslationID
+	})
+	return episodes, nil
+}
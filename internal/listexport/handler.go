@@ -0,0 +1,56 @@
+package listexport
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+var contentTypes = map[string]string{
+	FormatMAL:  "application/xml; charset=utf-8",
+	FormatCSV:  "text/csv; charset=utf-8",
+	FormatJSON: echo.MIMEApplicationJSONCharsetUTF8,
+}
+
+var fileNames = map[string]string{
+	FormatMAL:  "animelist.xml",
+	FormatCSV:  "animelist.csv",
+	FormatJSON: "animelist.json",
+}
+
+// Export отдает весь список файлом. После начала ответа ошибку уже не
+// вернуть клиенту, поэтому она только логируется.
+func (h *Handler) Export(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = FormatJSON
+	}
+	if !IsValidFormat(format) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": ErrInvalidFormat.Error()})
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, contentTypes[format])
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+fileNames[format]+`"`)
+	res.WriteHeader(http.StatusOK)
+
+	if err := h.service.Export(c.Request().Context(), principal.UserID, format, res); err != nil {
+		log.Printf("Export for user %s failed: %v", principal.UserID, err)
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package listexport
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+)
+
+const (
+	FormatMAL  = "mal"
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+var ErrInvalidFormat = errors.New("invalid format, expected mal, csv or json")
+
+// ListReader - часть user.Service, из которой берется список для экспорта.
+type ListReader interface {
+	GetAnimeList(userID, status string) ([]user.AnimeEntry, error)
+}
+
+type Service interface {
+	Export(ctx context.Context, userID uuid.UUID, format string, w io.Writer) error
+}
+
+type service struct {
+	lists            ListReader
+	shikimoriService *shikimori.Service
+}
+
+func NewService(lists ListReader, shikimoriService *shikimori.Service) Service {
+	return &service{lists: lists, shikimoriService: shikimoriService}
+}
+
+func IsValidFormat(format string) bool {
+	return format == FormatMAL || format == FormatCSV || format == FormatJSON
+}
+
+// Row - запись списка вместе с данными Shikimori, нужными для экспорта.
+type Row struct {
+	user.AnimeEntry
+	MalID         string `json:"mal_id"`
+	Title         string `json:"title"`
+	TitleRussian  string `json:"title_russian"`
+	Kind          string `json:"kind"`
+	EpisodesTotal int    `json:"episodes_total"`
+}
+
+// rowWriter пишет экспорт по частям, чтобы большой список не собирался в памяти.
+type rowWriter interface {
+	begin(entries []user.AnimeEntry) error
+	write(rows []Row) error
+	end() error
+}
+
+// Export пишет весь список пользователя в w. Данные аниме подгружаются из
+// Shikimori пачками; если пачка не загрузилась, записи экспортируются без названий.
+func (s *service) Export(ctx context.Context, userID uuid.UUID, format string, w io.Writer) error {
+	var out rowWriter
+	switch format {
+	case FormatMAL:
+		out = &malWriter{w: w}
+	case FormatCSV:
+		out = &csvWriter{w: csv.NewWriter(w)}
+	case FormatJSON:
+		out = &jsonWriter{w: w}
+	default:
+		return ErrInvalidFormat
+	}
+
+	entries, err := s.lists.GetAnimeList(userID.String(), "")
+	if err != nil {
+		return err
+	}
+	if err := out.begin(entries); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(entries); start += shikimori.MaxIDsPerRequest {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		chunk := entries[start:min(start+shikimori.MaxIDsPerRequest, len(entries))]
+		ids := make([]string, len(chunk))
+		for i, entry := range chunk {
+			ids[i] = entry.AnimeID
+		}
+		found, _ := s.shikimoriService.FetchAnimesByIDs(ctx, ids)
+
+		rows := make([]Row, len(chunk))
+		for i, entry := range chunk {
+			rows[i] = Row{AnimeEntry: entry}
+			if anime, ok := found[entry.AnimeID]; ok {
+				rows[i].MalID = anime.MalID
+				rows[i].Title = anime.Name
+				rows[i].TitleRussian = anime.Russian
+				rows[i].Kind = anime.Kind
+				rows[i].EpisodesTotal = anime.Episodes
+			}
+		}
+		if err := out.write(rows); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return out.end()
+}
+
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// MAL XML в формате их собственного экспорта, его принимают MAL и Shikimori.
+
+var malStatuses = map[string]string{
+	user.StatusWatching:  "Watching",
+	user.StatusCompleted: "Completed",
+	user.StatusOnHold:    "On-Hold",
+	user.StatusDropped:   "Dropped",
+	user.StatusPlanned:   "Plan to Watch",
+}
+
+var malTypes = map[string]string{
+	"tv":         "TV",
+	"tv_special": "Special",
+	"special":    "Special",
+	"movie":      "Movie",
+	"ova":        "OVA",
+	"ona":        "ONA",
+	"music":      "Music",
+}
+
+type malCDATA struct {
+	Value string `xml:",cdata"`
+}
+
+type malAnime struct {
+	XMLName         xml.Name `xml:"anime"`
+	SeriesID        string   `xml:"series_animedb_id"`
+	SeriesTitle     malCDATA `xml:"series_title"`
+	SeriesType      string   `xml:"series_type"`
+	SeriesEpisodes  int      `xml:"series_episodes"`
+	MyID            int      `xml:"my_id"`
+	WatchedEpisodes int      `xml:"my_watched_episodes"`
+	StartDate       string   `xml:"my_start_date"`
+	FinishDate      string   `xml:"my_finish_date"`
+	Rated           string   `xml:"my_rated"`
+	Score           int      `xml:"my_score"`
+	Storage         string   `xml:"my_storage"`
+	StorageValue    string   `xml:"my_storage_value"`
+	Status          string   `xml:"my_status"`
+	Comments        malCDATA `xml:"my_comments"`
+	TimesWatched    int      `xml:"my_times_watched"`
+	RewatchValue    string   `xml:"my_rewatch_value"`
+	Priority        string   `xml:"my_priority"`
+	Tags            malCDATA `xml:"my_tags"`
+	Rewatching      int      `xml:"my_rewatching"`
+	RewatchingEp    int      `xml:"my_rewatching_ep"`
+	Discuss         int      `xml:"my_discuss"`
+	SNS             string   `xml:"my_sns"`
+	UpdateOnImport  int      `xml:"update_on_import"`
+}
+
+type malInfo struct {
+	XMLName     xml.Name `xml:"myinfo"`
+	ExportType  int      `xml:"user_export_type"`
+	Total       int      `xml:"user_total_anime"`
+	Watching    int      `xml:"user_total_watching"`
+	Completed   int      `xml:"user_total_completed"`
+	OnHold      int      `xml:"user_total_onhold"`
+	Dropped     int      `xml:"user_total_dropped"`
+	PlanToWatch int      `xml:"user_total_plantowatch"`
+}
+
+type malWriter struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+func (m *malWriter) begin(entries []user.AnimeEntry) error {
+	info := malInfo{ExportType: 1, Total: len(entries)}
+	for _, entry := range entries {
+		switch entry.Status {
+		case user.StatusWatching:
+			info.Watching++
+		case user.StatusCompleted:
+			info.Completed++
+		case user.StatusOnHold:
+			info.OnHold++
+		case user.StatusDropped:
+			info.Dropped++
+		case user.StatusPlanned:
+			info.PlanToWatch++
+		}
+	}
+
+	if _, err := io.WriteString(m.w, xml.Header+"<myanimelist>\n"); err != nil {
+		return err
+	}
+	m.enc = xml.NewEncoder(m.w)
+	m.enc.Indent("\t", "\t")
+	if err := m.enc.Encode(info); err != nil {
+		return err
+	}
+	return m.enc.Flush()
+}
+
+func (m *malWriter) write(rows []Row) error {
+	for _, row := range rows {
+		id := row.MalID
+		if id == "" {
+			id = row.AnimeID
+		}
+		kind, ok := malTypes[row.Kind]
+		if !ok {
+			kind = "Unknown"
+		}
+		score := 0
+		if row.Score != nil {
+			score = *row.Score
+		}
+		startDate, finishDate := formatDate(row.StartedAt), formatDate(row.FinishedAt)
+		if startDate == "" {
+			startDate = "0000-00-00"
+		}
+		if finishDate == "" {
+			finishDate = "0000-00-00"
+		}
+
+		if err := m.enc.Encode(malAnime{
+			SeriesID:        id,
+			SeriesTitle:     malCDATA{row.Title},
+			SeriesType:      kind,
+			SeriesEpisodes:  row.EpisodesTotal,
+			WatchedEpisodes: row.EpisodesWatched,
+			StartDate:       startDate,
+			FinishDate:      finishDate,
+			Score:           score,
+			StorageValue:    "0.00",
+			Status:          malStatuses[row.Status],
+			TimesWatched:    row.RewatchCount,
+			Priority:        "LOW",
+			Discuss:         1,
+			SNS:             "default",
+			UpdateOnImport:  1,
+		}); err != nil {
+			return err
+		}
+	}
+	return m.enc.Flush()
+}
+
+func (m *malWriter) end() error {
+	_, err := io.WriteString(m.w, "\n</myanimelist>\n")
+	return err
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) begin([]user.AnimeEntry) error {
+	return c.w.Write([]string{
+		"anime_id", "mal_id", "title", "title_russian", "kind", "episodes_total",
+		"status", "score", "episodes_watched", "rewatch_count", "is_favorite",
+		"started_at", "finished_at", "created_at", "updated_at",
+	})
+}
+
+func (c *csvWriter) write(rows []Row) error {
+	for _, row := range rows {
+		score := ""
+		if row.Score != nil {
+			score = strconv.Itoa(*row.Score)
+		}
+		if err := c.w.Write([]string{
+			row.AnimeID, row.MalID, row.Title, row.TitleRussian, row.Kind, strconv.Itoa(row.EpisodesTotal),
+			row.Status, score, strconv.Itoa(row.EpisodesWatched), strconv.Itoa(row.RewatchCount), strconv.FormatBool(row.IsFavorite),
+			formatDate(row.StartedAt), formatDate(row.FinishedAt), row.CreatedAt.Format(time.RFC3339), row.UpdatedAt.Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) end() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter пишет массив Row по одному элементу.
+type jsonWriter struct {
+	w       io.Writer
+	written int
+}
+
+func (j *jsonWriter) begin([]user.AnimeEntry) error {
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *jsonWriter) write(rows []Row) error {
+	for _, row := range rows {
+		data, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		sep := ",\n"
+		if j.written == 0 {
+			sep = "\n"
+		}
+		if _, err := fmt.Fprintf(j.w, "%s%s", sep, data); err != nil {
+			return err
+		}
+		j.written++
+	}
+	return nil
+}
+
+func (j *jsonWriter) end() error {
+	_, err := io.WriteString(j.w, "\n]\n")
+	return err
+}
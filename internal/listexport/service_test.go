@@ -0,0 +1,147 @@
+package listexport
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/listimport"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+)
+
+type fakeLists []user.AnimeEntry
+
+func (l fakeLists) GetAnimeList(userID, status string) ([]user.AnimeEntry, error) {
+	return l, nil
+}
+
+func testRows() []Row {
+	score := 9
+	started := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	finished := time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)
+	return []Row{
+		{
+			AnimeEntry: user.AnimeEntry{AnimeID: "5114", Status: user.StatusCompleted, Score: &score,
+				EpisodesWatched: 64, RewatchCount: 1, StartedAt: &started, FinishedAt: &finished},
+			MalID: "5114", Title: "Fullmetal Alchemist: Brotherhood", Kind: "tv", EpisodesTotal: 64,
+		},
+		// без данных Shikimori: ID сайта вместо ID MAL, даты и оценка пустые
+		{AnimeEntry: user.AnimeEntry{AnimeID: "z9253", Status: user.StatusPlanned}},
+	}
+}
+
+// writeAll прогоняет строки через writer так же, как Export: по одной пачке.
+func writeAll(t *testing.T, out rowWriter, rows []Row) {
+	t.Helper()
+	entries := make([]user.AnimeEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = row.AnimeEntry
+	}
+	if err := out.begin(entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := out.write([]Row{row}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := out.end(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMALExportIsImportable(t *testing.T) {
+	var buf bytes.Buffer
+	writeAll(t, &malWriter{w: &buf}, testRows())
+
+	items, err := listimport.Parse(listimport.SourceMAL, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	finished := time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)
+	want := []listimport.Item{
+		{SourceID: "5114", Title: "Fullmetal Alchemist: Brotherhood", Status: user.StatusCompleted, Score: 9,
+			Episodes: 64, Rewatches: 1, StartedAt: &started, FinishedAt: &finished},
+		{SourceID: "z9253", Status: user.StatusPlanned},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("imported\n%+v\nwant\n%+v", items, want)
+	}
+}
+
+func TestCSVExport(t *testing.T) {
+	var buf bytes.Buffer
+	writeAll(t, &csvWriter{w: csv.NewWriter(&buf)}, testRows())
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("%d records, want a header and 2 rows", len(records))
+	}
+	header := records[0]
+	column := func(record []string, name string) string {
+		for i, h := range header {
+			if h == name {
+				return record[i]
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return ""
+	}
+	for name, want := range map[string]string{
+		"anime_id": "5114", "title": "Fullmetal Alchemist: Brotherhood", "score": "9",
+		"episodes_watched": "64", "started_at": "2024-01-02", "finished_at": "2024-02-03",
+	} {
+		if got := column(records[1], name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if score, started := column(records[2], "score"), column(records[2], "started_at"); score != "" || started != "" {
+		t.Errorf("empty score and date exported as %q and %q", score, started)
+	}
+}
+
+func TestJSONExport(t *testing.T) {
+	for _, rows := range [][]Row{testRows(), nil} {
+		var buf bytes.Buffer
+		writeAll(t, &jsonWriter{w: &buf}, rows)
+
+		var decoded []Row
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatalf("%d rows: %v\n%s", len(rows), err, buf.String())
+		}
+		if len(decoded) != len(rows) {
+			t.Fatalf("decoded %d rows, want %d", len(decoded), len(rows))
+		}
+		for i := range rows {
+			if decoded[i].AnimeID != rows[i].AnimeID || decoded[i].Title != rows[i].Title || decoded[i].Status != rows[i].Status {
+				t.Errorf("row %d: %+v, want %+v", i, decoded[i], rows[i])
+			}
+		}
+	}
+}
+
+func TestExportFormat(t *testing.T) {
+	s := NewService(fakeLists{}, nil)
+
+	if err := s.Export(context.Background(), uuid.New(), "xlsx", &bytes.Buffer{}); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("unknown format: %v", err)
+	}
+	// пустой список экспортируется без запросов к Shikimori
+	var buf bytes.Buffer
+	if err := s.Export(context.Background(), uuid.New(), FormatJSON, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "[\n]\n" {
+		t.Errorf("empty export = %q", got)
+	}
+}
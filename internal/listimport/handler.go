@@ -0,0 +1,92 @@
+package listimport
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+const maxUploadSize = 10 << 20
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// StartImport принимает multipart-форму: file, source (mal|shikimori), policy.
+func (h *Handler) StartImport(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "export file is required"})
+	}
+	if file.Size > maxUploadSize {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "file too large, max 10MB"})
+	}
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed to open file"})
+	}
+	defer src.Close()
+
+	job, err := h.service.StartImport(principal.UserID, c.FormValue("source"), c.FormValue("policy"), src)
+	if err != nil {
+		return importError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func (h *Handler) GetJob(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	jobID, err := uuid.Parse(c.Param("job_id"))
+	if err != nil {
+		return importError(c, ErrJobNotFound)
+	}
+
+	job, err := h.service.GetJob(principal.UserID, jobID)
+	if err != nil {
+		return importError(c, err)
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) ListJobs(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	jobs, err := h.service.ListJobs(principal.UserID)
+	if err != nil {
+		return importError(c, err)
+	}
+	return c.JSON(http.StatusOK, jobs)
+}
+
+func importError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrImportInProgress):
+		status = http.StatusConflict
+	case errors.Is(err, ErrInvalidSource), errors.Is(err, ErrInvalidPolicy), errors.Is(err, ErrInvalidExport),
+		errors.Is(err, ErrTooManyItems):
+		status = http.StatusBadRequest
+	}
+	return c.JSON(status, echo.Map{"error": err.Error()})
+}
@@ -0,0 +1,73 @@
+package listimport
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Источники экспорта
+const (
+	SourceMAL       = "mal"       // XML-экспорт MyAnimeList, можно в .gz
+	SourceShikimori = "shikimori" // JSON-экспорт списка Shikimori
+)
+
+// Политики при совпадении с уже существующей записью списка
+const (
+	PolicySkip      = "skip"      // существующая запись не меняется
+	PolicyOverwrite = "overwrite" // поля из экспорта заменяют текущие
+	PolicyMerge     = "merge"     // заполняются только пустые поля, счетчики берутся максимальные
+)
+
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Job - фоновая задача импорта. Разобранный экспорт лежит в Payload до
+// окончания задачи, поэтому после перезапуска сервера она продолжится.
+type Job struct {
+	ID     uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID uuid.UUID `gorm:"type:uuid;index" json:"-"`
+	Source string    `gorm:"size:16" json:"source"`
+	Policy string    `gorm:"size:16" json:"policy"`
+	Status string    `gorm:"size:16;index" json:"status"`
+
+	Total     int `json:"total"`
+	Processed int `json:"processed"`
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Skipped   int `json:"skipped"`
+	Unmatched int `json:"unmatched"` // не нашлись на Shikimori или с неизвестным статусом
+	Failed    int `json:"failed"`
+
+	// первые maxReportedIDs ID из экспорта, которые не удалось импортировать
+	UnmatchedIDs pq.StringArray `gorm:"type:text[]" json:"unmatched_ids"`
+	Error        string         `json:"error,omitempty"`
+	Payload      []byte         `gorm:"type:jsonb" json:"-"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func (Job) TableName() string {
+	return "list_import_jobs"
+}
+
+// Item - одна запись экспорта, приведенная к статусам нашего списка.
+// SourceID - ID аниме в системе источника (для MAL это MAL ID).
+type Item struct {
+	SourceID   string     `json:"source_id"`
+	Title      string     `json:"title,omitempty"`
+	Status     string     `json:"status"` // пусто, если статус не распознан
+	Score      int        `json:"score,omitempty"`
+	Episodes   int        `json:"episodes,omitempty"`
+	Rewatches  int        `json:"rewatches,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,187 @@
+package listimport
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/user"
+)
+
+// maxExportSize ограничивает экспорт после распаковки: лимит загрузки
+// действует только на сжатый файл.
+const maxExportSize = 64 << 20
+
+var (
+	ErrInvalidExport = errors.New("invalid export file")
+
+	errExportTooLarge = errors.New("export is larger than 64MB")
+)
+
+// Parse разбирает экспорт источника source. Сжатые gzip файлы
+// распаковываются автоматически.
+func Parse(source string, r io.Reader) ([]Item, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	r = &sizeLimitReader{r: r, n: maxExportSize}
+
+	var (
+		items []Item
+		err   error
+	)
+	switch source {
+	case SourceMAL:
+		items, err = parseMAL(r)
+	case SourceShikimori:
+		items, err = parseShikimori(r)
+	default:
+		return nil, ErrInvalidSource
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: no anime found", ErrInvalidExport)
+	}
+	return items, nil
+}
+
+type malExport struct {
+	Anime []struct {
+		ID           string `xml:"series_animedb_id"`
+		Title        string `xml:"series_title"`
+		Episodes     int    `xml:"my_watched_episodes"`
+		StartDate    string `xml:"my_start_date"`
+		FinishDate   string `xml:"my_finish_date"`
+		Score        int    `xml:"my_score"`
+		Status       string `xml:"my_status"`
+		TimesWatched int    `xml:"my_times_watched"`
+	} `xml:"anime"`
+}
+
+// статусы MAL встречаются и текстом, и числовыми кодами
+var malStatuses = map[string]string{
+	"watching":      user.StatusWatching,
+	"1":             user.StatusWatching,
+	"completed":     user.StatusCompleted,
+	"2":             user.StatusCompleted,
+	"on-hold":       user.StatusOnHold,
+	"3":             user.StatusOnHold,
+	"dropped":       user.StatusDropped,
+	"4":             user.StatusDropped,
+	"plan to watch": user.StatusPlanned,
+	"6":             user.StatusPlanned,
+}
+
+func parseMAL(r io.Reader) ([]Item, error) {
+	var export malExport
+	if err := xml.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(export.Anime))
+	for _, a := range export.Anime {
+		items = append(items, Item{
+			SourceID:   strings.TrimSpace(a.ID),
+			Title:      strings.TrimSpace(a.Title),
+			Status:     malStatuses[strings.ToLower(strings.TrimSpace(a.Status))],
+			Score:      a.Score,
+			Episodes:   a.Episodes,
+			Rewatches:  a.TimesWatched,
+			StartedAt:  parseDate(a.StartDate),
+			FinishedAt: parseDate(a.FinishDate),
+		})
+	}
+	return items, nil
+}
+
+type shikimoriRate struct {
+	TargetID    int64  `json:"target_id"`
+	TargetTitle string `json:"target_title"`
+	TargetType  string `json:"target_type"`
+	Score       int    `json:"score"`
+	Status      string `json:"status"`
+	Rewatches   int    `json:"rewatches"`
+	Episodes    int    `json:"episodes"`
+}
+
+var shikimoriStatuses = map[string]string{
+	"planned":    user.StatusPlanned,
+	"watching":   user.StatusWatching,
+	"rewatching": user.StatusWatching,
+	"completed":  user.StatusCompleted,
+	"on_hold":    user.StatusOnHold,
+	"dropped":    user.StatusDropped,
+}
+
+func parseShikimori(r io.Reader) ([]Item, error) {
+	var rates []shikimoriRate
+	if err := json.NewDecoder(r).Decode(&rates); err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(rates))
+	for _, rate := range rates {
+		// в экспорт аниме манга не попадает, но target_type проверяем на всякий случай
+		if rate.TargetType != "" && rate.TargetType != "Anime" {
+			continue
+		}
+		items = append(items, Item{
+			SourceID:  strconv.FormatInt(rate.TargetID, 10),
+			Title:     rate.TargetTitle,
+			Status:    shikimoriStatuses[rate.Status],
+			Score:     rate.Score,
+			Episodes:  rate.Episodes,
+			Rewatches: rate.Rewatches,
+		})
+	}
+	return items, nil
+}
+
+// sizeLimitReader, в отличие от io.LimitReader, не обрезает поток молча, а
+// возвращает errExportTooLarge: обрезанный файл иначе выглядит просто битым.
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		var probe [1]byte
+		if n, _ := l.r.Read(probe[:]); n > 0 {
+			return 0, errExportTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// parseDate понимает YYYY-MM-DD; пустые и нулевые даты MAL (0000-00-00) дают nil.
+func parseDate(value string) *time.Time {
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(value))
+	if err != nil {
+		return nil
+	}
+	return &date
+}
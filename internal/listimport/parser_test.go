@@ -0,0 +1,126 @@
+package listimport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/user"
+)
+
+const malExportXML = `<?xml version="1.0" encoding="UTF-8" ?>
+<myanimelist>
+	<anime>
+		<series_animedb_id>5114</series_animedb_id>
+		<series_title><![CDATA[Fullmetal Alchemist: Brotherhood]]></series_title>
+		<my_watched_episodes>64</my_watched_episodes>
+		<my_start_date>2020-01-02</my_start_date>
+		<my_finish_date>2020-02-03</my_finish_date>
+		<my_score>10</my_score>
+		<my_status>Completed</my_status>
+		<my_times_watched>1</my_times_watched>
+	</anime>
+	<anime>
+		<series_animedb_id> 9253 </series_animedb_id>
+		<series_title>Steins;Gate</series_title>
+		<my_watched_episodes>3</my_watched_episodes>
+		<my_start_date>0000-00-00</my_start_date>
+		<my_finish_date>0000-00-00</my_finish_date>
+		<my_score>0</my_score>
+		<my_status>1</my_status>
+		<my_times_watched>0</my_times_watched>
+	</anime>
+	<anime>
+		<series_animedb_id>1</series_animedb_id>
+		<my_status>Rewatching someday</my_status>
+	</anime>
+</myanimelist>`
+
+const shikimoriExportJSON = `[
+	{"target_id": 5114, "target_title": "Fullmetal Alchemist: Brotherhood", "target_type": "Anime",
+	 "score": 10, "status": "completed", "rewatches": 1, "episodes": 64},
+	{"target_id": 9253, "target_title": "Steins;Gate", "score": 0, "status": "rewatching", "episodes": 3},
+	{"target_id": 2, "target_title": "Berserk", "target_type": "Manga", "status": "watching"}
+]`
+
+func date(value string) *time.Time {
+	d, _ := time.Parse("2006-01-02", value)
+	return &d
+}
+
+func gzipped(t *testing.T, data string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(gz, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestParse(t *testing.T) {
+	malItems := []Item{
+		{SourceID: "5114", Title: "Fullmetal Alchemist: Brotherhood", Status: user.StatusCompleted, Score: 10,
+			Episodes: 64, Rewatches: 1, StartedAt: date("2020-01-02"), FinishedAt: date("2020-02-03")},
+		{SourceID: "9253", Title: "Steins;Gate", Status: user.StatusWatching, Episodes: 3},
+		{SourceID: "1"},
+	}
+	shikimoriItems := []Item{
+		{SourceID: "5114", Title: "Fullmetal Alchemist: Brotherhood", Status: user.StatusCompleted, Score: 10,
+			Episodes: 64, Rewatches: 1},
+		{SourceID: "9253", Title: "Steins;Gate", Status: user.StatusWatching, Episodes: 3},
+	}
+
+	for _, tc := range []struct {
+		name, source, data string
+		want               []Item
+		err                error
+	}{
+		{"mal", SourceMAL, malExportXML, malItems, nil},
+		{"mal gzip", SourceMAL, gzipped(t, malExportXML), malItems, nil},
+		{"shikimori", SourceShikimori, shikimoriExportJSON, shikimoriItems, nil},
+		{"shikimori gzip", SourceShikimori, gzipped(t, shikimoriExportJSON), shikimoriItems, nil},
+		{"mal without anime", SourceMAL, `<myanimelist></myanimelist>`, nil, ErrInvalidExport},
+		{"shikimori without anime", SourceShikimori, `[]`, nil, ErrInvalidExport},
+		{"broken xml", SourceMAL, `<myanimelist><anime>`, nil, ErrInvalidExport},
+		{"json for mal", SourceMAL, shikimoriExportJSON, nil, ErrInvalidExport},
+		{"broken gzip", SourceShikimori, "\x1f\x8bnot gzip", nil, ErrInvalidExport},
+		{"unknown source", "anilist", shikimoriExportJSON, nil, ErrInvalidSource},
+	} {
+		items, err := Parse(tc.source, strings.NewReader(tc.data))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: error %v, want %v", tc.name, err, tc.err)
+			continue
+		}
+		if !reflect.DeepEqual(items, tc.want) {
+			t.Errorf("%s: items\n%+v\nwant\n%+v", tc.name, items, tc.want)
+		}
+	}
+}
+
+func TestParseLimitsDecompressedSize(t *testing.T) {
+	// Пробелы JSON допускает, так что без лимита файл разобрался бы целиком
+	bomb := gzipped(t, strings.Repeat(" ", maxExportSize)+shikimoriExportJSON)
+	if len(bomb) > maxUploadSize {
+		t.Fatalf("compressed export is %d bytes, above the upload limit", len(bomb))
+	}
+
+	_, err := Parse(SourceShikimori, strings.NewReader(bomb))
+	if !errors.Is(err, ErrInvalidExport) || !strings.Contains(err.Error(), errExportTooLarge.Error()) {
+		t.Fatalf("got %v, want %v", err, errExportTooLarge)
+	}
+
+	// ровно на лимите файл еще принимается
+	exact := strings.Repeat(" ", maxExportSize-len(shikimoriExportJSON)) + shikimoriExportJSON
+	if _, err := Parse(SourceShikimori, strings.NewReader(gzipped(t, exact))); err != nil {
+		t.Fatalf("export of exactly %d bytes: %v", maxExportSize, err)
+	}
+}
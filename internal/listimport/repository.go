@@ -0,0 +1,96 @@
+package listimport
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	Create(job *Job) error
+	FindByID(userID, jobID uuid.UUID) (*Job, error)
+	FindByUser(userID uuid.UUID, limit int) ([]Job, error)
+	HasActive(userID uuid.UUID) (bool, error)
+	ClaimNext() (*Job, error)
+	RequeueStale(olderThan time.Time) (int64, error)
+	SaveProgress(job *Job) error
+	Finish(job *Job) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) Create(job *Job) error {
+	return r.db.Create(job).Error
+}
+
+func (r *repository) FindByID(userID, jobID uuid.UUID) (*Job, error) {
+	var job Job
+	if err := r.db.Omit("payload").First(&job, "id = ? AND user_id = ?", jobID, userID).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *repository) FindByUser(userID uuid.UUID, limit int) ([]Job, error) {
+	var jobs []Job
+	err := r.db.Omit("payload").Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+func (r *repository) HasActive(userID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.Model(&Job{}).Where("user_id = ? AND status IN ?", userID, []string{StatusQueued, StatusRunning}).Count(&count).Error
+	return count > 0, err
+}
+
+// ClaimNext забирает самую старую задачу из очереди. SKIP LOCKED позволяет
+// нескольким экземплярам сервера разбирать очередь, не мешая друг другу.
+func (r *repository) ClaimNext() (*Job, error) {
+	var job Job
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", StatusQueued).Order("created_at").First(&job).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		job.Status = StatusRunning
+		job.StartedAt = &now
+		return tx.Model(&job).Updates(map[string]interface{}{"status": job.Status, "started_at": now}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RequeueStale возвращает в очередь задачи, которые давно не обновлялись,
+// например, прерванные перезапуском сервера.
+func (r *repository) RequeueStale(olderThan time.Time) (int64, error) {
+	res := r.db.Model(&Job{}).Where("status = ? AND updated_at < ?", StatusRunning, olderThan).
+		Update("status", StatusQueued)
+	return res.RowsAffected, res.Error
+}
+
+func (r *repository) SaveProgress(job *Job) error {
+	return r.db.Model(job).Select("processed", "created", "updated", "skipped", "unmatched", "failed", "unmatched_ids", "updated_at").
+		Updates(job).Error
+}
+
+// Finish сохраняет итог задачи и удаляет разобранный экспорт.
+func (r *repository) Finish(job *Job) error {
+	job.Payload = nil
+	return r.db.Model(job).Select("status", "total", "processed", "created", "updated", "skipped", "unmatched", "failed",
+		"unmatched_ids", "error", "payload", "finished_at", "updated_at").Updates(job).Error
+}
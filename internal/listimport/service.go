@@ -0,0 +1,327 @@
+package listimport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxItems          = 20000
+	maxReportedIDs    = 100
+	progressEvery     = 50
+	pollInterval      = 30 * time.Second
+	staleJobThreshold = 10 * time.Minute
+	listedJobs        = 20
+)
+
+var (
+	ErrInvalidSource    = errors.New("invalid source, expected mal or shikimori")
+	ErrInvalidPolicy    = errors.New("invalid policy, expected skip, overwrite or merge")
+	ErrTooManyItems     = fmt.Errorf("export is too large, max %d anime", maxItems)
+	ErrImportInProgress = errors.New("another import is already in progress")
+	ErrJobNotFound      = errors.New("import job not found")
+)
+
+// ListWriter - часть user.Service, через которую импорт меняет список.
+type ListWriter interface {
+	ApplyAnimeEntry(userID uuid.UUID, animeID string, fn func(current *user.AnimeEntry) (*user.AnimeEntry, error)) (before, after *user.AnimeEntry, err error)
+}
+
+type Service interface {
+	StartImport(userID uuid.UUID, source, policy string, r io.Reader) (*Job, error)
+	GetJob(userID, jobID uuid.UUID) (*Job, error)
+	ListJobs(userID uuid.UUID) ([]Job, error)
+	Run(ctx context.Context)
+}
+
+type service struct {
+	repo             Repository
+	lists            ListWriter
+	shikimoriService *shikimori.Service
+	wake             chan struct{}
+}
+
+func NewService(repo Repository, lists ListWriter, shikimoriService *shikimori.Service) Service {
+	return &service{
+		repo:             repo,
+		lists:            lists,
+		shikimoriService: shikimoriService,
+		wake:             make(chan struct{}, 1),
+	}
+}
+
+// StartImport разбирает экспорт сразу, чтобы ошибки формата вернулись в
+// ответе, и ставит задачу в очередь.
+func (s *service) StartImport(userID uuid.UUID, source, policy string, r io.Reader) (*Job, error) {
+	if source != SourceMAL && source != SourceShikimori {
+		return nil, ErrInvalidSource
+	}
+	if policy == "" {
+		policy = PolicyMerge
+	}
+	if policy != PolicySkip && policy != PolicyOverwrite && policy != PolicyMerge {
+		return nil, ErrInvalidPolicy
+	}
+
+	active, err := s.repo.HasActive(userID)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		return nil, ErrImportInProgress
+	}
+
+	items, err := Parse(source, r)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) > maxItems {
+		return nil, ErrTooManyItems
+	}
+	payload, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:           uuid.New(),
+		UserID:       userID,
+		Source:       source,
+		Policy:       policy,
+		Status:       StatusQueued,
+		Total:        len(items),
+		UnmatchedIDs: []string{},
+		Payload:      payload,
+	}
+	if err := s.repo.Create(job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+func (s *service) GetJob(userID, jobID uuid.UUID) (*Job, error) {
+	job, err := s.repo.FindByID(userID, jobID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+func (s *service) ListJobs(userID uuid.UUID) ([]Job, error) {
+	jobs, err := s.repo.FindByUser(userID, listedJobs)
+	if err != nil {
+		return nil, err
+	}
+	if jobs == nil {
+		jobs = []Job{}
+	}
+	return jobs, nil
+}
+
+// Run - воркер импорта, работает до отмены ctx. Задачи выполняются по одной.
+func (s *service) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.repo.RequeueStale(time.Now().Add(-staleJobThreshold)); err != nil {
+			log.Printf("list import: failed to requeue stale jobs: %v", err)
+		} else if n > 0 {
+			log.Printf("list import: requeued %d stale jobs", n)
+		}
+
+		for ctx.Err() == nil {
+			job, err := s.repo.ClaimNext()
+			if err != nil {
+				log.Printf("list import: failed to claim job: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			s.process(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *service) process(ctx context.Context, job *Job) {
+	var items []Item
+	if err := json.Unmarshal(job.Payload, &items); err != nil {
+		s.finish(job, err)
+		return
+	}
+
+	// Статистику считаем заново: задача могла быть прервана и перезапущена
+	job.Processed, job.Created, job.Updated, job.Skipped, job.Unmatched, job.Failed = 0, 0, 0, 0, 0, 0
+	job.UnmatchedIDs = []string{}
+
+	animeIDs, err := s.resolveAnimeIDs(ctx, job.Source, items)
+	if err != nil {
+		s.finish(job, err)
+		return
+	}
+
+	for i, item := range items {
+		if ctx.Err() != nil {
+			// задача останется running и будет перезапущена как зависшая
+			return
+		}
+
+		animeID, ok := animeIDs[item.SourceID]
+		if !ok || item.Status == "" {
+			job.Unmatched++
+			if len(job.UnmatchedIDs) < maxReportedIDs {
+				job.UnmatchedIDs = append(job.UnmatchedIDs, item.SourceID)
+			}
+		} else {
+			s.importItem(job, animeID, item)
+		}
+
+		job.Processed = i + 1
+		if job.Processed%progressEvery == 0 {
+			if err := s.repo.SaveProgress(job); err != nil {
+				log.Printf("list import %s: failed to save progress: %v", job.ID, err)
+			}
+		}
+	}
+	s.finish(job, nil)
+}
+
+// resolveAnimeIDs сопоставляет ID источника с ID Shikimori. Экспорт Shikimori
+// уже содержит их ID, MAL ID проверяются по полю malId аниме на Shikimori.
+func (s *service) resolveAnimeIDs(ctx context.Context, source string, items []Item) (map[string]string, error) {
+	ids := make(map[string]string, len(items))
+	if source == SourceShikimori {
+		for _, item := range items {
+			ids[item.SourceID] = item.SourceID
+		}
+		return ids, nil
+	}
+
+	malIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		malIDs = append(malIDs, item.SourceID)
+	}
+	// ID аниме на Shikimori обычно совпадают с MAL, поэтому ищем по ним же
+	found, failed := s.shikimoriService.FetchAnimesByIDs(ctx, malIDs)
+	if len(found) == 0 && len(failed) > 0 {
+		return nil, errors.New("shikimori is unavailable, try again later")
+	}
+	for _, anime := range found {
+		if anime.MalID != "" {
+			ids[anime.MalID] = anime.ID
+		}
+	}
+	return ids, nil
+}
+
+func (s *service) importItem(job *Job, animeID string, item Item) {
+	before, after, err := s.lists.ApplyAnimeEntry(job.UserID, animeID, func(current *user.AnimeEntry) (*user.AnimeEntry, error) {
+		if current == nil {
+			entry := &user.AnimeEntry{}
+			applyItem(entry, item, true)
+			return entry, nil
+		}
+		switch job.Policy {
+		case PolicySkip:
+			return current, nil
+		case PolicyOverwrite:
+			applyItem(current, item, true)
+		default:
+			applyItem(current, item, false)
+		}
+		return current, nil
+	})
+	switch {
+	case err != nil:
+		log.Printf("list import %s: failed to import anime %s: %v", job.ID, animeID, err)
+		job.Failed++
+	case before == nil:
+		job.Created++
+	case job.Policy == PolicySkip || sameEntry(before, after):
+		job.Skipped++
+	default:
+		job.Updated++
+	}
+}
+
+// applyItem переносит поля экспорта в запись. Без overwrite заполняются
+// только пустые поля, а счетчики серий и пересмотров берутся максимальные.
+func applyItem(entry *user.AnimeEntry, item Item, overwrite bool) {
+	score := item.Score
+	if score < 1 || score > 10 {
+		score = 0
+	}
+	episodes := max(item.Episodes, 0)
+	rewatches := max(item.Rewatches, 0)
+
+	if overwrite {
+		entry.Status = item.Status
+		entry.Score = nil
+		if score > 0 {
+			entry.Score = &score
+		}
+		entry.EpisodesWatched = episodes
+		entry.RewatchCount = rewatches
+		entry.StartedAt = item.StartedAt
+		entry.FinishedAt = item.FinishedAt
+		return
+	}
+
+	if entry.Score == nil && score > 0 {
+		entry.Score = &score
+	}
+	entry.EpisodesWatched = max(entry.EpisodesWatched, episodes)
+	entry.RewatchCount = max(entry.RewatchCount, rewatches)
+	if entry.StartedAt == nil {
+		entry.StartedAt = item.StartedAt
+	}
+	if entry.FinishedAt == nil {
+		entry.FinishedAt = item.FinishedAt
+	}
+}
+
+func sameEntry(a, b *user.AnimeEntry) bool {
+	sameDate := func(x, y *time.Time) bool {
+		return (x == nil && y == nil) || (x != nil && y != nil && x.Equal(*y))
+	}
+	sameScore := (a.Score == nil && b.Score == nil) || (a.Score != nil && b.Score != nil && *a.Score == *b.Score)
+	return a.Status == b.Status && sameScore && a.EpisodesWatched == b.EpisodesWatched &&
+		a.RewatchCount == b.RewatchCount && sameDate(a.StartedAt, b.StartedAt) && sameDate(a.FinishedAt, b.FinishedAt)
+}
+
+func (s *service) finish(job *Job, err error) {
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = StatusDone
+	if err != nil {
+		log.Printf("list import %s failed: %v", job.ID, err)
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	}
+	if err := s.repo.Finish(job); err != nil {
+		log.Printf("list import %s: failed to save result: %v", job.ID, err)
+	}
+}
@@ -0,0 +1,168 @@
+package lockout
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// cleanupInterval - как часто Run удаляет устаревшие счетчики.
+const cleanupInterval = 15 * time.Minute
+
+// Policy описывает, сколько ошибок прощается и как растет задержка после них.
+type Policy struct {
+	FreeAttempts     int           // ошибок без задержки
+	BaseDelay        time.Duration // задержка после первой "платной" ошибки, дальше удваивается
+	MaxDelay         time.Duration
+	LockoutThreshold int // после стольких ошибок ключ блокируется на LockoutDuration
+	LockoutDuration  time.Duration
+	Window           time.Duration // через столько времени без ошибок счетчик сбрасывается
+}
+
+var (
+	DefaultAccountPolicy = Policy{
+		FreeAttempts:     3,
+		BaseDelay:        2 * time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  30 * time.Minute,
+		Window:           time.Hour,
+	}
+	// По IP прощаем больше: за одним адресом может быть много пользователей
+	DefaultIPPolicy = Policy{
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+	}
+)
+
+// Guard отслеживает неудачные входы по аккаунту и по IP.
+type Guard struct {
+	store         Store
+	accountPolicy Policy
+	ipPolicy      Policy
+}
+
+func NewGuard(store Store, accountPolicy, ipPolicy Policy) *Guard {
+	return &Guard{
+		store:         store,
+		accountPolicy: accountPolicy,
+		ipPolicy:      ipPolicy,
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check возвращает, сколько еще нужно ждать перед следующей попыткой (0 - можно).
+func (g *Guard) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		attempt, err := g.store.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if attempt != nil && attempt.LockedUntil != nil {
+			if d := attempt.LockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait, nil
+}
+
+// RegisterFailure учитывает неудачную попытку и возвращает задержку до следующей.
+func (g *Guard) RegisterFailure(ctx context.Context, email, ip string) (time.Duration, error) {
+	accountWait, err := g.fail(ctx, accountKey(email), g.accountPolicy)
+	if err != nil {
+		return 0, err
+	}
+	ipWait, err := g.fail(ctx, ipKey(ip), g.ipPolicy)
+	if err != nil {
+		return 0, err
+	}
+	if ipWait > accountWait {
+		return ipWait, nil
+	}
+	return accountWait, nil
+}
+
+// RegisterSuccess сбрасывает счетчик аккаунта. Счетчик IP не трогаем,
+// иначе перебор можно маскировать входами в собственный аккаунт.
+func (g *Guard) RegisterSuccess(ctx context.Context, email string) error {
+	return g.store.Reset(ctx, accountKey(email))
+}
+
+// UnlockAccount снимает блокировку аккаунта (для администраторов).
+func (g *Guard) UnlockAccount(ctx context.Context, email string) error {
+	return g.store.Reset(ctx, accountKey(email))
+}
+
+// Run - фоновая очистка счетчиков, по которым ошибок не было дольше окна
+// политики и нет действующей блокировки. Работает до отмены ctx.
+func (g *Guard) Run(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := g.Cleanup(ctx, time.Now()); err != nil {
+				log.Printf("lockout: cleanup failed: %v", err)
+			}
+		}
+	}
+}
+
+// Cleanup удаляет устаревшие счетчики и возвращает, сколько удалено.
+func (g *Guard) Cleanup(ctx context.Context, now time.Time) (int64, error) {
+	window := max(g.accountPolicy.Window, g.ipPolicy.Window)
+	return g.store.DeleteExpired(ctx, now.Add(-window), now)
+}
+
+func (g *Guard) fail(ctx context.Context, key string, policy Policy) (time.Duration, error) {
+	now := time.Now()
+	attempt, err := g.store.Increment(ctx, key, now, policy.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	delay := policy.delay(attempt.Failures)
+	if delay <= 0 {
+		return 0, nil
+	}
+	if err := g.store.Lock(ctx, key, now.Add(delay)); err != nil {
+		return 0, err
+	}
+	return delay, nil
+}
+
+func (p Policy) delay(failures int) time.Duration {
+	if failures >= p.LockoutThreshold {
+		return p.LockoutDuration
+	}
+	extra := failures - p.FreeAttempts
+	if extra <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < extra && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
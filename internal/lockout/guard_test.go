@@ -0,0 +1,51 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGuardCleanupKeepsActiveCounters(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	guard := NewGuard(store, DefaultAccountPolicy, DefaultIPPolicy)
+	now := time.Now()
+
+	// устаревший счетчик без блокировки
+	if _, err := store.Increment(ctx, "account:old@example.com", now.Add(-2*time.Hour), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// устаревший, но еще заблокированный
+	if _, err := store.Increment(ctx, "account:locked@example.com", now.Add(-2*time.Hour), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Lock(ctx, "account:locked@example.com", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// свежий
+	if _, err := store.Increment(ctx, "ip:10.0.0.1", now.Add(-time.Minute), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := guard.Cleanup(ctx, now)
+	if err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("deleted %d counters, want 1", deleted)
+	}
+	for key, want := range map[string]bool{
+		"account:old@example.com":    false,
+		"account:locked@example.com": true,
+		"ip:10.0.0.1":                true,
+	} {
+		attempt, err := store.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := attempt != nil; got != want {
+			t.Errorf("%s kept = %v, want %v", key, got, want)
+		}
+	}
+}
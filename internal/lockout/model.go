@@ -0,0 +1,11 @@
+package lockout
+
+import "time"
+
+// LoginAttempt - счетчик неудачных попыток входа по ключу (аккаунт или IP).
+type LoginAttempt struct {
+	Key           string    `gorm:"primaryKey;size:320"`
+	Failures      int       `gorm:"not null;default:0"`
+	LastFailureAt time.Time `gorm:"index"`
+	LockedUntil   *time.Time
+}
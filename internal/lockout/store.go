@@ -0,0 +1,157 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Store хранит счетчики попыток. Memory подходит для одного инстанса,
+// Postgres - когда сервер запущен в нескольких экземплярах.
+type Store interface {
+	Get(ctx context.Context, key string) (*LoginAttempt, error)
+	// Increment увеличивает счетчик; если прошлая ошибка была раньше
+	// now-window, счет начинается заново.
+	Increment(ctx context.Context, key string, now time.Time, window time.Duration) (*LoginAttempt, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	// DeleteExpired удаляет записи без ошибок после staleBefore и без
+	// блокировки, действующей на момент now.
+	DeleteExpired(ctx context.Context, staleBefore, now time.Time) (int64, error)
+}
+
+// memoryStore сам устаревшие записи не удаляет - это делает Guard.Cleanup.
+type memoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]*LoginAttempt
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{attempts: make(map[string]*LoginAttempt)}
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (*LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	copied := *attempt
+	return &copied, nil
+}
+
+func (s *memoryStore) Increment(ctx context.Context, key string, now time.Time, window time.Duration) (*LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		attempt = &LoginAttempt{Key: key}
+		s.attempts[key] = attempt
+	}
+	if now.Sub(attempt.LastFailureAt) > window {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+
+	copied := *attempt
+	return &copied, nil
+}
+
+func (s *memoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok {
+		attempt.LockedUntil = &until
+	}
+	return nil
+}
+
+func (s *memoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *memoryStore) DeleteExpired(ctx context.Context, staleBefore, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for key, attempt := range s.attempts {
+		locked := attempt.LockedUntil != nil && attempt.LockedUntil.After(now)
+		if !locked && attempt.LastFailureAt.Before(staleBefore) {
+			delete(s.attempts, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+type postgresStore struct {
+	db *gorm.DB
+}
+
+func NewPostgresStore(db *gorm.DB) Store {
+	return &postgresStore{db: db}
+}
+
+func (s *postgresStore) Get(ctx context.Context, key string) (*LoginAttempt, error) {
+	var attempt LoginAttempt
+	if err := s.db.WithContext(ctx).First(&attempt, "key = ?", key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (s *postgresStore) Increment(ctx context.Context, key string, now time.Time, window time.Duration) (*LoginAttempt, error) {
+	attempt := LoginAttempt{Key: key, Failures: 1, LastFailureAt: now}
+	// Атомарный upsert, чтобы параллельные попытки с разных инстансов не терялись
+	err := s.db.WithContext(ctx).
+		Clauses(
+			clause.OnConflict{
+				Columns: []clause.Column{{Name: "key"}},
+				DoUpdates: clause.Set{
+					{Column: clause.Column{Name: "failures"}, Value: gorm.Expr(
+						"CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END",
+						now.Add(-window),
+					)},
+					{Column: clause.Column{Name: "last_failure_at"}, Value: now},
+				},
+			},
+			clause.Returning{},
+		).
+		Create(&attempt).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (s *postgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	return s.db.WithContext(ctx).Model(&LoginAttempt{}).Where("key = ?", key).Update("locked_until", until).Error
+}
+
+func (s *postgresStore) Reset(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where("key = ?", key).Delete(&LoginAttempt{}).Error
+}
+
+func (s *postgresStore) DeleteExpired(ctx context.Context, staleBefore, now time.Time) (int64, error) {
+	res := s.db.WithContext(ctx).
+		Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", staleBefore, now).
+		Delete(&LoginAttempt{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,143 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxChunkRunes - модель сервиса модерации обрезает длинный текст, поэтому
+// длинные тексты проверяются по частям.
+const maxChunkRunes = 1000
+
+type Result struct {
+	IsApproved    bool               `json:"is_approved"`
+	ToxicityScore float64            `json:"toxicity_score"`
+	Details       map[string]float64 `json:"details"`
+}
+
+// ToxicLabels возвращает токсичные категории с высоким скором в виде "label (NN%)".
+func (r *Result) ToxicLabels() []string {
+	var labels []string
+	for label, score := range r.Details {
+		if score > 0.5 && label != "non-toxic" {
+			labels = append(labels, fmt.Sprintf("%s (%.0f%%)", label, score*100))
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Client - клиент сервиса модерации текста (comment-moderation).
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient создает клиента. При пустом url модерация отключена и любой
+// текст одобряется.
+func NewClient(url string) *Client {
+	return &Client{
+		url: strings.TrimRight(url, "/"),
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+func NewFromEnv() *Client {
+	return NewClient(os.Getenv("MODERATION_SERVICE_URL"))
+}
+
+// Moderate проверяет текст. Длинный текст делится на части, результат -
+// первая отклоненная часть либо часть с наибольшей токсичностью.
+func (c *Client) Moderate(ctx context.Context, text string) (*Result, error) {
+	if c.url == "" {
+		return &Result{IsApproved: true}, nil
+	}
+
+	var worst *Result
+	for _, chunk := range splitText(text, maxChunkRunes) {
+		result, err := c.moderate(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		if !result.IsApproved {
+			return result, nil
+		}
+		if worst == nil || result.ToxicityScore > worst.ToxicityScore {
+			worst = result
+		}
+	}
+	if worst == nil {
+		worst = &Result{IsApproved: true}
+	}
+	return worst, nil
+}
+
+func (c *Client) moderate(ctx context.Context, text string) (*Result, error) {
+	requestBody, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/moderate", bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation service returned status %d", resp.StatusCode)
+	}
+
+	var result Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// splitText делит текст на части не длиннее limit рун, стараясь резать
+// по границам абзацев и пробелам.
+func splitText(text string, limit int) []string {
+	var chunks []string
+	runes := []rune(strings.TrimSpace(text))
+	for len(runes) > limit {
+		cut := limit
+		if i := lastIndex(runes[:limit], '\n'); i > limit/2 {
+			cut = i
+		} else if i := lastIndex(runes[:limit], ' '); i > limit/2 {
+			cut = i
+		}
+		if chunk := strings.TrimSpace(string(runes[:cut])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		runes = runes[cut:]
+	}
+	if chunk := strings.TrimSpace(string(runes)); chunk != "" {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func lastIndex(runes []rune, r rune) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
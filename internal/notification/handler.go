@@ -0,0 +1,119 @@
+package notification
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/pkg/pagination"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) List(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	page, limit := pagination.FromQuery(c, 20, 100)
+	unreadOnly, _ := strconv.ParseBool(c.QueryParam("unread"))
+
+	notifications, total, unread, err := h.service.List(principal.UserID, unreadOnly, page, limit)
+	if err != nil {
+		return notificationError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"notifications": notifications,
+		"unread":        unread,
+		"total":         total,
+		"page":          page,
+		"limit":         limit,
+	})
+}
+
+func (h *Handler) MarkRead(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return notificationError(c, ErrNotFound)
+	}
+
+	if err := h.service.MarkRead(principal.UserID, id); err != nil {
+		return notificationError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) MarkAllRead(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	marked, err := h.service.MarkAllRead(principal.UserID)
+	if err != nil {
+		return notificationError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"marked": marked})
+}
+
+func (h *Handler) ListMutes(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	mutes, err := h.service.ListMutes(principal.UserID)
+	if err != nil {
+		return notificationError(c, err)
+	}
+	return c.JSON(http.StatusOK, mutes)
+}
+
+func (h *Handler) Mute(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.Mute(principal.UserID, c.Param("anime_id")); err != nil {
+		return notificationError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) Unmute(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.Unmute(principal.UserID, c.Param("anime_id")); err != nil {
+		return notificationError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func notificationError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidAnimeID):
+		status = http.StatusBadRequest
+	}
+	return c.JSON(status, echo.Map{"error": err.Error()})
+}
@@ -0,0 +1,50 @@
+package notification
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SourceShikimori = "shikimori"
+	SourceKodik     = "kodik"
+)
+
+// Notification - уведомление о новой серии. На серию аниме у пользователя
+// не больше одного уведомления, из какого бы источника она ни пришла.
+type Notification struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_notification_episode;index:idx_notification_user_created,priority:1" json:"-"`
+	AnimeID     string     `gorm:"size:32;not null;uniqueIndex:idx_notification_episode" json:"anime_id"`
+	Episode     int        `gorm:"not null;uniqueIndex:idx_notification_episode" json:"episode"`
+	AnimeTitle  string     `gorm:"size:255" json:"anime_title"`
+	Source      string     `gorm:"size:16;not null" json:"source"`
+	Translation string     `gorm:"size:255" json:"translation,omitempty"`
+	ReadAt      *time.Time `json:"read_at"`
+	CreatedAt   time.Time  `gorm:"index:idx_notification_user_created,priority:2" json:"created_at"`
+}
+
+// Mute отключает уведомления пользователя по одному аниме.
+type Mute struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	AnimeID   string    `gorm:"size:32;primaryKey" json:"anime_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Mute) TableName() string {
+	return "notification_mutes"
+}
+
+// EpisodeSource - последняя известная серия аниме в одном источнике:
+// на Shikimori ("shikimori") или в озвучке Kodik ("kodik:<id озвучки>").
+type EpisodeSource struct {
+	AnimeID     string `gorm:"size:32;primaryKey"`
+	Source      string `gorm:"size:32;primaryKey"`
+	LastEpisode int    `gorm:"not null"`
+	UpdatedAt   time.Time
+}
+
+func (EpisodeSource) TableName() string {
+	return "notification_episode_sources"
+}
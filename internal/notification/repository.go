@@ -0,0 +1,138 @@
+package notification
+
+import (
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	TrackedAnimeIDs() ([]string, error)
+	FindSources(animeID string) ([]EpisodeSource, error)
+	// Publish сохраняет источник и создает уведомления о сериях episodes для
+	// всех, кто смотрит аниме, еще не видел серию и не отключил уведомления.
+	Publish(source *EpisodeSource, episodes []int, template Notification) (int64, error)
+
+	List(userID uuid.UUID, unreadOnly bool, limit, offset int) ([]Notification, int64, error)
+	CountUnread(userID uuid.UUID) (int64, error)
+	MarkRead(userID, id uuid.UUID) error
+	MarkAllRead(userID uuid.UUID) (int64, error)
+
+	FindMutes(userID uuid.UUID) ([]Mute, error)
+	Mute(userID uuid.UUID, animeID string) error
+	Unmute(userID uuid.UUID, animeID string) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// TrackedAnimeIDs - аниме, которые кто-то сейчас смотрит.
+func (r *repository) TrackedAnimeIDs() ([]string, error) {
+	var ids []string
+	err := r.db.Model(&user.AnimeEntry{}).
+		Where("status = ?", user.StatusWatching).
+		Distinct().
+		Order("anime_id").
+		Pluck("anime_id", &ids).Error
+	return ids, err
+}
+
+func (r *repository) FindSources(animeID string) ([]EpisodeSource, error) {
+	var sources []EpisodeSource
+	err := r.db.Where("anime_id = ?", animeID).Find(&sources).Error
+	return sources, err
+}
+
+func (r *repository) Publish(source *EpisodeSource, episodes []int, template Notification) (int64, error) {
+	var created int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		for _, episode := range episodes {
+			res := tx.Exec(`
+				INSERT INTO notifications (id, user_id, anime_id, episode, anime_title, source, translation, created_at)
+				SELECT gen_random_uuid(), e.user_id, e.anime_id, ?, ?, ?, ?, now()
+				FROM user_anime_entries e
+				WHERE e.anime_id = ? AND e.status = ? AND e.episodes_watched < ?
+					AND NOT EXISTS (
+						SELECT 1 FROM notification_mutes m WHERE m.user_id = e.user_id AND m.anime_id = e.anime_id
+					)
+				ON CONFLICT (user_id, anime_id, episode) DO NOTHING`,
+				episode, template.AnimeTitle, template.Source, template.Translation,
+				source.AnimeID, user.StatusWatching, episode)
+			if res.Error != nil {
+				return res.Error
+			}
+			created += res.RowsAffected
+		}
+
+		source.UpdatedAt = time.Now()
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "anime_id"}, {Name: "source"}},
+			DoUpdates: clause.AssignmentColumns([]string{"last_episode", "updated_at"}),
+		}).Create(source).Error
+	})
+	return created, err
+}
+
+func (r *repository) List(userID uuid.UUID, unreadOnly bool, limit, offset int) ([]Notification, int64, error) {
+	query := r.db.Model(&Notification{}).Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var notifications []Notification
+	err := query.Order("created_at DESC, episode DESC").Limit(limit).Offset(offset).Find(&notifications).Error
+	return notifications, total, err
+}
+
+func (r *repository) CountUnread(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *repository) MarkRead(userID, id uuid.UUID) error {
+	res := r.db.Model(&Notification{}).
+		Where("id = ? AND user_id = ?", id, userID).
+		Update("read_at", gorm.Expr("COALESCE(read_at, now())"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *repository) MarkAllRead(userID uuid.UUID) (int64, error) {
+	res := r.db.Model(&Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	return res.RowsAffected, res.Error
+}
+
+func (r *repository) FindMutes(userID uuid.UUID) ([]Mute, error) {
+	var mutes []Mute
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&mutes).Error
+	return mutes, err
+}
+
+func (r *repository) Mute(userID uuid.UUID, animeID string) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Mute{UserID: userID, AnimeID: animeID}).Error
+}
+
+func (r *repository) Unmute(userID uuid.UUID, animeID string) error {
+	return r.db.Where("user_id = ? AND anime_id = ?", userID, animeID).Delete(&Mute{}).Error
+}
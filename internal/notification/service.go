@@ -0,0 +1,249 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/kodik"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPollInterval = 30 * time.Minute
+	kodikTimeout        = 15 * time.Second
+	// если источник прибавил сразу много серий (вышел весь сезон), уведомления
+	// создаются только о последних
+	maxEpisodesPerUpdate = 3
+)
+
+var (
+	ErrNotFound       = errors.New("notification not found")
+	ErrInvalidAnimeID = errors.New("invalid anime id")
+)
+
+// AnimeFetcher загружает данные аниме с Shikimori.
+type AnimeFetcher interface {
+	FetchAnimesByIDs(ctx context.Context, ids []string) (map[string]shikimori.Anime, []string)
+}
+
+// EpisodeFetcher возвращает последние серии озвучек.
+type EpisodeFetcher interface {
+	LatestEpisodes(ctx context.Context, shikimoriID string) ([]kodik.TranslationEpisode, error)
+}
+
+type Service interface {
+	List(userID uuid.UUID, unreadOnly bool, page, limit int) ([]Notification, int64, int64, error)
+	MarkRead(userID, id uuid.UUID) error
+	MarkAllRead(userID uuid.UUID) (int64, error)
+	ListMutes(userID uuid.UUID) ([]Mute, error)
+	Mute(userID uuid.UUID, animeID string) error
+	Unmute(userID uuid.UUID, animeID string) error
+	ProfileSection(ctx context.Context, userID uuid.UUID) (string, interface{}, error)
+	Run(ctx context.Context)
+}
+
+type service struct {
+	repo     Repository
+	animes   AnimeFetcher
+	episodes EpisodeFetcher
+	interval time.Duration
+}
+
+func NewService(repo Repository, animes AnimeFetcher, episodes EpisodeFetcher) Service {
+	interval, err := time.ParseDuration(os.Getenv("NOTIFICATIONS_POLL_INTERVAL"))
+	if err != nil || interval < time.Minute {
+		interval = defaultPollInterval
+	}
+	return &service{
+		repo:     repo,
+		animes:   animes,
+		episodes: episodes,
+		interval: interval,
+	}
+}
+
+// List возвращает страницу уведомлений, их общее число и число непрочитанных.
+func (s *service) List(userID uuid.UUID, unreadOnly bool, page, limit int) ([]Notification, int64, int64, error) {
+	notifications, total, err := s.repo.List(userID, unreadOnly, limit, (page-1)*limit)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	unread, err := s.repo.CountUnread(userID)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return notifications, total, unread, nil
+}
+
+func (s *service) MarkRead(userID, id uuid.UUID) error {
+	err := s.repo.MarkRead(userID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *service) MarkAllRead(userID uuid.UUID) (int64, error) {
+	return s.repo.MarkAllRead(userID)
+}
+
+func (s *service) ListMutes(userID uuid.UUID) ([]Mute, error) {
+	return s.repo.FindMutes(userID)
+}
+
+func (s *service) Mute(userID uuid.UUID, animeID string) error {
+	if !shikimori.IsValidAnimeID(animeID) {
+		return ErrInvalidAnimeID
+	}
+	return s.repo.Mute(userID, animeID)
+}
+
+func (s *service) Unmute(userID uuid.UUID, animeID string) error {
+	if !shikimori.IsValidAnimeID(animeID) {
+		return ErrInvalidAnimeID
+	}
+	return s.repo.Unmute(userID, animeID)
+}
+
+// ProfileSection добавляет число непрочитанных уведомлений в ответ /profile.
+func (s *service) ProfileSection(_ context.Context, userID uuid.UUID) (string, interface{}, error) {
+	unread, err := s.repo.CountUnread(userID)
+	return "notifications", map[string]int64{"unread": unread}, err
+}
+
+// Run - воркер опроса новых серий, работает до отмены ctx.
+func (s *service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll проверяет аниме, которые кто-то смотрит: число вышедших серий на
+// Shikimori и последнюю серию каждой озвучки на Kodik.
+func (s *service) poll(ctx context.Context) {
+	ids, err := s.repo.TrackedAnimeIDs()
+	if err != nil {
+		log.Printf("notifications: failed to load tracked anime: %v", err)
+		return
+	}
+	animes, failed := s.animes.FetchAnimesByIDs(ctx, ids)
+	if len(failed) > 0 {
+		log.Printf("notifications: failed to load %d anime from shikimori", len(failed))
+	}
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		anime, ok := animes[id]
+		if !ok {
+			continue
+		}
+		if err := s.checkAnime(ctx, anime); err != nil {
+			log.Printf("notifications: failed to check anime %s: %v", id, err)
+		}
+	}
+}
+
+func (s *service) checkAnime(ctx context.Context, anime shikimori.Anime) error {
+	sources, err := s.repo.FindSources(anime.ID)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]int, len(sources))
+	firstCheck := len(sources) == 0
+	kodikLatest := 0
+	for _, source := range sources {
+		known[source.Source] = source.LastEpisode
+		if source.Source != SourceShikimori {
+			kodikLatest = max(kodikLatest, source.LastEpisode)
+		}
+	}
+
+	title := anime.Russian
+	if title == "" {
+		title = anime.Name
+	}
+
+	aired := anime.EpisodesAired
+	if anime.Status == "released" {
+		aired = max(aired, anime.Episodes)
+	}
+	if err := s.advance(anime.ID, SourceShikimori, known, firstCheck, aired, Notification{
+		AnimeTitle: title,
+		Source:     SourceShikimori,
+	}); err != nil {
+		return err
+	}
+
+	// у вышедшего аниме Kodik опрашивается, пока озвучки отстают от Shikimori
+	ongoing := anime.Status == "ongoing" || anime.NextEpisodeAt != ""
+	if !ongoing && (anime.Status != "released" || kodikLatest >= anime.Episodes) {
+		return nil
+	}
+
+	kodikCtx, cancel := context.WithTimeout(ctx, kodikTimeout)
+	defer cancel()
+	translations, err := s.episodes.LatestEpisodes(kodikCtx, anime.ID)
+	if err != nil {
+		return err
+	}
+	for _, translation := range translations {
+		key := SourceKodik + ":" + strconv.Itoa(translation.TranslationID)
+		if err := s.advance(anime.ID, key, known, firstCheck, translation.LastEpisode, Notification{
+			AnimeTitle:  title,
+			Source:      SourceKodik,
+			Translation: translation.TranslationTitle,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// advance сохраняет последнюю серию источника и уведомляет о новых. Для
+// нового источника новыми считаются серии после последней известной по всем
+// источникам. При первой проверке аниме источники только запоминаются,
+// иначе разослались бы уведомления обо всех уже вышедших сериях.
+func (s *service) advance(animeID, source string, known map[string]int, firstCheck bool, latest int, template Notification) error {
+	previous, seen := known[source]
+	if latest <= 0 || (seen && latest <= previous) {
+		return nil
+	}
+	if !seen {
+		for _, episode := range known {
+			previous = max(previous, episode)
+		}
+	}
+
+	var episodes []int
+	if !firstCheck {
+		for episode := max(previous+1, latest-maxEpisodesPerUpdate+1); episode <= latest; episode++ {
+			episodes = append(episodes, episode)
+		}
+	}
+	created, err := s.repo.Publish(&EpisodeSource{AnimeID: animeID, Source: source, LastEpisode: latest}, episodes, template)
+	if err != nil {
+		return err
+	}
+	known[source] = latest
+	if created > 0 {
+		log.Printf("notifications: anime %s episode %d from %s, %d notifications", animeID, latest, source, created)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package notification
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Zipklas/anime-site-backend/internal/kodik"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+)
+
+type published struct {
+	source   string
+	episodes []int
+}
+
+// fakeRepository хранит источники в памяти и записывает вызовы Publish.
+type fakeRepository struct {
+	Repository
+
+	sources   map[string]int
+	published []published
+}
+
+func (r *fakeRepository) TrackedAnimeIDs() ([]string, error) {
+	return []string{"1"}, nil
+}
+
+func (r *fakeRepository) FindSources(animeID string) ([]EpisodeSource, error) {
+	var sources []EpisodeSource
+	for source, episode := range r.sources {
+		sources = append(sources, EpisodeSource{AnimeID: animeID, Source: source, LastEpisode: episode})
+	}
+	return sources, nil
+}
+
+func (r *fakeRepository) Publish(source *EpisodeSource, episodes []int, template Notification) (int64, error) {
+	r.sources[source.Source] = source.LastEpisode
+	r.published = append(r.published, published{source.Source, episodes})
+	return int64(len(episodes)), nil
+}
+
+type fakeAnimes struct {
+	anime shikimori.Anime
+}
+
+func (f *fakeAnimes) FetchAnimesByIDs(ctx context.Context, ids []string) (map[string]shikimori.Anime, []string) {
+	return map[string]shikimori.Anime{f.anime.ID: f.anime}, []string{}
+}
+
+type fakeEpisodes struct {
+	translations []kodik.TranslationEpisode
+	calls        int
+}
+
+func (f *fakeEpisodes) LatestEpisodes(ctx context.Context, shikimoriID string) ([]kodik.TranslationEpisode, error) {
+	f.calls++
+	return f.translations, nil
+}
+
+func TestPollNotifiesAboutNewEpisodes(t *testing.T) {
+	repo := &fakeRepository{sources: map[string]int{}}
+	animes := &fakeAnimes{shikimori.Anime{ID: "1", Name: "Frieren", Status: "ongoing", Episodes: 28, EpisodesAired: 5}}
+	episodes := &fakeEpisodes{translations: []kodik.TranslationEpisode{{TranslationID: 10, LastEpisode: 4}}}
+	s := NewService(repo, animes, episodes).(*service)
+
+	poll := func(step string, want ...published) {
+		t.Helper()
+		repo.published = nil
+		s.poll(context.Background())
+		if !reflect.DeepEqual(repo.published, want) {
+			t.Errorf("%s: published %+v, want %+v", step, repo.published, want)
+		}
+	}
+
+	// первая проверка только запоминает источники
+	poll("first check", published{"shikimori", nil}, published{"kodik:10", nil})
+	poll("nothing new")
+
+	animes.anime.EpisodesAired = 6
+	episodes.translations[0].LastEpisode = 6
+	poll("new episode", published{"shikimori", []int{6}}, published{"kodik:10", []int{5, 6}})
+
+	// новая озвучка догоняет уже известные серии, уведомлять не о чем
+	episodes.translations = append(episodes.translations, kodik.TranslationEpisode{TranslationID: 20, LastEpisode: 6})
+	poll("new translation", published{"kodik:20", nil})
+
+	// сразу весь сезон - уведомления только о последних сериях
+	animes.anime.EpisodesAired = 28
+	poll("whole season", published{"shikimori", []int{26, 27, 28}})
+
+	// у вышедшего аниме Kodik не опрашивается, когда озвучки догнали Shikimori
+	animes.anime.Status = "released"
+	episodes.translations[0].LastEpisode = 28
+	poll("released", published{"kodik:10", []int{26, 27, 28}})
+	calls := episodes.calls
+	poll("released and caught up")
+	if episodes.calls != calls {
+		t.Errorf("kodik polled for a released anime with all episodes voiced")
+	}
+}
@@ -0,0 +1,24 @@
+package rating
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MaxScore - оценки в списках от 1 до MaxScore.
+const MaxScore = 10
+
+// Score - агрегат оценок аниме из списков пользователей. Обновляется
+// инкрементально при изменении записей списков.
+type Score struct {
+	AnimeID   string        `gorm:"size:32;primaryKey"`
+	Votes     int           `gorm:"not null;default:0;index"`
+	ScoreSum  int64         `gorm:"not null;default:0"`
+	Histogram pq.Int64Array `gorm:"type:integer[];not null"` // Histogram[i] - число оценок i+1
+	UpdatedAt time.Time
+}
+
+func (Score) TableName() string {
+	return "anime_community_scores"
+}
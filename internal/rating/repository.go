@@ -0,0 +1,123 @@
+package rating
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	// ApplyChange переносит голос пользователя с oldScore на newScore,
+	// 0 означает отсутствие оценки.
+	ApplyChange(animeID string, oldScore, newScore int) error
+	FindByAnimeIDs(animeIDs []string) ([]Score, error)
+	GlobalAverage() (float64, error)
+	// Reconcile пересчитывает агрегаты по спискам пользователей и исправляет
+	// расхождения, оставшиеся после неудачных ApplyChange.
+	Reconcile() error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) ApplyChange(animeID string, oldScore, newScore int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`
+			INSERT INTO anime_community_scores (anime_id, votes, score_sum, histogram, updated_at)
+			VALUES (?, 0, 0, array_fill(0, ARRAY[?::int]), now())
+			ON CONFLICT (anime_id) DO NOTHING`, animeID, MaxScore).Error; err != nil {
+			return err
+		}
+		if oldScore > 0 {
+			if err := addVote(tx, animeID, oldScore, -1); err != nil {
+				return err
+			}
+		}
+		if newScore > 0 {
+			return addVote(tx, animeID, newScore, 1)
+		}
+		return nil
+	})
+}
+
+func addVote(tx *gorm.DB, animeID string, score, delta int) error {
+	return tx.Exec(`
+		UPDATE anime_community_scores SET
+			votes = votes + ?,
+			score_sum = score_sum + ?,
+			histogram[?::int] = histogram[?::int] + ?,
+			updated_at = now()
+		WHERE anime_id = ?`, delta, score*delta, score, score, delta, animeID).Error
+}
+
+func (r *repository) FindByAnimeIDs(animeIDs []string) ([]Score, error) {
+	var scores []Score
+	if len(animeIDs) == 0 {
+		return scores, nil
+	}
+	err := r.db.Where("anime_id IN ? AND votes > 0", animeIDs).Find(&scores).Error
+	return scores, err
+}
+
+// GlobalAverage - средняя оценка по всем голосам сайта.
+func (r *repository) GlobalAverage() (float64, error) {
+	var avg float64
+	err := r.db.Model(&Score{}).
+		Select("COALESCE(SUM(score_sum)::float / NULLIF(SUM(votes), 0), 0)").
+		Scan(&avg).Error
+	return avg, err
+}
+
+func (r *repository) Reconcile() error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(aggregateEntries+`
+			ON CONFLICT (anime_id) DO UPDATE SET
+				votes = excluded.votes,
+				score_sum = excluded.score_sum,
+				histogram = excluded.histogram,
+				updated_at = now()
+			WHERE (anime_community_scores.votes, anime_community_scores.score_sum, anime_community_scores.histogram)
+				IS DISTINCT FROM (excluded.votes, excluded.score_sum, excluded.histogram)`, MaxScore).Error; err != nil {
+			return err
+		}
+		// оценки, которых в списках больше нет
+		return tx.Exec(`
+			UPDATE anime_community_scores SET
+				votes = 0, score_sum = 0, histogram = array_fill(0, ARRAY[?::int]), updated_at = now()
+			WHERE votes <> 0 AND anime_id NOT IN (
+				SELECT anime_id FROM user_anime_entries WHERE score BETWEEN 1 AND ?
+			)`, MaxScore, MaxScore).Error
+	})
+}
+
+// aggregateEntries - вставка агрегатов, посчитанных по оценкам в списках.
+var aggregateEntries = func() string {
+	buckets := make([]string, MaxScore)
+	for i := range buckets {
+		buckets[i] = fmt.Sprintf("COUNT(*) FILTER (WHERE score = %d)", i+1)
+	}
+	return `
+		INSERT INTO anime_community_scores (anime_id, votes, score_sum, histogram, updated_at)
+		SELECT anime_id, COUNT(*), SUM(score), ARRAY[` + strings.Join(buckets, ", ") + `]::integer[], now()
+		FROM user_anime_entries
+		WHERE score BETWEEN 1 AND ?
+		GROUP BY anime_id`
+}()
+
+// Backfill заполняет пустую таблицу агрегатов по уже существующим оценкам
+// в списках. Дальше агрегаты поддерживаются инкрементально и сверяются
+// Service.Run.
+func Backfill(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&Score{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	return db.Exec(aggregateEntries+`
+		ON CONFLICT (anime_id) DO NOTHING`, MaxScore).Error
+}
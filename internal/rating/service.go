@@ -0,0 +1,134 @@
+package rating
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+)
+
+const (
+	// priorVotes - вес средней оценки сайта в байесовском среднем: аниме с
+	// парой голосов не обгонит тайтлы с сотнями оценок.
+	priorVotes = 10
+
+	globalAverageTTL = 10 * time.Minute
+
+	// агрегаты обновляются после коммита записи списка, и неудачное
+	// обновление исправляет только периодическая сверка
+	reconcileInterval = time.Hour
+)
+
+type Service struct {
+	repo Repository
+
+	mu            sync.Mutex
+	globalAverage float64
+	averageAt     time.Time
+}
+
+func NewService(repo Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// AnimeEntryChanged реализует user.EntryObserver: голос пользователя
+// переносится со старой оценки на новую.
+func (s *Service) AnimeEntryChanged(userID uuid.UUID, before, after *user.AnimeEntry) {
+	oldScore, newScore := entryScore(before), entryScore(after)
+	if oldScore == newScore {
+		return
+	}
+
+	entry := after
+	if entry == nil {
+		entry = before
+	}
+	if err := s.repo.ApplyChange(entry.AnimeID, oldScore, newScore); err != nil {
+		log.Printf("Failed to update community score of anime %s: %v", entry.AnimeID, err)
+	}
+}
+
+// Run периодически сверяет агрегаты со списками пользователей. Работает до
+// отмены ctx.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.repo.Reconcile(); err != nil {
+				log.Printf("rating: reconcile failed: %v", err)
+			}
+		}
+	}
+}
+
+func entryScore(entry *user.AnimeEntry) int {
+	if entry == nil || entry.Score == nil || *entry.Score < 1 || *entry.Score > MaxScore {
+		return 0
+	}
+	return *entry.Score
+}
+
+// CommunityScores возвращает оценки сайта по ID аниме. Аниме без оценок в
+// результат не попадают.
+func (s *Service) CommunityScores(ctx context.Context, animeIDs []string) (map[string]*shikimori.CommunityScore, error) {
+	scores, err := s.repo.FindByAnimeIDs(animeIDs)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*shikimori.CommunityScore, len(scores))
+	if len(scores) == 0 {
+		return result, nil
+	}
+
+	prior, err := s.average()
+	if err != nil {
+		return nil, err
+	}
+	for _, score := range scores {
+		average := float64(score.ScoreSum) / float64(score.Votes)
+		votes := float64(score.Votes)
+		histogram := make([]int, MaxScore)
+		for i := 0; i < len(score.Histogram) && i < MaxScore; i++ {
+			histogram[i] = int(score.Histogram[i])
+		}
+		result[score.AnimeID] = &shikimori.CommunityScore{
+			Score:     round2((votes*average + priorVotes*prior) / (votes + priorVotes)),
+			Average:   round2(average),
+			Votes:     score.Votes,
+			Histogram: histogram,
+		}
+	}
+	return result, nil
+}
+
+// average - средняя оценка сайта, кэшируется: она меняется медленно, а
+// считается по всей таблице.
+func (s *Service) average() (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.averageAt) < globalAverageTTL {
+		return s.globalAverage, nil
+	}
+	avg, err := s.repo.GlobalAverage()
+	if err != nil {
+		return 0, err
+	}
+	s.globalAverage = avg
+	s.averageAt = time.Now()
+	return avg, nil
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,107 @@
+package rating
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type change struct {
+	animeID            string
+	oldScore, newScore int
+}
+
+type fakeRepository struct {
+	Repository
+
+	scores       []Score
+	average      float64
+	averageCalls int
+	changes      []change
+}
+
+func (r *fakeRepository) ApplyChange(animeID string, oldScore, newScore int) error {
+	r.changes = append(r.changes, change{animeID, oldScore, newScore})
+	return nil
+}
+
+func (r *fakeRepository) FindByAnimeIDs(animeIDs []string) ([]Score, error) {
+	return r.scores, nil
+}
+
+func (r *fakeRepository) GlobalAverage() (float64, error) {
+	r.averageCalls++
+	return r.average, nil
+}
+
+func scored(animeID string, score int) *user.AnimeEntry {
+	return &user.AnimeEntry{AnimeID: animeID, Score: &score}
+}
+
+func TestAnimeEntryChangedMovesVote(t *testing.T) {
+	repo := &fakeRepository{}
+	s := NewService(repo)
+	userID := uuid.New()
+
+	s.AnimeEntryChanged(userID, nil, scored("1", 7))
+	s.AnimeEntryChanged(userID, scored("1", 7), scored("1", 9))
+	s.AnimeEntryChanged(userID, scored("1", 9), nil)
+	// без изменения оценки и с оценками вне шкалы голос не меняется
+	s.AnimeEntryChanged(userID, scored("2", 5), scored("2", 5))
+	s.AnimeEntryChanged(userID, &user.AnimeEntry{AnimeID: "3"}, scored("3", 0))
+	s.AnimeEntryChanged(userID, nil, scored("4", MaxScore+1))
+
+	want := []change{{"1", 0, 7}, {"1", 7, 9}, {"1", 9, 0}}
+	if !reflect.DeepEqual(repo.changes, want) {
+		t.Fatalf("changes = %v, want %v", repo.changes, want)
+	}
+}
+
+func TestCommunityScores(t *testing.T) {
+	repo := &fakeRepository{
+		average: 7,
+		scores: []Score{
+			// два голоса по 10: байесовское среднее тянет к средней сайта
+			{AnimeID: "1", Votes: 2, ScoreSum: 20, Histogram: pq.Int64Array{0, 0, 0, 0, 0, 0, 0, 0, 0, 2}},
+			// у старых строк гистограмма может быть короче шкалы
+			{AnimeID: "2", Votes: 30, ScoreSum: 150, Histogram: pq.Int64Array{0, 0, 0, 0, 30}},
+		},
+	}
+	s := NewService(repo)
+
+	scores, err := s.CommunityScores(context.Background(), []string{"1", "2", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 2 {
+		t.Fatalf("scores for %d anime, want 2", len(scores))
+	}
+
+	first := scores["1"]
+	if first.Score != 7.5 || first.Average != 10 || first.Votes != 2 {
+		t.Errorf("anime 1: %+v, want score 7.5 of average 10", first)
+	}
+	if want := []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 2}; !reflect.DeepEqual(first.Histogram, want) {
+		t.Errorf("anime 1 histogram = %v, want %v", first.Histogram, want)
+	}
+
+	// (30*5 + 10*7) / 40
+	second := scores["2"]
+	if second.Score != 5.5 || second.Average != 5 {
+		t.Errorf("anime 2: %+v, want score 5.5 of average 5", second)
+	}
+	if want := []int{0, 0, 0, 0, 30, 0, 0, 0, 0, 0}; !reflect.DeepEqual(second.Histogram, want) {
+		t.Errorf("anime 2 histogram = %v, want %v", second.Histogram, want)
+	}
+
+	if _, err := s.CommunityScores(context.Background(), []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	if repo.averageCalls != 1 {
+		t.Errorf("site average computed %d times, want it cached", repo.averageCalls)
+	}
+}
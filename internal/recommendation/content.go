@@ -0,0 +1,391 @@
+package recommendation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+)
+
+const (
+	catalogPageSize = 50
+	catalogPages    = 20
+	// сколько лучших по оценке аниме каждого жанра добавляется к топу
+	catalogGenreSize = 50
+	// пауза между запросами каталога, чтобы не упереться в лимиты Shikimori
+	catalogRequestInterval = 700 * time.Millisecond
+	catalogTTL             = 24 * time.Hour
+	// через сколько повторить загрузку каталога после ошибки
+	catalogRetry = 10 * time.Minute
+
+	// если избранного нет, за основу берутся аниме с оценкой от minLikedScore
+	minLikedScore = 8
+	maxLiked      = 50
+	// минимальная похожесть, с которой аниме попадает в рекомендации
+	minContentScore = 0.3
+	// сколько общих жанров перечисляется в объяснении
+	maxExplainedGenres = 3
+)
+
+// Веса признаков похожести, в сумме 1.
+const (
+	genresWeight = 0.6
+	studioWeight = 0.2
+	kindWeight   = 0.1
+	yearWeight   = 0.1
+	// разница в годах выхода, после которой год не учитывается
+	yearWindow = 10
+)
+
+// ignoredKinds - клипы и рекламу не рекомендуем.
+var ignoredKinds = map[string]bool{"music": true, "pv": true, "cm": true}
+
+// catalog - кэш аниме Shikimori, среди которых ищутся похожие: топ
+// catalogPages*catalogPageSize по популярности и лучшие по оценке аниме
+// каждого встреченного в нем жанра, чтобы в рекомендации попадали и нишевые
+// тайтлы. Аниме вне каталога не рекомендуются. Обновляется в фоне из Run.
+type catalog struct {
+	animes Animes
+	pause  time.Duration
+
+	mu     sync.RWMutex
+	items  []shikimori.Anime
+	genres genreWeights
+}
+
+// genreWeights - IDF жанров по каталогу: редкие жанры говорят о похожести
+// больше, чем комедия или экшен.
+type genreWeights struct {
+	idf map[string]float64
+	// вес жанра, которого нет в каталоге
+	rare float64
+}
+
+func (w genreWeights) weight(id string) float64 {
+	if idf, ok := w.idf[id]; ok {
+		return idf
+	}
+	return w.rare
+}
+
+// get возвращает последний загруженный каталог, не дожидаясь обновления.
+// До первой загрузки каталог пуст.
+func (c *catalog) get() ([]shikimori.Anime, genreWeights) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.items, c.genres
+}
+
+// refresh загружает каталог заново и подменяет им прежний, который до тех пор
+// продолжает отдаваться. Если загрузка оборвалась, прежний каталог остается,
+// а первой загрузке хватит и части.
+func (c *catalog) refresh(ctx context.Context) error {
+	items, err := c.load(ctx)
+	if err != nil {
+		c.mu.RLock()
+		loaded := c.items != nil
+		c.mu.RUnlock()
+		if loaded || len(items) == 0 {
+			return err
+		}
+	}
+	c.store(items)
+	return err
+}
+
+func (c *catalog) load(ctx context.Context) ([]shikimori.Anime, error) {
+	var items []shikimori.Anime
+	seen := make(map[string]bool)
+	add := func(batch []shikimori.Anime) {
+		for _, anime := range batch {
+			if !ignoredKinds[anime.Kind] && !seen[anime.ID] {
+				seen[anime.ID] = true
+				items = append(items, anime)
+			}
+		}
+	}
+
+	for page := 1; page <= catalogPages; page++ {
+		if err := c.wait(ctx, page > 1); err != nil {
+			return items, err
+		}
+		batch, err := c.animes.GetPopularAnime(ctx, page, catalogPageSize)
+		if err != nil {
+			return items, fmt.Errorf("popular page %d: %w", page, err)
+		}
+		add(batch)
+		if len(batch) < catalogPageSize {
+			break
+		}
+	}
+
+	// жанры берутся из топа, так что в каталог попадают и те, что в нем редки
+	seenGenres := make(map[string]bool)
+	var genres []string
+	for _, anime := range items {
+		for _, genre := range anime.Genres {
+			if !seenGenres[genre.ID] {
+				seenGenres[genre.ID] = true
+				genres = append(genres, genre.ID)
+			}
+		}
+	}
+	sort.Strings(genres)
+	for _, genre := range genres {
+		if err := c.wait(ctx, true); err != nil {
+			return items, err
+		}
+		batch, err := c.animes.GetRankedAnimeByGenre(ctx, genre, catalogGenreSize)
+		if err != nil {
+			return items, fmt.Errorf("genre %s: %w", genre, err)
+		}
+		add(batch)
+	}
+	return items, nil
+}
+
+func (c *catalog) wait(ctx context.Context, pause bool) error {
+	if !pause || c.pause <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(c.pause)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// store сохраняет каталог и считает веса жанров.
+func (c *catalog) store(items []shikimori.Anime) {
+	counts := make(map[string]int)
+	for _, anime := range items {
+		for _, genre := range anime.Genres {
+			counts[genre.ID]++
+		}
+	}
+	weights := genreWeights{
+		idf:  make(map[string]float64, len(counts)),
+		rare: math.Log(1 + float64(len(items)+1)),
+	}
+	for id, n := range counts {
+		weights.idf[id] = math.Log(1 + float64(len(items))/float64(n))
+	}
+	if items == nil {
+		items = []shikimori.Anime{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = items
+	c.genres = weights
+}
+
+// match - чем кандидат похож на понравившееся аниме.
+type match struct {
+	score   float64
+	genres  []shikimori.Genre
+	studios []shikimori.Studio
+}
+
+// contentSimilarity сравнивает аниме по жанрам (взвешенный по IDF индекс
+// Жаккара), студиям, типу и году выхода.
+func contentSimilarity(liked, candidate *shikimori.Anime, genres genreWeights) match {
+	weight := genres.weight
+
+	var m match
+	likedGenres := make(map[string]bool, len(liked.Genres))
+	var union float64
+	for _, genre := range liked.Genres {
+		likedGenres[genre.ID] = true
+		union += weight(genre.ID)
+	}
+	var shared float64
+	for _, genre := range candidate.Genres {
+		if likedGenres[genre.ID] {
+			shared += weight(genre.ID)
+			m.genres = append(m.genres, genre)
+		} else {
+			union += weight(genre.ID)
+		}
+	}
+	if union > 0 {
+		m.score += genresWeight * shared / union
+	}
+
+	likedStudios := make(map[string]bool, len(liked.Studios))
+	for _, studio := range liked.Studios {
+		likedStudios[studio.ID] = true
+	}
+	for _, studio := range candidate.Studios {
+		if likedStudios[studio.ID] {
+			m.studios = append(m.studios, studio)
+		}
+	}
+	if len(m.studios) > 0 {
+		m.score += studioWeight
+	}
+
+	if liked.Kind != "" && liked.Kind == candidate.Kind {
+		m.score += kindWeight
+	}
+	if liked.AiredOn != nil && candidate.AiredOn != nil && liked.AiredOn.Year > 0 && candidate.AiredOn.Year > 0 {
+		diff := math.Abs(float64(liked.AiredOn.Year - candidate.AiredOn.Year))
+		m.score += yearWeight * max(0, 1-diff/yearWindow)
+	}
+
+	sort.SliceStable(m.genres, func(i, j int) bool {
+		return weight(m.genres[i].ID) > weight(m.genres[j].ID)
+	})
+	return m
+}
+
+// explain описывает совпадение, например
+// "shares studio Madhouse and genres Psychological, Thriller".
+func (m match) explain() string {
+	var parts []string
+	if len(m.studios) > 0 {
+		names := make([]string, len(m.studios))
+		for i, studio := range m.studios {
+			names[i] = studio.Name
+		}
+		parts = append(parts, plural(len(names), "studio", "studios")+" "+strings.Join(names, ", "))
+	}
+	if len(m.genres) > 0 {
+		genres := m.genres[:min(len(m.genres), maxExplainedGenres)]
+		names := make([]string, len(genres))
+		for i, genre := range genres {
+			names[i] = genre.Name
+		}
+		parts = append(parts, plural(len(names), "genre", "genres")+" "+strings.Join(names, ", "))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "shares " + strings.Join(parts, " and ")
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// likedAnimeIDs - избранное пользователя, а без него - аниме с высокой оценкой.
+func likedAnimeIDs(entries []user.AnimeEntry) []string {
+	var favorites, scored []user.AnimeEntry
+	for _, entry := range entries {
+		switch {
+		case entry.IsFavorite:
+			favorites = append(favorites, entry)
+		case entry.Score != nil && *entry.Score >= minLikedScore:
+			scored = append(scored, entry)
+		}
+	}
+
+	liked := favorites
+	if len(liked) == 0 {
+		liked = scored
+		sort.SliceStable(liked, func(i, j int) bool {
+			if *liked[i].Score != *liked[j].Score {
+				return *liked[i].Score > *liked[j].Score
+			}
+			return liked[i].UpdatedAt.After(liked[j].UpdatedAt)
+		})
+	}
+
+	ids := make([]string, 0, min(len(liked), maxLiked))
+	for _, entry := range liked[:min(len(liked), maxLiked)] {
+		ids = append(ids, entry.AnimeID)
+	}
+	return ids
+}
+
+// BecauseYouLiked рекомендует аниме из каталога, похожие по содержанию на
+// избранное пользователя, с объяснением, чем именно.
+func (s *service) BecauseYouLiked(ctx context.Context, userID uuid.UUID, limit int) (*Result, error) {
+	limit = min(max(limit, 1), maxLimit)
+	entries, err := s.lists.GetAnimeList(userID.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	inList := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		inList[entry.AnimeID] = true
+	}
+
+	likedIDs := likedAnimeIDs(entries)
+	candidates, genres := s.catalog.get()
+	// без понравившихся аниме или пока каталог не загружен отдаем топ
+	if len(likedIDs) == 0 || len(candidates) == 0 {
+		items, err := s.top(ctx, inList, limit)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Source: SourceTop, Items: items}, nil
+	}
+
+	// понравившиеся аниме берутся из каталога, остальные догружаются
+	byID := make(map[string]*shikimori.Anime, len(candidates))
+	for i := range candidates {
+		byID[candidates[i].ID] = &candidates[i]
+	}
+	var missing []string
+	for _, id := range likedIDs {
+		if byID[id] == nil {
+			missing = append(missing, id)
+		}
+	}
+	fetched, _ := s.animes.FetchAnimesByIDs(ctx, missing)
+	liked := make([]*shikimori.Anime, 0, len(likedIDs))
+	for _, id := range likedIDs {
+		if anime := byID[id]; anime != nil {
+			liked = append(liked, anime)
+		} else if anime, ok := fetched[id]; ok {
+			liked = append(liked, &anime)
+		}
+	}
+
+	items := []Recommendation{}
+	for i := range candidates {
+		candidate := &candidates[i]
+		if inList[candidate.ID] {
+			continue
+		}
+		var best match
+		var because string
+		for _, l := range liked {
+			if m := contentSimilarity(l, candidate, genres); m.score > best.score {
+				best, because = m, l.ID
+			}
+		}
+		if best.score < minContentScore {
+			continue
+		}
+		anime := *candidate
+		items = append(items, Recommendation{
+			AnimeID:     candidate.ID,
+			Score:       math.Round(best.score*1000) / 1000,
+			BecauseOf:   []string{because},
+			Explanation: best.explain(),
+			Anime:       &anime,
+		})
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return items[i].Anime.Score > items[j].Anime.Score
+	})
+	return &Result{Source: SourceContent, Items: items[:min(len(items), limit)]}, nil
+}
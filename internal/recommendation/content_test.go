@@ -0,0 +1,257 @@
+package recommendation
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+)
+
+// fakeAnimes отдает один популярный page и ranked по жанрам. Пока открыт
+// block, GetPopularAnime ждет его закрытия.
+type fakeAnimes struct {
+	Animes
+
+	mu      sync.Mutex
+	popular []shikimori.Anime
+	ranked  map[string][]shikimori.Anime
+	err     error
+	block   chan struct{}
+	genres  []string
+}
+
+func (f *fakeAnimes) GetPopularAnime(ctx context.Context, page, limit int) ([]shikimori.Anime, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if page > 1 {
+		return nil, nil
+	}
+	return f.popular, nil
+}
+
+func (f *fakeAnimes) GetRankedAnimeByGenre(ctx context.Context, genre string, limit int) ([]shikimori.Anime, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.genres = append(f.genres, genre)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.ranked[genre], nil
+}
+
+func anime(id string, genres ...string) shikimori.Anime {
+	a := shikimori.Anime{ID: id, Kind: "tv"}
+	for _, genre := range genres {
+		a.Genres = append(a.Genres, shikimori.Genre{ID: genre})
+	}
+	return a
+}
+
+func catalogIDs(c *catalog) []string {
+	items, _ := c.get()
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestCatalogAddsRankedAnimeOfEachGenre(t *testing.T) {
+	animes := &fakeAnimes{
+		popular: []shikimori.Anime{anime("1", "7"), anime("2", "7", "9"), {ID: "4", Kind: "music"}},
+		ranked: map[string][]shikimori.Anime{
+			"7": {anime("1", "7")},
+			// нишевое аниме, которого нет в топе
+			"9": {anime("3", "9"), anime("2", "7", "9")},
+		},
+	}
+	c := &catalog{animes: animes}
+
+	if err := c.refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if got := catalogIDs(c); len(got) != 3 || got[0] != "1" || got[1] != "2" || got[2] != "3" {
+		t.Fatalf("catalog = %v, want [1 2 3]", got)
+	}
+	if len(animes.genres) != 2 || animes.genres[0] != "7" || animes.genres[1] != "9" {
+		t.Fatalf("ranked genres requested: %v", animes.genres)
+	}
+}
+
+func TestCatalogServesPreviousSnapshotWhileRefreshing(t *testing.T) {
+	animes := &fakeAnimes{popular: []shikimori.Anime{anime("1")}}
+	c := &catalog{animes: animes}
+	if err := c.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	animes.block = make(chan struct{})
+	animes.popular = []shikimori.Anime{anime("2")}
+	done := make(chan error)
+	go func() { done <- c.refresh(context.Background()) }()
+
+	got := make(chan []string)
+	go func() { got <- catalogIDs(c) }()
+	select {
+	case ids := <-got:
+		if len(ids) != 1 || ids[0] != "1" {
+			t.Fatalf("catalog during refresh = %v, want the previous [1]", ids)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("get waits for the refresh")
+	}
+
+	close(animes.block)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if ids := catalogIDs(c); len(ids) != 1 || ids[0] != "2" {
+		t.Fatalf("catalog after refresh = %v, want [2]", ids)
+	}
+}
+
+func TestCatalogKeepsPreviousSnapshotOnError(t *testing.T) {
+	failure := errors.New("shikimori is down")
+	animes := &fakeAnimes{popular: []shikimori.Anime{anime("1", "7")}, err: failure}
+	c := &catalog{animes: animes}
+
+	// первой загрузке хватит и части каталога
+	if err := c.refresh(context.Background()); !errors.Is(err, failure) {
+		t.Fatalf("refresh: got %v, want %v", err, failure)
+	}
+	if ids := catalogIDs(c); len(ids) != 1 || ids[0] != "1" {
+		t.Fatalf("partial first catalog = %v, want [1]", ids)
+	}
+
+	animes.popular = []shikimori.Anime{anime("2", "7")}
+	if err := c.refresh(context.Background()); !errors.Is(err, failure) {
+		t.Fatalf("refresh: got %v, want %v", err, failure)
+	}
+	if ids := catalogIDs(c); len(ids) != 1 || ids[0] != "1" {
+		t.Fatalf("catalog after a failed refresh = %v, want the previous [1]", ids)
+	}
+}
+
+func named(a shikimori.Anime, studio string, year int) shikimori.Anime {
+	for i := range a.Genres {
+		a.Genres[i].Name = "Genre " + a.Genres[i].ID
+	}
+	if studio != "" {
+		a.Studios = []shikimori.Studio{{ID: studio, Name: "Studio " + studio}}
+	}
+	if year > 0 {
+		a.AiredOn = &shikimori.Date{Year: year}
+	}
+	return a
+}
+
+func TestContentSimilarity(t *testing.T) {
+	c := &catalog{}
+	// жанр "common" есть у всех, "rare" - у двух аниме из пяти
+	c.store([]shikimori.Anime{
+		anime("1", "common", "rare"), anime("2", "common", "rare"),
+		anime("3", "common"), anime("4", "common"), anime("5", "common"),
+	})
+	_, genres := c.get()
+
+	liked := named(anime("10", "common", "rare", "other"), "m", 2020)
+	same := contentSimilarity(&liked, &liked, genres)
+	if same.score < 0.999 || same.score > 1.001 {
+		t.Errorf("anime compared with itself scores %v, want 1", same.score)
+	}
+
+	rare := named(anime("11", "rare"), "", 0)
+	common := named(anime("12", "common"), "", 0)
+	if contentSimilarity(&liked, &rare, genres).score <= contentSimilarity(&liked, &common, genres).score {
+		t.Error("a shared rare genre counts no more than a shared common one")
+	}
+
+	candidate := named(anime("13", "common", "rare"), "m", 2015)
+	m := contentSimilarity(&liked, &candidate, genres)
+	if got, want := m.explain(), "shares studio Studio m and genres Genre rare, Genre common"; got != want {
+		t.Errorf("explanation %q, want %q", got, want)
+	}
+	// год учитывается только в пределах yearWindow
+	far := candidate
+	far.AiredOn = &shikimori.Date{Year: 2020 - yearWindow}
+	if diff := m.score - contentSimilarity(&liked, &far, genres).score; diff < yearWeight/2-0.001 || diff > yearWeight/2+0.001 {
+		t.Errorf("5 and 10 years apart differ by %v, want %v", diff, yearWeight/2)
+	}
+
+	movie := shikimori.Anime{ID: "14", Kind: "movie"}
+	if m := contentSimilarity(&liked, &movie, genres); m.score != 0 || m.explain() != "" {
+		t.Errorf("unrelated anime: %v %q", m.score, m.explain())
+	}
+}
+
+func TestLikedAnimeIDs(t *testing.T) {
+	scored := []user.AnimeEntry{
+		entry(1, "1", user.StatusCompleted, 8, false),
+		entry(2, "2", user.StatusCompleted, 10, false),
+		entry(3, "3", user.StatusCompleted, 8, false),
+		entry(4, "4", user.StatusCompleted, 7, false),
+		entry(5, "5", user.StatusPlanned, 0, false),
+	}
+	if got, want := likedAnimeIDs(scored), []string{"2", "3", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without favorites: %v, want %v", got, want)
+	}
+
+	withFavorite := append(scored, entry(6, "6", user.StatusCompleted, 0, true))
+	if got, want := likedAnimeIDs(withFavorite), []string{"6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("with favorites: %v, want %v", got, want)
+	}
+}
+
+func TestBecauseYouLiked(t *testing.T) {
+	liked := named(anime("1", "a", "b"), "m", 2020)
+	lists := fakeLists{
+		entry(1, "1", user.StatusCompleted, 0, true),
+		entry(2, "2", user.StatusWatching, 0, false),
+	}
+	animes := &topAnimes{top: []shikimori.Anime{{ID: "1"}, {ID: "5"}}}
+	s := NewService(&fakeRepository{}, lists, animes).(*service)
+
+	// пока каталог не загружен, отдается топ без аниме из списка
+	result, err := s.BecauseYouLiked(context.Background(), uuid.New(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Source != SourceTop || len(result.Items) != 1 || result.Items[0].AnimeID != "5" {
+		t.Errorf("without catalog: %+v", result)
+	}
+
+	s.catalog.store([]shikimori.Anime{
+		liked,
+		named(anime("2", "a", "b"), "m", 2020), // уже в списке
+		named(anime("3", "a", "b"), "m", 2019),
+		named(anime("4", "a"), "", 2000),
+		named(anime("6", "c"), "", 0),
+		anime("7", "x"), anime("8", "y"), anime("9", "z"),
+	})
+	result, err = s.BecauseYouLiked(context.Background(), uuid.New(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, item := range result.Items {
+		ids = append(ids, item.AnimeID)
+	}
+	if result.Source != SourceContent || !reflect.DeepEqual(ids, []string{"3", "4"}) {
+		t.Fatalf("%s %v, want content [3 4]", result.Source, ids)
+	}
+	first := result.Items[0]
+	if !reflect.DeepEqual(first.BecauseOf, []string{"1"}) || first.Explanation != "shares studio Studio m and genres Genre b, Genre a" {
+		t.Errorf("first recommendation %+v", first)
+	}
+}
@@ -0,0 +1,67 @@
+package recommendation
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/pkg/pagination"
+	"github.com/labstack/echo/v4"
+)
+
+const defaultLimit = 20
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// GetRecommendations отдает рекомендации для текущего пользователя.
+func (h *Handler) GetRecommendations(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.service.ForUser(c.Request().Context(), principal.UserID, pagination.Limit(c, defaultLimit, maxLimit))
+	if err != nil {
+		return recommendationError(c, err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// GetContentRecommendations отдает аниме, похожие по содержанию на избранное
+// текущего пользователя, с объяснениями.
+func (h *Handler) GetContentRecommendations(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.service.BecauseYouLiked(c.Request().Context(), principal.UserID, pagination.Limit(c, defaultLimit, maxLimit))
+	if err != nil {
+		return recommendationError(c, err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// GetSimilar отдает аниме, которые чаще всего встречаются в списках вместе с данным.
+func (h *Handler) GetSimilar(c echo.Context) error {
+	items, err := h.service.Similar(c.Request().Context(), c.Param("id"), pagination.Limit(c, defaultLimit, maxLimit))
+	if err != nil {
+		return recommendationError(c, err)
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+func recommendationError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrInvalidAnimeID):
+		status = http.StatusBadRequest
+	}
+	return c.JSON(status, echo.Map{"error": err.Error()})
+}
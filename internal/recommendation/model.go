@@ -0,0 +1,42 @@
+package recommendation
+
+import (
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+)
+
+const (
+	SourceCollaborative = "collaborative"
+	SourceContent       = "content" // похожие по жанрам, студиям, типу и году
+	SourceTop           = "top"     // холодный старт: топ Shikimori
+)
+
+// Similarity - похожесть двух аниме по совместному появлению в списках
+// (косинусная мера). Таблица пересчитывается фоновой задачей целиком.
+type Similarity struct {
+	AnimeID   string    `gorm:"size:32;primaryKey"`
+	SimilarID string    `gorm:"size:32;primaryKey"`
+	Score     float64   `gorm:"not null"`
+	CoCount   int       `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+func (Similarity) TableName() string {
+	return "anime_similarities"
+}
+
+type Recommendation struct {
+	AnimeID string  `json:"anime_id"`
+	Score   float64 `json:"score"`
+	// аниме из списка пользователя, из-за которых появилась рекомендация
+	BecauseOf []string `json:"because_of,omitempty"`
+	// чем рекомендация похожа на аниме из BecauseOf
+	Explanation string           `json:"explanation,omitempty"`
+	Anime       *shikimori.Anime `json:"anime,omitempty"`
+}
+
+type Result struct {
+	Source string           `json:"source"`
+	Items  []Recommendation `json:"items"`
+}
@@ -0,0 +1,115 @@
+package recommendation
+
+import (
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"gorm.io/gorm"
+)
+
+// refreshLockKey - ключ advisory lock пересчета, чтобы несколько экземпляров
+// сервера не считали одновременно.
+const refreshLockKey = 7301
+
+// RefreshParams - параметры пересчета похожести.
+type RefreshParams struct {
+	MinItemUsers   int // аниме реже в списках не участвуют
+	MinCoCount     int // минимум пользователей, у которых есть оба аниме
+	MaxUserEntries int // сколько последних записей пользователя учитывается
+	TopPerAnime    int // сколько похожих хранится на каждое аниме
+}
+
+type Repository interface {
+	// Refresh пересчитывает таблицу похожести. ran == false, если пересчет
+	// уже идет в другом экземпляре.
+	Refresh(params RefreshParams) (rows int64, ran bool, err error)
+	LastRefreshedAt() (*time.Time, error)
+	FindSimilar(animeIDs []string, limitPerAnime int) ([]Similarity, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// Refresh пересчитывает похожесть в одной транзакции: читатели до коммита
+// видят старую таблицу. Учитываются записи, которые говорят, что аниме
+// понравилось или смотрится: просмотренные, смотрю, отложенные и избранное,
+// кроме оцененных на 4 и ниже.
+func (r *repository) Refresh(params RefreshParams) (int64, bool, error) {
+	var rows int64
+	ran := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", refreshLockKey).Scan(&ran).Error; err != nil || !ran {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM anime_similarities").Error; err != nil {
+			return err
+		}
+		res := tx.Exec(`
+			WITH positive AS (
+				SELECT user_id, anime_id FROM (
+					SELECT user_id, anime_id,
+						row_number() OVER (PARTITION BY user_id ORDER BY updated_at DESC) AS n
+					FROM user_anime_entries
+					WHERE (status IN ? OR is_favorite) AND (score IS NULL OR score > 4)
+				) recent
+				WHERE n <= ?
+			),
+			counts AS (
+				SELECT anime_id, COUNT(*) AS users FROM positive
+				GROUP BY anime_id HAVING COUNT(*) >= ?
+			),
+			pairs AS (
+				SELECT a.anime_id, b.anime_id AS similar_id, COUNT(*) AS co_count
+				FROM positive a
+				JOIN positive b ON b.user_id = a.user_id AND b.anime_id <> a.anime_id
+				WHERE a.anime_id IN (SELECT anime_id FROM counts) AND b.anime_id IN (SELECT anime_id FROM counts)
+				GROUP BY a.anime_id, b.anime_id
+				HAVING COUNT(*) >= ?
+			),
+			scored AS (
+				SELECT p.anime_id, p.similar_id, p.co_count,
+					p.co_count / sqrt(ca.users::float * cb.users) AS score
+				FROM pairs p
+				JOIN counts ca ON ca.anime_id = p.anime_id
+				JOIN counts cb ON cb.anime_id = p.similar_id
+			),
+			ranked AS (
+				SELECT *, row_number() OVER (PARTITION BY anime_id ORDER BY score DESC, co_count DESC, similar_id) AS rank
+				FROM scored
+			)
+			INSERT INTO anime_similarities (anime_id, similar_id, score, co_count, updated_at)
+			SELECT anime_id, similar_id, score, co_count, now() FROM ranked WHERE rank <= ?`,
+			[]string{user.StatusCompleted, user.StatusWatching, user.StatusOnHold},
+			params.MaxUserEntries, params.MinItemUsers, params.MinCoCount, params.TopPerAnime)
+		rows = res.RowsAffected
+		return res.Error
+	})
+	return rows, ran, err
+}
+
+func (r *repository) LastRefreshedAt() (*time.Time, error) {
+	var last *time.Time
+	err := r.db.Model(&Similarity{}).Select("MAX(updated_at)").Scan(&last).Error
+	return last, err
+}
+
+// FindSimilar возвращает до limitPerAnime самых похожих на каждое аниме.
+func (r *repository) FindSimilar(animeIDs []string, limitPerAnime int) ([]Similarity, error) {
+	var similar []Similarity
+	if len(animeIDs) == 0 {
+		return similar, nil
+	}
+	err := r.db.Raw(`
+		SELECT anime_id, similar_id, score, co_count, updated_at FROM (
+			SELECT *, row_number() OVER (PARTITION BY anime_id ORDER BY score DESC, similar_id) AS rank
+			FROM anime_similarities WHERE anime_id IN ?
+		) ranked
+		WHERE rank <= ?
+		ORDER BY anime_id, score DESC`, animeIDs, limitPerAnime).Scan(&similar).Error
+	return similar, err
+}
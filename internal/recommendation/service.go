@@ -0,0 +1,314 @@
+package recommendation
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultRefreshInterval = 6 * time.Hour
+
+	// минимум аниме в списке, с которого рекомендации строятся по похожести
+	minSeeds = 3
+	// сколько последних понравившихся аниме пользователя берется за основу
+	maxSeeds = 200
+	// сколько похожих на каждое аниме из списка учитывается
+	similarPerSeed = 30
+	maxBecauseOf   = 3
+	maxLimit       = 50
+)
+
+var (
+	ErrInvalidAnimeID = errors.New("invalid anime id")
+)
+
+var defaultRefreshParams = RefreshParams{
+	MinItemUsers: 3,
+	MinCoCount:   2,
+	// пары строятся внутри списка каждого пользователя, их число растет
+	// квадратично: 100 записей дают до 10 тысяч строк на пользователя
+	MaxUserEntries: 100,
+	TopPerAnime:    50,
+}
+
+// Animes - часть shikimori.Service, нужная рекомендациям.
+type Animes interface {
+	FetchAnimesByIDs(ctx context.Context, ids []string) (map[string]shikimori.Anime, []string)
+	GetTopAnime(ctx context.Context, limit int, page int, genre string) ([]shikimori.Anime, error)
+	GetPopularAnime(ctx context.Context, page, limit int) ([]shikimori.Anime, error)
+	GetRankedAnimeByGenre(ctx context.Context, genre string, limit int) ([]shikimori.Anime, error)
+}
+
+// ListReader - часть user.Service, нужная рекомендациям.
+type ListReader interface {
+	GetAnimeList(userID, status string) ([]user.AnimeEntry, error)
+}
+
+type Service interface {
+	ForUser(ctx context.Context, userID uuid.UUID, limit int) (*Result, error)
+	BecauseYouLiked(ctx context.Context, userID uuid.UUID, limit int) (*Result, error)
+	Similar(ctx context.Context, animeID string, limit int) ([]Recommendation, error)
+	Run(ctx context.Context)
+}
+
+type service struct {
+	repo     Repository
+	lists    ListReader
+	animes   Animes
+	catalog  *catalog
+	interval time.Duration
+}
+
+func NewService(repo Repository, lists ListReader, animes Animes) Service {
+	interval, err := time.ParseDuration(os.Getenv("RECOMMENDATIONS_REFRESH_INTERVAL"))
+	if err != nil || interval < time.Minute {
+		interval = defaultRefreshInterval
+	}
+	return &service{
+		repo:     repo,
+		lists:    lists,
+		animes:   animes,
+		catalog:  &catalog{animes: animes, pause: catalogRequestInterval},
+		interval: interval,
+	}
+}
+
+// ForUser рекомендует аниме, похожие на понравившиеся пользователю, кроме
+// тех, что уже есть в его списке. Пользователям с коротким списком или без
+// похожих аниме отдается топ Shikimori.
+func (s *service) ForUser(ctx context.Context, userID uuid.UUID, limit int) (*Result, error) {
+	limit = min(max(limit, 1), maxLimit)
+	entries, err := s.lists.GetAnimeList(userID.String(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	inList := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		inList[entry.AnimeID] = true
+	}
+	seeds := seedWeights(entries)
+
+	if len(seeds) >= minSeeds {
+		items, err := s.collaborative(seeds, inList, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) > 0 {
+			return &Result{Source: SourceCollaborative, Items: s.withAnime(ctx, items)}, nil
+		}
+	}
+
+	items, err := s.top(ctx, inList, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Source: SourceTop, Items: items}, nil
+}
+
+// seedWeights - вес каждого понравившегося аниме: оценка, если она есть,
+// и бонус за избранное. Берутся последние maxSeeds записей.
+func seedWeights(entries []user.AnimeEntry) map[string]float64 {
+	sorted := append([]user.AnimeEntry{}, entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].UpdatedAt.After(sorted[j].UpdatedAt)
+	})
+
+	seeds := make(map[string]float64)
+	for _, entry := range sorted {
+		if len(seeds) >= maxSeeds {
+			break
+		}
+		positive := entry.IsFavorite || entry.Status == user.StatusCompleted ||
+			entry.Status == user.StatusWatching || entry.Status == user.StatusOnHold
+		if !positive || (entry.Score != nil && *entry.Score <= 4) {
+			continue
+		}
+		weight := 0.7
+		if entry.Score != nil {
+			weight = float64(*entry.Score) / 10
+		}
+		if entry.IsFavorite {
+			weight += 0.5
+		}
+		seeds[entry.AnimeID] = weight
+	}
+	return seeds
+}
+
+func (s *service) collaborative(seeds map[string]float64, inList map[string]bool, limit int) ([]Recommendation, error) {
+	ids := make([]string, 0, len(seeds))
+	for id := range seeds {
+		ids = append(ids, id)
+	}
+	similar, err := s.repo.FindSimilar(ids, similarPerSeed)
+	if err != nil {
+		return nil, err
+	}
+
+	type contribution struct {
+		seed  string
+		score float64
+	}
+	scores := make(map[string]float64)
+	reasons := make(map[string][]contribution)
+	for _, sim := range similar {
+		if inList[sim.SimilarID] {
+			continue
+		}
+		score := sim.Score * seeds[sim.AnimeID]
+		scores[sim.SimilarID] += score
+		reasons[sim.SimilarID] = append(reasons[sim.SimilarID], contribution{sim.AnimeID, score})
+	}
+
+	items := make([]Recommendation, 0, len(scores))
+	for id, score := range scores {
+		because := reasons[id]
+		sort.Slice(because, func(i, j int) bool {
+			if because[i].score != because[j].score {
+				return because[i].score > because[j].score
+			}
+			return because[i].seed < because[j].seed
+		})
+		item := Recommendation{AnimeID: id, Score: score}
+		for _, c := range because[:min(len(because), maxBecauseOf)] {
+			item.BecauseOf = append(item.BecauseOf, c.seed)
+		}
+		items = append(items, item)
+	}
+	sortByScore(items)
+	return items[:min(len(items), limit)], nil
+}
+
+// top - холодный старт: топ Shikimori без аниме из списка.
+func (s *service) top(ctx context.Context, inList map[string]bool, limit int) ([]Recommendation, error) {
+	items := []Recommendation{}
+	for page := 1; page <= 3 && len(items) < limit; page++ {
+		animes, err := s.animes.GetTopAnime(ctx, maxLimit, page, "")
+		if err != nil {
+			return nil, err
+		}
+		for i := range animes {
+			if inList[animes[i].ID] || len(items) >= limit {
+				continue
+			}
+			items = append(items, Recommendation{AnimeID: animes[i].ID, Score: animes[i].Score, Anime: &animes[i]})
+		}
+		if len(animes) < maxLimit {
+			break
+		}
+	}
+	return items, nil
+}
+
+func (s *service) Similar(ctx context.Context, animeID string, limit int) ([]Recommendation, error) {
+	if !shikimori.IsValidAnimeID(animeID) {
+		return nil, ErrInvalidAnimeID
+	}
+	limit = min(max(limit, 1), maxLimit)
+
+	similar, err := s.repo.FindSimilar([]string{animeID}, limit)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Recommendation, 0, len(similar))
+	for _, sim := range similar {
+		items = append(items, Recommendation{AnimeID: sim.SimilarID, Score: sim.Score})
+	}
+	return s.withAnime(ctx, items), nil
+}
+
+// withAnime добавляет данные Shikimori. Рекомендации, которые не удалось
+// загрузить, отдаются без них.
+func (s *service) withAnime(ctx context.Context, items []Recommendation) []Recommendation {
+	ids := make([]string, len(items))
+	for i := range items {
+		ids[i] = items[i].AnimeID
+	}
+	animes, _ := s.animes.FetchAnimesByIDs(ctx, ids)
+	for i := range items {
+		if anime, ok := animes[items[i].AnimeID]; ok {
+			items[i].Anime = &anime
+		}
+	}
+	return items
+}
+
+// Run - фоновый пересчет похожести раз в interval, работает до отмены ctx.
+// Рядом в своей горутине обновляется каталог для BecauseYouLiked: его загрузка
+// долгая и не должна задерживать пересчет.
+func (s *service) Run(ctx context.Context) {
+	go s.runCatalog(ctx)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	last, err := s.repo.LastRefreshedAt()
+	if err != nil {
+		log.Printf("recommendations: failed to check last refresh: %v", err)
+	}
+	if last == nil || time.Since(*last) >= s.interval {
+		s.refresh()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refresh()
+		}
+	}
+}
+
+// runCatalog обновляет каталог раз в catalogTTL, после ошибки - через catalogRetry.
+func (s *service) runCatalog(ctx context.Context) {
+	for {
+		next := catalogTTL
+		if err := s.catalog.refresh(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("recommendations: failed to load catalog: %v", err)
+			next = catalogRetry
+		}
+
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *service) refresh() {
+	started := time.Now()
+	rows, ran, err := s.repo.Refresh(defaultRefreshParams)
+	switch {
+	case err != nil:
+		log.Printf("recommendations: refresh failed: %v", err)
+	case !ran:
+		log.Printf("recommendations: refresh is already running elsewhere, skipped")
+	default:
+		log.Printf("recommendations: %d similar pairs computed in %s", rows, time.Since(started).Round(time.Millisecond))
+	}
+}
+
+func sortByScore(items []Recommendation) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return items[i].AnimeID < items[j].AnimeID
+	})
+}
@@ -0,0 +1,172 @@
+package recommendation
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+)
+
+// topAnimes отдает одну страницу топа и любое аниме по ID.
+type topAnimes struct {
+	Animes
+	top []shikimori.Anime
+}
+
+func (f *topAnimes) GetTopAnime(ctx context.Context, limit int, page int, genre string) ([]shikimori.Anime, error) {
+	if page > 1 {
+		return nil, nil
+	}
+	return f.top, nil
+}
+
+func (f *topAnimes) FetchAnimesByIDs(ctx context.Context, ids []string) (map[string]shikimori.Anime, []string) {
+	found := make(map[string]shikimori.Anime, len(ids))
+	for _, id := range ids {
+		found[id] = shikimori.Anime{ID: id}
+	}
+	return found, nil
+}
+
+// fakeRepository отдает похожесть из similar для запрошенных аниме.
+type fakeRepository struct {
+	Repository
+	similar []Similarity
+}
+
+func (r *fakeRepository) FindSimilar(animeIDs []string, limitPerAnime int) ([]Similarity, error) {
+	requested := make(map[string]bool, len(animeIDs))
+	for _, id := range animeIDs {
+		requested[id] = true
+	}
+	var result []Similarity
+	for _, sim := range r.similar {
+		if requested[sim.AnimeID] {
+			result = append(result, sim)
+		}
+	}
+	return result, nil
+}
+
+type fakeLists []user.AnimeEntry
+
+func (l fakeLists) GetAnimeList(userID, status string) ([]user.AnimeEntry, error) {
+	return l, nil
+}
+
+var listStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// entry - запись списка; чем больше n, тем позже она изменена.
+func entry(n int, animeID, status string, score int, favorite bool) user.AnimeEntry {
+	e := user.AnimeEntry{AnimeID: animeID, Status: status, IsFavorite: favorite,
+		UpdatedAt: listStart.Add(time.Duration(n) * time.Hour)}
+	if score > 0 {
+		e.Score = &score
+	}
+	return e
+}
+
+func TestSeedWeights(t *testing.T) {
+	seeds := seedWeights([]user.AnimeEntry{
+		entry(1, "completed", user.StatusCompleted, 0, false),
+		entry(2, "scored", user.StatusWatching, 8, false),
+		entry(3, "favorite", user.StatusCompleted, 10, true),
+		entry(4, "planned favorite", user.StatusPlanned, 0, true),
+		entry(5, "disliked", user.StatusCompleted, 4, true),
+		entry(6, "planned", user.StatusPlanned, 0, false),
+		entry(7, "dropped", user.StatusDropped, 9, false),
+	})
+	want := map[string]float64{
+		"completed":        0.7,
+		"scored":           0.8,
+		"favorite":         1.5,
+		"planned favorite": 1.2,
+	}
+	if !reflect.DeepEqual(seeds, want) {
+		t.Fatalf("seeds = %v, want %v", seeds, want)
+	}
+}
+
+func TestSeedWeightsKeepsRecentEntries(t *testing.T) {
+	var entries []user.AnimeEntry
+	for i := 0; i < maxSeeds+10; i++ {
+		entries = append(entries, entry(i, fmt.Sprint(i), user.StatusCompleted, 0, false))
+	}
+	seeds := seedWeights(entries)
+	if len(seeds) != maxSeeds {
+		t.Fatalf("%d seeds, want %d", len(seeds), maxSeeds)
+	}
+	if _, ok := seeds["0"]; ok {
+		t.Fatal("the oldest entry is a seed")
+	}
+	if _, ok := seeds[fmt.Sprint(maxSeeds+9)]; !ok {
+		t.Fatal("the newest entry is not a seed")
+	}
+}
+
+func TestForUserCollaborative(t *testing.T) {
+	lists := fakeLists{
+		entry(1, "1", user.StatusCompleted, 10, false),
+		entry(2, "2", user.StatusCompleted, 5, false),
+		entry(3, "3", user.StatusWatching, 0, false),
+		entry(4, "4", user.StatusPlanned, 0, false),
+	}
+	repo := &fakeRepository{similar: []Similarity{
+		{AnimeID: "1", SimilarID: "10", Score: 0.5},
+		{AnimeID: "2", SimilarID: "10", Score: 0.5},
+		{AnimeID: "2", SimilarID: "20", Score: 0.9},
+		// уже в списке, пусть и в планах
+		{AnimeID: "3", SimilarID: "4", Score: 1},
+	}}
+	s := NewService(repo, lists, &topAnimes{})
+
+	result, err := s.ForUser(context.Background(), uuid.New(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Source != SourceCollaborative {
+		t.Fatalf("source = %s, want %s", result.Source, SourceCollaborative)
+	}
+	// 10: 0.5*1.0 + 0.5*0.5 = 0.75, 20: 0.9*0.5 = 0.45
+	if len(result.Items) != 2 {
+		t.Fatalf("items = %+v", result.Items)
+	}
+	first, second := result.Items[0], result.Items[1]
+	if first.AnimeID != "10" || first.Score != 0.75 || !reflect.DeepEqual(first.BecauseOf, []string{"1", "2"}) {
+		t.Errorf("first = %+v, want 10 with score 0.75 because of [1 2]", first)
+	}
+	if second.AnimeID != "20" || second.Score != 0.45 || second.Anime == nil {
+		t.Errorf("second = %+v, want 20 with score 0.45 and anime data", second)
+	}
+}
+
+func TestForUserColdStart(t *testing.T) {
+	animes := &topAnimes{top: []shikimori.Anime{{ID: "1"}, {ID: "100"}, {ID: "200"}, {ID: "300"}}}
+	repo := &fakeRepository{}
+
+	for name, lists := range map[string]fakeLists{
+		"short list": {entry(1, "1", user.StatusCompleted, 0, false)},
+		"no similar anime": {
+			entry(1, "1", user.StatusCompleted, 0, false),
+			entry(2, "2", user.StatusCompleted, 0, false),
+			entry(3, "3", user.StatusCompleted, 0, false),
+		},
+	} {
+		result, err := NewService(repo, lists, animes).ForUser(context.Background(), uuid.New(), 2)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var ids []string
+		for _, item := range result.Items {
+			ids = append(ids, item.AnimeID)
+		}
+		if result.Source != SourceTop || !reflect.DeepEqual(ids, []string{"100", "200"}) {
+			t.Errorf("%s: %s %v, want top [100 200] without the listed anime", name, result.Source, ids)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package review
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/pkg/pagination"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// viewer - пользователь публичного маршрута, nil для анонимов.
+func viewer(c echo.Context) *uuid.UUID {
+	if principal, ok := auth.FromContext(c); ok {
+		return &principal.UserID
+	}
+	return nil
+}
+
+func reviewID(c echo.Context) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return uuid.Nil, ErrNotFound
+	}
+	return id, nil
+}
+
+// List отдает рецензии на аниме. Рецензии со спойлерами скрыты, пока не
+// передан spoilers=true.
+func (h *Handler) List(c echo.Context) error {
+	page, limit := pagination.FromQuery(c, 20, 100)
+	spoilers, _ := strconv.ParseBool(c.QueryParam("spoilers"))
+
+	reviews, total, err := h.service.List(c.Request().Context(), viewer(c), c.Param("anime_id"), c.QueryParam("sort"), spoilers, page, limit)
+	if err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"reviews": reviews,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+func (h *Handler) Get(c echo.Context) error {
+	id, err := reviewID(c)
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	review, err := h.service.Get(c.Request().Context(), viewer(c), id)
+	if err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusOK, review)
+}
+
+func (h *Handler) ListMine(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	reviews, err := h.service.ListMine(principal.UserID)
+	if err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusOK, reviews)
+}
+
+func (h *Handler) Create(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req Input
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	review, err := h.service.Create(c.Request().Context(), principal.UserID, c.Param("anime_id"), req)
+	if err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusCreated, review)
+}
+
+func (h *Handler) Update(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := reviewID(c)
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	var req Input
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	review, err := h.service.Update(c.Request().Context(), principal.UserID, id, req)
+	if err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusOK, review)
+}
+
+func (h *Handler) Delete(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := reviewID(c)
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	if err := h.service.Delete(principal.UserID, id); err != nil {
+		return reviewError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) ModerateDelete(c echo.Context) error {
+	id, err := reviewID(c)
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	if err := h.service.ModerateDelete(id); err != nil {
+		return reviewError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) Vote(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := reviewID(c)
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	var req struct {
+		IsHelpful *bool `json:"is_helpful"`
+	}
+	if err := c.Bind(&req); err != nil || req.IsHelpful == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "is_helpful is required")
+	}
+
+	if err := h.service.Vote(principal.UserID, id, *req.IsHelpful); err != nil {
+		return reviewError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) RemoveVote(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := reviewID(c)
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	if err := h.service.RemoveVote(principal.UserID, id); err != nil {
+		return reviewError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func reviewError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrReviewExists):
+		status = http.StatusConflict
+	case errors.Is(err, ErrOwnReview):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrRejected):
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, ErrModeration):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrInvalidReview), errors.Is(err, ErrInvalidAnimeID), errors.Is(err, ErrInvalidSortOrder):
+		status = http.StatusBadRequest
+	}
+	return c.JSON(status, echo.Map{"error": err.Error()})
+}
@@ -0,0 +1,65 @@
+package review
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Review - развернутая рецензия на аниме. У пользователя не больше одной
+// рецензии на тайтл.
+type Review struct {
+	ID              uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID          uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_review_user_anime" json:"user_id"`
+	AnimeID         string    `gorm:"size:32;not null;uniqueIndex:idx_review_user_anime;index" json:"anime_id"`
+	Title           string    `gorm:"size:150;not null" json:"title"`
+	Body            string    `gorm:"type:text;not null" json:"body"`
+	Score           int       `gorm:"not null" json:"score"`
+	StoryScore      *int      `json:"story_score"`
+	ArtScore        *int      `json:"art_score"`
+	SoundScore      *int      `json:"sound_score"`
+	CharactersScore *int      `json:"characters_score"`
+	IsSpoiler       bool      `gorm:"not null;default:false" json:"is_spoiler"`
+	IsApproved      bool      `gorm:"default:true" json:"is_approved"`
+	HelpfulCount    int       `gorm:"not null;default:0" json:"helpful_count"`
+	NotHelpfulCount int       `gorm:"not null;default:0" json:"not_helpful_count"`
+	// HelpfulScore - нижняя граница доверительного интервала Уилсона для
+	// доли "полезно", по ней сортируется выдача
+	HelpfulScore float64   `gorm:"not null;default:0;index" json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// заполняется запросами с join на users
+	AuthorNickname string `gorm:"->;-:migration" json:"author_nickname,omitempty"`
+}
+
+type Vote struct {
+	ReviewID  uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+	IsHelpful bool      `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+func (Vote) TableName() string {
+	return "review_votes"
+}
+
+// Input - поля рецензии при создании и изменении. nil-поля не меняются,
+// нулевая подоценка удаляет ее.
+type Input struct {
+	Title           *string `json:"title"`
+	Body            *string `json:"body"`
+	Score           *int    `json:"score"`
+	StoryScore      *int    `json:"story_score"`
+	ArtScore        *int    `json:"art_score"`
+	SoundScore      *int    `json:"sound_score"`
+	CharactersScore *int    `json:"characters_score"`
+	IsSpoiler       *bool   `json:"is_spoiler"`
+}
+
+// WithVote - рецензия с голосом текущего пользователя: nil - нет голоса,
+// true - полезно, false - бесполезно.
+type WithVote struct {
+	Review
+	MyVote *bool `json:"my_vote"`
+}
@@ -0,0 +1,188 @@
+package review
+
+import (
+	"math"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	SortHelpful = "helpful"
+	SortNew     = "new"
+	SortScore   = "score"
+)
+
+type Repository interface {
+	Create(review *Review) error
+	FindByID(id uuid.UUID) (*Review, error)
+	FindByUserAndAnime(userID uuid.UUID, animeID string) (*Review, error)
+	FindByAnime(animeID, sort string, includeSpoilers bool, limit, offset int) ([]Review, int64, error)
+	FindByUser(userID uuid.UUID) ([]Review, error)
+	Update(review *Review) error
+	Delete(id uuid.UUID) error
+	Vote(reviewID, userID uuid.UUID, isHelpful bool) error
+	RemoveVote(reviewID, userID uuid.UUID) error
+	FindVotes(userID uuid.UUID, reviewIDs []uuid.UUID) (map[uuid.UUID]bool, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// withAuthor добавляет к рецензиям ник автора.
+func (r *repository) withAuthor() *gorm.DB {
+	return r.db.Model(&Review{}).
+		Select("reviews.*, users.nickname AS author_nickname").
+		Joins("JOIN users ON users.id = reviews.user_id")
+}
+
+func (r *repository) Create(review *Review) error {
+	return r.db.Create(review).Error
+}
+
+func (r *repository) FindByID(id uuid.UUID) (*Review, error) {
+	var review Review
+	if err := r.withAuthor().First(&review, "reviews.id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (r *repository) FindByUserAndAnime(userID uuid.UUID, animeID string) (*Review, error) {
+	var review Review
+	if err := r.withAuthor().First(&review, "reviews.user_id = ? AND reviews.anime_id = ?", userID, animeID).Error; err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (r *repository) FindByAnime(animeID, sort string, includeSpoilers bool, limit, offset int) ([]Review, int64, error) {
+	query := r.withAuthor().Where("reviews.anime_id = ? AND reviews.is_approved", animeID)
+	if !includeSpoilers {
+		query = query.Where("NOT reviews.is_spoiler")
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	order := "reviews.helpful_score DESC, reviews.helpful_count DESC, reviews.created_at DESC"
+	switch sort {
+	case SortNew:
+		order = "reviews.created_at DESC"
+	case SortScore:
+		order = "reviews.score DESC, reviews.helpful_score DESC, reviews.created_at DESC"
+	}
+	var reviews []Review
+	err := query.Order(order).Limit(limit).Offset(offset).Find(&reviews).Error
+	return reviews, total, err
+}
+
+func (r *repository) FindByUser(userID uuid.UUID) ([]Review, error) {
+	var reviews []Review
+	err := r.withAuthor().Where("reviews.user_id = ?", userID).Order("reviews.created_at DESC").Find(&reviews).Error
+	return reviews, err
+}
+
+func (r *repository) Update(review *Review) error {
+	return r.db.Model(review).
+		Select("title", "body", "score", "story_score", "art_score", "sound_score", "characters_score",
+			"is_spoiler", "is_approved", "updated_at").
+		Updates(review).Error
+}
+
+func (r *repository) Delete(id uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("review_id = ?", id).Delete(&Vote{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Review{}, "id = ?", id).Error
+	})
+}
+
+// Vote ставит или меняет голос пользователя и пересчитывает счетчики рецензии.
+func (r *repository) Vote(reviewID, userID uuid.UUID, isHelpful bool) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		vote := &Vote{ReviewID: reviewID, UserID: userID, IsHelpful: isHelpful}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "review_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"is_helpful"}),
+		}).Create(vote).Error
+		if err != nil {
+			return err
+		}
+		return recountVotes(tx, reviewID)
+	})
+}
+
+func (r *repository) RemoveVote(reviewID, userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("review_id = ? AND user_id = ?", reviewID, userID).Delete(&Vote{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return recountVotes(tx, reviewID)
+	})
+}
+
+// recountVotes пересчитывает счетчики по таблице голосов. Строка рецензии
+// блокируется, чтобы параллельные голоса не затерли друг друга.
+func recountVotes(tx *gorm.DB, reviewID uuid.UUID) error {
+	var review Review
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&review, "id = ?", reviewID).Error; err != nil {
+		return err
+	}
+
+	var counts struct {
+		Helpful    int
+		NotHelpful int
+	}
+	err := tx.Model(&Vote{}).
+		Select("COUNT(*) FILTER (WHERE is_helpful) AS helpful, COUNT(*) FILTER (WHERE NOT is_helpful) AS not_helpful").
+		Where("review_id = ?", reviewID).
+		Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&Review{}).Where("id = ?", reviewID).UpdateColumns(map[string]interface{}{
+		"helpful_count":     counts.Helpful,
+		"not_helpful_count": counts.NotHelpful,
+		"helpful_score":     wilsonLowerBound(counts.Helpful, counts.Helpful+counts.NotHelpful),
+	}).Error
+}
+
+func (r *repository) FindVotes(userID uuid.UUID, reviewIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	votes := make(map[uuid.UUID]bool)
+	if len(reviewIDs) == 0 {
+		return votes, nil
+	}
+
+	var rows []Vote
+	if err := r.db.Where("user_id = ? AND review_id IN ?", userID, reviewIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, vote := range rows {
+		votes[vote.ReviewID] = vote.IsHelpful
+	}
+	return votes, nil
+}
+
+// wilsonLowerBound - нижняя граница 95% доверительного интервала Уилсона:
+// рецензия с 40 из 50 "полезно" окажется выше рецензии с 2 из 2.
+func wilsonLowerBound(positive, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	const z = 1.96
+	n := float64(total)
+	p := float64(positive) / n
+	return (p + z*z/(2*n) - z*math.Sqrt((p*(1-p)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
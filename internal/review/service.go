@@ -0,0 +1,294 @@
+package review
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Zipklas/anime-site-backend/internal/moderation"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxTitleLength = 150
+	minBodyLength  = 200
+	maxBodyLength  = 20000
+)
+
+var (
+	ErrNotFound         = errors.New("review not found")
+	ErrReviewExists     = errors.New("you have already reviewed this anime")
+	ErrInvalidReview    = errors.New("invalid review")
+	ErrInvalidAnimeID   = errors.New("invalid anime id")
+	ErrOwnReview        = errors.New("you cannot vote for your own review")
+	ErrModeration       = errors.New("moderation service error")
+	ErrRejected         = errors.New("review rejected by moderation")
+	ErrInvalidSortOrder = errors.New("sort must be one of: helpful, new, score")
+)
+
+type Service interface {
+	List(ctx context.Context, viewer *uuid.UUID, animeID, sort string, includeSpoilers bool, page, limit int) ([]WithVote, int64, error)
+	Get(ctx context.Context, viewer *uuid.UUID, id uuid.UUID) (*WithVote, error)
+	ListMine(userID uuid.UUID) ([]Review, error)
+	Create(ctx context.Context, userID uuid.UUID, animeID string, input Input) (*Review, error)
+	Update(ctx context.Context, userID, id uuid.UUID, input Input) (*Review, error)
+	Delete(userID, id uuid.UUID) error
+	ModerateDelete(id uuid.UUID) error
+	Vote(userID, id uuid.UUID, isHelpful bool) error
+	RemoveVote(userID, id uuid.UUID) error
+}
+
+type service struct {
+	repo      Repository
+	moderator *moderation.Client
+}
+
+func NewService(repo Repository, moderator *moderation.Client) Service {
+	return &service{repo: repo, moderator: moderator}
+}
+
+func (s *service) List(ctx context.Context, viewer *uuid.UUID, animeID, sort string, includeSpoilers bool, page, limit int) ([]WithVote, int64, error) {
+	if !shikimori.IsValidAnimeID(animeID) {
+		return nil, 0, ErrInvalidAnimeID
+	}
+	switch sort {
+	case "":
+		sort = SortHelpful
+	case SortHelpful, SortNew, SortScore:
+	default:
+		return nil, 0, ErrInvalidSortOrder
+	}
+
+	reviews, total, err := s.repo.FindByAnime(animeID, sort, includeSpoilers, limit, (page-1)*limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	result, err := s.withVotes(viewer, reviews)
+	return result, total, err
+}
+
+func (s *service) Get(ctx context.Context, viewer *uuid.UUID, id uuid.UUID) (*WithVote, error) {
+	review, err := s.find(id)
+	if err != nil {
+		return nil, err
+	}
+	if !review.IsApproved && (viewer == nil || *viewer != review.UserID) {
+		return nil, ErrNotFound
+	}
+
+	result, err := s.withVotes(viewer, []Review{*review})
+	if err != nil {
+		return nil, err
+	}
+	return &result[0], nil
+}
+
+func (s *service) ListMine(userID uuid.UUID) ([]Review, error) {
+	return s.repo.FindByUser(userID)
+}
+
+func (s *service) Create(ctx context.Context, userID uuid.UUID, animeID string, input Input) (*Review, error) {
+	if !shikimori.IsValidAnimeID(animeID) {
+		return nil, ErrInvalidAnimeID
+	}
+	if _, err := s.repo.FindByUserAndAnime(userID, animeID); err == nil {
+		return nil, ErrReviewExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if input.Title == nil || input.Body == nil || input.Score == nil {
+		return nil, fmt.Errorf("%w: title, body and score are required", ErrInvalidReview)
+	}
+
+	review := &Review{
+		ID:      uuid.New(),
+		UserID:  userID,
+		AnimeID: animeID,
+	}
+	if err := applyInput(review, input); err != nil {
+		return nil, err
+	}
+	if err := s.moderate(ctx, review); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(review); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "idx_review_user_anime") {
+			return nil, ErrReviewExists
+		}
+		return nil, err
+	}
+	return review, nil
+}
+
+func (s *service) Update(ctx context.Context, userID, id uuid.UUID, input Input) (*Review, error) {
+	review, err := s.owned(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	textChanged := (input.Title != nil && *input.Title != review.Title) || (input.Body != nil && *input.Body != review.Body)
+	if err := applyInput(review, input); err != nil {
+		return nil, err
+	}
+	if textChanged {
+		if err := s.moderate(ctx, review); err != nil {
+			return nil, err
+		}
+	}
+
+	review.UpdatedAt = time.Now()
+	if err := s.repo.Update(review); err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+func (s *service) Delete(userID, id uuid.UUID) error {
+	if _, err := s.owned(userID, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(id)
+}
+
+func (s *service) ModerateDelete(id uuid.UUID) error {
+	if _, err := s.find(id); err != nil {
+		return err
+	}
+	return s.repo.Delete(id)
+}
+
+func (s *service) Vote(userID, id uuid.UUID, isHelpful bool) error {
+	review, err := s.find(id)
+	if err != nil {
+		return err
+	}
+	if !review.IsApproved {
+		return ErrNotFound
+	}
+	if review.UserID == userID {
+		return ErrOwnReview
+	}
+	return s.repo.Vote(id, userID, isHelpful)
+}
+
+func (s *service) RemoveVote(userID, id uuid.UUID) error {
+	if _, err := s.find(id); err != nil {
+		return err
+	}
+	return s.repo.RemoveVote(id, userID)
+}
+
+func (s *service) find(id uuid.UUID) (*Review, error) {
+	review, err := s.repo.FindByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	return review, err
+}
+
+func (s *service) owned(userID, id uuid.UUID) (*Review, error) {
+	review, err := s.find(id)
+	if err != nil {
+		return nil, err
+	}
+	if review.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return review, nil
+}
+
+// moderate проверяет заголовок и текст рецензии. Отклоненная рецензия не
+// сохраняется, как и комментарии.
+func (s *service) moderate(ctx context.Context, review *Review) error {
+	result, err := s.moderator.Moderate(ctx, review.Title+"\n\n"+review.Body)
+	if err != nil {
+		return ErrModeration
+	}
+	if !result.IsApproved {
+		return fmt.Errorf("%w: общий уровень токсичности %.0f%%, проблемные категории: %s",
+			ErrRejected, result.ToxicityScore*100, strings.Join(result.ToxicLabels(), ", "))
+	}
+	review.IsApproved = true
+	return nil
+}
+
+func (s *service) withVotes(viewer *uuid.UUID, reviews []Review) ([]WithVote, error) {
+	result := make([]WithVote, len(reviews))
+	for i := range reviews {
+		result[i].Review = reviews[i]
+	}
+	if viewer == nil || len(reviews) == 0 {
+		return result, nil
+	}
+
+	ids := make([]uuid.UUID, len(reviews))
+	for i := range reviews {
+		ids[i] = reviews[i].ID
+	}
+	votes, err := s.repo.FindVotes(*viewer, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		if vote, ok := votes[result[i].ID]; ok {
+			result[i].MyVote = &vote
+		}
+	}
+	return result, nil
+}
+
+func applyInput(review *Review, input Input) error {
+	if input.Title != nil {
+		title := strings.TrimSpace(*input.Title)
+		if title == "" || utf8.RuneCountInString(title) > maxTitleLength {
+			return fmt.Errorf("%w: title must be 1-%d characters", ErrInvalidReview, maxTitleLength)
+		}
+		review.Title = title
+	}
+	if input.Body != nil {
+		body := strings.TrimSpace(*input.Body)
+		if n := utf8.RuneCountInString(body); n < minBodyLength || n > maxBodyLength {
+			return fmt.Errorf("%w: body must be %d-%d characters", ErrInvalidReview, minBodyLength, maxBodyLength)
+		}
+		review.Body = body
+	}
+	if input.Score != nil {
+		if *input.Score < 1 || *input.Score > 10 {
+			return fmt.Errorf("%w: score must be between 1 and 10", ErrInvalidReview)
+		}
+		review.Score = *input.Score
+	}
+	subScores := []struct {
+		name  string
+		value *int
+		field **int
+	}{
+		{"story_score", input.StoryScore, &review.StoryScore},
+		{"art_score", input.ArtScore, &review.ArtScore},
+		{"sound_score", input.SoundScore, &review.SoundScore},
+		{"characters_score", input.CharactersScore, &review.CharactersScore},
+	}
+	for _, sub := range subScores {
+		if sub.value == nil {
+			continue
+		}
+		switch score := *sub.value; {
+		case score == 0:
+			*sub.field = nil
+		case score >= 1 && score <= 10:
+			*sub.field = &score
+		default:
+			return fmt.Errorf("%w: %s must be between 1 and 10", ErrInvalidReview, sub.name)
+		}
+	}
+	if input.IsSpoiler != nil {
+		review.IsSpoiler = *input.IsSpoiler
+	}
+	return nil
+}
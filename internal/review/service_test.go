@@ -0,0 +1,232 @@
+package review
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Zipklas/anime-site-backend/internal/moderation"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// fakeRepository хранит рецензии и голоса в памяти.
+type fakeRepository struct {
+	Repository
+
+	reviews map[uuid.UUID]*Review
+	votes   map[uuid.UUID]map[uuid.UUID]bool
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{reviews: map[uuid.UUID]*Review{}, votes: map[uuid.UUID]map[uuid.UUID]bool{}}
+}
+
+func (r *fakeRepository) Create(review *Review) error {
+	copied := *review
+	r.reviews[review.ID] = &copied
+	return nil
+}
+
+func (r *fakeRepository) FindByID(id uuid.UUID) (*Review, error) {
+	review, ok := r.reviews[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *review
+	return &copied, nil
+}
+
+func (r *fakeRepository) FindByUserAndAnime(userID uuid.UUID, animeID string) (*Review, error) {
+	for id, review := range r.reviews {
+		if review.UserID == userID && review.AnimeID == animeID {
+			return r.FindByID(id)
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) Update(review *Review) error {
+	return r.Create(review)
+}
+
+func (r *fakeRepository) Vote(reviewID, userID uuid.UUID, isHelpful bool) error {
+	if r.votes[reviewID] == nil {
+		r.votes[reviewID] = map[uuid.UUID]bool{}
+	}
+	r.votes[reviewID][userID] = isHelpful
+	return nil
+}
+
+func (r *fakeRepository) FindVotes(userID uuid.UUID, reviewIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	votes := map[uuid.UUID]bool{}
+	for _, id := range reviewIDs {
+		if vote, ok := r.votes[id][userID]; ok {
+			votes[id] = vote
+		}
+	}
+	return votes, nil
+}
+
+// moderator одобряет любой текст, кроме содержащего "токсично".
+func moderator(t *testing.T) *moderation.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct{ Text string }
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(request.Text, "токсично") {
+			json.NewEncoder(w).Encode(moderation.Result{ToxicityScore: 0.9, Details: map[string]float64{"insult": 0.9}})
+			return
+		}
+		json.NewEncoder(w).Encode(moderation.Result{IsApproved: true})
+	}))
+	t.Cleanup(server.Close)
+	return moderation.NewClient(server.URL)
+}
+
+func reviewInput(body string, score int) Input {
+	title := "Рецензия"
+	return Input{Title: &title, Body: &body, Score: &score}
+}
+
+var validBody = strings.Repeat("Хорошее аниме. ", minBodyLength/10)
+
+func TestApplyInput(t *testing.T) {
+	text := func(v string) *string { return &v }
+	num := func(v int) *int { return &v }
+
+	for _, tc := range []struct {
+		name  string
+		input Input
+		valid bool
+	}{
+		{"score", Input{Score: num(10)}, true},
+		{"score above scale", Input{Score: num(11)}, false},
+		{"zero score", Input{Score: num(0)}, false},
+		{"sub-score", Input{StoryScore: num(7)}, true},
+		{"zero sub-score clears", Input{ArtScore: num(0)}, true},
+		{"sub-score above scale", Input{SoundScore: num(11)}, false},
+		{"negative sub-score", Input{CharactersScore: num(-1)}, false},
+		{"blank title", Input{Title: text("  ")}, false},
+		{"long title", Input{Title: text(strings.Repeat("я", maxTitleLength+1))}, false},
+		{"short body", Input{Body: text(strings.Repeat("я", minBodyLength-1))}, false},
+		// длина считается в символах, а не байтах
+		{"body at minimum", Input{Body: text(strings.Repeat("я", minBodyLength))}, true},
+		{"long body", Input{Body: text(strings.Repeat("a", maxBodyLength+1))}, false},
+	} {
+		art := 5
+		review := &Review{ArtScore: &art}
+		err := applyInput(review, tc.input)
+		if tc.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidReview)) {
+			t.Errorf("%s: error %v", tc.name, err)
+		}
+	}
+
+	review := &Review{}
+	if err := applyInput(review, Input{ArtScore: num(0), StoryScore: num(8)}); err != nil {
+		t.Fatal(err)
+	}
+	if review.ArtScore != nil || review.StoryScore == nil || *review.StoryScore != 8 {
+		t.Errorf("sub-scores %v %v", review.ArtScore, review.StoryScore)
+	}
+}
+
+func TestCreateModeratesAndRejectsDuplicates(t *testing.T) {
+	repo := newFakeRepository()
+	s := NewService(repo, moderator(t))
+	ctx, author := context.Background(), uuid.New()
+
+	if _, err := s.Create(ctx, author, "1", reviewInput(validBody+"токсично", 8)); !errors.Is(err, ErrRejected) {
+		t.Errorf("toxic review: %v", err)
+	}
+	if len(repo.reviews) != 0 {
+		t.Errorf("rejected review is stored")
+	}
+	if _, err := s.Create(ctx, author, "abc", reviewInput(validBody, 8)); !errors.Is(err, ErrInvalidAnimeID) {
+		t.Errorf("invalid anime ID: %v", err)
+	}
+	if _, err := s.Create(ctx, author, "1", Input{Body: &validBody}); !errors.Is(err, ErrInvalidReview) {
+		t.Errorf("review without a title and score: %v", err)
+	}
+
+	review, err := s.Create(ctx, author, "1", reviewInput(validBody, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !review.IsApproved || review.Body != strings.TrimSpace(validBody) {
+		t.Errorf("created %+v", review)
+	}
+	if _, err := s.Create(ctx, author, "1", reviewInput(validBody, 6)); !errors.Is(err, ErrReviewExists) {
+		t.Errorf("second review of the same anime: %v", err)
+	}
+
+	// правка текста проходит модерацию заново
+	toxic := validBody + "токсично"
+	if _, err := s.Update(ctx, author, review.ID, Input{Body: &toxic}); !errors.Is(err, ErrRejected) {
+		t.Errorf("toxic edit: %v", err)
+	}
+	if _, err := s.Update(ctx, uuid.New(), review.ID, Input{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("edit by a stranger: %v", err)
+	}
+}
+
+func TestVote(t *testing.T) {
+	repo := newFakeRepository()
+	s := NewService(repo, moderation.NewClient(""))
+	author, reader := uuid.New(), uuid.New()
+
+	review, err := s.Create(context.Background(), author, "1", reviewInput(validBody, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Vote(author, review.ID, true); !errors.Is(err, ErrOwnReview) {
+		t.Errorf("vote for an own review: %v", err)
+	}
+	if err := s.Vote(reader, uuid.New(), true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("vote for a missing review: %v", err)
+	}
+	if err := s.Vote(reader, review.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get(context.Background(), &reader, review.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MyVote == nil || *got.MyVote {
+		t.Errorf("reader's vote = %v, want not helpful", got.MyVote)
+	}
+	if got, _ := s.Get(context.Background(), nil, review.ID); got.MyVote != nil {
+		t.Errorf("anonymous viewer sees a vote")
+	}
+
+	// неодобренную рецензию видит только автор, голосовать за нее нельзя
+	repo.reviews[review.ID].IsApproved = false
+	if _, err := s.Get(context.Background(), &reader, review.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unapproved review shown to a reader: %v", err)
+	}
+	if _, err := s.Get(context.Background(), &author, review.ID); err != nil {
+		t.Errorf("unapproved review hidden from the author: %v", err)
+	}
+	if err := s.Vote(reader, review.ID, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("vote for an unapproved review: %v", err)
+	}
+}
+
+func TestWilsonLowerBound(t *testing.T) {
+	if wilsonLowerBound(0, 0) != 0 {
+		t.Error("review without votes has a non-zero score")
+	}
+	if wilsonLowerBound(40, 50) <= wilsonLowerBound(2, 2) {
+		t.Error("40 of 50 helpful ranks below 2 of 2")
+	}
+	if wilsonLowerBound(10, 10) <= wilsonLowerBound(9, 10) {
+		t.Error("more helpful votes lower the score")
+	}
+}
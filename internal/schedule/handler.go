@@ -0,0 +1,107 @@
+package schedule
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// GetSchedule отдает расписание на неделю. С list=true - только аниме из
+// списка пользователя, нужна авторизация, а API-токену - scope lists:read:
+// маршрут под Optional, который scopes не проверяет.
+func (h *Handler) GetSchedule(c echo.Context) error {
+	var listOf *uuid.UUID
+	if onlyList, _ := strconv.ParseBool(c.QueryParam("list")); onlyList {
+		principal, err := auth.CurrentUser(c)
+		if err != nil {
+			return err
+		}
+		if !principal.HasScopes(auth.ScopeListsRead) {
+			return echo.NewHTTPError(http.StatusForbidden, "API token is missing required scope")
+		}
+		listOf = &principal.UserID
+	}
+
+	schedule, err := h.service.GetSchedule(c.Request().Context(), c.QueryParam("tz"), listOf)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	return c.JSON(http.StatusOK, schedule)
+}
+
+// Feed отдает iCalendar-ленту по секретной ссылке /calendar/<token>.ics.
+func (h *Handler) Feed(c echo.Context) error {
+	token, ok := strings.CutSuffix(c.Param("file"), ".ics")
+	if !ok || token == "" {
+		return scheduleError(c, ErrFeedNotFound)
+	}
+
+	var buf strings.Builder
+	if err := h.service.WriteFeed(c.Request().Context(), token, &buf); err != nil {
+		return scheduleError(c, err)
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "private, max-age=900")
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(buf.String()))
+}
+
+func (h *Handler) GetFeed(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	url, err := h.service.GetFeedURL(principal.UserID)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"url": url})
+}
+
+func (h *Handler) RotateFeed(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	url, err := h.service.RotateFeed(principal.UserID)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"url": url})
+}
+
+func (h *Handler) DisableFeed(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.DisableFeed(principal.UserID); err != nil {
+		return scheduleError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func scheduleError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrFeedNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidTimezone):
+		status = http.StatusBadRequest
+	}
+	return c.JSON(status, echo.Map{"error": err.Error()})
+}
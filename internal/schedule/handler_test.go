@@ -0,0 +1,67 @@
+package schedule
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// fakeService запоминает, чей список запросили. Остальные методы Service не нужны.
+type fakeService struct {
+	Service
+	listOf *uuid.UUID
+}
+
+func (s *fakeService) GetSchedule(ctx context.Context, tz string, listOf *uuid.UUID) (*Schedule, error) {
+	s.listOf = listOf
+	return &Schedule{}, nil
+}
+
+type fakeAPITokens map[string]*auth.Principal
+
+func (t fakeAPITokens) ResolveAPIToken(token string) (*auth.Principal, error) {
+	return t[token], nil
+}
+
+func TestScheduleListRequiresListsReadScope(t *testing.T) {
+	userID, tokenID := uuid.New(), uuid.New()
+	tokens := fakeAPITokens{
+		"ast_comments": {UserID: userID, APITokenID: &tokenID, Scopes: []string{auth.ScopeCommentsWrite}},
+		"ast_lists":    {UserID: userID, APITokenID: &tokenID, Scopes: []string{auth.ScopeListsRead}},
+	}
+	authenticator := auth.NewAuthenticator(nil, nil, tokens)
+
+	for _, tc := range []struct {
+		token, query string
+		status       int
+		listOf       bool
+	}{
+		{"ast_comments", "?list=true", http.StatusForbidden, false},
+		{"ast_comments", "", http.StatusOK, false},
+		{"ast_lists", "?list=true", http.StatusOK, true},
+		{"", "?list=true", http.StatusUnauthorized, false},
+	} {
+		service := &fakeService{}
+		e := echo.New()
+		e.GET("/api/schedule", NewHandler(service).GetSchedule, authenticator.Optional())
+
+		req := httptest.NewRequest(http.MethodGet, "/api/schedule"+tc.query, nil)
+		if tc.token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != tc.status {
+			t.Errorf("%s %q: status %d, want %d", tc.token, tc.query, rec.Code, tc.status)
+		}
+		if got := service.listOf != nil && *service.listOf == userID; got != tc.listOf {
+			t.Errorf("%s %q: list of the user served = %v, want %v", tc.token, tc.query, got, tc.listOf)
+		}
+	}
+}
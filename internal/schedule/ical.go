@@ -0,0 +1,121 @@
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+)
+
+const (
+	icalTimeFormat = "20060102T150405Z"
+	// RFC 5545: строки длиннее 75 октетов переносятся
+	icalLineLimit = 75
+	// длительность серии, если Shikimori ее не знает
+	defaultEpisodeMinutes = 24
+)
+
+type event struct {
+	uid         string
+	start       time.Time
+	minutes     int
+	summary     string
+	description string
+	url         string
+}
+
+func episodeEvent(anime shikimori.Anime, episode int, start time.Time, predicted bool, baseURL string) event {
+	title := anime.Russian
+	if title == "" {
+		title = anime.Name
+	}
+	minutes := anime.Duration
+	if minutes <= 0 {
+		minutes = defaultEpisodeMinutes
+	}
+	description := fmt.Sprintf("%s, серия %d", title, episode)
+	if anime.Episodes > 0 {
+		description = fmt.Sprintf("%s, серия %d из %d", title, episode, anime.Episodes)
+	}
+	if predicted {
+		description += ". Дата ожидаемая: рассчитана по еженедельному выходу серий"
+	}
+
+	host := "anime-site"
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return event{
+		uid:         fmt.Sprintf("anime-%s-episode-%d@%s", anime.ID, episode, host),
+		start:       start,
+		minutes:     minutes,
+		summary:     fmt.Sprintf("%s — серия %d", title, episode),
+		description: description,
+		url:         "https://shikimori.one/animes/" + anime.ID,
+	}
+}
+
+// writeCalendar пишет VCALENDAR с событиями по RFC 5545.
+func writeCalendar(w io.Writer, events []event, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeFolded(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//anime-site-backend//schedule//RU")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	line("X-WR-CALNAME", "Выход серий")
+	line("REFRESH-INTERVAL;VALUE=DURATION", "PT6H")
+	for _, e := range events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.uid)
+		line("DTSTAMP", now.UTC().Format(icalTimeFormat))
+		line("DTSTART", e.start.UTC().Format(icalTimeFormat))
+		line("DURATION", "PT"+strconv.Itoa(e.minutes)+"M")
+		line("SUMMARY", escapeText(e.summary))
+		line("DESCRIPTION", escapeText(e.description))
+		line("URL", e.url)
+		line("TRANSP", "TRANSPARENT")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+func escapeText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// writeFolded пишет строку с CRLF, перенося ее по 75 октетов без разрыва
+// многобайтовых символов.
+func writeFolded(w *bufio.Writer, s string) {
+	limit := icalLineLimit
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		// продолжение начинается с пробела, он входит в лимит
+		limit = icalLineLimit - 1
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,67 @@
+package schedule
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEscapeText(t *testing.T) {
+	for in, want := range map[string]string{
+		"Steins;Gate":           `Steins\;Gate`,
+		"серия 1, финал":        `серия 1\, финал`,
+		`C:\anime`:              `C:\\anime`,
+		"строка\r\nвторая\nещё": `строка\nвторая\nещё`,
+	} {
+		if got := escapeText(in); got != want {
+			t.Errorf("escapeText(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func folded(s string) string {
+	var sb strings.Builder
+	w := bufio.NewWriter(&sb)
+	writeFolded(w, s)
+	w.Flush()
+	return sb.String()
+}
+
+func TestWriteFolded(t *testing.T) {
+	for _, s := range []string{
+		"SUMMARY:short",
+		"SUMMARY:" + strings.Repeat("a", 200),
+		// кириллица по два байта, граница 75 октетов попадает внутрь символа
+		"SUMMARY:" + strings.Repeat("я", 100),
+		"X:" + strings.Repeat("😀", 50),
+	} {
+		out := folded(s)
+		if !strings.HasSuffix(out, "\r\n") {
+			t.Fatalf("%q: no trailing CRLF", out)
+		}
+		lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+		var unfolded strings.Builder
+		for i, line := range lines {
+			if len(line) > icalLineLimit {
+				t.Errorf("line %d is %d octets long", i, len(line))
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("line %d splits a multibyte rune: %q", i, line)
+			}
+			if i > 0 {
+				var ok bool
+				if line, ok = strings.CutPrefix(line, " "); !ok {
+					t.Errorf("continuation line %d does not start with a space", i)
+				}
+			}
+			unfolded.WriteString(line)
+		}
+		if unfolded.String() != s {
+			t.Errorf("unfolded %q, want %q", unfolded.String(), s)
+		}
+		if len(s) <= icalLineLimit && len(lines) != 1 {
+			t.Errorf("%q is folded although it fits", s)
+		}
+	}
+}
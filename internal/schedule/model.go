@@ -0,0 +1,40 @@
+package schedule
+
+import (
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/google/uuid"
+)
+
+// Feed - секретный токен iCalendar-ленты пользователя. Токен хранится как
+// есть: ссылку на ленту нужно показывать пользователю повторно.
+type Feed struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Token     string    `gorm:"size:64;not null;uniqueIndex"`
+	CreatedAt time.Time
+}
+
+func (Feed) TableName() string {
+	return "calendar_feeds"
+}
+
+// Item - выход следующей серии аниме.
+type Item struct {
+	Time    string          `json:"time"` // HH:MM в часовом поясе запроса
+	AirsAt  time.Time       `json:"airs_at"`
+	Episode int             `json:"episode"`
+	Anime   shikimori.Anime `json:"anime"`
+}
+
+// Day - день недели расписания. Дни идут начиная с сегодняшнего.
+type Day struct {
+	Weekday string `json:"weekday"`
+	Date    string `json:"date"`
+	Items   []Item `json:"items"`
+}
+
+type Schedule struct {
+	Timezone string `json:"timezone"`
+	Days     []Day  `json:"days"`
+}
@@ -0,0 +1,49 @@
+package schedule
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	FindFeed(userID uuid.UUID) (*Feed, error)
+	FindFeedByToken(token string) (*Feed, error)
+	SaveFeed(feed *Feed) error
+	DeleteFeed(userID uuid.UUID) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) FindFeed(userID uuid.UUID) (*Feed, error) {
+	var feed Feed
+	if err := r.db.First(&feed, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+func (r *repository) FindFeedByToken(token string) (*Feed, error) {
+	var feed Feed
+	if err := r.db.First(&feed, "token = ?", token).Error; err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+func (r *repository) SaveFeed(feed *Feed) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"token", "created_at"}),
+	}).Create(feed).Error
+}
+
+func (r *repository) DeleteFeed(userID uuid.UUID) error {
+	return r.db.Where("user_id = ?", userID).Delete(&Feed{}).Error
+}
@@ -0,0 +1,295 @@
+package schedule
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	ongoingPageSize = 50
+	maxOngoingPages = 6
+	ongoingCacheTTL = 15 * time.Minute
+	// сколько недель вперед лента предсказывает еженедельные серии
+	feedWeeks = 4
+)
+
+var (
+	ErrFeedNotFound    = errors.New("calendar feed is not enabled")
+	ErrInvalidTimezone = errors.New("invalid timezone, expected IANA name like Europe/Moscow")
+)
+
+// listStatuses - записи списка, которые попадают в расписание и ленту.
+var listStatuses = map[string]bool{
+	user.StatusWatching: true,
+	user.StatusPlanned:  true,
+	user.StatusOnHold:   true,
+}
+
+// Animes - часть shikimori.Service, нужная расписанию.
+type Animes interface {
+	GetOngoingAnime(ctx context.Context, page, limit int) ([]shikimori.Anime, error)
+	FetchAnimesByIDs(ctx context.Context, ids []string) (map[string]shikimori.Anime, []string)
+}
+
+// ListReader - часть user.Service, нужная расписанию.
+type ListReader interface {
+	GetAnimeList(userID, status string) ([]user.AnimeEntry, error)
+}
+
+type Service interface {
+	// GetSchedule группирует онгоинги по дням недели в часовом поясе tz.
+	// Если задан listOf, в расписание попадают только аниме из его списка.
+	GetSchedule(ctx context.Context, tz string, listOf *uuid.UUID) (*Schedule, error)
+	GetFeedURL(userID uuid.UUID) (string, error)
+	RotateFeed(userID uuid.UUID) (string, error)
+	DisableFeed(userID uuid.UUID) error
+	WriteFeed(ctx context.Context, token string, w io.Writer) error
+}
+
+type service struct {
+	repo    Repository
+	animes  Animes
+	lists   ListReader
+	baseURL string
+
+	mu        sync.Mutex
+	ongoing   []shikimori.Anime
+	ongoingAt time.Time
+}
+
+func NewService(repo Repository, animes Animes, lists ListReader) Service {
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	return &service{
+		repo:    repo,
+		animes:  animes,
+		lists:   lists,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+func (s *service) GetSchedule(ctx context.Context, tz string, listOf *uuid.UUID) (*Schedule, error) {
+	if tz == "" {
+		tz = "UTC"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, ErrInvalidTimezone
+	}
+
+	var animes []shikimori.Anime
+	if listOf != nil {
+		animes, err = s.listAnime(ctx, *listOf)
+	} else {
+		animes, err = s.ongoingAnime(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	schedule := &Schedule{Timezone: loc.String(), Days: make([]Day, 7)}
+	for i := range schedule.Days {
+		date := today.AddDate(0, 0, i)
+		schedule.Days[i] = Day{
+			Weekday: strings.ToLower(date.Weekday().String()),
+			Date:    date.Format(time.DateOnly),
+			Items:   []Item{},
+		}
+	}
+
+	for _, anime := range animes {
+		airsAt, ok := nextEpisodeAt(anime)
+		if !ok {
+			continue
+		}
+		local := airsAt.In(loc)
+		// серия после перерыва может выйти позже чем через неделю,
+		// такие в расписание на эту неделю не попадают
+		day := daysBetween(today, local)
+		if day < 0 || day >= len(schedule.Days) {
+			continue
+		}
+		schedule.Days[day].Items = append(schedule.Days[day].Items, Item{
+			Time:    local.Format("15:04"),
+			AirsAt:  airsAt,
+			Episode: anime.EpisodesAired + 1,
+			Anime:   anime,
+		})
+	}
+	for i := range schedule.Days {
+		items := schedule.Days[i].Items
+		sort.SliceStable(items, func(a, b int) bool {
+			if items[a].Time != items[b].Time {
+				return items[a].Time < items[b].Time
+			}
+			return items[a].AirsAt.Before(items[b].AirsAt)
+		})
+	}
+	return schedule, nil
+}
+
+// ongoingAnime возвращает онгоинги из кэша, обновляя его раз в ongoingCacheTTL.
+func (s *service) ongoingAnime(ctx context.Context) ([]shikimori.Anime, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ongoing != nil && time.Since(s.ongoingAt) < ongoingCacheTTL {
+		return s.ongoing, nil
+	}
+
+	var animes []shikimori.Anime
+	for page := 1; page <= maxOngoingPages; page++ {
+		batch, err := s.animes.GetOngoingAnime(ctx, page, ongoingPageSize)
+		if err != nil {
+			if s.ongoing != nil {
+				log.Printf("schedule: failed to refresh ongoing anime, serving stale list: %v", err)
+				return s.ongoing, nil
+			}
+			return nil, err
+		}
+		animes = append(animes, batch...)
+		if len(batch) < ongoingPageSize {
+			break
+		}
+	}
+	s.ongoing = animes
+	s.ongoingAt = time.Now()
+	return animes, nil
+}
+
+// listAnime загружает с Shikimori аниме из списка пользователя в порядке
+// добавления.
+func (s *service) listAnime(ctx context.Context, userID uuid.UUID) ([]shikimori.Anime, error) {
+	entries, err := s.lists.GetAnimeList(userID.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if listStatuses[entry.Status] {
+			ids = append(ids, entry.AnimeID)
+		}
+	}
+
+	found, failed := s.animes.FetchAnimesByIDs(ctx, ids)
+	if len(failed) > 0 {
+		log.Printf("schedule: list of user %s: failed to load %d anime", userID, len(failed))
+	}
+	animes := make([]shikimori.Anime, 0, len(found))
+	for _, id := range ids {
+		if anime, ok := found[id]; ok {
+			animes = append(animes, anime)
+		}
+	}
+	return animes, nil
+}
+
+func (s *service) GetFeedURL(userID uuid.UUID) (string, error) {
+	feed, err := s.repo.FindFeed(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrFeedNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return s.feedURL(feed.Token), nil
+}
+
+// RotateFeed включает ленту или меняет ее токен: старая ссылка перестает
+// работать.
+func (s *service) RotateFeed(userID uuid.UUID) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	feed := &Feed{
+		UserID:    userID,
+		Token:     base64.RawURLEncoding.EncodeToString(buf),
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.SaveFeed(feed); err != nil {
+		return "", err
+	}
+	return s.feedURL(feed.Token), nil
+}
+
+func (s *service) DisableFeed(userID uuid.UUID) error {
+	return s.repo.DeleteFeed(userID)
+}
+
+func (s *service) feedURL(token string) string {
+	return s.baseURL + "/calendar/" + token + ".ics"
+}
+
+// WriteFeed пишет iCalendar-ленту с ближайшими сериями аниме из списка
+// владельца токена.
+func (s *service) WriteFeed(ctx context.Context, token string, w io.Writer) error {
+	feed, err := s.repo.FindFeedByToken(token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrFeedNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	animes, err := s.listAnime(ctx, feed.UserID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	var events []event
+	for _, anime := range animes {
+		airsAt, ok := nextEpisodeAt(anime)
+		if !ok {
+			continue
+		}
+		// следующие серии предполагаются еженедельными
+		for week := 0; week < feedWeeks; week++ {
+			episode := anime.EpisodesAired + 1 + week
+			if anime.Episodes > 0 && episode > anime.Episodes {
+				break
+			}
+			events = append(events, episodeEvent(anime, episode, airsAt.AddDate(0, 0, 7*week), week > 0, s.baseURL))
+		}
+	}
+	return writeCalendar(w, events, now)
+}
+
+// daysBetween - число календарных дней от from до to, без учета переходов
+// на летнее время.
+func daysBetween(from, to time.Time) int {
+	a := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// nextEpisodeAt разбирает дату следующей серии из Shikimori.
+func nextEpisodeAt(anime shikimori.Anime) (time.Time, bool) {
+	if anime.NextEpisodeAt == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, anime.NextEpisodeAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.UTC(), true
+}
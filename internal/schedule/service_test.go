@@ -0,0 +1,56 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+)
+
+// fakeAnimes отдает одну страницу онгоингов.
+type fakeAnimes struct {
+	Animes
+	ongoing []shikimori.Anime
+}
+
+func (f *fakeAnimes) GetOngoingAnime(ctx context.Context, page, limit int) ([]shikimori.Anime, error) {
+	if page > 1 {
+		return nil, nil
+	}
+	return f.ongoing, nil
+}
+
+func TestScheduleBucketsByDate(t *testing.T) {
+	now := time.Now().UTC()
+	airing := func(id string, after time.Duration) shikimori.Anime {
+		return shikimori.Anime{ID: id, NextEpisodeAt: now.Add(after).Format(time.RFC3339)}
+	}
+	animes := &fakeAnimes{ongoing: []shikimori.Anime{
+		airing("in-two-days", 48*time.Hour),
+		// после недельного перерыва: тот же день недели, но через 8 дней
+		airing("after-break", 8*24*time.Hour),
+		airing("overdue", -48*time.Hour),
+		{ID: "unknown"},
+	}}
+	schedule, err := NewService(nil, animes, nil).GetSchedule(context.Background(), "UTC", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found []string
+	for i, day := range schedule.Days {
+		for _, item := range day.Items {
+			found = append(found, item.Anime.ID)
+			if want := now.AddDate(0, 0, i).Format(time.DateOnly); item.AirsAt.Format(time.DateOnly) != want {
+				t.Errorf("%s airs %v but listed on %s", item.Anime.ID, item.AirsAt, day.Date)
+			}
+		}
+	}
+	if len(found) != 1 || found[0] != "in-two-days" {
+		t.Fatalf("scheduled %v, want only [in-two-days]", found)
+	}
+	if items := schedule.Days[2].Items; len(items) != 1 {
+		t.Fatalf("day 2 items = %+v", items)
+	}
+}
@@ -1,19 +1,48 @@
 package shikimori
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
+// ScoreProvider - источник оценок пользователей сайта.
+type ScoreProvider interface {
+	CommunityScores(ctx context.Context, animeIDs []string) (map[string]*CommunityScore, error)
+}
+
 type Handler struct {
 	service *Service
+	scores  ScoreProvider
+}
+
+func NewHandler(service *Service, scores ScoreProvider) *Handler {
+	return &Handler{service: service, scores: scores}
 }
 
-func NewHandler(service *Service) *Handler {
-	return &Handler{service: service}
+// withCommunityScores дополняет аниме оценками сайта. Ошибка только
+// логируется: без оценок сайта ответ остается полезным.
+func (h *Handler) withCommunityScores(ctx context.Context, animes []Anime) {
+	if h.scores == nil || len(animes) == 0 {
+		return
+	}
+	ids := make([]string, len(animes))
+	for i := range animes {
+		ids[i] = animes[i].ID
+	}
+	scores, err := h.scores.CommunityScores(ctx, ids)
+	if err != nil {
+		log.Printf("Ошибка при получении оценок сайта: %v", err)
+		return
+	}
+	for i := range animes {
+		animes[i].CommunityScore = scores[animes[i].ID]
+	}
 }
 
 func (h *Handler) SearchAnime(c echo.Context) error {
@@ -34,6 +63,7 @@ func (h *Handler) SearchAnime(c echo.Context) error {
 	if len(animes) == 0 {
 		log.Println("Не найдено аниме по запросу.")
 	}
+	h.withCommunityScores(c.Request().Context(), animes)
 
 	// Возвращаем найденные аниме
 	return c.JSON(http.StatusOK, animes)
@@ -67,6 +97,7 @@ func (h *Handler) GetTopAnime(c echo.Context) error {
 		log.Printf("Ошибка при получении топ-аниме: %v", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Не удалось получить топ-аниме"})
 	}
+	h.withCommunityScores(c.Request().Context(), animes)
 
 	return c.JSON(http.StatusOK, animes)
 }
@@ -81,8 +112,10 @@ func (h *Handler) GetAnimeByID(c echo.Context) error {
 		log.Printf("Ошибка при получении аниме: %v", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Не удалось получить информацию об аниме"})
 	}
+	animes := []Anime{*anime}
+	h.withCommunityScores(c.Request().Context(), animes)
 
-	return c.JSON(http.StatusOK, anime)
+	return c.JSON(http.StatusOK, animes[0])
 }
 func (h *Handler) GetNewReleases(c echo.Context) error {
 	limitStr := c.QueryParam("limit")
@@ -101,6 +134,64 @@ func (h *Handler) GetNewReleases(c echo.Context) error {
 			"error": "Не удалось получить список новинок",
 		})
 	}
+	h.withCommunityScores(c.Request().Context(), animes)
 
 	return c.JSON(http.StatusOK, animes)
 }
+
+// GetSeasons отдает текущий и следующий сезоны и список всех доступных.
+func (h *Handler) GetSeasons(c echo.Context) error {
+	now := time.Now()
+	return c.JSON(http.StatusOK, echo.Map{
+		"current": SeasonOf(now),
+		"next":    SeasonOf(now).Next(),
+		"seasons": AvailableSeasons(now),
+	})
+}
+
+// GetSeason отдает аниме сезона :season_year, например winter_2019.
+func (h *Handler) GetSeason(c echo.Context) error {
+	season, err := ParseSeason(c.Param("season_year"))
+	if err != nil {
+		return seasonError(c, err)
+	}
+	return h.seasonPage(c, season, "")
+}
+
+// GetNextSeason отдает анонсы следующего сезона.
+func (h *Handler) GetNextSeason(c echo.Context) error {
+	return h.seasonPage(c, SeasonOf(time.Now()).Next(), "anons")
+}
+
+func (h *Handler) seasonPage(c echo.Context, season Season, defaultStatus string) error {
+	query := SeasonQuery{
+		Season: season,
+		Kind:   c.QueryParam("kind"),
+		Status: c.QueryParam("status"),
+		Genre:  c.QueryParam("genre"),
+		Sort:   c.QueryParam("sort"),
+	}
+	if query.Status == "" {
+		query.Status = defaultStatus
+	}
+	query.Page, _ = strconv.Atoi(c.QueryParam("page"))
+	query.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+
+	page, err := h.service.GetSeasonAnime(c.Request().Context(), query)
+	if err != nil {
+		return seasonError(c, err)
+	}
+	h.withCommunityScores(c.Request().Context(), page.Animes)
+
+	return c.JSON(http.StatusOK, page)
+}
+
+func seasonError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidSeason), errors.Is(err, ErrInvalidKind), errors.Is(err, ErrInvalidStatus),
+		errors.Is(err, ErrInvalidGenre), errors.Is(err, ErrInvalidSeasonSort):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	log.Printf("Ошибка при получении аниме сезона: %v", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Не удалось получить аниме сезона"})
+}
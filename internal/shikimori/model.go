@@ -153,6 +153,35 @@ type Anime struct {
 	Description       string          `json:"description,omitempty"`
 	DescriptionHTML   string          `json:"descriptionHtml,omitempty"`
 	DescriptionSource string          `json:"descriptionSource,omitempty"`
+
+	// оценка пользователей сайта, заполняется не из Shikimori
+	CommunityScore *CommunityScore `json:"communityScore,omitempty"`
+}
+
+// CommunityScore - оценка аниме по спискам пользователей сайта.
+type CommunityScore struct {
+	Score     float64 `json:"score"`     // байесовское среднее
+	Average   float64 `json:"average"`   // простое среднее
+	Votes     int     `json:"votes"`     // число оценивших
+	Histogram []int   `json:"histogram"` // Histogram[i] - число оценок i+1
+}
+
+// IsValidAnimeID - ID аниме на Shikimori: непустая строка из цифр, не длиннее
+// колонок anime_id (32 символа).
+func IsValidAnimeID(id string) bool {
+	return len(id) <= 32 && isDigits(id)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 type AnimeSearchResponseData struct {
@@ -0,0 +1,165 @@
+package shikimori
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// OAuthConfig - настройки OAuth2-приложения Shikimori. BaseURL можно
+// переопределить, чтобы в тестах вместо Shikimori отвечал локальный фейк.
+type OAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	BaseURL      string
+	Scopes       []string
+	UserAgent    string
+}
+
+func OAuthConfigFromEnv() OAuthConfig {
+	baseURL := os.Getenv("SHIKIMORI_OAUTH_URL")
+	if baseURL == "" {
+		baseURL = "https://shikimori.one"
+	}
+	userAgent := os.Getenv("SHIKIMORI_APP_NAME")
+	if userAgent == "" {
+		userAgent = "shiki_api_test"
+	}
+	return OAuthConfig{
+		ClientID:     os.Getenv("SHIKIMORI_CLIENT_ID"),
+		ClientSecret: os.Getenv("SHIKIMORI_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("SHIKIMORI_REDIRECT_URL"),
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		Scopes:       []string{"user_rates"},
+		UserAgent:    userAgent,
+	}
+}
+
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	CreatedAt    int64  `json:"created_at"`
+	Scope        string `json:"scope"`
+}
+
+// Expiry возвращает момент истечения access-токена.
+func (t *OAuthToken) Expiry() time.Time {
+	created := time.Now()
+	if t.CreatedAt > 0 {
+		created = time.Unix(t.CreatedAt, 0)
+	}
+	return created.Add(time.Duration(t.ExpiresIn) * time.Second)
+}
+
+// Profile - пользователь Shikimori из /api/users/whoami
+type Profile struct {
+	ID       int64  `json:"id"`
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar"`
+}
+
+var ErrOAuthNotConfigured = errors.New("shikimori oauth is not configured")
+
+type OAuthClient struct {
+	config     OAuthConfig
+	httpClient *http.Client
+}
+
+func NewOAuthClient(config OAuthConfig) *OAuthClient {
+	return &OAuthClient{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *OAuthClient) Configured() bool {
+	return c.config.ClientID != "" && c.config.ClientSecret != "" && c.config.RedirectURL != ""
+}
+
+func (c *OAuthClient) AuthorizeURL(state string) string {
+	query := url.Values{}
+	query.Set("client_id", c.config.ClientID)
+	query.Set("redirect_uri", c.config.RedirectURL)
+	query.Set("response_type", "code")
+	query.Set("scope", strings.Join(c.config.Scopes, " "))
+	query.Set("state", state)
+	return c.config.BaseURL + "/oauth/authorize?" + query.Encode()
+}
+
+func (c *OAuthClient) Exchange(ctx context.Context, code string) (*OAuthToken, error) {
+	return c.requestToken(ctx, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {c.config.RedirectURL},
+	})
+}
+
+func (c *OAuthClient) Refresh(ctx context.Context, refreshToken string) (*OAuthToken, error) {
+	return c.requestToken(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+func (c *OAuthClient) requestToken(ctx context.Context, form url.Values) (*OAuthToken, error) {
+	if !c.Configured() {
+		return nil, ErrOAuthNotConfigured
+	}
+	form.Set("client_id", c.config.ClientID)
+	form.Set("client_secret", c.config.ClientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.BaseURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", c.config.UserAgent)
+
+	var token OAuthToken
+	if err := c.do(req, &token); err != nil {
+		return nil, fmt.Errorf("shikimori token request failed: %w", err)
+	}
+	return &token, nil
+}
+
+func (c *OAuthClient) WhoAmI(ctx context.Context, accessToken string) (*Profile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.BaseURL+"/api/users/whoami", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("User-Agent", c.config.UserAgent)
+
+	var profile Profile
+	if err := c.do(req, &profile); err != nil {
+		return nil, fmt.Errorf("shikimori whoami failed: %w", err)
+	}
+	if profile.ID == 0 {
+		return nil, errors.New("shikimori whoami returned no user")
+	}
+	return &profile, nil
+}
+
+func (c *OAuthClient) do(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
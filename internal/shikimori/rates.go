@@ -0,0 +1,190 @@
+package shikimori
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UserRate - запись списка пользователя в REST API Shikimori (/api/v2/user_rates).
+type UserRate struct {
+	ID         int64     `json:"id,omitempty"`
+	UserID     int64     `json:"user_id"`
+	TargetID   int64     `json:"target_id"`
+	TargetType string    `json:"target_type"`
+	Score      int       `json:"score"`
+	Status     string    `json:"status"`
+	Rewatches  int       `json:"rewatches"`
+	Episodes   int       `json:"episodes"`
+	CreatedAt  time.Time `json:"created_at,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at,omitempty"`
+}
+
+// ErrUnauthorized - Shikimori отклонил токен (истек или отозван).
+var ErrUnauthorized = errors.New("shikimori rejected the access token")
+
+const (
+	ratesPageLimit = 1000 // максимум API для user_rates
+	// Shikimori разрешает 90 запросов в минуту, оставляем запас
+	ratesMinInterval = 700 * time.Millisecond
+)
+
+// RatesClient работает со списками пользователей от их имени (OAuth-токен
+// со scope user_rates). BaseURL настраивается, чтобы подменять API фейком.
+type RatesClient struct {
+	baseURL    string
+	userAgent  string
+	httpClient *http.Client
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func NewRatesClient(baseURL, userAgent string) *RatesClient {
+	return &RatesClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		userAgent:  userAgent,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// RatesClientFromEnv берет адрес API из SHIKIMORI_API_URL, по умолчанию
+// тот же сервер, что и для OAuth.
+func RatesClientFromEnv() *RatesClient {
+	config := OAuthConfigFromEnv()
+	baseURL := os.Getenv("SHIKIMORI_API_URL")
+	if baseURL == "" {
+		baseURL = config.BaseURL
+	}
+	return NewRatesClient(baseURL, config.UserAgent)
+}
+
+// ListAnimeRates возвращает весь список аниме пользователя Shikimori.
+func (c *RatesClient) ListAnimeRates(ctx context.Context, accessToken string, shikimoriUserID int64) ([]UserRate, error) {
+	var rates []UserRate
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("user_id", strconv.FormatInt(shikimoriUserID, 10))
+		query.Set("target_type", "Anime")
+		query.Set("page", strconv.Itoa(page))
+		query.Set("limit", strconv.Itoa(ratesPageLimit))
+
+		var batch []UserRate
+		if err := c.do(ctx, http.MethodGet, "/api/v2/user_rates?"+query.Encode(), accessToken, nil, &batch); err != nil {
+			return nil, err
+		}
+		rates = append(rates, batch...)
+		if len(batch) < ratesPageLimit {
+			return rates, nil
+		}
+	}
+}
+
+func (c *RatesClient) CreateRate(ctx context.Context, accessToken string, rate UserRate) (*UserRate, error) {
+	var created UserRate
+	if err := c.do(ctx, http.MethodPost, "/api/v2/user_rates", accessToken, rateBody(rate), &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *RatesClient) UpdateRate(ctx context.Context, accessToken string, rate UserRate) (*UserRate, error) {
+	var updated UserRate
+	path := "/api/v2/user_rates/" + strconv.FormatInt(rate.ID, 10)
+	if err := c.do(ctx, http.MethodPatch, path, accessToken, rateBody(rate), &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (c *RatesClient) DeleteRate(ctx context.Context, accessToken string, rateID int64) error {
+	return c.do(ctx, http.MethodDelete, "/api/v2/user_rates/"+strconv.FormatInt(rateID, 10), accessToken, nil, nil)
+}
+
+func rateBody(rate UserRate) interface{} {
+	return map[string]interface{}{
+		"user_rate": map[string]interface{}{
+			"user_id":     rate.UserID,
+			"target_id":   rate.TargetID,
+			"target_type": "Anime",
+			"score":       rate.Score,
+			"status":      rate.Status,
+			"rewatches":   rate.Rewatches,
+			"episodes":    rate.Episodes,
+		},
+	}
+}
+
+// wait выдерживает паузу между запросами, общую для всех пользователей.
+func (c *RatesClient) wait(ctx context.Context) error {
+	c.mu.Lock()
+	delay := time.Until(c.last.Add(ratesMinInterval))
+	c.last = time.Now().Add(max(delay, 0))
+	c.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (c *RatesClient) do(ctx context.Context, method, path, accessToken string, body, out interface{}) error {
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("shikimori %s %s: %w", method, strings.SplitN(path, "?", 2)[0], ErrUnauthorized)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("shikimori %s %s: unexpected status %d: %s", method, strings.SplitN(path, "?", 2)[0],
+			resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
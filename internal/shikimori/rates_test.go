@@ -0,0 +1,67 @@
+package shikimori
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRatesClientKeepsMinInterval(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// Пауза общая для всех пользователей, поэтому запросы идут параллельно
+	client := NewRatesClient(server.URL, "test")
+	var wg sync.WaitGroup
+	for i := int64(1); i <= 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.DeleteRate(context.Background(), "token", i); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i := 1; i < len(times); i++ {
+		// небольшой допуск на доставку запроса до сервера
+		if gap := times[i].Sub(times[i-1]); gap < ratesMinInterval-50*time.Millisecond {
+			t.Errorf("request %d came %v after the previous one, want at least %v", i, gap, ratesMinInterval)
+		}
+	}
+}
+
+func TestRatesClientReportsRejectedToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer valid" {
+			http.Error(w, `{"error":"invalid_token"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	client := NewRatesClient(server.URL, "test")
+	if _, err := client.ListAnimeRates(context.Background(), "expired", 1); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expired token: got %v, want %v", err, ErrUnauthorized)
+	}
+	if _, err := client.ListAnimeRates(context.Background(), "valid", 1); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+package shikimori
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/pkg/pagination"
+	"github.com/machinebox/graphql"
+)
+
+const (
+	// с этого года сезоны отдаются в списке доступных
+	firstSeasonYear = 1960
+	maxSeasonLimit  = 50
+
+	// сезонные списки меняются медленно, как и онгоинги в расписании
+	seasonCacheTTL = 15 * time.Minute
+	// при переполнении кэш очищается целиком
+	maxSeasonCacheEntries = 1000
+)
+
+// Порядок сортировки сезонного списка.
+const (
+	SeasonSortPopularity = "popularity"
+	SeasonSortScore      = "score"
+	SeasonSortName       = "name"
+	SeasonSortAiredOn    = "aired_on"
+)
+
+var (
+	ErrInvalidSeason     = errors.New("invalid season, expected <winter|spring|summer|fall>_<year>")
+	ErrInvalidKind       = errors.New("invalid kind")
+	ErrInvalidStatus     = errors.New("invalid status, expected anons, ongoing or released")
+	ErrInvalidGenre      = errors.New("invalid genre, expected comma-separated genre ids")
+	ErrInvalidSeasonSort = errors.New("invalid sort order")
+)
+
+var seasonNames = []string{"winter", "spring", "summer", "fall"}
+
+// seasonOrders - сортировки API и соответствующий порядок Shikimori.
+var seasonOrders = map[string]string{
+	SeasonSortPopularity: "popularity",
+	SeasonSortScore:      "ranked",
+	SeasonSortName:       "name",
+	SeasonSortAiredOn:    "aired_on",
+}
+
+var animeKinds = map[string]bool{
+	"tv": true, "movie": true, "ova": true, "ona": true, "special": true,
+	"tv_special": true, "music": true, "pv": true, "cm": true,
+}
+
+var animeStatuses = map[string]bool{"anons": true, "ongoing": true, "released": true}
+
+// Season - аниме-сезон: зима - январь-март, весна - апрель-июнь и т.д.
+type Season struct {
+	Name string `json:"name"`
+	Year int    `json:"year"`
+}
+
+func (s Season) String() string {
+	return fmt.Sprintf("%s_%d", s.Name, s.Year)
+}
+
+// MarshalJSON добавляет к сезону id для ссылки /api/seasons/:season_year.
+func (s Season) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Year int    `json:"year"`
+	}{s.String(), s.Name, s.Year})
+}
+
+func (s Season) index() int {
+	for i, name := range seasonNames {
+		if name == s.Name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s Season) Next() Season {
+	i := s.index() + 1
+	return Season{Name: seasonNames[i%4], Year: s.Year + i/4}
+}
+
+// SeasonOf возвращает сезон, в который попадает t.
+func SeasonOf(t time.Time) Season {
+	return Season{Name: seasonNames[(int(t.Month())-1)/3], Year: t.Year()}
+}
+
+// ParseSeason разбирает сезон вида winter_2019.
+func ParseSeason(value string) (Season, error) {
+	name, yearStr, ok := strings.Cut(value, "_")
+	if !ok {
+		return Season{}, ErrInvalidSeason
+	}
+	year, err := strconv.Atoi(yearStr)
+	if err != nil || len(yearStr) != 4 || !isDigits(yearStr) {
+		return Season{}, ErrInvalidSeason
+	}
+	season := Season{Name: name, Year: year}
+	if season.index() < 0 {
+		return Season{}, ErrInvalidSeason
+	}
+	return season, nil
+}
+
+// AvailableSeasons возвращает сезоны от следующего за текущим до зимы
+// firstSeasonYear, новые первыми.
+func AvailableSeasons(now time.Time) []Season {
+	last := SeasonOf(now).Next()
+	seasons := make([]Season, 0, (last.Year-firstSeasonYear+1)*4)
+	for year := last.Year; year >= firstSeasonYear; year-- {
+		for i := len(seasonNames) - 1; i >= 0; i-- {
+			season := Season{Name: seasonNames[i], Year: year}
+			if year == last.Year && i > last.index() {
+				continue
+			}
+			seasons = append(seasons, season)
+		}
+	}
+	return seasons
+}
+
+// SeasonQuery - фильтры сезонного списка. Kind, Status и Genre могут
+// содержать несколько значений через запятую.
+type SeasonQuery struct {
+	Season Season
+	Kind   string
+	Status string
+	Genre  string
+	Sort   string
+	Page   int
+	Limit  int
+}
+
+// Validate проверяет фильтры и подставляет значения по умолчанию.
+func (q *SeasonQuery) Validate() error {
+	if q.Sort == "" {
+		q.Sort = SeasonSortPopularity
+	}
+	if _, ok := seasonOrders[q.Sort]; !ok {
+		return ErrInvalidSeasonSort
+	}
+	if !validList(q.Kind, func(v string) bool { return animeKinds[v] }) {
+		return ErrInvalidKind
+	}
+	if !validList(q.Status, func(v string) bool { return animeStatuses[v] }) {
+		return ErrInvalidStatus
+	}
+	if !validList(q.Genre, isDigits) {
+		return ErrInvalidGenre
+	}
+	// page*limit уходит в Shikimori и не должно переполняться
+	q.Page = min(max(q.Page, 1), pagination.MaxPage(maxSeasonLimit))
+	if q.Limit <= 0 || q.Limit > maxSeasonLimit {
+		q.Limit = 30
+	}
+	return nil
+}
+
+func validList(value string, valid func(string) bool) bool {
+	if value == "" {
+		return true
+	}
+	for _, v := range strings.Split(value, ",") {
+		if !valid(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// SeasonPage - страница сезонного списка.
+type SeasonPage struct {
+	Season  Season  `json:"season"`
+	Page    int     `json:"page"`
+	Limit   int     `json:"limit"`
+	HasMore bool    `json:"has_more"`
+	Animes  []Anime `json:"animes"`
+}
+
+type seasonCacheEntry struct {
+	page      *SeasonPage
+	fetchedAt time.Time
+}
+
+// seasonCache хранит страницы сезонных списков по запросу. Наружу отдаются
+// копии: обработчик дописывает в аниме оценки сайта.
+type seasonCache struct {
+	mu      sync.Mutex
+	entries map[SeasonQuery]seasonCacheEntry
+}
+
+func (c *seasonCache) get(q SeasonQuery) (*SeasonPage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[q]
+	if !ok || time.Since(entry.fetchedAt) >= seasonCacheTTL {
+		return nil, false
+	}
+	return entry.page.clone(), true
+}
+
+func (c *seasonCache) put(q SeasonQuery, page *SeasonPage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= maxSeasonCacheEntries {
+		c.entries = make(map[SeasonQuery]seasonCacheEntry)
+	}
+	c.entries[q] = seasonCacheEntry{page: page.clone(), fetchedAt: time.Now()}
+}
+
+func (p *SeasonPage) clone() *SeasonPage {
+	clone := *p
+	clone.Animes = append([]Anime{}, p.Animes...)
+	return &clone
+}
+
+// GetSeasonAnime возвращает страницу аниме сезона с фильтрами q. Страницы
+// кэшируются на seasonCacheTTL.
+func (s *Service) GetSeasonAnime(ctx context.Context, q SeasonQuery) (*SeasonPage, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	if page, ok := s.seasons.get(q); ok {
+		return page, nil
+	}
+
+	animes, err := s.fetchSeasonAnime(ctx, q, q.Page, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	hasMore := false
+	if len(animes) == q.Limit {
+		// Shikimori считает смещение как (page-1)*limit, поэтому limit+1 на
+		// той же странице сдвинул бы следующие. Первую запись следующей
+		// страницы запрашиваем отдельно страницей из одной записи.
+		next, err := s.fetchSeasonAnime(ctx, q, q.Page*q.Limit+1, 1)
+		if err != nil {
+			return nil, err
+		}
+		hasMore = len(next) > 0
+	}
+
+	page := &SeasonPage{
+		Season:  q.Season,
+		Page:    q.Page,
+		Limit:   q.Limit,
+		HasMore: hasMore,
+		Animes:  animes,
+	}
+	s.seasons.put(q, page)
+	return page, nil
+}
+
+func (s *Service) fetchSeasonAnime(ctx context.Context, q SeasonQuery, page, limit int) ([]Anime, error) {
+	req := graphql.NewRequest(`
+	query($season: SeasonString!, $order: OrderEnum!, $page: PositiveInt!, $limit: PositiveInt!,
+		$kind: AnimeKindString, $status: AnimeStatusString, $genre: String) {
+		animes(season: $season, order: $order, page: $page, limit: $limit,
+			kind: $kind, status: $status, genre: $genre) {
+			id
+			name
+			russian
+			kind
+			score
+			status
+			episodes
+			episodesAired
+			nextEpisodeAt
+			airedOn {
+				year
+				month
+				day
+				date
+			}
+			poster {
+				originalUrl
+				mainUrl
+			}
+			genres {
+				id
+				name
+				russian
+				kind
+			}
+			studios {
+				id
+				name
+			}
+		}
+	}
+`)
+	req.Var("season", q.Season.String())
+	req.Var("order", seasonOrders[q.Sort])
+	req.Var("page", page)
+	req.Var("limit", limit)
+	if q.Kind != "" {
+		req.Var("kind", q.Kind)
+	}
+	if q.Status != "" {
+		req.Var("status", q.Status)
+	}
+	if q.Genre != "" {
+		req.Var("genre", q.Genre)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SHIKIMORI_TOKEN"))
+
+	var resp AnimeSearchResponseData
+	if err := s.graphqlClient.Run(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Animes == nil {
+		resp.Animes = []Anime{}
+	}
+	return resp.Animes, nil
+}
@@ -0,0 +1,162 @@
+package shikimori
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/machinebox/graphql"
+)
+
+func TestParseSeason(t *testing.T) {
+	for value, want := range map[string]Season{
+		"winter_2019": {Name: "winter", Year: 2019},
+		"fall_1960":   {Name: "fall", Year: 1960},
+	} {
+		if got, err := ParseSeason(value); err != nil || got != want {
+			t.Errorf("ParseSeason(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "winter", "winter_19", "winter_+019", "autumn_2019", "2019_winter", "winter_2019_1"} {
+		if _, err := ParseSeason(value); !errors.Is(err, ErrInvalidSeason) {
+			t.Errorf("ParseSeason(%q): got %v, want %v", value, err, ErrInvalidSeason)
+		}
+	}
+}
+
+func TestAvailableSeasons(t *testing.T) {
+	seasons := AvailableSeasons(time.Date(2024, time.November, 5, 0, 0, 0, 0, time.UTC))
+
+	// осенью следующий сезон - зима следующего года
+	if seasons[0] != (Season{Name: "winter", Year: 2025}) || seasons[1] != (Season{Name: "fall", Year: 2024}) {
+		t.Fatalf("newest seasons = %v", seasons[:2])
+	}
+	if last := seasons[len(seasons)-1]; last != (Season{Name: "winter", Year: firstSeasonYear}) {
+		t.Fatalf("oldest season = %v", last)
+	}
+	if want := (2024-firstSeasonYear+1)*4 + 1; len(seasons) != want {
+		t.Fatalf("%d seasons, want %d", len(seasons), want)
+	}
+}
+
+func TestSeasonQueryValidate(t *testing.T) {
+	q := SeasonQuery{Page: -1, Limit: 500}
+	if err := q.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if q.Sort != SeasonSortPopularity || q.Page != 1 || q.Limit != 30 {
+		t.Fatalf("defaults: %+v", q)
+	}
+
+	q = SeasonQuery{Page: math.MaxInt, Limit: maxSeasonLimit}
+	if err := q.Validate(); err != nil || q.Page*q.Limit > math.MaxInt32 {
+		t.Fatalf("huge page: %+v, %v", q, err)
+	}
+
+	for _, tc := range []struct {
+		query SeasonQuery
+		err   error
+	}{
+		{SeasonQuery{Kind: "tv,movie", Status: "ongoing", Genre: "1,22", Sort: SeasonSortScore}, nil},
+		{SeasonQuery{Kind: "tv,manga"}, ErrInvalidKind},
+		{SeasonQuery{Kind: "tv,"}, ErrInvalidKind},
+		{SeasonQuery{Status: "finished"}, ErrInvalidStatus},
+		{SeasonQuery{Genre: "action"}, ErrInvalidGenre},
+		{SeasonQuery{Sort: "random"}, ErrInvalidSeasonSort},
+	} {
+		if err := tc.query.Validate(); !errors.Is(err, tc.err) {
+			t.Errorf("%+v: got %v, want %v", tc.query, err, tc.err)
+		}
+	}
+}
+
+// seasonServer отвечает как GraphQL Shikimori: в сезоне total аниме с ID
+// от 1, страницы считаются как (page-1)*limit.
+func seasonServer(t *testing.T, total int, requests *atomic.Int32) *Service {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var body struct {
+			Variables struct {
+				Page  int `json:"page"`
+				Limit int `json:"limit"`
+			} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		animes := []Anime{}
+		for i := (body.Variables.Page-1)*body.Variables.Limit + 1; i <= min(body.Variables.Page*body.Variables.Limit, total); i++ {
+			animes = append(animes, Anime{ID: fmt.Sprint(i)})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"animes": animes}})
+	}))
+	t.Cleanup(server.Close)
+	return &Service{graphqlClient: graphql.NewClient(server.URL)}
+}
+
+func TestGetSeasonAnimeHasMore(t *testing.T) {
+	var requests atomic.Int32
+	s := seasonServer(t, 5, &requests)
+	season := Season{Name: "winter", Year: 2024}
+
+	for _, tc := range []struct {
+		page, limit int
+		first       string
+		count       int
+		hasMore     bool
+	}{
+		{1, 2, "1", 2, true},
+		{2, 2, "3", 2, true},
+		{3, 2, "5", 1, false},
+		// последняя страница заполнена ровно
+		{1, 5, "1", 5, false},
+	} {
+		page, err := s.GetSeasonAnime(context.Background(), SeasonQuery{Season: season, Page: tc.page, Limit: tc.limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Animes) != tc.count || page.Animes[0].ID != tc.first || page.HasMore != tc.hasMore {
+			t.Errorf("page %d by %d: %d anime from %s, has_more %v; want %d from %s, has_more %v",
+				tc.page, tc.limit, len(page.Animes), page.Animes[0].ID, page.HasMore, tc.count, tc.first, tc.hasMore)
+		}
+	}
+}
+
+func TestGetSeasonAnimeIsCached(t *testing.T) {
+	var requests atomic.Int32
+	s := seasonServer(t, 1, &requests)
+	q := SeasonQuery{Season: Season{Name: "winter", Year: 2024}, Limit: 10}
+
+	first, err := s.GetSeasonAnime(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Animes[0].CommunityScore = &CommunityScore{Votes: 1}
+
+	second, err := s.GetSeasonAnime(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("%d requests to Shikimori, want 1", requests.Load())
+	}
+	if second.Animes[0].CommunityScore != nil {
+		t.Fatal("changes of a returned page leak into the cache")
+	}
+
+	q.Sort = SeasonSortScore
+	if _, err := s.GetSeasonAnime(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("another sort order is served from the cache")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/machinebox/graphql"
@@ -12,6 +14,7 @@ import (
 
 type Service struct {
 	graphqlClient *graphql.Client
+	seasons       seasonCache
 }
 
 func NewService() *Service {
@@ -168,32 +171,65 @@ func (s *Service) GetAnimeByID(ctx context.Context, id string) (*Anime, error) {
 	return &resp.Animes[0], nil
 }
 
+const (
+	// MaxIDsPerRequest - больше аниме за один запрос GraphQL API не отдает
+	MaxIDsPerRequest = 50
+	// сколько пачек ID запрашивается параллельно
+	idsFetchConcurrency = 4
+)
+
+// GetAnimesByIDs загружает до MaxIDsPerRequest аниме одним запросом.
+// Аниме, которых нет на Shikimori, в ответе просто отсутствуют.
 func (s *Service) GetAnimesByIDs(ctx context.Context, ids []string) ([]Anime, error) {
+	if len(ids) == 0 {
+		return []Anime{}, nil
+	}
+	if len(ids) > MaxIDsPerRequest {
+		return nil, fmt.Errorf("too many ids: %d, max %d", len(ids), MaxIDsPerRequest)
+	}
+
 	req := graphql.NewRequest(`
-        query($ids: [String!]!) {
-            animes(ids: $ids) {
+        query($ids: String!, $limit: PositiveInt!) {
+            animes(ids: $ids, limit: $limit) {
                 id
+                malId
                 name
                 russian
-				description
+                kind
+                episodes
+                episodesAired
+                nextEpisodeAt
+                duration
+                description
                 score
                 status
-				poster {
-                id
-                originalUrl
-                mainUrl
-            }
-			genres {
-                id
-                name
-                russian
-                kind
-            }
+                airedOn {
+                    year
+                    month
+                    day
+                    date
+                }
+                poster {
+                    id
+                    originalUrl
+                    mainUrl
+                }
+                genres {
+                    id
+                    name
+                    russian
+                    kind
+                }
+                studios {
+                    id
+                    name
+                }
             }
         }
     `)
 
-	req.Var("ids", ids)
+	req.Var("ids", strings.Join(ids, ","))
+	req.Var("limit", len(ids))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Origin", "https://shikimori.one")
@@ -209,6 +245,57 @@ func (s *Service) GetAnimesByIDs(ctx context.Context, ids []string) ([]Anime, er
 	return resp.Animes, nil
 }
 
+// FetchAnimesByIDs загружает любое количество аниме пачками по
+// MaxIDsPerRequest с ограниченным параллелизмом. Ошибка одной пачки не
+// прерывает остальные: ID, которые не удалось загрузить, возвращаются в failed
+// в исходном порядке.
+func (s *Service) FetchAnimesByIDs(ctx context.Context, ids []string) (found map[string]Anime, failed []string) {
+	found = make(map[string]Anime, len(ids))
+	failed = []string{}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, idsFetchConcurrency)
+	)
+	for start := 0; start < len(ids); start += MaxIDsPerRequest {
+		batch := ids[start:min(start+MaxIDsPerRequest, len(ids))]
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			animes, err := s.GetAnimesByIDs(ctx, batch)
+			if err != nil {
+				log.Printf("Ошибка загрузки пачки из %d аниме: %v", len(batch), err)
+				return
+			}
+			mu.Lock()
+			for _, anime := range animes {
+				found[anime.ID] = anime
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			failed = append(failed, id)
+		}
+	}
+	return found, failed
+}
+
 func (s *Service) GetNewReleases(ctx context.Context, limit int) ([]Anime, error) {
 	req := graphql.NewRequest(`
 	query($limit: Int!, $season: SeasonString!, $status: AnimeStatusString!) {
@@ -241,24 +328,8 @@ func (s *Service) GetNewReleases(ctx context.Context, limit int) ([]Anime, error
 		}
 	}
 `)
-	season := ""
-	currentTime := time.Now()
-	month := int(currentTime.Month())
-	year := int(currentTime.Year())
-	var seasonPart string
-	switch {
-	case month >= 1 && month <= 3:
-		seasonPart = "winter"
-	case month >= 4 && month <= 6:
-		seasonPart = "spring"
-	case month >= 7 && month <= 9:
-		seasonPart = "summer"
-	case month >= 10 && month <= 12:
-		seasonPart = "fall"
-	}
-	season = fmt.Sprintf("%s_%d", seasonPart, year)
 	req.Var("limit", limit)
-	req.Var("season", season)
+	req.Var("season", SeasonOf(time.Now()).String())
 	req.Var("status", "ongoing")
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
@@ -271,3 +342,100 @@ func (s *Service) GetNewReleases(ctx context.Context, limit int) ([]Anime, error
 
 	return resp.Animes, nil
 }
+
+// GetOngoingAnime возвращает страницу онгоингов любого сезона с датой
+// следующей серии.
+func (s *Service) GetOngoingAnime(ctx context.Context, page, limit int) ([]Anime, error) {
+	req := graphql.NewRequest(`
+	query($page: PositiveInt!, $limit: PositiveInt!) {
+		animes(page: $page, limit: $limit, order: popularity, status: "ongoing") {
+			id
+			name
+			russian
+			kind
+			score
+			episodes
+			episodesAired
+			nextEpisodeAt
+			duration
+			poster {
+				originalUrl
+				mainUrl
+			}
+		}
+	}
+`)
+	req.Var("page", page)
+	req.Var("limit", limit)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SHIKIMORI_TOKEN"))
+
+	var resp AnimeSearchResponseData
+	if err := s.graphqlClient.Run(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Animes, nil
+}
+
+// GetPopularAnime возвращает страницу самых популярных аниме с жанрами и
+// студиями - из них собирается каталог для рекомендаций.
+func (s *Service) GetPopularAnime(ctx context.Context, page, limit int) ([]Anime, error) {
+	return s.catalogAnimes(ctx, "popularity", "", page, limit)
+}
+
+// GetRankedAnimeByGenre возвращает лучшие по оценке аниме жанра с теми же
+// полями, что и GetPopularAnime.
+func (s *Service) GetRankedAnimeByGenre(ctx context.Context, genre string, limit int) ([]Anime, error) {
+	return s.catalogAnimes(ctx, "ranked", genre, 1, limit)
+}
+
+// catalogAnimes - страница аниме в порядке order (popularity, ranked), при
+// непустом genre - только этого жанра.
+func (s *Service) catalogAnimes(ctx context.Context, order, genre string, page, limit int) ([]Anime, error) {
+	req := graphql.NewRequest(`
+	query($page: PositiveInt!, $limit: PositiveInt!, $genre: String) {
+		animes(page: $page, limit: $limit, order: ` + order + `, genre: $genre) {
+			id
+			name
+			russian
+			kind
+			score
+			episodes
+			airedOn {
+				year
+			}
+			poster {
+				originalUrl
+				mainUrl
+			}
+			genres {
+				id
+				name
+				russian
+				kind
+			}
+			studios {
+				id
+				name
+			}
+		}
+	}
+`)
+	req.Var("page", page)
+	req.Var("limit", limit)
+	if genre != "" {
+		req.Var("genre", genre)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SHIKIMORI_TOKEN"))
+
+	var resp AnimeSearchResponseData
+	if err := s.graphqlClient.Run(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Animes, nil
+}
@@ -0,0 +1,74 @@
+package shikisync
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) GetState(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	state, err := h.service.GetState(principal.UserID)
+	if err != nil {
+		return syncError(c, err)
+	}
+	return c.JSON(http.StatusOK, state)
+}
+
+func (h *Handler) SetEnabled(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.Bind(&req); err != nil || req.Enabled == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "enabled is required")
+	}
+
+	state, err := h.service.SetEnabled(principal.UserID, *req.Enabled)
+	if err != nil {
+		return syncError(c, err)
+	}
+	return c.JSON(http.StatusOK, state)
+}
+
+func (h *Handler) SyncNow(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.SyncNow(principal.UserID); err != nil {
+		return syncError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, echo.Map{"message": "synchronization scheduled"})
+}
+
+func syncError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, user.ErrShikimoriNotLinked):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrSyncInProgress), errors.Is(err, ErrSyncDisabled):
+		status = http.StatusConflict
+	}
+	return c.JSON(status, echo.Map{"error": err.Error()})
+}
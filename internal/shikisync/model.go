@@ -0,0 +1,50 @@
+package shikisync
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	StatusIdle    = "idle"
+	StatusRunning = "running"
+	StatusOK      = "ok"
+	StatusError   = "error"
+)
+
+// State - настройки и итог последней синхронизации пользователя.
+type State struct {
+	UserID       uuid.UUID  `gorm:"type:uuid;primaryKey" json:"-"`
+	Enabled      bool       `gorm:"not null;default:false" json:"enabled"`
+	Status       string     `gorm:"size:16;not null;default:idle" json:"status"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastSyncedAt *time.Time `json:"last_synced_at,omitempty"`
+	NextSyncAt   time.Time  `gorm:"index" json:"next_sync_at"`
+
+	// итоги последнего прогона
+	Pulled  int `json:"pulled"`
+	Pushed  int `json:"pushed"`
+	Deleted int `json:"deleted"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (State) TableName() string {
+	return "shikimori_sync_states"
+}
+
+// RateLink связывает запись нашего списка с user_rate на Shikimori и хранит
+// время изменения обеих сторон на момент последней синхронизации. По ним
+// определяется, какая сторона изменилась с тех пор.
+type RateLink struct {
+	UserID          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	AnimeID         string    `gorm:"size:32;primaryKey"`
+	RateID          int64     `gorm:"not null"`
+	LocalUpdatedAt  time.Time
+	RemoteUpdatedAt time.Time
+}
+
+func (RateLink) TableName() string {
+	return "shikimori_rate_links"
+}
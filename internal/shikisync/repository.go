@@ -0,0 +1,90 @@
+package shikisync
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	FindState(userID uuid.UUID) (*State, error)
+	SaveState(state *State) error
+	SaveRun(state *State) error
+	ClaimDue(now time.Time, lease time.Duration, limit int) ([]State, error)
+	FindLinks(userID uuid.UUID) ([]RateLink, error)
+	SaveLink(link *RateLink) error
+	DeleteLink(userID uuid.UUID, animeID string) error
+	DeleteLinks(userID uuid.UUID) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) FindState(userID uuid.UUID) (*State, error) {
+	var state State
+	if err := r.db.First(&state, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (r *repository) SaveState(state *State) error {
+	return r.db.Save(state).Error
+}
+
+// SaveRun сохраняет итог прогона, не трогая Enabled, который пользователь
+// мог поменять, пока шла синхронизация.
+func (r *repository) SaveRun(state *State) error {
+	return r.db.Model(state).Select("status", "last_error", "last_synced_at", "next_sync_at", "pulled", "pushed", "deleted", "updated_at").
+		Updates(state).Error
+}
+
+// ClaimDue забирает пользователей, которым пора синхронизироваться, и сдвигает
+// их next_sync_at на lease: если воркер упадет, синхронизация повторится позже.
+func (r *repository) ClaimDue(now time.Time, lease time.Duration, limit int) ([]State, error) {
+	var states []State
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("enabled AND next_sync_at <= ?", now).Order("next_sync_at").Limit(limit).
+			Find(&states).Error; err != nil {
+			return err
+		}
+		if len(states) == 0 {
+			return nil
+		}
+		ids := make([]uuid.UUID, len(states))
+		for i := range states {
+			ids[i] = states[i].UserID
+			states[i].Status = StatusRunning
+			states[i].NextSyncAt = now.Add(lease)
+		}
+		return tx.Model(&State{}).Where("user_id IN ?", ids).
+			Updates(map[string]interface{}{"status": StatusRunning, "next_sync_at": now.Add(lease)}).Error
+	})
+	return states, err
+}
+
+func (r *repository) FindLinks(userID uuid.UUID) ([]RateLink, error) {
+	var links []RateLink
+	err := r.db.Where("user_id = ?", userID).Find(&links).Error
+	return links, err
+}
+
+func (r *repository) SaveLink(link *RateLink) error {
+	return r.db.Save(link).Error
+}
+
+func (r *repository) DeleteLink(userID uuid.UUID, animeID string) error {
+	return r.db.Where("user_id = ? AND anime_id = ?", userID, animeID).Delete(&RateLink{}).Error
+}
+
+func (r *repository) DeleteLinks(userID uuid.UUID) error {
+	return r.db.Where("user_id = ?", userID).Delete(&RateLink{}).Error
+}
@@ -0,0 +1,442 @@
+package shikisync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSyncInterval = time.Hour
+	pollInterval        = time.Minute
+	claimLease          = 30 * time.Minute
+	claimBatch          = 5
+)
+
+var (
+	ErrSyncInProgress = errors.New("synchronization is already running")
+	ErrSyncDisabled   = errors.New("synchronization is disabled")
+)
+
+// Accounts - часть user.Service, нужная синхронизации.
+type Accounts interface {
+	GetShikimoriAccount(userID string) (*user.ShikimoriAccount, error)
+	ShikimoriAccessToken(ctx context.Context, userID uuid.UUID) (string, error)
+	RefreshShikimoriToken(ctx context.Context, userID uuid.UUID) (string, error)
+	GetAnimeList(userID, status string) ([]user.AnimeEntry, error)
+	ApplyAnimeEntry(userID uuid.UUID, animeID string, fn func(current *user.AnimeEntry) (*user.AnimeEntry, error)) (before, after *user.AnimeEntry, err error)
+}
+
+type Service interface {
+	GetState(userID uuid.UUID) (*State, error)
+	SetEnabled(userID uuid.UUID, enabled bool) (*State, error)
+	SyncNow(userID uuid.UUID) error
+	ProfileSection(ctx context.Context, userID uuid.UUID) (string, interface{}, error)
+	Run(ctx context.Context)
+}
+
+type service struct {
+	repo     Repository
+	accounts Accounts
+	rates    *shikimori.RatesClient
+	interval time.Duration
+	wake     chan struct{}
+}
+
+func NewService(repo Repository, accounts Accounts, rates *shikimori.RatesClient) Service {
+	interval, err := time.ParseDuration(os.Getenv("SHIKIMORI_SYNC_INTERVAL"))
+	if err != nil || interval < time.Minute {
+		interval = defaultSyncInterval
+	}
+	return &service{
+		repo:     repo,
+		accounts: accounts,
+		rates:    rates,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (s *service) GetState(userID uuid.UUID) (*State, error) {
+	state, err := s.repo.FindState(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &State{UserID: userID, Status: StatusIdle}, nil
+	}
+	return state, err
+}
+
+// SetEnabled включает или выключает синхронизацию. Связи с user_rates при
+// этом сбрасываются: после долгого перерыва списки сливаются заново, а не
+// считают пропавшие за это время записи удаленными.
+func (s *service) SetEnabled(userID uuid.UUID, enabled bool) (*State, error) {
+	if enabled {
+		if _, err := s.accounts.GetShikimoriAccount(userID.String()); err != nil {
+			return nil, err
+		}
+	}
+	state, err := s.GetState(userID)
+	if err != nil {
+		return nil, err
+	}
+	if state.Enabled == enabled {
+		return state, nil
+	}
+	if err := s.repo.DeleteLinks(userID); err != nil {
+		return nil, err
+	}
+
+	state.Enabled = enabled
+	if enabled {
+		state.NextSyncAt = time.Now()
+		state.LastError = ""
+	} else {
+		state.Status = StatusIdle
+	}
+	if err := s.repo.SaveState(state); err != nil {
+		return nil, err
+	}
+	if enabled {
+		s.notify()
+	}
+	return state, nil
+}
+
+func (s *service) SyncNow(userID uuid.UUID) error {
+	state, err := s.GetState(userID)
+	if err != nil {
+		return err
+	}
+	if !state.Enabled {
+		return ErrSyncDisabled
+	}
+	if state.Status == StatusRunning {
+		return ErrSyncInProgress
+	}
+	state.NextSyncAt = time.Now()
+	if err := s.repo.SaveState(state); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// ProfileSection добавляет статус синхронизации в ответ /profile.
+func (s *service) ProfileSection(_ context.Context, userID uuid.UUID) (string, interface{}, error) {
+	state, err := s.GetState(userID)
+	return "shikimori_sync", state, err
+}
+
+func (s *service) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run - воркер синхронизации, работает до отмены ctx.
+func (s *service) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			states, err := s.repo.ClaimDue(time.Now(), claimLease, claimBatch)
+			if err != nil {
+				log.Printf("shikimori sync: failed to claim users: %v", err)
+				break
+			}
+			if len(states) == 0 {
+				break
+			}
+			for i := range states {
+				s.runOne(ctx, &states[i])
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *service) runOne(ctx context.Context, state *State) {
+	state.Pulled, state.Pushed, state.Deleted = 0, 0, 0
+	err := s.syncUser(ctx, state)
+	if ctx.Err() != nil {
+		// прогон прерван остановкой сервера, повторится после истечения lease
+		return
+	}
+
+	now := time.Now()
+	state.LastSyncedAt = &now
+	state.NextSyncAt = now.Add(s.interval)
+	state.Status, state.LastError = StatusOK, ""
+	if err != nil {
+		log.Printf("shikimori sync for user %s failed: %v", state.UserID, err)
+		state.Status, state.LastError = StatusError, err.Error()
+	}
+	if err := s.repo.SaveRun(state); err != nil {
+		log.Printf("shikimori sync: failed to save state for user %s: %v", state.UserID, err)
+	}
+}
+
+// syncUser сводит наш список и user_rates на Shikimori. Если запись менялась
+// только с одной стороны с прошлой синхронизации, эта сторона и побеждает;
+// если с обеих - побеждает более позднее изменение.
+func (s *service) syncUser(ctx context.Context, state *State) error {
+	userID := state.UserID
+	account, err := s.accounts.GetShikimoriAccount(userID.String())
+	if errors.Is(err, user.ErrShikimoriNotLinked) {
+		// аккаунт отвязали - выключаем синхронизацию
+		state.Enabled = false
+		if err := s.repo.SaveState(state); err != nil {
+			return err
+		}
+		return errors.Join(err, s.repo.DeleteLinks(userID))
+	}
+	if err != nil {
+		return err
+	}
+	token, err := s.accounts.ShikimoriAccessToken(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	p := &pass{service: s, ctx: ctx, state: state, token: token, shikimoriUserID: account.ShikimoriUserID}
+	var rates []shikimori.UserRate
+	if err := p.authorized(func(token string) (err error) {
+		rates, err = s.rates.ListAnimeRates(ctx, token, account.ShikimoriUserID)
+		return err
+	}); err != nil {
+		return err
+	}
+	entries, err := s.accounts.GetAnimeList(userID.String(), "")
+	if err != nil {
+		return err
+	}
+	links, err := s.repo.FindLinks(userID)
+	if err != nil {
+		return err
+	}
+
+	remote := make(map[string]shikimori.UserRate, len(rates))
+	local := make(map[string]user.AnimeEntry, len(entries))
+	linked := make(map[string]RateLink, len(links))
+	keys := make(map[string]struct{}, len(rates)+len(entries))
+	for _, rate := range rates {
+		id := strconv.FormatInt(rate.TargetID, 10)
+		remote[id] = rate
+		keys[id] = struct{}{}
+	}
+	for _, entry := range entries {
+		local[entry.AnimeID] = entry
+		keys[entry.AnimeID] = struct{}{}
+	}
+	for _, link := range links {
+		linked[link.AnimeID] = link
+		keys[link.AnimeID] = struct{}{}
+	}
+
+	for animeID := range keys {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rate, hasRemote := remote[animeID]
+		entry, hasLocal := local[animeID]
+		link, hasLink := linked[animeID]
+
+		var err error
+		switch {
+		case hasRemote && hasLocal:
+			localChanged := !hasLink || entry.UpdatedAt.After(link.LocalUpdatedAt)
+			remoteChanged := !hasLink || rate.UpdatedAt.After(link.RemoteUpdatedAt)
+			switch {
+			case sameRate(entry, rate):
+				err = p.link(animeID, rate.ID, entry.UpdatedAt, rate.UpdatedAt)
+			case remoteChanged && (!localChanged || rate.UpdatedAt.After(entry.UpdatedAt)):
+				err = p.pull(animeID, rate)
+			default:
+				err = p.push(entry, rate.ID)
+			}
+		case hasRemote:
+			if hasLink && !rate.UpdatedAt.After(link.RemoteUpdatedAt) {
+				// у нас запись удалили после синхронизации
+				err = p.deleteRemote(animeID, rate.ID)
+			} else {
+				err = p.pull(animeID, rate)
+			}
+		case hasLocal:
+			if hasLink && !entry.UpdatedAt.After(link.LocalUpdatedAt) {
+				// на Shikimori запись удалили после синхронизации
+				err = p.deleteLocal(animeID)
+			} else {
+				err = p.push(entry, 0)
+			}
+		default:
+			err = s.repo.DeleteLink(userID, animeID)
+		}
+		p.record(animeID, err)
+	}
+	return p.result()
+}
+
+// pass - состояние одного прогона синхронизации пользователя.
+type pass struct {
+	*service
+	ctx             context.Context
+	state           *State
+	token           string
+	shikimoriUserID int64
+	refreshed       bool
+
+	failed   int
+	firstErr error
+}
+
+// authorized выполняет запрос к Shikimori и на 401 один раз за прогон
+// обновляет токен и повторяет запрос.
+func (p *pass) authorized(call func(token string) error) error {
+	err := call(p.token)
+	if !errors.Is(err, shikimori.ErrUnauthorized) || p.refreshed {
+		return err
+	}
+	p.refreshed = true
+	token, err := p.accounts.RefreshShikimoriToken(p.ctx, p.state.UserID)
+	if err != nil {
+		return err
+	}
+	p.token = token
+	return call(token)
+}
+
+func (p *pass) record(animeID string, err error) {
+	if err == nil {
+		return
+	}
+	p.failed++
+	if p.firstErr == nil {
+		p.firstErr = fmt.Errorf("anime %s: %w", animeID, err)
+	}
+}
+
+func (p *pass) result() error {
+	if p.failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d entries failed to sync, first error: %w", p.failed, p.firstErr)
+}
+
+func (p *pass) link(animeID string, rateID int64, localUpdatedAt, remoteUpdatedAt time.Time) error {
+	return p.repo.SaveLink(&RateLink{
+		UserID:          p.state.UserID,
+		AnimeID:         animeID,
+		RateID:          rateID,
+		LocalUpdatedAt:  localUpdatedAt,
+		RemoteUpdatedAt: remoteUpdatedAt,
+	})
+}
+
+func (p *pass) pull(animeID string, rate shikimori.UserRate) error {
+	_, after, err := p.accounts.ApplyAnimeEntry(p.state.UserID, animeID, func(current *user.AnimeEntry) (*user.AnimeEntry, error) {
+		if current == nil {
+			current = &user.AnimeEntry{}
+		}
+		current.Status = localStatus(rate.Status)
+		current.Score = nil
+		if rate.Score >= 1 && rate.Score <= 10 {
+			score := rate.Score
+			current.Score = &score
+		}
+		current.EpisodesWatched = max(rate.Episodes, 0)
+		current.RewatchCount = max(rate.Rewatches, 0)
+		return current, nil
+	})
+	if err != nil {
+		return err
+	}
+	p.state.Pulled++
+	return p.link(animeID, rate.ID, after.UpdatedAt, rate.UpdatedAt)
+}
+
+func (p *pass) push(entry user.AnimeEntry, rateID int64) error {
+	targetID, err := strconv.ParseInt(entry.AnimeID, 10, 64)
+	if err != nil {
+		return err
+	}
+	rate := shikimori.UserRate{
+		ID:        rateID,
+		UserID:    p.shikimoriUserID,
+		TargetID:  targetID,
+		Status:    entry.Status,
+		Episodes:  entry.EpisodesWatched,
+		Rewatches: entry.RewatchCount,
+	}
+	if entry.Score != nil {
+		rate.Score = *entry.Score
+	}
+
+	var saved *shikimori.UserRate
+	err = p.authorized(func(token string) (err error) {
+		if rateID == 0 {
+			saved, err = p.rates.CreateRate(p.ctx, token, rate)
+		} else {
+			saved, err = p.rates.UpdateRate(p.ctx, token, rate)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	p.state.Pushed++
+	return p.link(entry.AnimeID, saved.ID, entry.UpdatedAt, saved.UpdatedAt)
+}
+
+func (p *pass) deleteRemote(animeID string, rateID int64) error {
+	if err := p.authorized(func(token string) error {
+		return p.rates.DeleteRate(p.ctx, token, rateID)
+	}); err != nil {
+		return err
+	}
+	p.state.Deleted++
+	return p.repo.DeleteLink(p.state.UserID, animeID)
+}
+
+func (p *pass) deleteLocal(animeID string) error {
+	_, _, err := p.accounts.ApplyAnimeEntry(p.state.UserID, animeID, func(*user.AnimeEntry) (*user.AnimeEntry, error) {
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	p.state.Deleted++
+	return p.repo.DeleteLink(p.state.UserID, animeID)
+}
+
+// localStatus - у Shikimori есть отдельный статус rewatching, у нас это watching.
+func localStatus(status string) string {
+	if status == "rewatching" {
+		return user.StatusWatching
+	}
+	return status
+}
+
+func sameRate(entry user.AnimeEntry, rate shikimori.UserRate) bool {
+	score := 0
+	if entry.Score != nil {
+		score = *entry.Score
+	}
+	return entry.Status == localStatus(rate.Status) && score == rate.Score &&
+		entry.EpisodesWatched == rate.Episodes && entry.RewatchCount == rate.Rewatches
+}
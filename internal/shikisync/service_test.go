@@ -0,0 +1,429 @@
+package shikisync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const testShikimoriUserID = 42
+
+// fakeRates - локальный фейк /api/v2/user_rates. Принимает только token.
+type fakeRates struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	token  string
+	rates  map[int64]shikimori.UserRate
+	nextID int64
+}
+
+func newFakeRates(t *testing.T, token string, rates ...shikimori.UserRate) *fakeRates {
+	f := &fakeRates{token: token, rates: make(map[int64]shikimori.UserRate), nextID: 1000}
+	for _, rate := range rates {
+		f.rates[rate.ID] = rate
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v2/user_rates", func(w http.ResponseWriter, r *http.Request) {
+		var list []shikimori.UserRate
+		for _, rate := range f.rates {
+			if strconv.FormatInt(rate.UserID, 10) == r.URL.Query().Get("user_id") {
+				list = append(list, rate)
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		json.NewEncoder(w).Encode(list)
+	})
+	mux.HandleFunc("POST /api/v2/user_rates", func(w http.ResponseWriter, r *http.Request) {
+		rate, ok := decodeRate(w, r)
+		if !ok {
+			return
+		}
+		f.nextID++
+		rate.ID = f.nextID
+		rate.UpdatedAt = time.Now().UTC()
+		f.rates[rate.ID] = rate
+		json.NewEncoder(w).Encode(rate)
+	})
+	mux.HandleFunc("PATCH /api/v2/user_rates/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if _, exists := f.rates[id]; !exists {
+			http.NotFound(w, r)
+			return
+		}
+		rate, ok := decodeRate(w, r)
+		if !ok {
+			return
+		}
+		rate.ID = id
+		rate.UpdatedAt = time.Now().UTC()
+		f.rates[id] = rate
+		json.NewEncoder(w).Encode(rate)
+	})
+	mux.HandleFunc("DELETE /api/v2/user_rates/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		delete(f.rates, id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+f.token {
+			http.Error(w, `{"error":"invalid_token"}`, http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func decodeRate(w http.ResponseWriter, r *http.Request) (shikimori.UserRate, bool) {
+	var body struct {
+		UserRate shikimori.UserRate `json:"user_rate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.UserRate.TargetType != "Anime" {
+		http.Error(w, "bad user_rate", http.StatusUnprocessableEntity)
+		return shikimori.UserRate{}, false
+	}
+	return body.UserRate, true
+}
+
+func (f *fakeRates) byTarget(targetID int64) (shikimori.UserRate, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rate := range f.rates {
+		if rate.TargetID == targetID {
+			return rate, true
+		}
+	}
+	return shikimori.UserRate{}, false
+}
+
+// fakeAccounts - список пользователя и его токен Shikimori. RefreshShikimoriToken
+// выдает refreshedToken.
+type fakeAccounts struct {
+	mu             sync.Mutex
+	token          string
+	refreshedToken string
+	refreshes      int
+	entries        map[string]user.AnimeEntry
+}
+
+func (a *fakeAccounts) GetShikimoriAccount(userID string) (*user.ShikimoriAccount, error) {
+	return &user.ShikimoriAccount{ShikimoriUserID: testShikimoriUserID}, nil
+}
+
+func (a *fakeAccounts) ShikimoriAccessToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.token, nil
+}
+
+func (a *fakeAccounts) RefreshShikimoriToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.refreshes++
+	a.token = a.refreshedToken
+	return a.token, nil
+}
+
+func (a *fakeAccounts) GetAnimeList(userID, status string) ([]user.AnimeEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries := make([]user.AnimeEntry, 0, len(a.entries))
+	for _, entry := range a.entries {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (a *fakeAccounts) ApplyAnimeEntry(userID uuid.UUID, animeID string, fn func(current *user.AnimeEntry) (*user.AnimeEntry, error)) (*user.AnimeEntry, *user.AnimeEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var before *user.AnimeEntry
+	if entry, ok := a.entries[animeID]; ok {
+		before = &entry
+	}
+	var current *user.AnimeEntry
+	if before != nil {
+		copied := *before
+		current = &copied
+	}
+	after, err := fn(current)
+	if err != nil {
+		return nil, nil, err
+	}
+	if after == nil {
+		delete(a.entries, animeID)
+		return before, nil, nil
+	}
+	after.AnimeID = animeID
+	after.UpdatedAt = time.Now().UTC()
+	a.entries[animeID] = *after
+	return before, after, nil
+}
+
+type fakeRepository struct {
+	mu     sync.Mutex
+	states map[uuid.UUID]State
+	links  map[string]RateLink
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{states: make(map[uuid.UUID]State), links: make(map[string]RateLink)}
+}
+
+func (r *fakeRepository) FindState(userID uuid.UUID) (*State, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[userID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &state, nil
+}
+
+func (r *fakeRepository) SaveState(state *State) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[state.UserID] = *state
+	return nil
+}
+
+func (r *fakeRepository) SaveRun(state *State) error {
+	return r.SaveState(state)
+}
+
+func (r *fakeRepository) ClaimDue(now time.Time, lease time.Duration, limit int) ([]State, error) {
+	return nil, nil
+}
+
+func (r *fakeRepository) FindLinks(userID uuid.UUID) ([]RateLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var links []RateLink
+	for _, link := range r.links {
+		if link.UserID == userID {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+func (r *fakeRepository) SaveLink(link *RateLink) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.links[link.UserID.String()+"/"+link.AnimeID] = *link
+	return nil
+}
+
+func (r *fakeRepository) DeleteLink(userID uuid.UUID, animeID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.links, userID.String()+"/"+animeID)
+	return nil
+}
+
+func (r *fakeRepository) DeleteLinks(userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, link := range r.links {
+		if link.UserID == userID {
+			delete(r.links, key)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepository) link(userID uuid.UUID, animeID string) (RateLink, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	link, ok := r.links[userID.String()+"/"+animeID]
+	return link, ok
+}
+
+type syncFixture struct {
+	userID   uuid.UUID
+	repo     *fakeRepository
+	accounts *fakeAccounts
+	rates    *fakeRates
+	service  *service
+}
+
+func newSyncFixture(t *testing.T, entries []user.AnimeEntry, rates []shikimori.UserRate) *syncFixture {
+	t.Helper()
+	f := &syncFixture{
+		userID:   uuid.New(),
+		repo:     newFakeRepository(),
+		accounts: &fakeAccounts{token: "token", refreshedToken: "token", entries: make(map[string]user.AnimeEntry)},
+		rates:    newFakeRates(t, "token", rates...),
+	}
+	for _, entry := range entries {
+		f.accounts.entries[entry.AnimeID] = entry
+	}
+	f.service = NewService(f.repo, f.accounts, shikimori.NewRatesClient(f.rates.URL, "test")).(*service)
+	return f
+}
+
+func (f *syncFixture) run(t *testing.T) *State {
+	t.Helper()
+	state := &State{UserID: f.userID, Enabled: true, Status: StatusRunning}
+	f.service.runOne(context.Background(), state)
+	return state
+}
+
+func intPtr(v int) *int { return &v }
+
+func TestSyncPushesNewLocalEntries(t *testing.T) {
+	updated := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	f := newSyncFixture(t, []user.AnimeEntry{
+		{AnimeID: "1", Status: user.StatusWatching, Score: intPtr(8), EpisodesWatched: 3, UpdatedAt: updated},
+	}, nil)
+
+	state := f.run(t)
+	if state.Status != StatusOK || state.Pushed != 1 || state.Pulled != 0 {
+		t.Fatalf("state = %+v", state)
+	}
+	rate, ok := f.rates.byTarget(1)
+	if !ok {
+		t.Fatal("entry is not pushed to Shikimori")
+	}
+	if rate.UserID != testShikimoriUserID || rate.Status != user.StatusWatching || rate.Score != 8 || rate.Episodes != 3 {
+		t.Fatalf("pushed rate = %+v", rate)
+	}
+	link, ok := f.repo.link(f.userID, "1")
+	if !ok || link.RateID != rate.ID || !link.LocalUpdatedAt.Equal(updated) || !link.RemoteUpdatedAt.Equal(rate.UpdatedAt) {
+		t.Fatalf("link = %+v, rate %+v", link, rate)
+	}
+
+	// Повторный прогон без изменений ничего не отправляет
+	if state := f.run(t); state.Pushed != 0 || state.Pulled != 0 || state.Deleted != 0 {
+		t.Fatalf("second run changed something: %+v", state)
+	}
+}
+
+func TestSyncPullsNewRemoteRates(t *testing.T) {
+	remoteUpdated := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	f := newSyncFixture(t, nil, []shikimori.UserRate{
+		{ID: 7, UserID: testShikimoriUserID, TargetID: 2, TargetType: "Anime", Status: "rewatching", Score: 9, Episodes: 5, Rewatches: 1, UpdatedAt: remoteUpdated},
+		// чужая запись не должна попасть в список
+		{ID: 8, UserID: testShikimoriUserID + 1, TargetID: 3, TargetType: "Anime", Status: user.StatusCompleted, UpdatedAt: remoteUpdated},
+	})
+
+	state := f.run(t)
+	if state.Status != StatusOK || state.Pulled != 1 || state.Pushed != 0 {
+		t.Fatalf("state = %+v", state)
+	}
+	entry, ok := f.accounts.entries["2"]
+	if !ok {
+		t.Fatal("rate is not pulled")
+	}
+	if entry.Status != user.StatusWatching || entry.Score == nil || *entry.Score != 9 || entry.EpisodesWatched != 5 || entry.RewatchCount != 1 {
+		t.Fatalf("pulled entry = %+v", entry)
+	}
+	if _, ok := f.accounts.entries["3"]; ok {
+		t.Fatal("another user's rate is pulled")
+	}
+	link, ok := f.repo.link(f.userID, "2")
+	if !ok || link.RateID != 7 || !link.RemoteUpdatedAt.Equal(remoteUpdated) || !link.LocalUpdatedAt.Equal(entry.UpdatedAt) {
+		t.Fatalf("link = %+v", link)
+	}
+}
+
+func TestSyncResolvesConflictsByRateLinks(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	hour := time.Hour
+	rate := func(id, target int64, episodes int, updated time.Time) shikimori.UserRate {
+		return shikimori.UserRate{ID: id, UserID: testShikimoriUserID, TargetID: target, TargetType: "Anime",
+			Status: user.StatusWatching, Episodes: episodes, UpdatedAt: updated}
+	}
+	entry := func(animeID string, episodes int, updated time.Time) user.AnimeEntry {
+		return user.AnimeEntry{AnimeID: animeID, Status: user.StatusWatching, EpisodesWatched: episodes, UpdatedAt: updated}
+	}
+
+	f := newSyncFixture(t, []user.AnimeEntry{
+		entry("10", 4, t0.Add(hour)),   // обе стороны изменились, Shikimori позже
+		entry("11", 6, t0.Add(2*hour)), // обе стороны изменились, у нас позже
+		entry("12", 1, t0.Add(3*hour)), // у нас без изменений с прошлой синхронизации
+		entry("13", 2, t0),             // на Shikimori запись удалили
+	}, []shikimori.UserRate{
+		rate(110, 10, 7, t0.Add(2*hour)),
+		rate(111, 11, 9, t0.Add(hour)),
+		rate(112, 12, 8, t0.Add(hour)), // изменилась раньше, чем наша запись, но после синхронизации
+		rate(114, 14, 3, t0),           // у нас запись удалили
+	})
+	for animeID, link := range map[string]RateLink{
+		"10": {RateID: 110, LocalUpdatedAt: t0, RemoteUpdatedAt: t0},
+		"11": {RateID: 111, LocalUpdatedAt: t0, RemoteUpdatedAt: t0},
+		"12": {RateID: 112, LocalUpdatedAt: t0.Add(3 * hour), RemoteUpdatedAt: t0},
+		"13": {RateID: 113, LocalUpdatedAt: t0, RemoteUpdatedAt: t0},
+		"14": {RateID: 114, LocalUpdatedAt: t0, RemoteUpdatedAt: t0},
+	} {
+		link.UserID, link.AnimeID = f.userID, animeID
+		f.repo.SaveLink(&link)
+	}
+
+	state := f.run(t)
+	if state.Status != StatusOK || state.Pulled != 2 || state.Pushed != 1 || state.Deleted != 2 {
+		t.Fatalf("state = %+v", state)
+	}
+	if got := f.accounts.entries["10"].EpisodesWatched; got != 7 {
+		t.Errorf("anime 10: local episodes = %d, want 7 from the later Shikimori change", got)
+	}
+	if got, _ := f.rates.byTarget(11); got.Episodes != 6 {
+		t.Errorf("anime 11: remote episodes = %d, want 6 from the later local change", got.Episodes)
+	}
+	if got := f.accounts.entries["12"].EpisodesWatched; got != 8 {
+		t.Errorf("anime 12: local episodes = %d, want 8 from the only changed side", got)
+	}
+	if _, ok := f.accounts.entries["13"]; ok {
+		t.Error("anime 13: entry deleted on Shikimori is kept locally")
+	}
+	if _, ok := f.rates.byTarget(14); ok {
+		t.Error("anime 14: locally deleted entry is kept on Shikimori")
+	}
+	for _, animeID := range []string{"13", "14"} {
+		if _, ok := f.repo.link(f.userID, animeID); ok {
+			t.Errorf("anime %s: link is kept after deletion", animeID)
+		}
+	}
+}
+
+func TestSyncRefreshesRejectedToken(t *testing.T) {
+	entries := []user.AnimeEntry{{AnimeID: "1", Status: user.StatusPlanned, UpdatedAt: time.Now().UTC()}}
+
+	f := newSyncFixture(t, entries, nil)
+	// Токен отозван раньше срока: Shikimori отвечает 401
+	f.accounts.token, f.accounts.refreshedToken = "revoked", "token"
+	state := f.run(t)
+	if state.Status != StatusOK || state.Pushed != 1 {
+		t.Fatalf("state after refresh = %+v", state)
+	}
+	if f.accounts.refreshes != 1 {
+		t.Fatalf("token refreshed %d times, want 1", f.accounts.refreshes)
+	}
+
+	f = newSyncFixture(t, entries, nil)
+	f.accounts.token, f.accounts.refreshedToken = "revoked", "also-revoked"
+	state = f.run(t)
+	if state.Status != StatusError || state.LastError == "" {
+		t.Fatalf("state with a rejected refreshed token = %+v", state)
+	}
+	if f.accounts.refreshes != 1 {
+		t.Fatalf("token refreshed %d times, want 1", f.accounts.refreshes)
+	}
+}
@@ -0,0 +1,42 @@
+package stats
+
+import "time"
+
+// Stats - статистика просмотра пользователя по его списку.
+type Stats struct {
+	TotalMinutes    int            `json:"total_minutes"`
+	TotalHours      float64        `json:"total_hours"`
+	TotalDays       float64        `json:"total_days"`
+	EpisodesWatched int            `json:"episodes_watched"`
+	TitlesCount     int            `json:"titles_count"`
+	ByStatus        map[string]int `json:"by_status"`
+	ByKind          []Bucket       `json:"by_kind"`
+	ByGenre         []Bucket       `json:"by_genre"`
+	ByStudio        []Bucket       `json:"by_studio"`
+	ByYear          []Bucket       `json:"by_year"`
+	MeanScore       *float64       `json:"mean_score"`
+	ScoredCount     int            `json:"scored_count"`
+	// Scores[i] - число оценок i+1
+	Scores  []int   `json:"scores"`
+	Streaks Streaks `json:"streaks"`
+	// аниме, которые не удалось загрузить с Shikimori: в разбивках и
+	// времени просмотра они не учтены
+	MissingAnimeIDs []string  `json:"missing_anime_ids"`
+	GeneratedAt     time.Time `json:"generated_at"`
+}
+
+// Bucket - строка разбивки: число тайтлов и время просмотра.
+type Bucket struct {
+	Name    string `json:"name"`
+	Russian string `json:"russian,omitempty"`
+	Count   int    `json:"count"`
+	Minutes int    `json:"minutes"`
+}
+
+// Streaks - серии дней подряд, в которые пользователь досматривал хотя бы
+// одну серию в плеере сайта. Дни считаются по UTC.
+type Streaks struct {
+	Current       int     `json:"current"`
+	Longest       int     `json:"longest"`
+	LastWatchedOn *string `json:"last_watched_on"`
+}
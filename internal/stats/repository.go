@@ -0,0 +1,40 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	FindEntries(userID uuid.UUID) ([]user.AnimeEntry, error)
+	FindWatchDays(userID uuid.UUID) ([]time.Time, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) FindEntries(userID uuid.UUID) ([]user.AnimeEntry, error) {
+	var entries []user.AnimeEntry
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&entries).Error
+	return entries, err
+}
+
+// FindWatchDays возвращает по возрастанию дни (UTC), в которые были
+// досмотрены серии.
+func (r *repository) FindWatchDays(userID uuid.UUID) ([]time.Time, error) {
+	var days []time.Time
+	err := r.db.Model(&user.EpisodeProgress{}).
+		Select("DISTINCT (completed_at AT TIME ZONE 'UTC')::date AS day").
+		Where("user_id = ? AND completed AND completed_at IS NOT NULL", userID).
+		Order("day").
+		Pluck("day", &days).Error
+	return days, err
+}
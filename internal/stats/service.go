@@ -0,0 +1,270 @@
+package stats
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+)
+
+const (
+	// статистика сбрасывается при изменении списка; TTL нужен для серий
+	// просмотра, которые меняются без изменения списка
+	cacheTTL = 30 * time.Minute
+	// при неполных данных Shikimori статистика пересчитывается чаще
+	partialCacheTTL = time.Minute
+	maxCacheEntries = 10000
+)
+
+// AnimeFetcher загружает данные аниме с Shikimori.
+type AnimeFetcher interface {
+	FetchAnimesByIDs(ctx context.Context, ids []string) (map[string]shikimori.Anime, []string)
+}
+
+type Service interface {
+	GetStats(ctx context.Context, userID uuid.UUID) (*Stats, error)
+	// AnimeEntryChanged реализует user.EntryObserver и сбрасывает кэш.
+	AnimeEntryChanged(userID uuid.UUID, before, after *user.AnimeEntry)
+}
+
+// cached - запись кэша. После изменения списка остается запись без stats
+// с changedAt, чтобы не сохранить статистику, посчитанную до изменения.
+type cached struct {
+	stats     *Stats
+	expiresAt time.Time
+	changedAt time.Time
+}
+
+type service struct {
+	repo   Repository
+	animes AnimeFetcher
+
+	mu    sync.Mutex
+	cache map[uuid.UUID]cached
+}
+
+func NewService(repo Repository, animes AnimeFetcher) Service {
+	return &service{
+		repo:   repo,
+		animes: animes,
+		cache:  make(map[uuid.UUID]cached),
+	}
+}
+
+func (s *service) GetStats(ctx context.Context, userID uuid.UUID) (*Stats, error) {
+	s.mu.Lock()
+	entry, ok := s.cache[userID]
+	s.mu.Unlock()
+	if ok && entry.stats != nil && time.Now().Before(entry.expiresAt) {
+		return entry.stats, nil
+	}
+	startedAt := time.Now()
+
+	entries, err := s.repo.FindEntries(userID)
+	if err != nil {
+		return nil, err
+	}
+	days, err := s.repo.FindWatchDays(userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].AnimeID
+	}
+	animes, failed := s.animes.FetchAnimesByIDs(ctx, ids)
+
+	stats := compute(entries, animes, days, time.Now().UTC())
+	stats.MissingAnimeIDs = failed
+
+	ttl := cacheTTL
+	if len(failed) > 0 {
+		ttl = partialCacheTTL
+	}
+	s.store(userID, stats, startedAt, ttl)
+	return stats, nil
+}
+
+func (s *service) AnimeEntryChanged(userID uuid.UUID, before, after *user.AnimeEntry) {
+	s.mu.Lock()
+	s.cache[userID] = cached{changedAt: time.Now()}
+	s.mu.Unlock()
+}
+
+func (s *service) store(userID uuid.UUID, stats *Stats, startedAt time.Time, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cache[userID].changedAt.After(startedAt) {
+		return
+	}
+	now := time.Now()
+	if len(s.cache) >= maxCacheEntries {
+		for id, entry := range s.cache {
+			if now.After(entry.expiresAt) && now.Sub(entry.changedAt) > time.Minute {
+				delete(s.cache, id)
+			}
+		}
+	}
+	if len(s.cache) >= maxCacheEntries {
+		return
+	}
+	s.cache[userID] = cached{stats: stats, expiresAt: now.Add(ttl)}
+}
+
+// compute считает статистику. Запланированные аниме учитываются только в
+// разбивке по статусам: в остальных разбивках - то, что пользователь смотрел.
+func compute(entries []user.AnimeEntry, animes map[string]shikimori.Anime, days []time.Time, now time.Time) *Stats {
+	stats := &Stats{
+		ByStatus:        make(map[string]int),
+		Scores:          make([]int, 10),
+		MissingAnimeIDs: []string{},
+		GeneratedAt:     now,
+	}
+	kinds := newBuckets()
+	genres := newBuckets()
+	studios := newBuckets()
+	years := newBuckets()
+
+	scoreSum := 0
+	for _, entry := range entries {
+		stats.ByStatus[entry.Status]++
+		if entry.Score != nil && *entry.Score >= 1 && *entry.Score <= 10 {
+			stats.ScoredCount++
+			scoreSum += *entry.Score
+			stats.Scores[*entry.Score-1]++
+		}
+		if entry.Status == user.StatusPlanned {
+			continue
+		}
+		stats.TitlesCount++
+
+		anime, ok := animes[entry.AnimeID]
+		episodes := watchedEpisodes(entry, anime)
+		minutes := episodes * anime.Duration
+		stats.EpisodesWatched += episodes
+		stats.TotalMinutes += minutes
+		if !ok {
+			continue
+		}
+
+		if anime.Kind != "" {
+			kinds.add(anime.Kind, anime.Kind, "", minutes)
+		}
+		for _, genre := range anime.Genres {
+			genres.add(genre.ID, genre.Name, genre.Russian, minutes)
+		}
+		for _, studio := range anime.Studios {
+			studios.add(studio.ID, studio.Name, "", minutes)
+		}
+		if anime.AiredOn != nil && anime.AiredOn.Year > 0 {
+			year := strconv.Itoa(anime.AiredOn.Year)
+			years.add(year, year, "", minutes)
+		}
+	}
+
+	stats.TotalHours = round1(float64(stats.TotalMinutes) / 60)
+	stats.TotalDays = round1(float64(stats.TotalMinutes) / (60 * 24))
+	if stats.ScoredCount > 0 {
+		mean := math.Round(float64(scoreSum)/float64(stats.ScoredCount)*100) / 100
+		stats.MeanScore = &mean
+	}
+	stats.ByKind = kinds.byCount()
+	stats.ByGenre = genres.byCount()
+	stats.ByStudio = studios.byCount()
+	stats.ByYear = years.byName()
+	stats.Streaks = streaks(days, now)
+	return stats
+}
+
+// watchedEpisodes - сколько серий пользователь посмотрел с учетом
+// пересмотров. У просмотренного аниме засчитываются все серии, даже если
+// счетчик в списке не заполнен.
+func watchedEpisodes(entry user.AnimeEntry, anime shikimori.Anime) int {
+	episodes := entry.EpisodesWatched
+	if entry.Status == user.StatusCompleted && anime.Episodes > episodes {
+		episodes = anime.Episodes
+	}
+	total := anime.Episodes
+	if total == 0 {
+		total = episodes
+	}
+	return episodes + entry.RewatchCount*total
+}
+
+// streaks считает серии по отсортированным дням просмотра. Текущая серия
+// не прерывается, пока сегодня еще можно посмотреть серию.
+func streaks(days []time.Time, now time.Time) Streaks {
+	var result Streaks
+	if len(days) == 0 {
+		return result
+	}
+
+	run := 0
+	var prev time.Time
+	for i, day := range days {
+		day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+		if i > 0 && day.Sub(prev) == 24*time.Hour {
+			run++
+		} else {
+			run = 1
+		}
+		result.Longest = max(result.Longest, run)
+		prev = day
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if today.Sub(prev) <= 24*time.Hour {
+		result.Current = run
+	}
+	last := prev.Format(time.DateOnly)
+	result.LastWatchedOn = &last
+	return result
+}
+
+type buckets struct {
+	index map[string]int
+	items []Bucket
+}
+
+func newBuckets() *buckets {
+	return &buckets{index: make(map[string]int)}
+}
+
+func (b *buckets) add(key, name, russian string, minutes int) {
+	i, ok := b.index[key]
+	if !ok {
+		i = len(b.items)
+		b.index[key] = i
+		b.items = append(b.items, Bucket{Name: name, Russian: russian})
+	}
+	b.items[i].Count++
+	b.items[i].Minutes += minutes
+}
+
+func (b *buckets) byCount() []Bucket {
+	sort.SliceStable(b.items, func(i, j int) bool {
+		if b.items[i].Count != b.items[j].Count {
+			return b.items[i].Count > b.items[j].Count
+		}
+		return b.items[i].Name < b.items[j].Name
+	})
+	return append([]Bucket{}, b.items...)
+}
+
+func (b *buckets) byName() []Bucket {
+	sort.SliceStable(b.items, func(i, j int) bool {
+		return b.items[i].Name < b.items[j].Name
+	})
+	return append([]Bucket{}, b.items...)
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
@@ -0,0 +1,165 @@
+package stats
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+)
+
+type fakeRepository struct {
+	entries []user.AnimeEntry
+	days    []time.Time
+	calls   int
+}
+
+func (r *fakeRepository) FindEntries(userID uuid.UUID) ([]user.AnimeEntry, error) {
+	r.calls++
+	return r.entries, nil
+}
+
+func (r *fakeRepository) FindWatchDays(userID uuid.UUID) ([]time.Time, error) {
+	return r.days, nil
+}
+
+// fakeAnimes отдает аниме из map, остальные ID считаются незагруженными.
+type fakeAnimes map[string]shikimori.Anime
+
+func (f fakeAnimes) FetchAnimesByIDs(ctx context.Context, ids []string) (map[string]shikimori.Anime, []string) {
+	found := map[string]shikimori.Anime{}
+	failed := []string{}
+	for _, id := range ids {
+		if anime, ok := f[id]; ok {
+			found[id] = anime
+		} else {
+			failed = append(failed, id)
+		}
+	}
+	return found, failed
+}
+
+func entry(animeID, status string, score, episodes, rewatches int) user.AnimeEntry {
+	e := user.AnimeEntry{AnimeID: animeID, Status: status, EpisodesWatched: episodes, RewatchCount: rewatches}
+	if score > 0 {
+		e.Score = &score
+	}
+	return e
+}
+
+func TestCompute(t *testing.T) {
+	action := shikimori.Genre{ID: "1", Name: "Action", Russian: "Экшен"}
+	drama := shikimori.Genre{ID: "8", Name: "Drama", Russian: "Драма"}
+	animes := map[string]shikimori.Anime{
+		"1": {Kind: "tv", Episodes: 12, Duration: 24, AiredOn: &shikimori.Date{Year: 2020},
+			Genres: []shikimori.Genre{action, drama}, Studios: []shikimori.Studio{{ID: "2", Name: "Bones"}}},
+		"2": {Kind: "tv", Episodes: 24, Duration: 20, AiredOn: &shikimori.Date{Year: 2018},
+			Genres: []shikimori.Genre{action}},
+		"3": {Kind: "movie", Episodes: 1, Duration: 120, Genres: []shikimori.Genre{drama}},
+	}
+	entries := []user.AnimeEntry{
+		// просмотренное засчитывается целиком и с пересмотром
+		entry("1", user.StatusCompleted, 9, 0, 1),
+		entry("2", user.StatusWatching, 6, 5, 0),
+		// запланированное учитывается только в статусах и оценках
+		entry("3", user.StatusPlanned, 10, 0, 0),
+		// без данных Shikimori известны только серии
+		entry("4", user.StatusDropped, 0, 3, 0),
+	}
+	stats := compute(entries, animes, nil, time.Now())
+
+	if stats.TitlesCount != 3 || stats.EpisodesWatched != 12*2+5+3 {
+		t.Errorf("titles %d, episodes %d", stats.TitlesCount, stats.EpisodesWatched)
+	}
+	if want := 24*24 + 5*20; stats.TotalMinutes != want || stats.TotalHours != 11.3 {
+		t.Errorf("minutes %d, hours %v, want %d minutes", stats.TotalMinutes, stats.TotalHours, want)
+	}
+	if stats.ScoredCount != 3 || stats.MeanScore == nil || *stats.MeanScore != 8.33 {
+		t.Errorf("scored %d, mean %v", stats.ScoredCount, stats.MeanScore)
+	}
+	if stats.Scores[5] != 1 || stats.Scores[8] != 1 || stats.Scores[9] != 1 {
+		t.Errorf("score histogram %v", stats.Scores)
+	}
+	wantStatus := map[string]int{user.StatusCompleted: 1, user.StatusWatching: 1, user.StatusPlanned: 1, user.StatusDropped: 1}
+	if !reflect.DeepEqual(stats.ByStatus, wantStatus) {
+		t.Errorf("by status %v", stats.ByStatus)
+	}
+
+	for name, tc := range map[string]struct{ got, want []Bucket }{
+		"kind":   {stats.ByKind, []Bucket{{Name: "tv", Count: 2, Minutes: 676}}},
+		"genre":  {stats.ByGenre, []Bucket{{Name: "Action", Russian: "Экшен", Count: 2, Minutes: 676}, {Name: "Drama", Russian: "Драма", Count: 1, Minutes: 576}}},
+		"studio": {stats.ByStudio, []Bucket{{Name: "Bones", Count: 1, Minutes: 576}}},
+		"year":   {stats.ByYear, []Bucket{{Name: "2018", Count: 1, Minutes: 100}, {Name: "2020", Count: 1, Minutes: 576}}},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("by %s = %+v, want %+v", name, tc.got, tc.want)
+		}
+	}
+
+	empty := compute(nil, nil, nil, time.Now())
+	if empty.MeanScore != nil || len(empty.ByGenre) != 0 || len(empty.Scores) != 10 {
+		t.Errorf("stats of an empty list: %+v", empty)
+	}
+}
+
+func TestStreaks(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	now := time.Date(2024, 5, 10, 18, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name             string
+		days             []time.Time
+		current, longest int
+	}{
+		{"none", nil, 0, 0},
+		{"ongoing today", []time.Time{day(1), day(2), day(3), day(8), day(9), day(10)}, 3, 3},
+		// вчерашняя серия не прерывается, пока сегодня можно посмотреть
+		{"ongoing yesterday", []time.Time{day(7), day(8), day(9)}, 3, 3},
+		{"broken", []time.Time{day(1), day(2), day(3), day(4), day(8)}, 0, 4},
+	} {
+		got := streaks(tc.days, now)
+		if got.Current != tc.current || got.Longest != tc.longest {
+			t.Errorf("%s: current %d, longest %d, want %d and %d", tc.name, got.Current, got.Longest, tc.current, tc.longest)
+		}
+		if (got.LastWatchedOn != nil) != (len(tc.days) > 0) {
+			t.Errorf("%s: last watched on %v", tc.name, got.LastWatchedOn)
+		}
+	}
+}
+
+func TestGetStatsCache(t *testing.T) {
+	repo := &fakeRepository{entries: []user.AnimeEntry{entry("1", user.StatusCompleted, 8, 12, 0)}}
+	s := NewService(repo, fakeAnimes{"1": {Episodes: 12, Duration: 24}})
+	ctx, userID := context.Background(), uuid.New()
+
+	for range 2 {
+		if _, err := s.GetStats(ctx, userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if repo.calls != 1 {
+		t.Errorf("stats computed %d times, want cached", repo.calls)
+	}
+
+	// изменение списка сбрасывает кэш
+	s.AnimeEntryChanged(userID, nil, nil)
+	if _, err := s.GetStats(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if repo.calls != 2 {
+		t.Errorf("stats computed %d times after a list change, want 2", repo.calls)
+	}
+
+	repo.entries = append(repo.entries, entry("2", user.StatusWatching, 0, 1, 0))
+	s.AnimeEntryChanged(userID, nil, nil)
+	stats, err := s.GetStats(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats.MissingAnimeIDs, []string{"2"}) {
+		t.Errorf("missing anime %v", stats.MissingAnimeIDs)
+	}
+}
@@ -0,0 +1,363 @@
+package user
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// fakeRepository хранит пользователей и токены в памяти. Встроенный
+// Repository остается nil: вызов нереализованного метода паникует, и тест
+// сразу показывает, какой метод понадобился.
+type fakeRepository struct {
+	Repository
+
+	mu            sync.Mutex
+	users         map[uuid.UUID]User
+	sessions      map[uuid.UUID]Session
+	refreshTokens map[uuid.UUID]RefreshToken
+	actionTokens  map[uuid.UUID]ActionToken
+	apiTokens     map[uuid.UUID]APIToken
+	settings      map[string]string
+	shikimori     map[uuid.UUID]ShikimoriAccount
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{
+		users:         make(map[uuid.UUID]User),
+		sessions:      make(map[uuid.UUID]Session),
+		refreshTokens: make(map[uuid.UUID]RefreshToken),
+		actionTokens:  make(map[uuid.UUID]ActionToken),
+		apiTokens:     make(map[uuid.UUID]APIToken),
+		settings:      make(map[string]string),
+		shikimori:     make(map[uuid.UUID]ShikimoriAccount),
+	}
+}
+
+func (r *fakeRepository) Create(user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, user.Email) {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *fakeRepository) FindByEmail(email string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) FindByID(userID string) (*User, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &u, nil
+}
+
+func (r *fakeRepository) update(userID uuid.UUID, fn func(u *User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[userID]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	fn(&u)
+	r.users[userID] = u
+	return nil
+}
+
+func (r *fakeRepository) UpdateRole(userID string, role string) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+	return r.update(id, func(u *User) { u.Role = role })
+}
+
+func (r *fakeRepository) MarkEmailVerified(userID uuid.UUID) error {
+	return r.update(userID, func(u *User) { u.EmailVerified = true })
+}
+
+func (r *fakeRepository) UpdateVerificationSentAt(userID uuid.UUID, sentAt time.Time) error {
+	return r.update(userID, func(u *User) { u.EmailVerificationSentAt = &sentAt })
+}
+
+func (r *fakeRepository) UpdatePasswordResetSentAt(userID uuid.UUID, sentAt time.Time) error {
+	return r.update(userID, func(u *User) { u.PasswordResetSentAt = &sentAt })
+}
+
+func (r *fakeRepository) CreateActionToken(token *ActionToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actionTokens[token.ID] = *token
+	return nil
+}
+
+func (r *fakeRepository) FindActionToken(tokenID uuid.UUID) (*ActionToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.actionTokens[tokenID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &token, nil
+}
+
+func (r *fakeRepository) UseActionToken(tokenID uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.actionTokens[tokenID]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	token.UsedAt = &now
+	r.actionTokens[tokenID] = token
+	return true, nil
+}
+
+func (r *fakeRepository) GetSetting(key string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.settings[key], nil
+}
+
+func (r *fakeRepository) FindActionTokenByHash(tokenHash string) (*ActionToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.actionTokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) InvalidateActionTokens(userID uuid.UUID, purpose string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for id, token := range r.actionTokens {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			token.UsedAt = &now
+			r.actionTokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepository) UpdatePassword(userID uuid.UUID, passwordHash string) error {
+	return r.update(userID, func(u *User) { u.Password = passwordHash })
+}
+
+func (r *fakeRepository) RevokeUserSessions(userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, session := range r.sessions {
+		if session.UserID == userID {
+			r.revokeSession(id)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepository) UseTOTPCounter(userID uuid.UUID, counter int64) (bool, error) {
+	var used bool
+	err := r.update(userID, func(u *User) {
+		if counter > u.TOTPLastCounter {
+			u.TOTPLastCounter = counter
+			used = true
+		}
+	})
+	return used, err
+}
+
+func (r *fakeRepository) SetTOTPSecret(userID uuid.UUID, secret string) error {
+	return r.update(userID, func(u *User) {
+		u.TOTPSecret = secret
+		u.TOTPEnabled = false
+		u.TOTPLastCounter = 0
+	})
+}
+
+func (r *fakeRepository) EnableTOTP(userID uuid.UUID, counter int64) error {
+	return r.update(userID, func(u *User) {
+		u.TOTPEnabled = true
+		u.TOTPLastCounter = counter
+	})
+}
+
+func (r *fakeRepository) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	return nil
+}
+
+func (r *fakeRepository) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	return false, nil
+}
+
+func (r *fakeRepository) CreateSession(session *Session, token *RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[session.ID] = *session
+	r.refreshTokens[token.ID] = *token
+	return nil
+}
+
+func (r *fakeRepository) FindSessionByID(sessionID uuid.UUID) (*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[sessionID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &session, nil
+}
+
+func (r *fakeRepository) FindRefreshToken(tokenHash string) (*RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.refreshTokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) RotateRefreshToken(oldTokenID uuid.UUID, token *RefreshToken) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.refreshTokens[oldTokenID]
+	if !ok || old.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	old.UsedAt = &now
+	r.refreshTokens[oldTokenID] = old
+	r.refreshTokens[token.ID] = *token
+	return true, nil
+}
+
+func (r *fakeRepository) RevokeSession(sessionID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revokeSession(sessionID)
+	return nil
+}
+
+func (r *fakeRepository) revokeSession(sessionID uuid.UUID) {
+	session, ok := r.sessions[sessionID]
+	if !ok || session.RevokedAt != nil {
+		return
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	r.sessions[sessionID] = session
+}
+
+func (r *fakeRepository) CreateWithShikimoriAccount(user *User, account *ShikimoriAccount) error {
+	if err := r.Create(user); err != nil {
+		return err
+	}
+	return r.SaveShikimoriAccount(account)
+}
+
+func (r *fakeRepository) FindShikimoriAccount(userID uuid.UUID) (*ShikimoriAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	account, ok := r.shikimori[userID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &account, nil
+}
+
+func (r *fakeRepository) FindShikimoriAccountByShikimoriID(shikimoriUserID int64) (*ShikimoriAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, account := range r.shikimori {
+		if account.ShikimoriUserID == shikimoriUserID {
+			return &account, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) SaveShikimoriAccount(account *ShikimoriAccount) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shikimori[account.UserID] = *account
+	return nil
+}
+
+func (r *fakeRepository) CreateAPIToken(token *APIToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.apiTokens[token.ID] = *token
+	return nil
+}
+
+func (r *fakeRepository) CountActiveAPITokens(userID uuid.UUID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	for _, token := range r.apiTokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeRepository) FindAPITokenByHash(tokenHash string) (*APIToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.apiTokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) RevokeAPIToken(userID, tokenID uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.apiTokens[tokenID]
+	if !ok || token.UserID != userID || token.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	token.RevokedAt = &now
+	r.apiTokens[tokenID] = token
+	return true, nil
+}
+
+func (r *fakeRepository) TouchAPIToken(tokenID uuid.UUID, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token := r.apiTokens[tokenID]
+	token.LastUsedAt = &usedAt
+	r.apiTokens[tokenID] = token
+	return nil
+}
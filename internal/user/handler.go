@@ -1,25 +1,40 @@
 package user
 
 import (
+	"context"
+	"errors"
+	"html/template"
 	"image"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/pkg/pagination"
 	"github.com/google/uuid"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
-	service Service
+	service  Service
+	sections []ProfileSection
 }
 
-func NewHandler(service Service) *Handler {
-	return &Handler{service}
+// ProfileSection - дополнительный блок ответа GET /profile из другого пакета.
+type ProfileSection interface {
+	ProfileSection(ctx context.Context, userID uuid.UUID) (key string, value interface{}, err error)
+}
+
+func NewHandler(service Service, sections ...ProfileSection) *Handler {
+	return &Handler{service: service, sections: sections}
 }
 
 func (h *Handler) Register(c echo.Context) error {
@@ -37,6 +52,120 @@ func (h *Handler) Register(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"message": "registered"})
 }
 
+func (h *Handler) VerifyEmail(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "token is required"})
+	}
+	if err := h.service.VerifyEmail(token); err != nil {
+		if errors.Is(err, ErrInvalidActionToken) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "email verified"})
+}
+
+func (h *Handler) ResendVerification(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.ResendVerification(principal.UserID.String()); err != nil {
+		var throttled *ThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			return tooManyRequests(c, throttled)
+		case errors.Is(err, ErrEmailAlreadyVerified):
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "verification email sent"})
+}
+
+func (h *Handler) ForgotPassword(c echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	h.service.ForgotPassword(req.Email)
+
+	// Ответ одинаковый независимо от того, существует ли аккаунт
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "if the account exists, a password reset link has been sent",
+	})
+}
+
+var passwordResetPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Сброс пароля</title></head>
+<body>
+<form id="reset">
+<h1>Сброс пароля</h1>
+<input type="hidden" name="token" value="{{.}}">
+<p><label>Новый пароль <input type="password" name="password" minlength="8" required autocomplete="new-password"></label></p>
+<p><button type="submit">Сохранить</button></p>
+<p id="result"></p>
+</form>
+<script>
+document.getElementById("reset").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const form = e.target;
+  const resp = await fetch("/password/reset", {
+    method: "POST",
+    headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({token: form.token.value, password: form.password.value}),
+  });
+  const data = await resp.json();
+  document.getElementById("result").textContent = resp.ok ? "Пароль изменен, можно войти." : data.error;
+});
+</script>
+</body>
+</html>
+`))
+
+// PasswordResetForm - страница из ссылки в письме, если PASSWORD_RESET_URL
+// не указывает на фронтенд. Форма отправляет пароль в POST /password/reset.
+func (h *Handler) PasswordResetForm(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "token is required"})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	c.Response().Header().Set("Referrer-Policy", "no-referrer")
+	c.Response().WriteHeader(http.StatusOK)
+	return passwordResetPage.Execute(c.Response(), token)
+}
+
+func (h *Handler) ResetPassword(c echo.Context) error {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "token is required"})
+	}
+
+	if err := h.service.ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, ErrInvalidActionToken) || errors.Is(err, ErrPasswordTooShort) || errors.Is(err, ErrPasswordTooLong) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "password updated"})
+}
+
 func (h *Handler) Login(c echo.Context) error {
 	var req struct {
 		Email    string `json:"email"`
@@ -45,29 +174,401 @@ func (h *Handler) Login(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
-	token, err := h.service.Login(req.Email, req.Password)
+	tokens, err := h.service.Login(req.Email, req.Password, ClientInfo{
+		UserAgent: c.Request().UserAgent(),
+		IP:        c.RealIP(),
+	})
+	if err != nil {
+		var throttled *ThrottledError
+		if errors.As(err, &throttled) {
+			return tooManyRequests(c, throttled)
+		}
+		if errors.Is(err, ErrInvalidCredentials) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+func (h *Handler) LoginTwoFactor(c echo.Context) error {
+	var req struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if req.Challenge == "" || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "challenge and code are required"})
+	}
+
+	tokens, err := h.service.CompleteTwoFactorLogin(req.Challenge, req.Code, ClientInfo{
+		UserAgent: c.Request().UserAgent(),
+		IP:        c.RealIP(),
+	})
+	if err != nil {
+		var throttled *ThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			return tooManyRequests(c, throttled)
+		case errors.Is(err, ErrInvalidActionToken), errors.Is(err, ErrInvalidTwoFactorCode):
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+func (h *Handler) EnrollTwoFactor(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	enrollment, err := h.service.EnrollTwoFactor(principal.UserID.String())
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+func (h *Handler) ConfirmTwoFactor(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	codes, err := h.service.ConfirmTwoFactor(principal.UserID.String(), req.Code)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":         "two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *Handler) DisableTwoFactor(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		return err
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := h.service.DisableTwoFactor(principal.UserID.String(), req.Code); err != nil {
+		return twoFactorError(c, err)
 	}
-	log.Println(token)
-	return c.JSON(http.StatusOK, echo.Map{"token": token})
+	return c.JSON(http.StatusOK, echo.Map{"status": "two-factor authentication disabled"})
 }
 
-func (h *Handler) Profile(c echo.Context) error {
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+func (h *Handler) RegenerateRecoveryCodes(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(principal.UserID.String(), req.Code)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"recovery_codes": codes})
+}
+
+func (h *Handler) ListAPITokens(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := h.service.ListAPITokens(principal.UserID.String())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+func (h *Handler) CreateAPIToken(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	token, plain, err := h.service.CreateAPIToken(principal.UserID.String(), req.Name, req.Scopes, req.ExpiresInDays)
+	if err != nil {
+		if errors.Is(err, ErrTooManyAPITokens) {
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusCreated, echo.Map{
+		"token":     plain,
+		"api_token": token,
+	})
+}
+
+func (h *Handler) RevokeAPIToken(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.RevokeAPIToken(principal.UserID.String(), c.Param("token_id")); err != nil {
+		if errors.Is(err, ErrAPITokenNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) GetTwoFactorPolicy(c echo.Context) error {
+	roles, err := h.service.GetTwoFactorPolicy()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"roles": roles})
+}
+
+func (h *Handler) SetTwoFactorPolicy(c echo.Context) error {
+	var req struct {
+		Roles []string `json:"roles"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := h.service.SetTwoFactorPolicy(req.Roles); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"roles": req.Roles})
+}
+
+// ShikimoriLogin перенаправляет на страницу авторизации Shikimori.
+func (h *Handler) ShikimoriLogin(c echo.Context) error {
+	authURL, nonce, err := h.service.ShikimoriAuthURL("")
+	if err != nil {
+		return shikimoriError(c, err)
+	}
+	setShikimoriNonce(c, nonce, shikimoriStateTTL)
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+func (h *Handler) ShikimoriCallback(c echo.Context) error {
+	if errParam := c.QueryParam("error"); errParam != "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": errParam})
+	}
+	code := c.QueryParam("code")
+	state := c.QueryParam("state")
+	if code == "" || state == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "code and state are required"})
+	}
+	var nonce string
+	if cookie, err := c.Cookie(shikimoriNonceCookie); err == nil {
+		nonce = cookie.Value
+	}
+	// nonce одноразовый, удаляем его при любом исходе
+	setShikimoriNonce(c, "", -time.Second)
+
+	result, err := h.service.ShikimoriCallback(c.Request().Context(), code, state, nonce, ClientInfo{
+		UserAgent: c.Request().UserAgent(),
+		IP:        c.RealIP(),
+	})
+	if err != nil {
+		return shikimoriError(c, err)
+	}
+	if result.Linked {
+		return c.JSON(http.StatusOK, echo.Map{"status": "shikimori account linked"})
+	}
+	return c.JSON(http.StatusOK, result.Login)
+}
+
+func (h *Handler) LinkShikimori(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	// Браузер не передаст Bearer-токен при редиректе, поэтому отдаем ссылку,
+	// а пользователь зашит в подписанный state. Cookie с nonce браузер
+	// сохранит, только если фронтенд на том же сайте, что и API, и делает
+	// запрос с credentials: include.
+	authURL, nonce, err := h.service.ShikimoriAuthURL(principal.UserID.String())
+	if err != nil {
+		return shikimoriError(c, err)
+	}
+	setShikimoriNonce(c, nonce, shikimoriStateTTL)
+	return c.JSON(http.StatusOK, echo.Map{"authorize_url": authURL})
+}
+
+const shikimoriNonceCookie = "shikimori_oauth_nonce"
+
+// setShikimoriNonce ставит (или при maxAge < 0 удаляет) cookie с nonce,
+// которым колбэк проверяет, что OAuth начат в этом же браузере. Lax нужен,
+// чтобы cookie пришла при редиректе обратно с shikimori.one.
+func setShikimoriNonce(c echo.Context, nonce string, maxAge time.Duration) {
+	c.SetCookie(&http.Cookie{
+		Name:     shikimoriNonceCookie,
+		Value:    nonce,
+		Path:     "/auth/shikimori",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *Handler) GetShikimoriAccount(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	account, err := h.service.GetShikimoriAccount(principal.UserID.String())
+	if err != nil {
+		return shikimoriError(c, err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+func (h *Handler) UnlinkShikimori(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
 	}
 
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
+	if err := h.service.UnlinkShikimori(principal.UserID.String()); err != nil {
+		return shikimoriError(c, err)
 	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "shikimori account unlinked"})
+}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+func (h *Handler) RefreshToken(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if req.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "refresh_token is required"})
 	}
+	tokens, err := h.service.RefreshTokens(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+func (h *Handler) Logout(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if req.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "refresh_token is required"})
+	}
+	if err := h.service.Logout(req.RefreshToken); err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "logged out"})
+}
+
+func (h *Handler) ListUsers(c echo.Context) error {
+	page, limit := pagination.FromQuery(c, 50, 200)
+
+	users, total, err := h.service.ListUsers(limit, (page-1)*limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"users": users,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+func (h *Handler) UpdateRole(c echo.Context) error {
+	userID := c.Param("user_id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user_id")
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := h.service.UpdateRole(userID, req.Role); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "user not found"})
+		}
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "role updated", "role": req.Role})
+}
+
+func (h *Handler) UnlockAccount(c echo.Context) error {
+	userID := c.Param("user_id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user_id")
+	}
+
+	if err := h.service.UnlockAccount(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "account unlocked"})
+}
+
+func (h *Handler) Profile(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	userID := principal.UserID.String()
 
 	user, err := h.service.GetProfile(userID)
 	if err != nil {
@@ -76,31 +577,41 @@ func (h *Handler) Profile(c echo.Context) error {
 		})
 	}
 
-	return c.JSON(http.StatusOK, echo.Map{
+	watched, favorites, err := h.service.GetListIDs(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	profile := echo.Map{
 		"user_id":            user.ID,
 		"email":              user.Email,
 		"nickname":           user.Nickname,
 		"avatar":             user.Avatar,
-		"watched_anime_ids":  user.WatchedAnimeIDs,
-		"favorite_anime_ids": user.FavoriteAnimeIDs,
-	})
-}
-
-func (h *Handler) AddWatched(c echo.Context) error {
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+		"role":               user.Role,
+		"email_verified":     user.EmailVerified,
+		"watched_anime_ids":  watched,
+		"favorite_anime_ids": favorites,
 	}
-
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
+	for _, section := range h.sections {
+		key, value, err := section.ProfileSection(c.Request().Context(), principal.UserID)
+		if err != nil {
+			log.Printf("Failed to load profile section %s: %v", key, err)
+			continue
+		}
+		profile[key] = value
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+	return c.JSON(http.StatusOK, profile)
+}
+
+func (h *Handler) AddWatched(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
 	}
+	userID := principal.UserID.String()
 
 	animeID := c.Param("anime_id")
 	if animeID == "" {
@@ -108,9 +619,7 @@ func (h *Handler) AddWatched(c echo.Context) error {
 	}
 
 	if err := h.service.AddWatched(userID, animeID); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"error": err.Error(),
-		})
+		return listError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{
@@ -120,20 +629,11 @@ func (h *Handler) AddWatched(c echo.Context) error {
 }
 
 func (h *Handler) AddFavorite(c echo.Context) error {
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
-	}
-
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
-	}
-
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
 	}
+	userID := principal.UserID.String()
 
 	animeID := c.Param("anime_id")
 	if animeID == "" {
@@ -141,9 +641,7 @@ func (h *Handler) AddFavorite(c echo.Context) error {
 	}
 
 	if err := h.service.AddFavorite(userID, animeID); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"error": err.Error(),
-		})
+		return listError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{
@@ -151,75 +649,224 @@ func (h *Handler) AddFavorite(c echo.Context) error {
 		"anime_id": animeID,
 	})
 }
-func (h *Handler) GetWatchedAnime(c echo.Context) error {
-	// Получаем userID из JWT токена
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+func (h *Handler) RemoveWatched(c echo.Context) error {
+	return h.removeFromList(c, h.service.RemoveWatched)
+}
+
+func (h *Handler) RemoveFavorite(c echo.Context) error {
+	return h.removeFromList(c, h.service.RemoveFavorites)
+}
+
+// removeFromList обслуживает и удаление одного аниме (:anime_id в пути),
+// и массовое удаление (anime_ids в теле запроса).
+func (h *Handler) removeFromList(c echo.Context, remove func(userID string, animeIDs []string) (int64, error)) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
 	}
 
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
+	var animeIDs []string
+	if animeID := c.Param("anime_id"); animeID != "" {
+		animeIDs = []string{animeID}
+	} else {
+		var req struct {
+			AnimeIDs []string `json:"anime_ids"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+		}
+		animeIDs = req.AnimeIDs
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+	removed, err := remove(principal.UserID.String(), animeIDs)
+	if err != nil {
+		return listError(c, err)
 	}
+	if removed == 0 && c.Param("anime_id") != "" {
+		return listError(c, ErrEntryNotFound)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"removed": removed})
+}
 
-	// Получаем список аниме с деталями
-	animeList, err := h.service.GetWatchedAnimeDetails(userID)
+func (h *Handler) MoveFavorite(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"error": err.Error(),
-		})
+		return err
+	}
+
+	var req struct {
+		Position int `json:"position"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
 	}
 
-	return c.JSON(http.StatusOK, animeList)
+	if err := h.service.MoveFavorite(principal.UserID.String(), c.Param("anime_id"), req.Position); err != nil {
+		return listError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) RecordProgress(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req ProgressInput
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	progress, err := h.service.RecordProgress(c.Request().Context(), principal.UserID.String(), c.Param("anime_id"), req)
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusOK, progress)
+}
+
+func (h *Handler) GetEpisodeProgress(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	progress, err := h.service.GetEpisodeProgress(principal.UserID.String(), c.Param("anime_id"))
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusOK, progress)
+}
+
+func (h *Handler) ContinueWatching(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	limit := pagination.Limit(c, 20, 100)
+	items, err := h.service.ContinueWatching(c.Request().Context(), principal.UserID.String(), limit)
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+func (h *Handler) GetWatchedAnime(c echo.Context) error {
+	// Получаем пользователя из контекста аутентификации
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	userID := principal.UserID.String()
+
+	// Получаем страницу списка с деталями
+	page, err := h.service.GetWatchedAnimeDetails(c.Request().Context(), userID, animeListQuery(c))
+	if err != nil {
+		return listError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, page)
 }
 func (h *Handler) GetFavouriteAnime(c echo.Context) error {
-	// Получаем userID из JWT токена
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+	// Получаем пользователя из контекста аутентификации
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
 	}
+	userID := principal.UserID.String()
 
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
+	// Получаем страницу списка с деталями
+	page, err := h.service.GetFavouriteAnimeDetails(c.Request().Context(), userID, animeListQuery(c))
+	if err != nil {
+		return listError(c, err)
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+	return c.JSON(http.StatusOK, page)
+}
+func (h *Handler) GetAnimeList(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
 	}
 
-	// Получаем список аниме с деталями
-	animeList, err := h.service.GetFavouriteAnimeDetails(userID)
+	entries, err := h.service.GetAnimeList(principal.UserID.String(), c.QueryParam("status"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"error": err.Error(),
-		})
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+func (h *Handler) GetAnimeEntry(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
 	}
 
-	return c.JSON(http.StatusOK, animeList)
+	entry, err := h.service.GetAnimeEntry(principal.UserID.String(), c.Param("anime_id"))
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusOK, entry)
 }
-func (h *Handler) UpdateNickname(c echo.Context) error {
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+
+func (h *Handler) CreateAnimeEntry(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
 	}
 
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
+	var req struct {
+		AnimeID string `json:"anime_id"`
+		AnimeEntryInput
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	entry, err := h.service.CreateAnimeEntry(principal.UserID.String(), req.AnimeID, req.AnimeEntryInput)
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusCreated, entry)
+}
+
+func (h *Handler) SaveAnimeEntry(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req AnimeEntryInput
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	entry, err := h.service.SaveAnimeEntry(principal.UserID.String(), c.Param("anime_id"), req)
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+func (h *Handler) DeleteAnimeEntry(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+	if err := h.service.DeleteAnimeEntry(principal.UserID.String(), c.Param("anime_id")); err != nil {
+		return listError(c, err)
 	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) UpdateNickname(c echo.Context) error {
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+	userID := principal.UserID.String()
 
 	var req struct {
 		Nickname string `json:"nickname"`
@@ -236,20 +883,11 @@ func (h *Handler) UpdateNickname(c echo.Context) error {
 }
 
 func (h *Handler) UploadAvatar(c echo.Context) error {
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
-	}
-
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
-	}
-
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+	principal, err := auth.CurrentUser(c)
+	if err != nil {
+		return err
 	}
+	userID := principal.UserID.String()
 
 	// Получаем файл из запроса
 	file, err := c.FormFile("avatar")
@@ -317,3 +955,60 @@ func (h *Handler) UploadAvatar(c echo.Context) error {
 		"path":   avatarPath,
 	})
 }
+
+func tooManyRequests(c echo.Context, throttled *ThrottledError) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	return c.JSON(http.StatusTooManyRequests, echo.Map{"error": throttled.Error()})
+}
+
+func twoFactorError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidTwoFactorCode):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, ErrTwoFactorEnabled),
+		errors.Is(err, ErrTwoFactorNotEnabled),
+		errors.Is(err, ErrTwoFactorNotEnrolled),
+		errors.Is(err, ErrTwoFactorRequiredForRole):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+}
+
+func shikimoriError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, shikimori.ErrOAuthNotConfigured):
+		return c.JSON(http.StatusServiceUnavailable, echo.Map{"error": err.Error()})
+	case errors.Is(err, ErrInvalidActionToken):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid or expired state"})
+	case errors.Is(err, ErrShikimoriAccountTaken):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	case errors.Is(err, ErrShikimoriNotLinked):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusBadGateway, echo.Map{"error": err.Error()})
+}
+
+func animeListQuery(c echo.Context) AnimeListQuery {
+	page, limit := pagination.FromQuery(c, 50, 200)
+	return AnimeListQuery{
+		Page:  page,
+		Limit: limit,
+		Sort:  c.QueryParam("sort"),
+		Order: c.QueryParam("order"),
+	}
+}
+
+func listError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrEntryNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrEntryExists):
+		status = http.StatusConflict
+	case errors.Is(err, ErrInvalidAnimeID), errors.Is(err, ErrInvalidStatus), errors.Is(err, ErrInvalidListEntry),
+		errors.Is(err, ErrTooManyAnimeIDs), errors.Is(err, ErrInvalidPosition), errors.Is(err, ErrInvalidSort),
+		errors.Is(err, ErrInvalidProgress):
+		status = http.StatusBadRequest
+	}
+	return c.JSON(status, echo.Map{"error": err.Error()})
+}
@@ -0,0 +1,75 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/internal/lockout"
+	"github.com/Zipklas/anime-site-backend/pkg/mailer"
+	"github.com/labstack/echo/v4"
+)
+
+func TestPasswordResetFormEscapesToken(t *testing.T) {
+	e := echo.New()
+	h := NewHandler(nil)
+	e.GET("/password/reset", h.PasswordResetForm)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, `/password/reset?token=abc"><script>x</script>`, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `name="token" value="abc&#34;&gt;&lt;script&gt;x&lt;/script&gt;"`) {
+		t.Fatalf("token is not escaped in the form:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/password/reset", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status without token = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLoginCountsIPWithSpoofedForwardedHeader(t *testing.T) {
+	store := lockout.NewMemoryStore()
+	svc := newTestService(t, newFakeRepository(), mailer.NewMemoryMailer())
+	svc.loginGuard = lockout.NewGuard(store, lockout.DefaultAccountPolicy, lockout.DefaultIPPolicy)
+
+	e := echo.New()
+	extractor, err := auth.IPExtractor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.IPExtractor = extractor
+	e.POST("/auth/login", NewHandler(svc).Login)
+
+	const attempts = 5
+	for i := 0; i < attempts; i++ {
+		body := fmt.Sprintf(`{"email":"user%d@example.com","password":"wrong-password"}`, i)
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		// каждая попытка притворяется новым клиентом
+		req.Header.Set(echo.HeaderXForwardedFor, fmt.Sprintf("203.0.113.%d", i))
+		req.Header.Set(echo.HeaderXRealIP, fmt.Sprintf("198.51.100.%d", i))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want %d", i, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// httptest.NewRequest ставит RemoteAddr 192.0.2.1:1234
+	attempt, err := store.Get(context.Background(), "ip:192.0.2.1")
+	if err != nil || attempt == nil || attempt.Failures != attempts {
+		t.Fatalf("connection IP attempt = %+v, %v; want %d failures", attempt, err, attempts)
+	}
+	if spoofed, _ := store.Get(context.Background(), "ip:203.0.113.0"); spoofed != nil {
+		t.Fatalf("spoofed IP is counted: %+v", spoofed)
+	}
+}
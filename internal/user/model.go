@@ -3,6 +3,7 @@ package user
 import (
 	"time"
 
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 )
@@ -15,7 +16,244 @@ type User struct {
 
 	Nickname string `gorm:"size:32" json:"nickname"`
 	Avatar   string `json:"avatar"`
+	Role     string `gorm:"size:16;not null;default:user" json:"role"`
 
-	WatchedAnimeIDs  pq.StringArray `gorm:"type:text[]" json:"watched_anime_ids"`
-	FavoriteAnimeIDs pq.StringArray `gorm:"type:text[]" json:"favorite_anime_ids"`
+	EmailVerified           bool       `gorm:"not null;default:false" json:"email_verified"`
+	EmailVerificationSentAt *time.Time `json:"-"`
+	PasswordResetSentAt     *time.Time `json:"-"`
+
+	TOTPSecret      string `json:"-"`
+	TOTPEnabled     bool   `gorm:"not null;default:false" json:"totp_enabled"`
+	TOTPLastCounter int64  `gorm:"not null;default:0" json:"-"`
+}
+
+// Статусы записей в списке аниме пользователя
+const (
+	StatusPlanned   = "planned"
+	StatusWatching  = "watching"
+	StatusCompleted = "completed"
+	StatusOnHold    = "on_hold"
+	StatusDropped   = "dropped"
+)
+
+func IsValidStatus(status string) bool {
+	switch status {
+	case StatusPlanned, StatusWatching, StatusCompleted, StatusOnHold, StatusDropped:
+		return true
+	}
+	return false
+}
+
+// AnimeEntry - запись в списке аниме пользователя. "Просмотренные" - это
+// записи со статусом completed, "избранное" - записи с IsFavorite.
+type AnimeEntry struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID          uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_user_anime_entry" json:"-"`
+	AnimeID         string     `gorm:"size:32;not null;uniqueIndex:idx_user_anime_entry;index" json:"anime_id"` // Shikimori ID
+	Status          string     `gorm:"size:16;not null;index" json:"status"`
+	Score           *int       `json:"score"` // 1-10, nil - без оценки
+	EpisodesWatched int        `gorm:"not null;default:0" json:"episodes_watched"`
+	RewatchCount    int        `gorm:"not null;default:0" json:"rewatch_count"`
+	StartedAt       *time.Time `gorm:"type:date" json:"started_at"`
+	FinishedAt      *time.Time `gorm:"type:date" json:"finished_at"`
+	IsFavorite      bool       `gorm:"not null;default:false" json:"is_favorite"`
+	// позиция в избранном, начиная с 1; nil, если не в избранном
+	FavoritePosition *int      `json:"favorite_position,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+func (AnimeEntry) TableName() string {
+	return "user_anime_entries"
+}
+
+// AnimeEntryInput - изменения записи списка. nil-поля не меняются.
+// Score 0 и пустая дата сбрасывают значение.
+type AnimeEntryInput struct {
+	Status          *string `json:"status"`
+	Score           *int    `json:"score"`
+	EpisodesWatched *int    `json:"episodes_watched"`
+	RewatchCount    *int    `json:"rewatch_count"`
+	StartedAt       *string `json:"started_at"`  // YYYY-MM-DD
+	FinishedAt      *string `json:"finished_at"` // YYYY-MM-DD
+	IsFavorite      *bool   `json:"is_favorite"`
+}
+
+// EpisodeProgress - прогресс просмотра одной серии. Плеер периодически
+// присылает позицию, Completed после выставления уже не сбрасывается.
+type EpisodeProgress struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primaryKey" json:"-"`
+	UserID        uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_user_episode_progress" json:"-"`
+	AnimeID       string     `gorm:"size:32;not null;uniqueIndex:idx_user_episode_progress" json:"anime_id"`
+	Episode       int        `gorm:"not null;uniqueIndex:idx_user_episode_progress" json:"episode"`
+	TranslationID int        `gorm:"not null;default:0" json:"translation_id"` // ID озвучки Kodik
+	Position      int        `gorm:"not null;default:0" json:"position"`       // секунды
+	Duration      int        `gorm:"not null;default:0" json:"duration"`       // секунды, 0 - неизвестно
+	Completed     bool       `gorm:"not null;default:false" json:"completed"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	UpdatedAt     time.Time  `gorm:"index" json:"updated_at"`
+}
+
+func (EpisodeProgress) TableName() string {
+	return "user_episode_progress"
+}
+
+// ProgressInput - heartbeat плеера.
+type ProgressInput struct {
+	Episode       int  `json:"episode"`
+	TranslationID int  `json:"translation_id"`
+	Position      int  `json:"position"`
+	Duration      int  `json:"duration"`
+	Completed     bool `json:"completed"`
+}
+
+// ContinueWatchingItem - серия, с которой стоит продолжить просмотр.
+type ContinueWatchingItem struct {
+	AnimeID       string           `json:"anime_id"`
+	Episode       int              `json:"episode"`
+	TranslationID int              `json:"translation_id"`
+	Position      int              `json:"position"`
+	Duration      int              `json:"duration"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+	Anime         *shikimori.Anime `json:"anime,omitempty"`
+}
+
+// Сортировки страниц просмотренного и избранного
+const (
+	SortAdded    = "added"
+	SortTitle    = "title"
+	SortScore    = "score"    // личная оценка пользователя
+	SortPosition = "position" // только для избранного
+)
+
+// AnimeListQuery - параметры страницы списка. Пустые Sort и Order означают
+// сортировку по умолчанию: позиция для избранного, дата добавления для
+// просмотренного. Порядок по умолчанию: asc для title и position, desc для
+// added и score.
+type AnimeListQuery struct {
+	Page  int
+	Limit int
+	Sort  string
+	Order string
+}
+
+// AnimeListPage - страница списка с данными Shikimori. Аниме, которые не
+// удалось загрузить, перечислены в FailedIDs и в Anime не попадают.
+type AnimeListPage struct {
+	Anime     []shikimori.Anime `json:"anime"`
+	FailedIDs []string          `json:"failed_ids"`
+	Total     int               `json:"total"`
+	Page      int               `json:"page"`
+	Limit     int               `json:"limit"`
+}
+
+// Session - серверная сессия (семейство refresh-токенов), созданная при логине.
+// Отзыв сессии делает недействительными все выданные в ней токены.
+type Session struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	UserAgent string     `json:"user_agent"`
+	IP        string     `json:"ip"`
+	TwoFactor bool       `gorm:"not null;default:false" json:"two_factor"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// RefreshToken хранится только в виде sha256-хеша. Каждый токен одноразовый:
+// при обновлении он помечается использованным и заменяется новым в той же сессии.
+type RefreshToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	SessionID uuid.UUID `gorm:"type:uuid;index"`
+	TokenHash string    `gorm:"uniqueIndex"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	// подписанный токен между первым и вторым шагом логина, в БД не хранится
+	PurposeTwoFactorChallenge = "2fa_challenge"
+	// state для OAuth Shikimori, тоже только подписывается
+	PurposeShikimoriOAuth = "shikimori_oauth"
+)
+
+// ActionToken - одноразовый токен для действий по ссылке из письма.
+// В БД хранится только хеш, UsedAt выставляется при использовании.
+type ActionToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;index"`
+	Purpose   string    `gorm:"size:32;index"`
+	TokenHash string    `gorm:"uniqueIndex"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// ShikimoriAccount - привязанный аккаунт Shikimori и его OAuth-токены,
+// чтобы позже ходить в API от имени пользователя.
+type ShikimoriAccount struct {
+	UserID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	ShikimoriUserID int64     `gorm:"uniqueIndex" json:"shikimori_user_id"`
+	Nickname        string    `json:"nickname"`
+	AccessToken     string    `json:"-"`
+	RefreshToken    string    `json:"-"`
+	TokenExpiresAt  time.Time `json:"-"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// APIToken - персональный токен для ботов и скриптов. Сам токен показывается
+// один раз при создании, в БД лежит только его хеш.
+type APIToken struct {
+	ID         uuid.UUID      `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     uuid.UUID      `gorm:"type:uuid;index" json:"-"`
+	Name       string         `gorm:"size:64" json:"name"`
+	Prefix     string         `gorm:"size:16" json:"prefix"`
+	TokenHash  string         `gorm:"uniqueIndex" json:"-"`
+	Scopes     pq.StringArray `gorm:"type:text[]" json:"scopes"`
+	CreatedAt  time.Time      `json:"created_at"`
+	ExpiresAt  *time.Time     `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time     `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time     `json:"revoked_at,omitempty"`
+}
+
+// RecoveryCode - одноразовый резервный код на случай потери аутентификатора.
+type RecoveryCode struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;index"`
+	CodeHash  string    `gorm:"index"`
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// Setting - настройка сайта, которую меняют администраторы.
+type Setting struct {
+	Key       string `gorm:"primaryKey;size:64"`
+	Value     string `gorm:"type:text"`
+	UpdatedAt time.Time
+}
+
+const SettingTwoFactorRoles = "two_factor_required_roles"
+
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// TokenPair - ответ на успешный логин или обновление токенов.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// LoginResult - результат первого шага логина: либо токены,
+// либо challenge для ввода кода второго фактора.
+type LoginResult struct {
+	*TokenPair
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	Challenge         string `json:"challenge,omitempty"`
 }
@@ -1,8 +1,14 @@
 package user
 
 import (
+	"errors"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/pkg/secret"
+	"github.com/google/uuid"
 	"github.com/lib/pq"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Repository interface {
@@ -10,11 +16,62 @@ type Repository interface {
 	FindByEmail(email string) (*User, error)
 	FindByID(userID string) (*User, error)
 
-	UpdateWatched(userID string, animeID string) error
-	UpdateFavorites(userID string, animeID string) error
+	FindAnimeEntries(userID uuid.UUID, status string) ([]AnimeEntry, error)
+	FindFavoriteEntries(userID uuid.UUID) ([]AnimeEntry, error)
+	FindAnimeEntry(userID uuid.UUID, animeID string) (*AnimeEntry, error)
+	UpdateAnimeEntry(userID uuid.UUID, animeID string, fn func(current *AnimeEntry) (*AnimeEntry, error)) (before, after *AnimeEntry, err error)
+	RemoveWatched(userID uuid.UUID, animeIDs []string) ([]AnimeEntry, error)
+	RemoveFavorites(userID uuid.UUID, animeIDs []string) ([]AnimeEntry, error)
+	MoveFavorite(userID uuid.UUID, animeID string, position int) (before, after *AnimeEntry, err error)
+	SaveEpisodeProgress(progress *EpisodeProgress) error
+	FindEpisodeProgress(userID uuid.UUID, animeID string) ([]EpisodeProgress, error)
+	FindLatestProgress(userID uuid.UUID, limit int) ([]EpisodeProgress, error)
 
 	UpdateNickname(userID string, nickname string) error
 	UpdateAvatar(userID string, avatarPath string) error
+	UpdateRole(userID string, role string) error
+	List(limit, offset int) ([]User, int64, error)
+
+	MarkEmailVerified(userID uuid.UUID) error
+	UpdateVerificationSentAt(userID uuid.UUID, sentAt time.Time) error
+	UpdatePasswordResetSentAt(userID uuid.UUID, sentAt time.Time) error
+
+	CreateActionToken(token *ActionToken) error
+	FindActionToken(tokenID uuid.UUID) (*ActionToken, error)
+	FindActionTokenByHash(tokenHash string) (*ActionToken, error)
+	UseActionToken(tokenID uuid.UUID) (bool, error)
+	InvalidateActionTokens(userID uuid.UUID, purpose string) error
+	UpdatePassword(userID uuid.UUID, passwordHash string) error
+
+	SetTOTPSecret(userID uuid.UUID, secret string) error
+	EnableTOTP(userID uuid.UUID, counter int64) error
+	DisableTOTP(userID uuid.UUID) error
+	UseTOTPCounter(userID uuid.UUID, counter int64) (bool, error)
+	ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error
+	UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error)
+
+	CreateWithShikimoriAccount(user *User, account *ShikimoriAccount) error
+	FindShikimoriAccount(userID uuid.UUID) (*ShikimoriAccount, error)
+	FindShikimoriAccountByShikimoriID(shikimoriUserID int64) (*ShikimoriAccount, error)
+	SaveShikimoriAccount(account *ShikimoriAccount) error
+	DeleteShikimoriAccount(userID uuid.UUID) error
+
+	CreateAPIToken(token *APIToken) error
+	ListAPITokens(userID uuid.UUID) ([]APIToken, error)
+	CountActiveAPITokens(userID uuid.UUID) (int64, error)
+	FindAPITokenByHash(tokenHash string) (*APIToken, error)
+	RevokeAPIToken(userID, tokenID uuid.UUID) (bool, error)
+	TouchAPIToken(tokenID uuid.UUID, usedAt time.Time) error
+
+	GetSetting(key string) (string, error)
+	SetSetting(key, value string) error
+
+	CreateSession(session *Session, token *RefreshToken) error
+	FindSessionByID(sessionID uuid.UUID) (*Session, error)
+	FindRefreshToken(tokenHash string) (*RefreshToken, error)
+	RotateRefreshToken(oldTokenID uuid.UUID, token *RefreshToken) (bool, error)
+	RevokeSession(sessionID uuid.UUID) error
+	RevokeUserSessions(userID uuid.UUID) error
 }
 type repository struct {
 	db *gorm.DB
@@ -41,53 +98,339 @@ func (r *repository) FindByID(userID string) (*User, error) {
 		return nil, result.Error
 	}
 
-	if user.WatchedAnimeIDs == nil {
-		user.WatchedAnimeIDs = pq.StringArray{}
-	}
-	if user.FavoriteAnimeIDs == nil {
-		user.FavoriteAnimeIDs = pq.StringArray{}
+	return &user, nil
+}
+func (r *repository) FindAnimeEntries(userID uuid.UUID, status string) ([]AnimeEntry, error) {
+	query := r.db.Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
 	}
+	var entries []AnimeEntry
+	err := query.Order("created_at").Find(&entries).Error
+	return entries, err
+}
 
-	return &user, nil
+func (r *repository) FindFavoriteEntries(userID uuid.UUID) ([]AnimeEntry, error) {
+	var entries []AnimeEntry
+	err := r.db.Where("user_id = ? AND is_favorite", userID).Order("favorite_position, created_at").Find(&entries).Error
+	return entries, err
 }
-func (r *repository) UpdateWatched(userID string, animeID string) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
-		var user User
-		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+
+func (r *repository) FindAnimeEntry(userID uuid.UUID, animeID string) (*AnimeEntry, error) {
+	var entry AnimeEntry
+	if err := r.db.First(&entry, "user_id = ? AND anime_id = ?", userID, animeID).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// UpdateAnimeEntry - единая точка изменения записей списка. fn получает
+// текущую запись (nil, если ее нет) и возвращает новое состояние (nil - удалить).
+// Строка пользователя блокируется, так что параллельные изменения одного
+// списка выполняются по очереди.
+func (r *repository) UpdateAnimeEntry(userID uuid.UUID, animeID string, fn func(current *AnimeEntry) (*AnimeEntry, error)) (before, after *AnimeEntry, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, userID); err != nil {
 			return err
 		}
 
-		// Проверяем, есть ли уже этот animeID в массиве
-		for _, id := range user.WatchedAnimeIDs {
-			if id == animeID {
-				return nil
+		var current AnimeEntry
+		err := tx.First(&current, "user_id = ? AND anime_id = ?", userID, animeID).Error
+		switch {
+		case err == nil:
+			copied := current
+			before = &copied
+		case errors.Is(err, gorm.ErrRecordNotFound):
+		default:
+			return err
+		}
+
+		var input *AnimeEntry
+		if before != nil {
+			input = &current
+		}
+		after, err = fn(input)
+		if err != nil {
+			return err
+		}
+
+		wasFavorite := before != nil && before.IsFavorite
+		if after != nil {
+			switch {
+			case after.IsFavorite && !wasFavorite:
+				var last int
+				if err := tx.Model(&AnimeEntry{}).Where("user_id = ? AND is_favorite", userID).
+					Select("COALESCE(MAX(favorite_position), 0)").Scan(&last).Error; err != nil {
+					return err
+				}
+				last++
+				after.FavoritePosition = &last
+			case !after.IsFavorite:
+				after.FavoritePosition = nil
 			}
 		}
 
-		// Обновляем массив
-		user.WatchedAnimeIDs = append(user.WatchedAnimeIDs, animeID)
-		return tx.Save(&user).Error
+		switch {
+		case after == nil && before != nil:
+			err = tx.Delete(&AnimeEntry{}, "id = ?", before.ID).Error
+		case after == nil:
+			return nil
+		case before == nil:
+			after.ID = uuid.New()
+			after.UserID = userID
+			after.AnimeID = animeID
+			err = tx.Create(after).Error
+		default:
+			err = tx.Save(after).Error
+		}
+		if err != nil {
+			return err
+		}
+		if wasFavorite && (after == nil || !after.IsFavorite) {
+			return renumberFavorites(tx, userID)
+		}
+		return nil
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
 }
 
-func (r *repository) UpdateFavorites(userID string, animeID string) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
-		var user User
-		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+// RemoveWatched удаляет из списка просмотренные (completed) записи
+// вместе с отметкой избранного и возвращает удаленные записи.
+func (r *repository) RemoveWatched(userID uuid.UUID, animeIDs []string) ([]AnimeEntry, error) {
+	var removed []AnimeEntry
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, userID); err != nil {
+			return err
+		}
+		var favorites int64
+		if err := tx.Model(&AnimeEntry{}).
+			Where("user_id = ? AND anime_id IN ? AND status = ? AND is_favorite", userID, animeIDs, StatusCompleted).
+			Count(&favorites).Error; err != nil {
 			return err
 		}
+		err := tx.Clauses(clause.Returning{}).
+			Where("user_id = ? AND anime_id IN ? AND status = ?", userID, animeIDs, StatusCompleted).
+			Delete(&removed).Error
+		if err != nil {
+			return err
+		}
+		if favorites > 0 {
+			return renumberFavorites(tx, userID)
+		}
+		return nil
+	})
+	return removed, err
+}
 
-		// Проверяем на существование
-		for _, id := range user.FavoriteAnimeIDs {
-			if id == animeID {
-				return nil
+// RemoveFavorites снимает отметку избранного, записи в списке остаются.
+// Возвращает затронутые записи в состоянии до изменения.
+func (r *repository) RemoveFavorites(userID uuid.UUID, animeIDs []string) ([]AnimeEntry, error) {
+	var removed []AnimeEntry
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, userID); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND anime_id IN ? AND is_favorite", userID, animeIDs).
+			Find(&removed).Error; err != nil {
+			return err
+		}
+		if len(removed) == 0 {
+			return nil
+		}
+		ids := make([]uuid.UUID, len(removed))
+		for i, entry := range removed {
+			ids[i] = entry.ID
+		}
+		if err := tx.Model(&AnimeEntry{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"is_favorite": false, "favorite_position": nil, "updated_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		return renumberFavorites(tx, userID)
+	})
+	return removed, err
+}
+
+// MoveFavorite ставит аниме на позицию position (с 1) в избранном,
+// сдвигая остальные. Позиция за концом списка означает "в конец".
+// Возвращает перемещенную запись до и после изменения.
+func (r *repository) MoveFavorite(userID uuid.UUID, animeID string, position int) (before, after *AnimeEntry, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, userID); err != nil {
+			return err
+		}
+		var entries []AnimeEntry
+		if err := tx.Where("user_id = ? AND is_favorite", userID).
+			Order("favorite_position, created_at").Find(&entries).Error; err != nil {
+			return err
+		}
+
+		from := -1
+		for i, entry := range entries {
+			if entry.AnimeID == animeID {
+				from = i
+				break
 			}
 		}
+		if from < 0 {
+			return gorm.ErrRecordNotFound
+		}
+		to := position - 1
+		if to >= len(entries) {
+			to = len(entries) - 1
+		}
+		moved := entries[from]
+		entries = append(entries[:from], entries[from+1:]...)
+		entries = append(entries[:to], append([]AnimeEntry{moved}, entries[to:]...)...)
 
-		user.FavoriteAnimeIDs = append(user.FavoriteAnimeIDs, animeID)
-		return tx.Save(&user).Error
+		ids := make([]uuid.UUID, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.ID
+		}
+		if err := setFavoritePositions(tx, ids); err != nil {
+			return err
+		}
+		before = &moved
+		updated := moved
+		newPosition := to + 1
+		updated.FavoritePosition = &newPosition
+		after = &updated
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// SaveEpisodeProgress сохраняет heartbeat одним upsert и заполняет progress
+// итоговым состоянием строки.
+func (r *repository) SaveEpisodeProgress(progress *EpisodeProgress) error {
+	if progress.ID == uuid.Nil {
+		progress.ID = uuid.New()
+	}
+	return r.db.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "anime_id"}, {Name: "episode"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"translation_id": gorm.Expr("excluded.translation_id"),
+				"position":       gorm.Expr("excluded.position"),
+				"duration":       gorm.Expr("GREATEST(excluded.duration, user_episode_progress.duration)"),
+				"completed":      gorm.Expr("user_episode_progress.completed OR excluded.completed"),
+				"completed_at":   gorm.Expr("COALESCE(user_episode_progress.completed_at, excluded.completed_at)"),
+				"updated_at":     gorm.Expr("excluded.updated_at"),
+			}),
+		},
+		clause.Returning{},
+	).Create(progress).Error
+}
+
+func (r *repository) FindEpisodeProgress(userID uuid.UUID, animeID string) ([]EpisodeProgress, error) {
+	var progress []EpisodeProgress
+	err := r.db.Where("user_id = ? AND anime_id = ?", userID, animeID).Order("episode").Find(&progress).Error
+	return progress, err
+}
+
+// FindLatestProgress возвращает последнюю просмотренную серию каждого аниме,
+// кроме досмотренных и брошенных, начиная с самых свежих.
+func (r *repository) FindLatestProgress(userID uuid.UUID, limit int) ([]EpisodeProgress, error) {
+	var progress []EpisodeProgress
+	err := r.db.Raw(`
+		SELECT * FROM (
+			SELECT DISTINCT ON (p.anime_id) p.*
+			FROM user_episode_progress p
+			LEFT JOIN user_anime_entries e ON e.user_id = p.user_id AND e.anime_id = p.anime_id
+			WHERE p.user_id = ? AND (e.status IS NULL OR e.status NOT IN ?)
+			ORDER BY p.anime_id, p.updated_at DESC, p.episode DESC
+		) latest
+		ORDER BY updated_at DESC
+		LIMIT ?`, userID, []string{StatusCompleted, StatusDropped}, limit).Scan(&progress).Error
+	return progress, err
+}
+
+// lockUser блокирует строку пользователя до конца транзакции. Все изменения
+// списка аниме берут эту блокировку, поэтому не гоняются друг с другом.
+func lockUser(tx *gorm.DB, userID uuid.UUID) error {
+	var user User
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, "id = ?", userID).Error
+}
+
+// renumberFavorites убирает дырки в позициях избранного после удаления.
+func renumberFavorites(tx *gorm.DB, userID uuid.UUID) error {
+	var ids []uuid.UUID
+	if err := tx.Model(&AnimeEntry{}).Where("user_id = ? AND is_favorite", userID).
+		Order("favorite_position, created_at").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	return setFavoritePositions(tx, ids)
+}
+
+func setFavoritePositions(tx *gorm.DB, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return tx.Exec(`
+		UPDATE user_anime_entries e SET favorite_position = o.pos
+		FROM unnest(?::uuid[]) WITH ORDINALITY AS o(id, pos)
+		WHERE e.id = o.id AND e.favorite_position IS DISTINCT FROM o.pos`, pq.Array(ids)).Error
+}
+
+// BackfillFavoritePositions нумерует избранное, у которого еще нет позиции,
+// в порядке добавления.
+func BackfillFavoritePositions(db *gorm.DB) error {
+	return db.Exec(`
+		UPDATE user_anime_entries e SET favorite_position = n.pos
+		FROM (
+			SELECT id, row_number() OVER (PARTITION BY user_id ORDER BY favorite_position NULLS LAST, created_at) AS pos
+			FROM user_anime_entries WHERE is_favorite
+		) n
+		WHERE e.id = n.id AND e.user_id IN (
+			SELECT user_id FROM user_anime_entries WHERE is_favorite AND favorite_position IS NULL
+		)`).Error
+}
+
+// MigrateLegacyAnimeLists переносит старые массивы users.watched_anime_ids и
+// users.favorite_anime_ids в user_anime_entries и удаляет эти колонки.
+// Порядок добавления сохраняется через created_at.
+func MigrateLegacyAnimeLists(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&User{}, "watched_anime_ids") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`
+			INSERT INTO user_anime_entries (id, user_id, anime_id, status, episodes_watched, rewatch_count, is_favorite, created_at, updated_at)
+			SELECT DISTINCT ON (u.id, w.anime_id)
+				gen_random_uuid(), u.id, w.anime_id, ?, 0, 0, false,
+				u.created_at + w.pos * interval '1 millisecond', now()
+			FROM users u, unnest(u.watched_anime_ids) WITH ORDINALITY AS w(anime_id, pos)
+			WHERE w.anime_id <> ''
+			ORDER BY u.id, w.anime_id, w.pos
+			ON CONFLICT (user_id, anime_id) DO NOTHING`, StatusCompleted).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec(`
+			INSERT INTO user_anime_entries (id, user_id, anime_id, status, episodes_watched, rewatch_count, is_favorite, created_at, updated_at)
+			SELECT DISTINCT ON (u.id, f.anime_id)
+				gen_random_uuid(), u.id, f.anime_id, ?, 0, 0, true,
+				u.created_at + f.pos * interval '1 millisecond', now()
+			FROM users u, unnest(u.favorite_anime_ids) WITH ORDINALITY AS f(anime_id, pos)
+			WHERE f.anime_id <> ''
+			ORDER BY u.id, f.anime_id, f.pos
+			ON CONFLICT (user_id, anime_id) DO UPDATE SET is_favorite = true`, StatusCompleted).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Migrator().DropColumn(&User{}, "watched_anime_ids"); err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&User{}, "favorite_anime_ids")
 	})
 }
+
 func (r *repository) UpdateNickname(userID string, nickname string) error {
 	return r.db.Model(&User{}).Where("id = ?", userID).Update("nickname", nickname).Error
 }
@@ -95,3 +438,345 @@ func (r *repository) UpdateNickname(userID string, nickname string) error {
 func (r *repository) UpdateAvatar(userID string, avatarPath string) error {
 	return r.db.Model(&User{}).Where("id = ?", userID).Update("avatar", avatarPath).Error
 }
+
+func (r *repository) UpdateRole(userID string, role string) error {
+	result := r.db.Model(&User{}).Where("id = ?", userID).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *repository) List(limit, offset int) ([]User, int64, error) {
+	var total int64
+	if err := r.db.Model(&User{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []User
+	if err := r.db.Order("created_at").Limit(limit).Offset(offset).Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (r *repository) MarkEmailVerified(userID uuid.UUID) error {
+	return r.db.Model(&User{}).Where("id = ?", userID).Update("email_verified", true).Error
+}
+
+func (r *repository) UpdateVerificationSentAt(userID uuid.UUID, sentAt time.Time) error {
+	return r.db.Model(&User{}).Where("id = ?", userID).Update("email_verification_sent_at", sentAt).Error
+}
+
+func (r *repository) UpdatePasswordResetSentAt(userID uuid.UUID, sentAt time.Time) error {
+	return r.db.Model(&User{}).Where("id = ?", userID).Update("password_reset_sent_at", sentAt).Error
+}
+
+func (r *repository) CreateActionToken(token *ActionToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *repository) FindActionToken(tokenID uuid.UUID) (*ActionToken, error) {
+	var token ActionToken
+	if err := r.db.First(&token, "id = ?", tokenID).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *repository) FindActionTokenByHash(tokenHash string) (*ActionToken, error) {
+	var token ActionToken
+	if err := r.db.First(&token, "token_hash = ?", tokenHash).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *repository) InvalidateActionTokens(userID uuid.UUID, purpose string) error {
+	return r.db.Model(&ActionToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+func (r *repository) UpdatePassword(userID uuid.UUID, passwordHash string) error {
+	return r.db.Model(&User{}).Where("id = ?", userID).Update("password", passwordHash).Error
+}
+
+// UseActionToken атомарно помечает токен использованным.
+// Возвращает false, если токен уже был использован.
+func (r *repository) UseActionToken(tokenID uuid.UUID) (bool, error) {
+	result := r.db.Model(&ActionToken{}).
+		Where("id = ? AND used_at IS NULL", tokenID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *repository) SetTOTPSecret(userID uuid.UUID, secret string) error {
+	return r.db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":       secret,
+		"totp_enabled":      false,
+		"totp_last_counter": 0,
+	}).Error
+}
+
+func (r *repository) EnableTOTP(userID uuid.UUID, counter int64) error {
+	return r.db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_enabled":      true,
+		"totp_last_counter": counter,
+	}).Error
+}
+
+func (r *repository) DisableTOTP(userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":       "",
+			"totp_enabled":      false,
+			"totp_last_counter": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// UseTOTPCounter запоминает шаг последнего принятого кода.
+// Возвращает false, если код с этим или более поздним шагом уже использован.
+func (r *repository) UseTOTPCounter(userID uuid.UUID, counter int64) (bool, error) {
+	result := r.db.Model(&User{}).
+		Where("id = ? AND totp_last_counter < ?", userID, counter).
+		Update("totp_last_counter", counter)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *repository) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]RecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, RecoveryCode{ID: uuid.New(), UserID: userID, CodeHash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (r *repository) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	result := r.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *repository) CreateWithShikimoriAccount(user *User, account *ShikimoriAccount) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return tx.Create(account).Error
+	})
+}
+
+func (r *repository) FindShikimoriAccount(userID uuid.UUID) (*ShikimoriAccount, error) {
+	var account ShikimoriAccount
+	if err := r.db.First(&account, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (r *repository) FindShikimoriAccountByShikimoriID(shikimoriUserID int64) (*ShikimoriAccount, error) {
+	var account ShikimoriAccount
+	if err := r.db.First(&account, "shikimori_user_id = ?", shikimoriUserID).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (r *repository) SaveShikimoriAccount(account *ShikimoriAccount) error {
+	return r.db.Save(account).Error
+}
+
+func (r *repository) DeleteShikimoriAccount(userID uuid.UUID) error {
+	return r.db.Where("user_id = ?", userID).Delete(&ShikimoriAccount{}).Error
+}
+
+// EncryptShikimoriTokens шифрует токены Shikimori, сохраненные до появления
+// шифрования. Уже зашифрованные записи не трогает.
+func EncryptShikimoriTokens(db *gorm.DB, box *secret.Box) error {
+	var accounts []ShikimoriAccount
+	if err := db.Where("access_token NOT LIKE 'enc:%' OR refresh_token NOT LIKE 'enc:%'").
+		Find(&accounts).Error; err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		if secret.IsSealed(account.AccessToken) && secret.IsSealed(account.RefreshToken) {
+			continue
+		}
+		updates := map[string]interface{}{}
+		for column, value := range map[string]string{
+			"access_token":  account.AccessToken,
+			"refresh_token": account.RefreshToken,
+		} {
+			if secret.IsSealed(value) {
+				continue
+			}
+			sealed, err := box.Seal(value)
+			if err != nil {
+				return err
+			}
+			updates[column] = sealed
+		}
+		if err := db.Model(&ShikimoriAccount{}).Where("user_id = ?", account.UserID).
+			UpdateColumns(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncryptTOTPSecrets шифрует TOTP-секреты, сохраненные до появления
+// шифрования. Уже зашифрованные записи не трогает.
+func EncryptTOTPSecrets(db *gorm.DB, box *secret.Box) error {
+	var users []User
+	if err := db.Select("id", "totp_secret").
+		Where("totp_secret <> '' AND totp_secret NOT LIKE 'enc:%'").Find(&users).Error; err != nil {
+		return err
+	}
+	for _, user := range users {
+		sealed, err := box.Seal(user.TOTPSecret)
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&User{}).Where("id = ?", user.ID).
+			UpdateColumn("totp_secret", sealed).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *repository) CreateAPIToken(token *APIToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *repository) ListAPITokens(userID uuid.UUID) ([]APIToken, error) {
+	var tokens []APIToken
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at desc").Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *repository) CountActiveAPITokens(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&APIToken{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *repository) FindAPITokenByHash(tokenHash string) (*APIToken, error) {
+	var token APIToken
+	if err := r.db.First(&token, "token_hash = ?", tokenHash).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *repository) RevokeAPIToken(userID, tokenID uuid.UUID) (bool, error) {
+	result := r.db.Model(&APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *repository) TouchAPIToken(tokenID uuid.UUID, usedAt time.Time) error {
+	return r.db.Model(&APIToken{}).Where("id = ?", tokenID).Update("last_used_at", usedAt).Error
+}
+
+func (r *repository) GetSetting(key string) (string, error) {
+	var setting Setting
+	if err := r.db.First(&setting, "key = ?", key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return setting.Value, nil
+}
+
+func (r *repository) SetSetting(key, value string) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&Setting{Key: key, Value: value}).Error
+}
+
+func (r *repository) CreateSession(session *Session, token *RefreshToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *repository) FindSessionByID(sessionID uuid.UUID) (*Session, error) {
+	var session Session
+	if err := r.db.First(&session, "id = ?", sessionID).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *repository) FindRefreshToken(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	if err := r.db.First(&token, "token_hash = ?", tokenHash).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken помечает старый токен использованным и сохраняет новый.
+// Возвращает false, если старый токен уже был использован параллельным запросом.
+func (r *repository) RotateRefreshToken(oldTokenID uuid.UUID, token *RefreshToken) (bool, error) {
+	rotated := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", oldTokenID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		rotated = true
+		return tx.Create(token).Error
+	})
+	return rotated, err
+}
+
+func (r *repository) RevokeSession(sessionID uuid.UUID) error {
+	return r.db.Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *repository) RevokeUserSessions(userID uuid.UUID) error {
+	return r.db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
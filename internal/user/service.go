@@ -2,66 +2,228 @@ package user
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"net/mail"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/internal/lockout"
 	"github.com/Zipklas/anime-site-backend/internal/shikimori"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/Zipklas/anime-site-backend/pkg/mailer"
+	"github.com/Zipklas/anime-site-backend/pkg/secret"
+	"github.com/Zipklas/anime-site-backend/pkg/totp"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type Service interface {
 	Register(nickname, email, password string) error
-	Login(email, password string) (string, error)
+	Login(email, password string, client ClientInfo) (*LoginResult, error)
+	CompleteTwoFactorLogin(challenge, code string, client ClientInfo) (*TokenPair, error)
+	EnrollTwoFactor(userID string) (*TwoFactorEnrollment, error)
+	ConfirmTwoFactor(userID, code string) ([]string, error)
+	DisableTwoFactor(userID, code string) error
+	RegenerateRecoveryCodes(userID, code string) ([]string, error)
+	ShikimoriAuthURL(linkUserID string) (authURL, nonce string, err error)
+	ShikimoriCallback(ctx context.Context, code, state, nonce string, client ClientInfo) (*ShikimoriAuthResult, error)
+	GetShikimoriAccount(userID string) (*ShikimoriAccount, error)
+	UnlinkShikimori(userID string) error
+	ShikimoriAccessToken(ctx context.Context, userID uuid.UUID) (string, error)
+	RefreshShikimoriToken(ctx context.Context, userID uuid.UUID) (string, error)
+	CreateAPIToken(userID, name string, scopes []string, expiresInDays int) (*APIToken, string, error)
+	ListAPITokens(userID string) ([]APIToken, error)
+	RevokeAPIToken(userID, tokenID string) error
+	ResolveAPIToken(token string) (*auth.Principal, error)
+	GetTwoFactorPolicy() ([]string, error)
+	SetTwoFactorPolicy(roles []string) error
+	RefreshTokens(refreshToken string) (*TokenPair, error)
+	Logout(refreshToken string) error
+	IsSessionActive(sessionID uuid.UUID) (bool, error)
+	VerifyEmail(token string) error
+	ResendVerification(userID string) error
+	ForgotPassword(email string)
+	ResetPassword(token, newPassword string) error
+	UnlockAccount(userID string) error
+	EnsureAdmin(email string) error
+	UpdateRole(userID string, role string) error
+	ListUsers(limit, offset int) ([]User, int64, error)
 	UpdateNickname(userID string, nickname string) error
 	UpdateAvatar(userID string, avatarPath string) error
 	GetProfile(userID string) (*User, error)
 	AddWatched(userID, animeID string) error
 	AddFavorite(userID, animeID string) error
-	GetAnimeLists(userID string) (watched []shikimori.Anime, favorites []shikimori.Anime, err error)
-	GetWatchedAnimeDetails(userID string) ([]shikimori.Anime, error)
-	GetFavouriteAnimeDetails(userID string) ([]shikimori.Anime, error)
+	RemoveWatched(userID string, animeIDs []string) (int64, error)
+	RemoveFavorites(userID string, animeIDs []string) (int64, error)
+	MoveFavorite(userID, animeID string, position int) error
+	GetAnimeLists(ctx context.Context, userID string) (watched []shikimori.Anime, favorites []shikimori.Anime, err error)
+	GetListIDs(userID string) (watched []string, favorites []string, err error)
+	RecordProgress(ctx context.Context, userID, animeID string, input ProgressInput) (*EpisodeProgress, error)
+	GetEpisodeProgress(userID, animeID string) ([]EpisodeProgress, error)
+	ContinueWatching(ctx context.Context, userID string, limit int) ([]ContinueWatchingItem, error)
+	GetAnimeList(userID, status string) ([]AnimeEntry, error)
+	GetAnimeEntry(userID, animeID string) (*AnimeEntry, error)
+	CreateAnimeEntry(userID, animeID string, input AnimeEntryInput) (*AnimeEntry, error)
+	SaveAnimeEntry(userID, animeID string, input AnimeEntryInput) (*AnimeEntry, error)
+	DeleteAnimeEntry(userID, animeID string) error
+	ApplyAnimeEntry(userID uuid.UUID, animeID string, fn func(current *AnimeEntry) (*AnimeEntry, error)) (before, after *AnimeEntry, err error)
+	GetWatchedAnimeDetails(ctx context.Context, userID string, query AnimeListQuery) (*AnimeListPage, error)
+	GetFavouriteAnimeDetails(ctx context.Context, userID string, query AnimeListQuery) (*AnimeListPage, error)
+}
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	verificationTokenTTL  = 24 * time.Hour
+	twoFactorChallengeTTL = 5 * time.Minute
+	shikimoriStateTTL     = 10 * time.Minute
+	maxAPITokensPerUser   = 20
+	maxBulkAnimeIDs       = 500
+	// серия считается досмотренной после 90% длительности
+	episodeCompletedRatio      = 0.9
+	apiTokenTouchInterval      = time.Minute
+	recoveryCodesCount         = 10
+	verificationResendInterval = time.Minute
+
+	passwordResetTTL = time.Hour
+	// не чаще одного письма сброса на адрес, как и с подтверждением почты
+	passwordResetInterval = time.Minute
+	minPasswordLength     = 8  // синхронно с ErrPasswordTooShort
+	maxPasswordLength     = 72 // предел bcrypt в байтах, синхронно с ErrPasswordTooLong
+)
+
+var (
+	ErrInvalidCredentials   = errors.New("invalid credentials")
+	ErrInvalidRefreshToken  = errors.New("invalid refresh token")
+	ErrInvalidActionToken   = errors.New("invalid or expired token")
+	ErrEmailAlreadyVerified = errors.New("email is already verified")
+	ErrPasswordTooShort     = errors.New("password must be at least 8 characters")
+	ErrPasswordTooLong      = errors.New("password must be at most 72 bytes")
+
+	ErrTwoFactorEnabled         = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotEnrolled     = errors.New("two-factor enrollment has not been started")
+	ErrInvalidTwoFactorCode     = errors.New("invalid two-factor code")
+	ErrTwoFactorRequiredForRole = errors.New("two-factor authentication is required for your role")
+
+	ErrShikimoriAccountTaken = errors.New("this shikimori account is linked to another user")
+	ErrShikimoriNotLinked    = errors.New("shikimori account is not linked")
+
+	ErrAPITokenNotFound = errors.New("api token not found")
+	ErrTooManyAPITokens = errors.New("too many api tokens, revoke unused ones first")
+
+	ErrEntryNotFound    = errors.New("anime is not in your list")
+	ErrEntryExists      = errors.New("anime is already in your list")
+	ErrInvalidAnimeID   = errors.New("invalid anime id")
+	ErrInvalidStatus    = errors.New("invalid status, expected one of: planned, watching, completed, on_hold, dropped")
+	ErrInvalidListEntry = errors.New("invalid list entry")
+	ErrTooManyAnimeIDs  = errors.New("too many anime ids in one request")
+	ErrInvalidPosition  = errors.New("position must be a positive number")
+	ErrInvalidProgress  = errors.New("invalid progress")
+	ErrInvalidSort      = errors.New("invalid sort, expected sort=added|title|score|position and order=asc|desc")
+)
+
+// ThrottledError возвращается, когда действие повторяется слишком часто.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many requests, retry in %d seconds", int(e.RetryAfter.Seconds()))
+}
+
+// actionClaims - claims подписанных токенов для ссылок из писем.
+// ID (jti) совпадает с ActionToken.ID, что делает токен одноразовым.
+type actionClaims struct {
+	UserID  string `json:"user_id"`
+	Purpose string `json:"purpose"`
+	// NonceHash привязывает OAuth state к браузеру, начавшему вход
+	NonceHash string `json:"nonce_hash,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// ClientInfo - данные клиента, сохраняемые в сессии при логине.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// EntryObserver получает изменения записей списков после их сохранения:
+// before == nil - запись создана, after == nil - удалена.
+type EntryObserver interface {
+	AnimeEntryChanged(userID uuid.UUID, before, after *AnimeEntry)
 }
 
 type service struct {
 	repo             Repository
 	shikimoriService *shikimori.Service
+	tokens           *auth.TokenManager
+	mailer           mailer.Mailer
+	loginGuard       *lockout.Guard
+	shikimoriOAuth   *shikimori.OAuthClient
+	// шифрует токены Shikimori и TOTP-секреты перед записью в БД
+	tokenBox *secret.Box
+	baseURL  string
+	// страница сброса пароля, на которую ведет ссылка из письма
+	passwordResetURL string
+	observers        []EntryObserver
 }
 
-func NewService(repo Repository, shikimoriService *shikimori.Service) Service {
+func NewService(repo Repository, shikimoriService *shikimori.Service, tokens *auth.TokenManager, mailer mailer.Mailer, loginGuard *lockout.Guard, shikimoriOAuth *shikimori.OAuthClient, tokenBox *secret.Box, observers ...EntryObserver) Service {
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	// Без отдельной страницы фронтенда ссылка ведет на форму GET /password/reset
+	passwordResetURL := os.Getenv("PASSWORD_RESET_URL")
+	if passwordResetURL == "" {
+		passwordResetURL = baseURL + "/password/reset"
+	}
 	return &service{
 		repo:             repo,
 		shikimoriService: shikimoriService,
+		tokens:           tokens,
+		mailer:           mailer,
+		loginGuard:       loginGuard,
+		shikimoriOAuth:   shikimoriOAuth,
+		tokenBox:         tokenBox,
+		baseURL:          baseURL,
+		passwordResetURL: passwordResetURL,
+		observers:        observers,
 	}
 }
 
-func (s *service) GetAnimeLists(userID string) ([]shikimori.Anime, []shikimori.Anime, error) {
-	user, err := s.repo.FindByID(userID)
+func (s *service) GetAnimeLists(ctx context.Context, userID string) ([]shikimori.Anime, []shikimori.Anime, error) {
+	watchedIDs, favoriteIDs, err := s.GetListIDs(userID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	var watched []shikimori.Anime
-	for _, id := range user.WatchedAnimeIDs {
-		anime, err := s.shikimoriService.GetAnimeByID(context.Background(), id)
-		if err == nil {
-			watched = append(watched, *anime)
-		}
-	}
-
-	var favorites []shikimori.Anime
-	for _, id := range user.FavoriteAnimeIDs {
-		anime, err := s.shikimoriService.GetAnimeByID(context.Background(), id)
-		if err == nil {
-			favorites = append(favorites, *anime)
+	found, _ := s.shikimoriService.FetchAnimesByIDs(ctx, append(append([]string{}, watchedIDs...), favoriteIDs...))
+	collect := func(ids []string) []shikimori.Anime {
+		list := []shikimori.Anime{}
+		for _, id := range ids {
+			if anime, ok := found[id]; ok {
+				list = append(list, anime)
+			}
 		}
+		return list
 	}
-
-	return watched, favorites, nil
+	return collect(watchedIDs), collect(favoriteIDs), nil
 }
 
 func (s *service) Register(nickname, email, password string) error {
@@ -73,109 +235,1683 @@ func (s *service) Register(nickname, email, password string) error {
 	if nickname == "" {
 		return errors.New("nickname cannot be empty")
 	}
-	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return errors.New("invalid email")
+	}
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	// ADMIN_EMAIL получает роль только после подтверждения почты, см. promoteAdmin
 	user := &User{
 		ID:       id,
 		Email:    email,
 		Nickname: nickname,
 		Password: string(hash),
+		Role:     auth.RoleUser,
 	}
-	return s.repo.Create(user)
+	if err := s.repo.Create(user); err != nil {
+		return err
+	}
+
+	// Регистрация не должна падать из-за почты - письмо можно запросить повторно
+	if err := s.sendVerification(user); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+	}
+	return nil
+}
+
+// validatePassword проверяет длину пароля до bcrypt, который не принимает
+// пароли длиннее 72 байт.
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if len(password) > maxPasswordLength {
+		return ErrPasswordTooLong
+	}
+	return nil
 }
 
-func (s *service) Login(email, password string) (string, error) {
+func (s *service) Login(email, password string, client ClientInfo) (*LoginResult, error) {
+	ctx := context.Background()
+
+	// Проверяем блокировку до bcrypt, чтобы перебор не грузил сервер
+	wait, err := s.loginGuard.Check(ctx, email, client.IP)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return nil, &ThrottledError{RetryAfter: wait}
+	}
+
 	user, err := s.repo.FindByEmail(email)
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	}
+	if err != nil {
+		wait, guardErr := s.loginGuard.RegisterFailure(ctx, email, client.IP)
+		if guardErr != nil {
+			log.Printf("Failed to register login failure: %v", guardErr)
+		}
+		if wait > 0 {
+			return nil, &ThrottledError{RetryAfter: wait}
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	// С 2FA счетчик сбрасывается только после верного кода, иначе повторный
+	// вход с известным паролем обнулял бы его и позволял перебирать коды
+	if !user.TOTPEnabled {
+		if err := s.loginGuard.RegisterSuccess(ctx, email); err != nil {
+			log.Printf("Failed to reset login attempts: %v", err)
+		}
+	}
+
+	return s.startSession(user, client)
+}
+
+// startSession завершает первый шаг входа: выдает токены или, если у
+// пользователя включена 2FA, challenge для второго шага.
+func (s *service) startSession(user *User, client ClientInfo) (*LoginResult, error) {
+	if user.TOTPEnabled {
+		challenge, err := s.issueTwoFactorChallenge(user)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{TwoFactorRequired: true, Challenge: challenge}, nil
+	}
+
+	tokens, err := s.createSession(user, client, false)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{TokenPair: tokens}, nil
+}
+
+// createSession открывает новую сессию и выдает первую пару токенов.
+// twoFactor - прошел ли пользователь второй фактор при входе.
+func (s *service) createSession(user *User, client ClientInfo, twoFactor bool) (*TokenPair, error) {
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		TwoFactor: twoFactor,
+		ExpiresAt: now.Add(refreshTokenTTL),
+	}
+	token := &RefreshToken{
+		ID:        uuid.New(),
+		SessionID: session.ID,
+		TokenHash: refreshHash,
+		ExpiresAt: now.Add(refreshTokenTTL),
+	}
+	if err := s.repo.CreateSession(session, token); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.signAccessToken(user, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// RefreshTokens обменивает refresh-токен на новую пару токенов.
+// Повторное использование уже обменянного токена означает его утечку,
+// поэтому в этом случае отзывается вся сессия.
+func (s *service) RefreshTokens(refreshToken string) (*TokenPair, error) {
+	stored, err := s.repo.FindRefreshToken(hashToken(refreshToken))
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := s.repo.FindSessionByID(stored.SessionID)
 	if err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, ErrInvalidRefreshToken
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if stored.UsedAt != nil {
+		log.Printf("Refresh token reuse detected, revoking session %s", session.ID)
+		if err := s.repo.RevokeSession(session.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", errors.New("invalid credentials")
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := s.repo.FindByID(session.UserID.String())
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
 	}
 
-	// Генерация JWT токена
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"email":   user.Email,
-		"exp":     time.Now().Add(time.Hour * 72).Unix(), // токен действителен 72 часа
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := s.repo.RotateRefreshToken(stored.ID, &RefreshToken{
+		ID:        uuid.New(),
+		SessionID: session.ID,
+		TokenHash: newHash,
+		ExpiresAt: session.ExpiresAt,
 	})
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// Токен успели обменять параллельно - это такое же повторное использование
+		if err := s.repo.RevokeSession(session.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
 
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return "", errors.New("JWT secret is not set")
+	accessToken, err := s.signAccessToken(user, session)
+	if err != nil {
+		return nil, err
 	}
-	log.Println("JWT_SECRET:", os.Getenv("JWT_SECRET"))
-	tokenString, err := token.SignedString([]byte(secret))
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: newToken,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *service) Logout(refreshToken string) error {
+	stored, err := s.repo.FindRefreshToken(hashToken(refreshToken))
 	if err != nil {
-		return "", err
+		return ErrInvalidRefreshToken
 	}
-	log.Println("Generated Token:", tokenString)
+	return s.repo.RevokeSession(stored.SessionID)
+}
 
-	return tokenString, nil
+func (s *service) IsSessionActive(sessionID uuid.UUID) (bool, error) {
+	session, err := s.repo.FindSessionByID(sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return session.RevokedAt == nil && time.Now().Before(session.ExpiresAt), nil
 }
-func (s *service) GetProfile(userID string) (*User, error) {
+
+func (s *service) VerifyEmail(token string) error {
+	var claims actionClaims
+	if err := s.tokens.Parse(token, &claims); err != nil {
+		return ErrInvalidActionToken
+	}
+	if claims.Purpose != PurposeVerifyEmail {
+		return ErrInvalidActionToken
+	}
+
+	tokenID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return ErrInvalidActionToken
+	}
+	stored, err := s.repo.FindActionToken(tokenID)
+	if err != nil {
+		return ErrInvalidActionToken
+	}
+	if stored.Purpose != PurposeVerifyEmail || stored.TokenHash != hashToken(token) {
+		return ErrInvalidActionToken
+	}
+
+	used, err := s.repo.UseActionToken(stored.ID)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidActionToken
+	}
+
+	if err := s.repo.MarkEmailVerified(stored.UserID); err != nil {
+		return err
+	}
+
+	// Почта уже подтверждена, ошибка выдачи роли на ответ не влияет
+	user, err := s.repo.FindByID(stored.UserID.String())
+	if err == nil {
+		err = s.promoteAdmin(user)
+	}
+	if err != nil {
+		log.Printf("Failed to grant admin role after email verification: %v", err)
+	}
+	return nil
+}
+
+func (s *service) ResendVerification(userID string) error {
 	user, err := s.repo.FindByID(userID)
 	if err != nil {
-		return nil, errors.New("user not found")
+		return errors.New("user not found")
 	}
-	return user, nil
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+	if user.EmailVerificationSentAt != nil {
+		if wait := verificationResendInterval - time.Since(*user.EmailVerificationSentAt); wait > 0 {
+			return &ThrottledError{RetryAfter: wait}
+		}
+	}
+	return s.sendVerification(user)
 }
-func (s *service) AddWatched(userID, animeID string) error {
-	return s.repo.UpdateWatched(userID, animeID)
+
+func (s *service) sendVerification(user *User) error {
+	now := time.Now()
+	tokenID := uuid.New()
+	token, err := s.tokens.Sign(&actionClaims{
+		UserID:  user.ID.String(),
+		Purpose: PurposeVerifyEmail,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(verificationTokenTTL)),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.CreateActionToken(&ActionToken{
+		ID:        tokenID,
+		UserID:    user.ID,
+		Purpose:   PurposeVerifyEmail,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(verificationTokenTTL),
+	}); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateVerificationSentAt(user.ID, now); err != nil {
+		return err
+	}
+
+	link := s.baseURL + "/verify-email?token=" + url.QueryEscape(token)
+	return s.mailer.Send(context.Background(), mailer.Message{
+		To:      user.Email,
+		Subject: "Подтверждение email",
+		Body: fmt.Sprintf("Здравствуйте, %s!\n\nЧтобы подтвердить адрес почты, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка действует 24 часа.\n", user.Nickname, link),
+	})
 }
 
-func (s *service) AddFavorite(userID, animeID string) error {
-	return s.repo.UpdateFavorites(userID, animeID)
+// ForgotPassword отправляет ссылку для сброса пароля. Ничего не возвращает,
+// чтобы по ответу нельзя было понять, зарегистрирован ли email.
+func (s *service) ForgotPassword(email string) {
+	go func() {
+		if err := s.sendPasswordReset(email); err != nil {
+			log.Printf("Failed to send password reset email: %v", err)
+		}
+	}()
+}
+
+func (s *service) sendPasswordReset(email string) error {
+	user, err := s.repo.FindByEmail(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.PasswordResetSentAt != nil && time.Since(*user.PasswordResetSentAt) < passwordResetInterval {
+		return nil
+	}
+	now := time.Now()
+	if err := s.repo.UpdatePasswordResetSentAt(user.ID, now); err != nil {
+		return err
+	}
+
+	// Случайный токен, а не JWT: в письме он нужен только для поиска по хешу
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return err
+	}
+	if err := s.repo.CreateActionToken(&ActionToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Purpose:   PurposeResetPassword,
+		TokenHash: hash,
+		ExpiresAt: now.Add(passwordResetTTL),
+	}); err != nil {
+		return err
+	}
+
+	link := withQuery(s.passwordResetURL, "token", token)
+	return s.mailer.Send(context.Background(), mailer.Message{
+		To:      user.Email,
+		Subject: "Сброс пароля",
+		Body: fmt.Sprintf("Здравствуйте, %s!\n\nДля сброса пароля перейдите по ссылке:\n%s\n\n"+
+			"Ссылка действует 1 час. Если вы не запрашивали сброс, просто проигнорируйте это письмо.\n",
+			user.Nickname, link),
+	})
+}
+
+// ResetPassword меняет пароль по токену из письма и отзывает все сессии пользователя.
+func (s *service) ResetPassword(token, newPassword string) error {
+	// Длина проверяется до того, как токен будет израсходован
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	stored, err := s.repo.FindActionTokenByHash(hashToken(token))
+	if err != nil {
+		return ErrInvalidActionToken
+	}
+	if stored.Purpose != PurposeResetPassword || stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return ErrInvalidActionToken
+	}
+
+	used, err := s.repo.UseActionToken(stored.ID)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidActionToken
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdatePassword(stored.UserID, string(hash)); err != nil {
+		return err
+	}
+	if err := s.repo.InvalidateActionTokens(stored.UserID, PurposeResetPassword); err != nil {
+		return err
+	}
+	return s.repo.RevokeUserSessions(stored.UserID)
 }
-func (s *service) GetWatchedAnimeDetails(userID string) ([]shikimori.Anime, error) {
-	// Получаем пользователя с его списком просмотренных аниме
+
+func (s *service) UnlockAccount(userID string) error {
 	user, err := s.repo.FindByID(userID)
 	if err != nil {
-		return nil, fmt.Errorf("user not found: %w", err)
+		return errors.New("user not found")
+	}
+	return s.loginGuard.UnlockAccount(context.Background(), user.Email)
+}
+
+func (s *service) issueTwoFactorChallenge(user *User) (string, error) {
+	now := time.Now()
+	return s.tokens.Sign(&actionClaims{
+		UserID:  user.ID.String(),
+		Purpose: PurposeTwoFactorChallenge,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(twoFactorChallengeTTL)),
+		},
+	})
+}
+
+// CompleteTwoFactorLogin - второй шаг логина: challenge из Login плюс код
+// из аутентификатора или резервный код.
+func (s *service) CompleteTwoFactorLogin(challenge, code string, client ClientInfo) (*TokenPair, error) {
+	var claims actionClaims
+	if err := s.tokens.Parse(challenge, &claims); err != nil || claims.Purpose != PurposeTwoFactorChallenge {
+		return nil, ErrInvalidActionToken
+	}
+	user, err := s.repo.FindByID(claims.UserID)
+	if err != nil || !user.TOTPEnabled {
+		return nil, ErrInvalidActionToken
 	}
 
-	// Если список пуст, возвращаем пустой массив
-	if len(user.WatchedAnimeIDs) == 0 {
-		return []shikimori.Anime{}, nil
+	// Перебор кодов ограничиваем тем же механизмом, что и перебор паролей
+	ctx := context.Background()
+	wait, err := s.loginGuard.Check(ctx, user.Email, client.IP)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return nil, &ThrottledError{RetryAfter: wait}
 	}
 
-	// Получаем информацию об аниме из Shikimori API
-	var animeList []shikimori.Anime
-	for _, animeID := range user.WatchedAnimeIDs {
-		anime, err := s.shikimoriService.GetAnimeByID(context.Background(), animeID)
-		if err != nil {
-			log.Printf("Failed to get anime %s: %v", animeID, err)
-			continue // Пропускаем если не удалось получить
+	ok, err := s.verifySecondFactor(user, code, true)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		wait, guardErr := s.loginGuard.RegisterFailure(ctx, user.Email, client.IP)
+		if guardErr != nil {
+			log.Printf("Failed to register login failure: %v", guardErr)
+		}
+		if wait > 0 {
+			return nil, &ThrottledError{RetryAfter: wait}
 		}
-		animeList = append(animeList, *anime)
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if err := s.loginGuard.RegisterSuccess(ctx, user.Email); err != nil {
+		log.Printf("Failed to reset login attempts: %v", err)
+	}
+	return s.createSession(user, client, true)
+}
+
+// verifySecondFactor проверяет TOTP-код, а если allowRecovery - и резервный код.
+// Любой код принимается только один раз.
+func (s *service) verifySecondFactor(user *User, code string, allowRecovery bool) (bool, error) {
+	totpSecret, err := s.tokenBox.Open(user.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+	if counter, ok := totp.Validate(totpSecret, code, time.Now()); ok {
+		return s.repo.UseTOTPCounter(user.ID, counter)
+	}
+	if !allowRecovery {
+		return false, nil
+	}
+	return s.repo.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code)))
+}
+
+func (s *service) EnrollTwoFactor(userID string) (*TwoFactorEnrollment, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	// Секрет хранится зашифрованным, как и токены Shikimori
+	sealed, err := s.tokenBox.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetTOTPSecret(user.ID, sealed); err != nil {
+		return nil, err
 	}
 
-	return animeList, nil
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "AnimeSite"
+	}
+	return &TwoFactorEnrollment{
+		Secret:     secret,
+		OtpauthURI: totp.URI(issuer, user.Email, secret),
+	}, nil
 }
-func (s *service) GetFavouriteAnimeDetails(userID string) ([]shikimori.Anime, error) {
-	// Получаем пользователя с его списком просмотренных аниме
+
+// ConfirmTwoFactor включает 2FA после проверки первого кода и возвращает
+// резервные коды. Они показываются только один раз.
+func (s *service) ConfirmTwoFactor(userID, code string) ([]string, error) {
 	user, err := s.repo.FindByID(userID)
 	if err != nil {
-		return nil, fmt.Errorf("user not found: %w", err)
+		return nil, errors.New("user not found")
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
 	}
 
-	// Если список пуст, возвращаем пустой массив
-	if len(user.FavoriteAnimeIDs) == 0 {
-		return []shikimori.Anime{}, nil
+	totpSecret, err := s.tokenBox.Open(user.TOTPSecret)
+	if err != nil {
+		return nil, err
 	}
+	counter, ok := totp.Validate(totpSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	if err := s.repo.EnableTOTP(user.ID, counter); err != nil {
+		return nil, err
+	}
+	return s.replaceRecoveryCodes(user.ID)
+}
 
-	// Получаем информацию об аниме из Shikimori API
-	var animeList []shikimori.Anime
-	for _, animeID := range user.FavoriteAnimeIDs {
-		anime, err := s.shikimoriService.GetAnimeByID(context.Background(), animeID)
-		if err != nil {
-			log.Printf("Failed to get anime %s: %v", animeID, err)
-			continue // Пропускаем если не удалось получить
+func (s *service) DisableTwoFactor(userID, code string) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	required, err := s.twoFactorRequiredFor(user.Role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrTwoFactorRequiredForRole
+	}
+
+	ok, err := s.verifySecondFactor(user, code, true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	return s.repo.DisableTOTP(user.ID)
+}
+
+func (s *service) RegenerateRecoveryCodes(userID, code string) ([]string, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	ok, err := s.verifySecondFactor(user, code, false)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	return s.replaceRecoveryCodes(user.ID)
+}
+
+func (s *service) replaceRecoveryCodes(userID uuid.UUID) ([]string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
 		}
-		animeList = append(animeList, *anime)
+		raw := hex.EncodeToString(buf)
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashToken(raw))
+	}
+	if err := s.repo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// ShikimoriAuthResult - итог OAuth-колбэка: вход (Login) или привязка (Linked).
+type ShikimoriAuthResult struct {
+	Login  *LoginResult
+	Linked bool
+}
+
+// ShikimoriAuthURL возвращает ссылку на авторизацию в Shikimori и nonce,
+// который handler кладет в cookie браузера: колбэк примет state только вместе
+// с ним. Если задан linkUserID, после колбэка аккаунт будет привязан к этому
+// пользователю.
+func (s *service) ShikimoriAuthURL(linkUserID string) (string, string, error) {
+	if !s.shikimoriOAuth.Configured() {
+		return "", "", shikimori.ErrOAuthNotConfigured
 	}
 
-	return animeList, nil
+	nonce, nonceHash, err := newRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	state, err := s.tokens.Sign(&actionClaims{
+		UserID:    linkUserID,
+		Purpose:   PurposeShikimoriOAuth,
+		NonceHash: nonceHash,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(shikimoriStateTTL)),
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return s.shikimoriOAuth.AuthorizeURL(state), nonce, nil
 }
+
+// ShikimoriCallback завершает OAuth. nonce берется из cookie браузера: без
+// него чужая ссылка с подписанным state не привяжет аккаунт жертвы.
+func (s *service) ShikimoriCallback(ctx context.Context, code, state, nonce string, client ClientInfo) (*ShikimoriAuthResult, error) {
+	var claims actionClaims
+	if err := s.tokens.Parse(state, &claims); err != nil || claims.Purpose != PurposeShikimoriOAuth {
+		return nil, ErrInvalidActionToken
+	}
+	if nonce == "" || claims.NonceHash == "" ||
+		subtle.ConstantTimeCompare([]byte(hashToken(nonce)), []byte(claims.NonceHash)) != 1 {
+		return nil, ErrInvalidActionToken
+	}
+
+	token, err := s.shikimoriOAuth.Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.shikimoriOAuth.WhoAmI(ctx, token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.repo.FindShikimoriAccountByShikimoriID(profile.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Привязка к уже вошедшему пользователю
+	if claims.UserID != "" {
+		user, err := s.repo.FindByID(claims.UserID)
+		if err != nil {
+			return nil, errors.New("user not found")
+		}
+		if existing != nil && existing.UserID != user.ID {
+			return nil, ErrShikimoriAccountTaken
+		}
+		account := &ShikimoriAccount{UserID: user.ID}
+		if existing != nil {
+			account = existing
+		} else if current, err := s.repo.FindShikimoriAccount(user.ID); err == nil {
+			// Пользователь перепривязывает другой аккаунт Shikimori
+			account = current
+		}
+		if err := s.applyShikimoriToken(account, profile, token); err != nil {
+			return nil, err
+		}
+		if err := s.repo.SaveShikimoriAccount(account); err != nil {
+			return nil, err
+		}
+		return &ShikimoriAuthResult{Linked: true}, nil
+	}
+
+	// Вход через уже привязанный аккаунт
+	if existing != nil {
+		user, err := s.repo.FindByID(existing.UserID.String())
+		if err != nil {
+			return nil, errors.New("user not found")
+		}
+		if err := s.applyShikimoriToken(existing, profile, token); err != nil {
+			return nil, err
+		}
+		if err := s.repo.SaveShikimoriAccount(existing); err != nil {
+			return nil, err
+		}
+		login, err := s.startSession(user, client)
+		if err != nil {
+			return nil, err
+		}
+		return &ShikimoriAuthResult{Login: login}, nil
+	}
+
+	// Новый пользователь. Shikimori не отдает email, поэтому адрес служебный;
+	// личность подтверждена Shikimori, так что аккаунт считается подтвержденным.
+	nickname := profile.Nickname
+	if runes := []rune(nickname); len(runes) > 32 {
+		nickname = string(runes[:32])
+	}
+	user := &User{
+		ID:            uuid.New(),
+		Email:         fmt.Sprintf("shikimori-%d@users.noreply.local", profile.ID),
+		Nickname:      nickname,
+		Role:          auth.RoleUser,
+		EmailVerified: true,
+	}
+	account := &ShikimoriAccount{UserID: user.ID}
+	if err := s.applyShikimoriToken(account, profile, token); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateWithShikimoriAccount(user, account); err != nil {
+		return nil, err
+	}
+
+	login, err := s.startSession(user, client)
+	if err != nil {
+		return nil, err
+	}
+	return &ShikimoriAuthResult{Login: login}, nil
+}
+
+func (s *service) applyShikimoriToken(account *ShikimoriAccount, profile *shikimori.Profile, token *shikimori.OAuthToken) error {
+	account.ShikimoriUserID = profile.ID
+	account.Nickname = profile.Nickname
+	return s.storeShikimoriToken(account, token)
+}
+
+// storeShikimoriToken шифрует токены перед записью в account. Пустой
+// refresh_token в ответе на обновление оставляет прежний.
+func (s *service) storeShikimoriToken(account *ShikimoriAccount, token *shikimori.OAuthToken) error {
+	accessToken, err := s.tokenBox.Seal(token.AccessToken)
+	if err != nil {
+		return err
+	}
+	account.AccessToken = accessToken
+	if token.RefreshToken != "" {
+		refreshToken, err := s.tokenBox.Seal(token.RefreshToken)
+		if err != nil {
+			return err
+		}
+		account.RefreshToken = refreshToken
+	}
+	account.TokenExpiresAt = token.Expiry()
+	return nil
+}
+
+func (s *service) GetShikimoriAccount(userID string) (*ShikimoriAccount, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user id")
+	}
+	account, err := s.repo.FindShikimoriAccount(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShikimoriNotLinked
+		}
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *service) UnlinkShikimori(userID string) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user id")
+	}
+	return s.repo.DeleteShikimoriAccount(id)
+}
+
+// ShikimoriAccessToken возвращает действующий токен пользователя для API
+// Shikimori, при необходимости обновляя его.
+func (s *service) ShikimoriAccessToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	return s.shikimoriAccessToken(ctx, userID, false)
+}
+
+// RefreshShikimoriToken обновляет токен, даже если срок еще не вышел: Shikimori
+// мог отозвать его раньше и ответить 401.
+func (s *service) RefreshShikimoriToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	return s.shikimoriAccessToken(ctx, userID, true)
+}
+
+func (s *service) shikimoriAccessToken(ctx context.Context, userID uuid.UUID, forceRefresh bool) (string, error) {
+	account, err := s.repo.FindShikimoriAccount(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrShikimoriNotLinked
+		}
+		return "", err
+	}
+	if !forceRefresh && time.Until(account.TokenExpiresAt) > time.Minute {
+		return s.tokenBox.Open(account.AccessToken)
+	}
+
+	refreshToken, err := s.tokenBox.Open(account.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+	token, err := s.shikimoriOAuth.Refresh(ctx, refreshToken)
+	if err != nil {
+		return "", err
+	}
+	if err := s.storeShikimoriToken(account, token); err != nil {
+		return "", err
+	}
+	if err := s.repo.SaveShikimoriAccount(account); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// CreateAPIToken создает персональный токен. Открытое значение возвращается
+// только здесь - потом его уже нельзя получить.
+func (s *service) CreateAPIToken(userID, name string, scopes []string, expiresInDays int) (*APIToken, string, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, "", errors.New("invalid user id")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", errors.New("token name cannot be empty")
+	}
+	if len([]rune(name)) > 64 {
+		return nil, "", errors.New("token name too long, max 64 characters")
+	}
+	if len(scopes) == 0 {
+		return nil, "", errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !auth.IsValidScope(scope) {
+			return nil, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if expiresInDays < 0 {
+		return nil, "", errors.New("expires_in_days cannot be negative")
+	}
+
+	count, err := s.repo.CountActiveAPITokens(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if count >= maxAPITokensPerUser {
+		return nil, "", ErrTooManyAPITokens
+	}
+
+	secret, _, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	plain := auth.APITokenPrefix + secret
+
+	token := &APIToken{
+		ID:        uuid.New(),
+		UserID:    id,
+		Name:      name,
+		Prefix:    plain[:len(auth.APITokenPrefix)+6],
+		TokenHash: hashToken(plain),
+		Scopes:    scopes,
+	}
+	if expiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, expiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := s.repo.CreateAPIToken(token); err != nil {
+		return nil, "", err
+	}
+	return token, plain, nil
+}
+
+func (s *service) ListAPITokens(userID string) ([]APIToken, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user id")
+	}
+	return s.repo.ListAPITokens(id)
+}
+
+func (s *service) RevokeAPIToken(userID, tokenID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user id")
+	}
+	tid, err := uuid.Parse(tokenID)
+	if err != nil {
+		return ErrAPITokenNotFound
+	}
+	revoked, err := s.repo.RevokeAPIToken(uid, tid)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// ResolveAPIToken используется auth.Authenticator для запросов с API-токеном.
+func (s *service) ResolveAPIToken(token string) (*auth.Principal, error) {
+	stored, err := s.repo.FindAPITokenByHash(hashToken(token))
+	if err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+	now := time.Now()
+	if stored.RevokedAt != nil || (stored.ExpiresAt != nil && now.After(*stored.ExpiresAt)) {
+		return nil, auth.ErrInvalidToken
+	}
+
+	user, err := s.repo.FindByID(stored.UserID.String())
+	if err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+
+	// Не пишем в БД на каждый запрос бота
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) > apiTokenTouchInterval {
+		if err := s.repo.TouchAPIToken(stored.ID, now); err != nil {
+			log.Printf("Failed to update api token usage: %v", err)
+		}
+	}
+
+	return &auth.Principal{
+		UserID:        user.ID,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		APITokenID:    &stored.ID,
+		Scopes:        stored.Scopes,
+	}, nil
+}
+
+func (s *service) GetTwoFactorPolicy() ([]string, error) {
+	value, err := s.repo.GetSetting(SettingTwoFactorRoles)
+	if err != nil {
+		return nil, err
+	}
+	roles := []string{}
+	for _, role := range strings.Split(value, ",") {
+		if role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+func (s *service) SetTwoFactorPolicy(roles []string) error {
+	for _, role := range roles {
+		if !auth.IsValidRole(role) {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return s.repo.SetSetting(SettingTwoFactorRoles, strings.Join(roles, ","))
+}
+
+func (s *service) twoFactorRequiredFor(role string) (bool, error) {
+	roles, err := s.GetTwoFactorPolicy()
+	if err != nil {
+		return false, err
+	}
+	for _, r := range roles {
+		if r == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// EnsureAdmin выдает роль администратора уже зарегистрированному пользователю.
+// Вызывается при старте сервера для ADMIN_EMAIL.
+func (s *service) EnsureAdmin(email string) error {
+	user, err := s.repo.FindByEmail(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Пользователь получит роль, когда подтвердит почту
+			return nil
+		}
+		return err
+	}
+	if !user.EmailVerified {
+		log.Printf("Admin %s has not verified email yet, role will be granted after verification", email)
+		return nil
+	}
+	return s.promoteAdmin(user)
+}
+
+// promoteAdmin выдает роль администратора владельцу ADMIN_EMAIL. Почта
+// должна быть подтверждена: иначе роль получил бы любой, кто первым
+// зарегистрируется с этим адресом.
+func (s *service) promoteAdmin(user *User) error {
+	adminEmail := os.Getenv("ADMIN_EMAIL")
+	if adminEmail == "" || !strings.EqualFold(adminEmail, user.Email) || !user.EmailVerified {
+		return nil
+	}
+	if user.Role == auth.RoleAdmin {
+		return nil
+	}
+	return s.repo.UpdateRole(user.ID.String(), auth.RoleAdmin)
+}
+
+func (s *service) UpdateRole(userID string, role string) error {
+	if !auth.IsValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	return s.repo.UpdateRole(userID, role)
+}
+
+func (s *service) ListUsers(limit, offset int) ([]User, int64, error) {
+	return s.repo.List(limit, offset)
+}
+
+func (s *service) signAccessToken(user *User, session *Session) (string, error) {
+	required, err := s.twoFactorRequiredFor(user.Role)
+	if err != nil {
+		return "", err
+	}
+
+	return s.tokens.IssueAccessToken(auth.Principal{
+		UserID:    user.ID,
+		Role:      user.Role,
+		SessionID: session.ID,
+
+		EmailVerified:    user.EmailVerified,
+		TwoFactorPending: required && !session.TwoFactor,
+	}, user.Email, accessTokenTTL)
+}
+
+// newRefreshToken возвращает случайный токен для клиента и его хеш для БД
+func newRefreshToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// withQuery добавляет к ссылке параметр, сохраняя уже имеющиеся.
+func withQuery(link, key, value string) string {
+	sep := "?"
+	if strings.Contains(link, "?") {
+		sep = "&"
+	}
+	return link + sep + key + "=" + url.QueryEscape(value)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *service) GetProfile(userID string) (*User, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+func (s *service) AddWatched(userID, animeID string) error {
+	status := StatusCompleted
+	_, err := s.SaveAnimeEntry(userID, animeID, AnimeEntryInput{Status: &status})
+	return err
+}
+
+func (s *service) AddFavorite(userID, animeID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+	if !shikimori.IsValidAnimeID(animeID) {
+		return ErrInvalidAnimeID
+	}
+	// Избранное без записи в списке добавляется как просмотренное
+	_, _, err = s.updateEntry(uid, animeID, func(current *AnimeEntry) (*AnimeEntry, error) {
+		if current == nil {
+			current = &AnimeEntry{Status: StatusCompleted}
+		}
+		current.IsFavorite = true
+		return current, nil
+	})
+	return err
+}
+
+func (s *service) RemoveWatched(userID string, animeIDs []string) (int64, error) {
+	uid, ids, err := bulkAnimeIDs(userID, animeIDs)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := s.repo.RemoveWatched(uid, ids)
+	if err != nil {
+		return 0, err
+	}
+	for i := range removed {
+		s.notifyEntryChanged(uid, &removed[i], nil)
+	}
+	return int64(len(removed)), nil
+}
+
+func (s *service) RemoveFavorites(userID string, animeIDs []string) (int64, error) {
+	uid, ids, err := bulkAnimeIDs(userID, animeIDs)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := s.repo.RemoveFavorites(uid, ids)
+	if err != nil {
+		return 0, err
+	}
+	for i := range removed {
+		after := removed[i]
+		after.IsFavorite = false
+		after.FavoritePosition = nil
+		s.notifyEntryChanged(uid, &removed[i], &after)
+	}
+	return int64(len(removed)), nil
+}
+
+func (s *service) MoveFavorite(userID, animeID string, position int) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+	if position < 1 {
+		return ErrInvalidPosition
+	}
+	before, after, err := s.repo.MoveFavorite(uid, animeID, position)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrEntryNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.notifyEntryChanged(uid, before, after)
+	return nil
+}
+
+// bulkAnimeIDs проверяет ID для массовых операций и убирает повторы.
+func bulkAnimeIDs(userID string, animeIDs []string) (uuid.UUID, []string, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if len(animeIDs) == 0 {
+		return uuid.Nil, nil, fmt.Errorf("%w: anime_ids is required", ErrInvalidAnimeID)
+	}
+	if len(animeIDs) > maxBulkAnimeIDs {
+		return uuid.Nil, nil, ErrTooManyAnimeIDs
+	}
+	seen := make(map[string]bool, len(animeIDs))
+	ids := make([]string, 0, len(animeIDs))
+	for _, id := range animeIDs {
+		if !shikimori.IsValidAnimeID(id) {
+			return uuid.Nil, nil, fmt.Errorf("%w: %q", ErrInvalidAnimeID, id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return uid, ids, nil
+}
+
+// GetListIDs возвращает ID просмотренных аниме в порядке добавления
+// и избранных в порядке, заданном пользователем.
+func (s *service) GetListIDs(userID string) (watched []string, favorites []string, err error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	completed, err := s.repo.FindAnimeEntries(uid, StatusCompleted)
+	if err != nil {
+		return nil, nil, err
+	}
+	favorite, err := s.repo.FindFavoriteEntries(uid)
+	if err != nil {
+		return nil, nil, err
+	}
+	watched, favorites = make([]string, len(completed)), make([]string, len(favorite))
+	for i, entry := range completed {
+		watched[i] = entry.AnimeID
+	}
+	for i, entry := range favorite {
+		favorites[i] = entry.AnimeID
+	}
+	return watched, favorites, nil
+}
+
+func (s *service) GetAnimeList(userID, status string) ([]AnimeEntry, error) {
+	if status != "" && !IsValidStatus(status) {
+		return nil, ErrInvalidStatus
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.repo.FindAnimeEntries(uid, status)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []AnimeEntry{}
+	}
+	return entries, nil
+}
+
+func (s *service) GetAnimeEntry(userID, animeID string) (*AnimeEntry, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := s.repo.FindAnimeEntry(uid, animeID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrEntryNotFound
+	}
+	return entry, err
+}
+
+// CreateAnimeEntry добавляет аниме в список, если его там еще нет.
+func (s *service) CreateAnimeEntry(userID, animeID string, input AnimeEntryInput) (*AnimeEntry, error) {
+	return s.saveAnimeEntry(userID, animeID, input, true)
+}
+
+// SaveAnimeEntry создает запись или применяет изменения к существующей.
+func (s *service) SaveAnimeEntry(userID, animeID string, input AnimeEntryInput) (*AnimeEntry, error) {
+	return s.saveAnimeEntry(userID, animeID, input, false)
+}
+
+func (s *service) saveAnimeEntry(userID, animeID string, input AnimeEntryInput, createOnly bool) (*AnimeEntry, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !shikimori.IsValidAnimeID(animeID) {
+		return nil, ErrInvalidAnimeID
+	}
+
+	_, entry, err := s.updateEntry(uid, animeID, func(current *AnimeEntry) (*AnimeEntry, error) {
+		if current != nil && createOnly {
+			return nil, ErrEntryExists
+		}
+		if current == nil {
+			current = &AnimeEntry{Status: StatusPlanned}
+		}
+		if err := applyEntryInput(current, input); err != nil {
+			return nil, err
+		}
+		return current, nil
+	})
+	return entry, err
+}
+
+func (s *service) DeleteAnimeEntry(userID, animeID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+	before, _, err := s.updateEntry(uid, animeID, func(*AnimeEntry) (*AnimeEntry, error) {
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return ErrEntryNotFound
+	}
+	return nil
+}
+
+// updateEntry - Repository.UpdateAnimeEntry с оповещением наблюдателей.
+func (s *service) updateEntry(userID uuid.UUID, animeID string, fn func(current *AnimeEntry) (*AnimeEntry, error)) (*AnimeEntry, *AnimeEntry, error) {
+	before, after, err := s.repo.UpdateAnimeEntry(userID, animeID, fn)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.notifyEntryChanged(userID, before, after)
+	return before, after, nil
+}
+
+func (s *service) notifyEntryChanged(userID uuid.UUID, before, after *AnimeEntry) {
+	if before == nil && after == nil {
+		return
+	}
+	for _, observer := range s.observers {
+		observer.AnimeEntryChanged(userID, before, after)
+	}
+}
+
+// ApplyAnimeEntry - изменение записи списка произвольной функцией для
+// фоновых задач (импорт, синхронизация). Семантика fn как у Repository.UpdateAnimeEntry.
+func (s *service) ApplyAnimeEntry(userID uuid.UUID, animeID string, fn func(current *AnimeEntry) (*AnimeEntry, error)) (*AnimeEntry, *AnimeEntry, error) {
+	if !shikimori.IsValidAnimeID(animeID) {
+		return nil, nil, ErrInvalidAnimeID
+	}
+	return s.updateEntry(userID, animeID, fn)
+}
+
+func applyEntryInput(entry *AnimeEntry, input AnimeEntryInput) error {
+	if input.Status != nil {
+		if !IsValidStatus(*input.Status) {
+			return ErrInvalidStatus
+		}
+		entry.Status = *input.Status
+	}
+	if input.Score != nil {
+		switch score := *input.Score; {
+		case score == 0:
+			entry.Score = nil
+		case score >= 1 && score <= 10:
+			entry.Score = &score
+		default:
+			return fmt.Errorf("%w: score must be between 1 and 10", ErrInvalidListEntry)
+		}
+	}
+	if input.EpisodesWatched != nil {
+		if *input.EpisodesWatched < 0 {
+			return fmt.Errorf("%w: episodes_watched must not be negative", ErrInvalidListEntry)
+		}
+		entry.EpisodesWatched = *input.EpisodesWatched
+	}
+	if input.RewatchCount != nil {
+		if *input.RewatchCount < 0 {
+			return fmt.Errorf("%w: rewatch_count must not be negative", ErrInvalidListEntry)
+		}
+		entry.RewatchCount = *input.RewatchCount
+	}
+	if input.StartedAt != nil {
+		date, err := parseEntryDate(*input.StartedAt)
+		if err != nil {
+			return err
+		}
+		entry.StartedAt = date
+	}
+	if input.FinishedAt != nil {
+		date, err := parseEntryDate(*input.FinishedAt)
+		if err != nil {
+			return err
+		}
+		entry.FinishedAt = date
+	}
+	if entry.StartedAt != nil && entry.FinishedAt != nil && entry.FinishedAt.Before(*entry.StartedAt) {
+		return fmt.Errorf("%w: finished_at is before started_at", ErrInvalidListEntry)
+	}
+	if input.IsFavorite != nil {
+		entry.IsFavorite = *input.IsFavorite
+	}
+	return nil
+}
+
+func parseEntryDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: dates must be in YYYY-MM-DD format", ErrInvalidListEntry)
+	}
+	return &date, nil
+}
+
+// RecordProgress сохраняет heartbeat плеера и продвигает запись в списке:
+// аниме попадает в "смотрю", а после последней серии - в "просмотрено".
+func (s *service) RecordProgress(ctx context.Context, userID, animeID string, input ProgressInput) (*EpisodeProgress, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !shikimori.IsValidAnimeID(animeID) {
+		return nil, ErrInvalidAnimeID
+	}
+	if input.Episode < 1 || input.Position < 0 || input.Duration < 0 || input.TranslationID < 0 {
+		return nil, fmt.Errorf("%w: episode must be positive, position and duration must not be negative", ErrInvalidProgress)
+	}
+
+	now := time.Now()
+	progress := &EpisodeProgress{
+		UserID:        uid,
+		AnimeID:       animeID,
+		Episode:       input.Episode,
+		TranslationID: input.TranslationID,
+		Position:      input.Position,
+		Duration:      input.Duration,
+		Completed:     input.Completed || (input.Duration > 0 && float64(input.Position) >= float64(input.Duration)*episodeCompletedRatio),
+		UpdatedAt:     now,
+	}
+	if progress.Completed {
+		progress.CompletedAt = &now
+	}
+	if err := s.repo.SaveEpisodeProgress(progress); err != nil {
+		return nil, err
+	}
+
+	if err := s.advanceEntry(ctx, uid, animeID, progress); err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// advanceEntry обновляет запись списка по прогрессу. Heartbeat приходят
+// часто, поэтому запись блокируется только когда в ней что-то меняется.
+func (s *service) advanceEntry(ctx context.Context, userID uuid.UUID, animeID string, progress *EpisodeProgress) error {
+	needsUpdate := func(entry *AnimeEntry) bool {
+		if entry == nil {
+			return true
+		}
+		if entry.Status == StatusCompleted {
+			return false
+		}
+		return entry.Status != StatusWatching || (progress.Completed && progress.Episode > entry.EpisodesWatched)
+	}
+
+	entry, err := s.repo.FindAnimeEntry(userID, animeID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if !needsUpdate(entry) {
+		return nil
+	}
+
+	// Общее число серий нужно только когда досмотрена новая серия
+	totalEpisodes := 0
+	if progress.Completed {
+		animes, err := s.shikimoriService.GetAnimesByIDs(ctx, []string{animeID})
+		if err != nil {
+			log.Printf("Failed to get episodes count for anime %s: %v", animeID, err)
+		} else if len(animes) > 0 {
+			totalEpisodes = animes[0].Episodes
+		}
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	_, _, err = s.updateEntry(userID, animeID, func(current *AnimeEntry) (*AnimeEntry, error) {
+		if !needsUpdate(current) {
+			return current, nil
+		}
+		if current == nil {
+			current = &AnimeEntry{}
+		}
+		current.Status = StatusWatching
+		if current.StartedAt == nil {
+			current.StartedAt = &today
+		}
+		if progress.Completed && progress.Episode > current.EpisodesWatched {
+			current.EpisodesWatched = progress.Episode
+		}
+		if totalEpisodes > 0 && current.EpisodesWatched >= totalEpisodes {
+			current.EpisodesWatched = totalEpisodes
+			current.Status = StatusCompleted
+			if current.FinishedAt == nil {
+				current.FinishedAt = &today
+			}
+		}
+		return current, nil
+	})
+	return err
+}
+
+func (s *service) GetEpisodeProgress(userID, animeID string) ([]EpisodeProgress, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	progress, err := s.repo.FindEpisodeProgress(uid, animeID)
+	if err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = []EpisodeProgress{}
+	}
+	return progress, nil
+}
+
+// ContinueWatching возвращает, с какой серии и позиции продолжить каждое
+// недосмотренное аниме. Если последняя серия досмотрена, предлагается следующая.
+func (s *service) ContinueWatching(ctx context.Context, userID string, limit int) ([]ContinueWatchingItem, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := s.repo.FindLatestProgress(uid, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(latest))
+	for i, progress := range latest {
+		ids[i] = progress.AnimeID
+	}
+	found, _ := s.shikimoriService.FetchAnimesByIDs(ctx, ids)
+
+	items := []ContinueWatchingItem{}
+	for _, progress := range latest {
+		item := ContinueWatchingItem{
+			AnimeID:       progress.AnimeID,
+			Episode:       progress.Episode,
+			TranslationID: progress.TranslationID,
+			Position:      progress.Position,
+			Duration:      progress.Duration,
+			UpdatedAt:     progress.UpdatedAt,
+		}
+		anime, ok := found[progress.AnimeID]
+		if ok {
+			item.Anime = &anime
+		}
+		if progress.Completed {
+			if ok && anime.Episodes > 0 && progress.Episode >= anime.Episodes {
+				continue
+			}
+			item.Episode++
+			item.Position, item.Duration = 0, 0
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (s *service) GetWatchedAnimeDetails(ctx context.Context, userID string, query AnimeListQuery) (*AnimeListPage, error) {
+	if query.Sort == "" {
+		query.Sort = SortAdded
+	}
+	if query.Sort == SortPosition {
+		return nil, ErrInvalidSort
+	}
+	entries, err := s.GetAnimeList(userID, StatusCompleted)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	return s.animeListPage(ctx, entries, query)
+}
+
+func (s *service) GetFavouriteAnimeDetails(ctx context.Context, userID string, query AnimeListQuery) (*AnimeListPage, error) {
+	if query.Sort == "" {
+		query.Sort = SortPosition
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.repo.FindFavoriteEntries(uid)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	return s.animeListPage(ctx, entries, query)
+}
+
+// animeListPage сортирует записи, вырезает страницу и подгружает для нее
+// данные Shikimori. Для сортировки по названию нужны данные всего списка,
+// в остальных случаях загружается только страница.
+func (s *service) animeListPage(ctx context.Context, entries []AnimeEntry, query AnimeListQuery) (*AnimeListPage, error) {
+	desc := query.Sort == SortAdded || query.Sort == SortScore
+	switch query.Order {
+	case "":
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	default:
+		return nil, ErrInvalidSort
+	}
+
+	page := &AnimeListPage{
+		Anime:     []shikimori.Anime{},
+		FailedIDs: []string{},
+		Total:     len(entries),
+		Page:      query.Page,
+		Limit:     query.Limit,
+	}
+
+	var found map[string]shikimori.Anime
+	switch query.Sort {
+	case SortTitle:
+		found, _ = s.shikimoriService.FetchAnimesByIDs(ctx, entryAnimeIDs(entries))
+		sortEntriesByTitle(entries, found, desc)
+	case SortAdded, SortScore, SortPosition:
+		sortEntries(entries, query.Sort, desc)
+	default:
+		return nil, ErrInvalidSort
+	}
+
+	// page приходит из запроса, поэтому сравниваем до умножения
+	start := len(entries)
+	if query.Page-1 < len(entries)/query.Limit+1 {
+		start = min((query.Page-1)*query.Limit, len(entries))
+	}
+	entries = entries[start:min(start+query.Limit, len(entries))]
+	if found == nil {
+		found, _ = s.shikimoriService.FetchAnimesByIDs(ctx, entryAnimeIDs(entries))
+	}
+
+	for _, entry := range entries {
+		if anime, ok := found[entry.AnimeID]; ok {
+			page.Anime = append(page.Anime, anime)
+		} else {
+			page.FailedIDs = append(page.FailedIDs, entry.AnimeID)
+		}
+	}
+	return page, nil
+}
+
+func sortEntries(entries []AnimeEntry, by string, desc bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch by {
+		case SortScore:
+			// записи без оценки всегда в конце
+			if (a.Score == nil) != (b.Score == nil) {
+				return a.Score != nil
+			}
+			if a.Score != nil && *a.Score != *b.Score {
+				return (*a.Score > *b.Score) == desc
+			}
+		case SortPosition:
+			if a.FavoritePosition != nil && b.FavoritePosition != nil && *a.FavoritePosition != *b.FavoritePosition {
+				return (*a.FavoritePosition > *b.FavoritePosition) == desc
+			}
+		}
+		if a.CreatedAt.Equal(b.CreatedAt) {
+			return false
+		}
+		return a.CreatedAt.After(b.CreatedAt) == desc
+	})
+}
+
+// sortEntriesByTitle сортирует по русскому названию (или оригинальному, если
+// русского нет). Незагруженные аниме идут в конце.
+func sortEntriesByTitle(entries []AnimeEntry, found map[string]shikimori.Anime, desc bool) {
+	title := func(e AnimeEntry) (string, bool) {
+		anime, ok := found[e.AnimeID]
+		if !ok {
+			return "", false
+		}
+		if anime.Russian != "" {
+			return strings.ToLower(anime.Russian), true
+		}
+		return strings.ToLower(anime.Name), true
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, okA := title(entries[i])
+		b, okB := title(entries[j])
+		if okA != okB {
+			return okA
+		}
+		if a == b {
+			return false
+		}
+		return (a > b) == desc
+	})
+}
+
+func entryAnimeIDs(entries []AnimeEntry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.AnimeID
+	}
+	return ids
+}
+
 func (s *service) UpdateNickname(userID string, nickname string) error {
 	// Можно добавить валидацию никнейма
 	if len(nickname) > 32 {
@@ -0,0 +1,770 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/internal/lockout"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/pkg/mailer"
+	"github.com/Zipklas/anime-site-backend/pkg/secret"
+	"github.com/Zipklas/anime-site-backend/pkg/totp"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const testBaseURL = "https://anime.test"
+
+func newTestService(t *testing.T, repo *fakeRepository, mail mailer.Mailer) *service {
+	t.Helper()
+	t.Setenv("APP_BASE_URL", testBaseURL)
+	guard := lockout.NewGuard(lockout.NewMemoryStore(), lockout.DefaultAccountPolicy, lockout.DefaultIPPolicy)
+	return NewService(repo, nil, auth.NewTokenManager("test-secret"), mail, guard, nil, testTokenBox(t)).(*service)
+}
+
+// createUser сохраняет пользователя с паролем password123.
+func createUser(t *testing.T, repo *fakeRepository, email string) *User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{ID: uuid.New(), Email: email, Password: string(hash), Role: auth.RoleUser}
+	if err := repo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newTestService(t, repo, mailer.NewMemoryMailer())
+	user := createUser(t, repo, "user@example.com")
+
+	login, err := svc.Login(user.Email, "password123", ClientInfo{UserAgent: "test", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	principal, err := svc.tokens.ParseAccessToken(login.AccessToken)
+	if err != nil || principal.UserID != user.ID {
+		t.Fatalf("access token: %+v, %v", principal, err)
+	}
+
+	rotated, err := svc.RefreshTokens(login.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshTokens: %v", err)
+	}
+	if rotated.RefreshToken == login.RefreshToken {
+		t.Fatal("refresh token is not rotated")
+	}
+	if next, err := svc.tokens.ParseAccessToken(rotated.AccessToken); err != nil || next.SessionID != principal.SessionID {
+		t.Fatalf("refreshed access token: %+v, %v; want session %s", next, err, principal.SessionID)
+	}
+
+	// повторное использование обменянного токена отзывает всю сессию
+	if _, err := svc.RefreshTokens(login.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("reused token: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if active, _ := svc.IsSessionActive(principal.SessionID); active {
+		t.Fatal("session is active after refresh token reuse")
+	}
+	if _, err := svc.RefreshTokens(rotated.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("token of a revoked session: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if _, err := svc.RefreshTokens("unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("unknown token: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newTestService(t, repo, mailer.NewMemoryMailer())
+	user := createUser(t, repo, "user@example.com")
+
+	login, err := svc.Login(user.Email, "password123", ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	principal, _ := svc.tokens.ParseAccessToken(login.AccessToken)
+	if active, err := svc.IsSessionActive(principal.SessionID); err != nil || !active {
+		t.Fatalf("new session active = %v, %v", active, err)
+	}
+
+	if err := svc.Logout(login.RefreshToken); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if active, _ := svc.IsSessionActive(principal.SessionID); active {
+		t.Fatal("session is active after logout")
+	}
+	if _, err := svc.RefreshTokens(login.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("refresh after logout: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if active, _ := svc.IsSessionActive(uuid.New()); active {
+		t.Fatal("unknown session is active")
+	}
+}
+
+var verifyLinkRe = regexp.MustCompile(regexp.QuoteMeta(testBaseURL) + `/verify-email\?token=(\S+)`)
+
+// verificationToken достает токен из последнего письма с подтверждением.
+func verificationToken(t *testing.T, mail *mailer.MemoryMailer, to string) string {
+	t.Helper()
+	messages := mail.Messages()
+	if len(messages) == 0 {
+		t.Fatal("no emails sent")
+	}
+	msg := messages[len(messages)-1]
+	if msg.To != to {
+		t.Fatalf("email sent to %q, want %q", msg.To, to)
+	}
+	match := verifyLinkRe.FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("no verification link in email body:\n%s", msg.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("bad token in link: %v", err)
+	}
+	return token
+}
+
+func TestVerifyEmailRoundTrip(t *testing.T) {
+	repo := newFakeRepository()
+	mail := mailer.NewMemoryMailer()
+	svc := newTestService(t, repo, mail)
+
+	if err := svc.Register("tester", "tester@example.com", "password123"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	user, err := repo.FindByEmail("tester@example.com")
+	if err != nil {
+		t.Fatalf("user not created: %v", err)
+	}
+	if user.EmailVerified {
+		t.Fatal("email is verified right after registration")
+	}
+	if user.EmailVerificationSentAt == nil {
+		t.Fatal("verification sent time is not recorded")
+	}
+
+	token := verificationToken(t, mail, "tester@example.com")
+	if err := svc.VerifyEmail(token); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	user, _ = repo.FindByEmail("tester@example.com")
+	if !user.EmailVerified {
+		t.Fatal("email is not verified after following the link")
+	}
+
+	if err := svc.VerifyEmail(token); !errors.Is(err, ErrInvalidActionToken) {
+		t.Fatalf("second VerifyEmail with the same token: got %v, want %v", err, ErrInvalidActionToken)
+	}
+	if err := svc.ResendVerification(user.ID.String()); !errors.Is(err, ErrEmailAlreadyVerified) {
+		t.Fatalf("ResendVerification after verification: got %v, want %v", err, ErrEmailAlreadyVerified)
+	}
+}
+
+func TestVerifyEmailRejectsForeignTokens(t *testing.T) {
+	repo := newFakeRepository()
+	mail := mailer.NewMemoryMailer()
+	svc := newTestService(t, repo, mail)
+
+	if err := svc.Register("tester", "tester@example.com", "password123"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	token := verificationToken(t, mail, "tester@example.com")
+
+	other := NewService(repo, nil, auth.NewTokenManager("other-secret"), mail, nil, nil, testTokenBox(t))
+	for name, verify := range map[string]func() error{
+		"garbage":      func() error { return svc.VerifyEmail("not-a-token") },
+		"other secret": func() error { return other.VerifyEmail(token) },
+	} {
+		if err := verify(); !errors.Is(err, ErrInvalidActionToken) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidActionToken)
+		}
+	}
+	user, _ := repo.FindByEmail("tester@example.com")
+	if user.EmailVerified {
+		t.Fatal("email verified by an invalid token")
+	}
+}
+
+func TestAdminEmailPromotedOnlyAfterVerification(t *testing.T) {
+	t.Setenv("ADMIN_EMAIL", "admin@example.com")
+	repo := newFakeRepository()
+	mail := mailer.NewMemoryMailer()
+	svc := newTestService(t, repo, mail)
+
+	if err := svc.Register("admin", "admin@example.com", "password123"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	user, _ := repo.FindByEmail("admin@example.com")
+	if user.Role != auth.RoleUser {
+		t.Fatalf("role right after registration = %q, want %q", user.Role, auth.RoleUser)
+	}
+
+	if err := svc.EnsureAdmin("admin@example.com"); err != nil {
+		t.Fatalf("EnsureAdmin: %v", err)
+	}
+	user, _ = repo.FindByEmail("admin@example.com")
+	if user.Role != auth.RoleUser {
+		t.Fatalf("EnsureAdmin promoted an unverified account to %q", user.Role)
+	}
+
+	if err := svc.VerifyEmail(verificationToken(t, mail, "admin@example.com")); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	user, _ = repo.FindByEmail("admin@example.com")
+	if user.Role != auth.RoleAdmin {
+		t.Fatalf("role after verification = %q, want %q", user.Role, auth.RoleAdmin)
+	}
+}
+
+func TestPasswordResetLink(t *testing.T) {
+	repo := newFakeRepository()
+	mail := mailer.NewMemoryMailer()
+
+	t.Setenv("PASSWORD_RESET_URL", "https://front.test/reset?lang=ru")
+	svc := newTestService(t, repo, mail)
+	if err := svc.Register("tester", "tester@example.com", "password123"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := svc.sendPasswordReset("tester@example.com"); err != nil {
+		t.Fatalf("sendPasswordReset: %v", err)
+	}
+	body := mail.Messages()[len(mail.Messages())-1].Body
+	match := regexp.MustCompile(`https://front\.test/reset\?lang=ru&token=(\S+)`).FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("reset link does not use PASSWORD_RESET_URL:\n%s", body)
+	}
+	token, _ := url.QueryUnescape(match[1])
+	if err := svc.ResetPassword(token, "new-password"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if err := svc.ResetPassword(token, "other-password"); !errors.Is(err, ErrInvalidActionToken) {
+		t.Fatalf("second ResetPassword with the same token: got %v, want %v", err, ErrInvalidActionToken)
+	}
+
+	// Без PASSWORD_RESET_URL ссылка ведет на форму самого бэкенда
+	t.Setenv("PASSWORD_RESET_URL", "")
+	svc = newTestService(t, repo, mail)
+	user, _ := repo.FindByEmail("tester@example.com")
+	repo.update(user.ID, func(u *User) { u.PasswordResetSentAt = nil })
+	if err := svc.sendPasswordReset("tester@example.com"); err != nil {
+		t.Fatalf("sendPasswordReset: %v", err)
+	}
+	body = mail.Messages()[len(mail.Messages())-1].Body
+	if !regexp.MustCompile(regexp.QuoteMeta(testBaseURL) + `/password/reset\?token=\S+`).MatchString(body) {
+		t.Fatalf("default reset link does not point to GET /password/reset:\n%s", body)
+	}
+}
+
+func TestTwoFactorCodesCannotBeGuessedByRepeatingLogin(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newTestService(t, repo, mailer.NewMemoryMailer())
+	svc.loginGuard = lockout.NewGuard(lockout.NewMemoryStore(),
+		lockout.Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, LockoutThreshold: 10, LockoutDuration: time.Hour, Window: time.Hour},
+		lockout.Policy{FreeAttempts: 1000, BaseDelay: time.Second, MaxDelay: time.Second, LockoutThreshold: 1000, LockoutDuration: time.Second, Window: time.Hour},
+	)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	user := &User{ID: uuid.New(), Email: "2fa@example.com", Password: string(hash), Role: auth.RoleUser,
+		EmailVerified: true, TOTPSecret: sealTOTPSecret(t, svc, secret), TOTPEnabled: true}
+	if err := repo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	client := ClientInfo{IP: "10.0.0.1"}
+
+	login := func() string {
+		t.Helper()
+		result, err := svc.Login(user.Email, "password123", client)
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		if !result.TwoFactorRequired {
+			t.Fatal("Login did not ask for the second factor")
+		}
+		return result.Challenge
+	}
+
+	// Каждая неверная попытка идет с нового входа по паролю
+	for i := 0; i < 2; i++ {
+		if _, err := svc.CompleteTwoFactorLogin(login(), "not-a-code", client); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("attempt %d: got %v, want %v", i+1, err, ErrInvalidTwoFactorCode)
+		}
+	}
+	var throttled *ThrottledError
+	if _, err := svc.CompleteTwoFactorLogin(login(), "not-a-code", client); !errors.As(err, &throttled) {
+		t.Fatalf("third wrong code after re-login: got %v, want ThrottledError", err)
+	}
+	if _, err := svc.Login(user.Email, "password123", client); !errors.As(err, &throttled) {
+		t.Fatalf("Login during lockout: got %v, want ThrottledError", err)
+	}
+}
+
+func sealTOTPSecret(t *testing.T, svc *service, secret string) string {
+	t.Helper()
+	sealed, err := svc.tokenBox.Seal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestTwoFactorSecretIsStoredEncrypted(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newTestService(t, repo, mailer.NewMemoryMailer())
+	user := &User{ID: uuid.New(), Email: "2fa@example.com", Role: auth.RoleUser}
+	if err := repo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+
+	enrollment, err := svc.EnrollTwoFactor(user.ID.String())
+	if err != nil {
+		t.Fatalf("EnrollTwoFactor: %v", err)
+	}
+	stored, _ := repo.FindByID(user.ID.String())
+	if !secret.IsSealed(stored.TOTPSecret) || stored.TOTPSecret == "" || strings.Contains(stored.TOTPSecret, enrollment.Secret) {
+		t.Fatalf("TOTP secret is stored in plaintext: %q", stored.TOTPSecret)
+	}
+
+	code, _ := totp.Code(enrollment.Secret, totp.Counter(time.Now()))
+	if _, err := svc.ConfirmTwoFactor(user.ID.String(), code); err != nil {
+		t.Fatalf("ConfirmTwoFactor with a valid code: %v", err)
+	}
+	if stored, _ := repo.FindByID(user.ID.String()); !stored.TOTPEnabled {
+		t.Fatal("2FA is not enabled after confirmation")
+	}
+}
+
+func TestTwoFactorSuccessResetsCounter(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newTestService(t, repo, mailer.NewMemoryMailer())
+	store := lockout.NewMemoryStore()
+	svc.loginGuard = lockout.NewGuard(store, lockout.DefaultAccountPolicy, lockout.DefaultIPPolicy)
+
+	secret, _ := totp.GenerateSecret()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	user := &User{ID: uuid.New(), Email: "2fa@example.com", Password: string(hash), Role: auth.RoleUser,
+		TOTPSecret: sealTOTPSecret(t, svc, secret), TOTPEnabled: true}
+	if err := repo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	client := ClientInfo{IP: "10.0.0.1"}
+
+	result, err := svc.Login(user.Email, "password123", client)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := svc.CompleteTwoFactorLogin(result.Challenge, "not-a-code", client); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("wrong code: got %v", err)
+	}
+
+	result, err = svc.Login(user.Email, "password123", client)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if attempt, _ := store.Get(context.Background(), "account:2fa@example.com"); attempt == nil || attempt.Failures != 1 {
+		t.Fatalf("password login reset the counter of a 2FA account: %+v", attempt)
+	}
+
+	code, _ := totp.Code(secret, totp.Counter(time.Now()))
+	if _, err := svc.CompleteTwoFactorLogin(result.Challenge, code, client); err != nil {
+		t.Fatalf("CompleteTwoFactorLogin with a valid code: %v", err)
+	}
+	if attempt, _ := store.Get(context.Background(), "account:2fa@example.com"); attempt != nil {
+		t.Fatalf("counter is not reset after a valid code: %+v", attempt)
+	}
+}
+
+func TestRegisterValidatesPasswordLength(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newTestService(t, repo, mailer.NewMemoryMailer())
+
+	for password, want := range map[string]error{
+		"short":                 ErrPasswordTooShort,
+		strings.Repeat("x", 73): ErrPasswordTooLong,
+		strings.Repeat("я", 37): ErrPasswordTooLong, // 74 байта
+		strings.Repeat("x", 72): nil,
+	} {
+		email := fmt.Sprintf("user%d@example.com", len(password))
+		if err := svc.Register("tester", email, password); !errors.Is(err, want) {
+			t.Errorf("password of %d bytes: got %v, want %v", len(password), err, want)
+		}
+	}
+	if len(repo.users) != 1 {
+		t.Fatalf("%d users registered, want 1", len(repo.users))
+	}
+}
+
+func TestPasswordResetEmailsAreThrottled(t *testing.T) {
+	repo := newFakeRepository()
+	mail := mailer.NewMemoryMailer()
+	svc := newTestService(t, repo, mail)
+	if err := svc.Register("tester", "tester@example.com", "password123"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	sent := len(mail.Messages())
+
+	for i := 0; i < 3; i++ {
+		if err := svc.sendPasswordReset("tester@example.com"); err != nil {
+			t.Fatalf("sendPasswordReset: %v", err)
+		}
+	}
+	if got := len(mail.Messages()) - sent; got != 1 {
+		t.Fatalf("%d reset emails sent, want 1", got)
+	}
+
+	user, _ := repo.FindByEmail("tester@example.com")
+	repo.update(user.ID, func(u *User) {
+		past := time.Now().Add(-passwordResetInterval)
+		u.PasswordResetSentAt = &past
+	})
+	if err := svc.sendPasswordReset("tester@example.com"); err != nil {
+		t.Fatalf("sendPasswordReset: %v", err)
+	}
+	if got := len(mail.Messages()) - sent; got != 2 {
+		t.Fatalf("%d reset emails sent after the cooldown, want 2", got)
+	}
+}
+
+func TestResetPasswordChecksLengthBeforeUsingToken(t *testing.T) {
+	repo := newFakeRepository()
+	mail := mailer.NewMemoryMailer()
+	svc := newTestService(t, repo, mail)
+	if err := svc.Register("tester", "tester@example.com", "password123"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := svc.sendPasswordReset("tester@example.com"); err != nil {
+		t.Fatalf("sendPasswordReset: %v", err)
+	}
+	body := mail.Messages()[len(mail.Messages())-1].Body
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(body)
+	token, _ := url.QueryUnescape(match[1])
+
+	if err := svc.ResetPassword(token, strings.Repeat("x", 73)); !errors.Is(err, ErrPasswordTooLong) {
+		t.Fatalf("long password: got %v, want %v", err, ErrPasswordTooLong)
+	}
+	if err := svc.ResetPassword(token, "short"); !errors.Is(err, ErrPasswordTooShort) {
+		t.Fatalf("short password: got %v, want %v", err, ErrPasswordTooShort)
+	}
+	if err := svc.ResetPassword(token, "new-password"); err != nil {
+		t.Fatalf("token is burned by a rejected password: %v", err)
+	}
+}
+
+func TestApplyEntryInput(t *testing.T) {
+	str := func(v string) *string { return &v }
+	num := func(v int) *int { return &v }
+	started := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name  string
+		input AnimeEntryInput
+		err   error
+		check func(*AnimeEntry) bool
+	}{
+		{"status", AnimeEntryInput{Status: str(StatusDropped)}, nil,
+			func(e *AnimeEntry) bool { return e.Status == StatusDropped }},
+		{"unknown status", AnimeEntryInput{Status: str("rewatching")}, ErrInvalidStatus, nil},
+		{"score", AnimeEntryInput{Score: num(10)}, nil,
+			func(e *AnimeEntry) bool { return e.Score != nil && *e.Score == 10 }},
+		{"zero score clears", AnimeEntryInput{Score: num(0)}, nil,
+			func(e *AnimeEntry) bool { return e.Score == nil }},
+		{"score above scale", AnimeEntryInput{Score: num(11)}, ErrInvalidListEntry, nil},
+		{"negative score", AnimeEntryInput{Score: num(-1)}, ErrInvalidListEntry, nil},
+		{"negative episodes", AnimeEntryInput{EpisodesWatched: num(-1)}, ErrInvalidListEntry, nil},
+		{"negative rewatches", AnimeEntryInput{RewatchCount: num(-1)}, ErrInvalidListEntry, nil},
+		{"finished date", AnimeEntryInput{FinishedAt: str("2024-03-02")}, nil,
+			func(e *AnimeEntry) bool { return e.FinishedAt != nil && e.FinishedAt.Day() == 2 }},
+		{"empty date clears", AnimeEntryInput{StartedAt: str("")}, nil,
+			func(e *AnimeEntry) bool { return e.StartedAt == nil }},
+		{"malformed date", AnimeEntryInput{StartedAt: str("01.03.2024")}, ErrInvalidListEntry, nil},
+		{"finished before started", AnimeEntryInput{FinishedAt: str("2024-02-29")}, ErrInvalidListEntry, nil},
+	} {
+		score := 5
+		entry := &AnimeEntry{Status: StatusPlanned, Score: &score, StartedAt: &started}
+		err := applyEntryInput(entry, tc.input)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: error %v, want %v", tc.name, err, tc.err)
+			continue
+		}
+		if tc.check != nil && !tc.check(entry) {
+			t.Errorf("%s: entry %+v", tc.name, entry)
+		}
+	}
+}
+
+func TestBulkAnimeIDs(t *testing.T) {
+	userID := uuid.NewString()
+
+	_, ids, err := bulkAnimeIDs(userID, []string{"1", "2", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "1,2" {
+		t.Errorf("ids = %v, want duplicates removed", ids)
+	}
+
+	if _, _, err := bulkAnimeIDs(userID, nil); !errors.Is(err, ErrInvalidAnimeID) {
+		t.Errorf("empty list: %v", err)
+	}
+	if _, _, err := bulkAnimeIDs(userID, []string{"1", "abc"}); !errors.Is(err, ErrInvalidAnimeID) {
+		t.Errorf("invalid id: %v", err)
+	}
+	if _, _, err := bulkAnimeIDs(userID, make([]string, maxBulkAnimeIDs+1)); !errors.Is(err, ErrTooManyAnimeIDs) {
+		t.Errorf("too many ids: %v", err)
+	}
+}
+
+// favoritesRepository отдает фиксированное избранное: аниме "1" на первой
+// позиции, "2" на второй.
+type favoritesRepository struct {
+	*fakeRepository
+}
+
+func favoriteEntry(animeID string, position int) AnimeEntry {
+	return AnimeEntry{AnimeID: animeID, Status: StatusCompleted, IsFavorite: true, FavoritePosition: &position}
+}
+
+func (r favoritesRepository) RemoveFavorites(userID uuid.UUID, animeIDs []string) ([]AnimeEntry, error) {
+	return []AnimeEntry{favoriteEntry("1", 1)}, nil
+}
+
+func (r favoritesRepository) MoveFavorite(userID uuid.UUID, animeID string, position int) (*AnimeEntry, *AnimeEntry, error) {
+	before, after := favoriteEntry(animeID, 2), favoriteEntry(animeID, position)
+	return &before, &after, nil
+}
+
+type entryChange struct {
+	before, after *AnimeEntry
+}
+
+type recordingObserver struct {
+	changes []entryChange
+}
+
+func (o *recordingObserver) AnimeEntryChanged(userID uuid.UUID, before, after *AnimeEntry) {
+	o.changes = append(o.changes, entryChange{before, after})
+}
+
+func TestFavoriteChangesNotifyObservers(t *testing.T) {
+	observer := &recordingObserver{}
+	svc := NewService(favoritesRepository{newFakeRepository()}, nil, auth.NewTokenManager("test-secret"),
+		mailer.NewMemoryMailer(), nil, nil, testTokenBox(t), observer)
+	userID := uuid.New().String()
+
+	if removed, err := svc.RemoveFavorites(userID, []string{"1", "3"}); err != nil || removed != 1 {
+		t.Fatalf("RemoveFavorites = %d, %v", removed, err)
+	}
+	if err := svc.MoveFavorite(userID, "2", 1); err != nil {
+		t.Fatalf("MoveFavorite: %v", err)
+	}
+
+	if len(observer.changes) != 2 {
+		t.Fatalf("%d changes observed, want 2", len(observer.changes))
+	}
+	removed := observer.changes[0]
+	if !removed.before.IsFavorite || removed.after.IsFavorite || removed.after.FavoritePosition != nil {
+		t.Errorf("removed favorite: before %+v, after %+v", removed.before, removed.after)
+	}
+	moved := observer.changes[1]
+	if *moved.before.FavoritePosition != 2 || *moved.after.FavoritePosition != 1 {
+		t.Errorf("moved favorite: position %d -> %d, want 2 -> 1", *moved.before.FavoritePosition, *moved.after.FavoritePosition)
+	}
+}
+
+func TestAnimeListPageBeyondTheEnd(t *testing.T) {
+	svc := newTestService(t, newFakeRepository(), mailer.NewMemoryMailer())
+	svc.shikimoriService = &shikimori.Service{}
+	entries := []AnimeEntry{{AnimeID: "1"}, {AnimeID: "2"}, {AnimeID: "3"}}
+
+	for _, page := range []int{3, 92233720368547759, math.MaxInt} {
+		result, err := svc.animeListPage(context.Background(), entries, AnimeListQuery{Page: page, Limit: 100, Sort: SortAdded})
+		if err != nil {
+			t.Fatalf("page %d: %v", page, err)
+		}
+		if len(result.Anime) != 0 || len(result.FailedIDs) != 0 || result.Total != 3 {
+			t.Errorf("page %d: %+v, want an empty page of 3 entries", page, result)
+		}
+	}
+}
+
+// progressRepository хранит записи списка и последний heartbeat в памяти.
+type progressRepository struct {
+	*fakeRepository
+
+	entries  map[string]*AnimeEntry
+	progress *EpisodeProgress
+	updates  int
+}
+
+func (r *progressRepository) SaveEpisodeProgress(progress *EpisodeProgress) error {
+	r.progress = progress
+	return nil
+}
+
+func (r *progressRepository) FindAnimeEntry(userID uuid.UUID, animeID string) (*AnimeEntry, error) {
+	entry, ok := r.entries[animeID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *entry
+	return &copied, nil
+}
+
+func (r *progressRepository) UpdateAnimeEntry(userID uuid.UUID, animeID string, fn func(current *AnimeEntry) (*AnimeEntry, error)) (*AnimeEntry, *AnimeEntry, error) {
+	r.updates++
+	before, _ := r.FindAnimeEntry(userID, animeID)
+	var current *AnimeEntry
+	if before != nil {
+		copied := *before
+		current = &copied
+	}
+	after, err := fn(current)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.entries[animeID] = after
+	return before, after, nil
+}
+
+func TestRecordProgress(t *testing.T) {
+	repo := &progressRepository{fakeRepository: newFakeRepository(), entries: map[string]*AnimeEntry{
+		"2": {AnimeID: "2", Status: StatusWatching, EpisodesWatched: 3},
+		"3": {AnimeID: "3", Status: StatusCompleted, EpisodesWatched: 12},
+	}}
+	svc := newTestService(t, repo.fakeRepository, mailer.NewMemoryMailer())
+	svc.repo = repo
+	ctx, userID := context.Background(), uuid.NewString()
+
+	for _, input := range []ProgressInput{{Episode: 0}, {Episode: 1, Position: -1}, {Episode: 1, Duration: -1}} {
+		if _, err := svc.RecordProgress(ctx, userID, "1", input); !errors.Is(err, ErrInvalidProgress) {
+			t.Errorf("%+v: error %v, want %v", input, err, ErrInvalidProgress)
+		}
+	}
+	if _, err := svc.RecordProgress(ctx, userID, "abc", ProgressInput{Episode: 1}); !errors.Is(err, ErrInvalidAnimeID) {
+		t.Errorf("invalid anime ID: %v", err)
+	}
+
+	// первый heartbeat переводит аниме в "смотрю"
+	progress, err := svc.RecordProgress(ctx, userID, "1", ProgressInput{Episode: 1, Position: 600, Duration: 1400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Completed || progress.CompletedAt != nil {
+		t.Errorf("episode at 600 of 1400 seconds is completed")
+	}
+	if entry := repo.entries["1"]; entry == nil || entry.Status != StatusWatching || entry.StartedAt == nil || entry.EpisodesWatched != 0 {
+		t.Errorf("entry after the first heartbeat: %+v", entry)
+	}
+
+	// недосмотренная серия и просмотренное аниме запись не трогают
+	repo.updates = 0
+	for _, animeID := range []string{"2", "3"} {
+		if _, err := svc.RecordProgress(ctx, userID, animeID, ProgressInput{Episode: 13, Position: 60, Duration: 1400}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 90% длительности засчитывают серию
+	progress, err = svc.RecordProgress(ctx, userID, "3", ProgressInput{Episode: 13, Position: 1260, Duration: 1400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.Completed || progress.CompletedAt == nil || repo.progress != progress {
+		t.Errorf("episode at 1260 of 1400 seconds: %+v", progress)
+	}
+	if repo.updates != 0 {
+		t.Errorf("list entries updated %d times, want 0", repo.updates)
+	}
+}
+
+func TestCreateAPITokenValidatesInput(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newTestService(t, repo, mailer.NewMemoryMailer())
+	userID := createUser(t, repo, "bot@example.com").ID.String()
+
+	for _, tc := range []struct {
+		name   string
+		scopes []string
+		days   int
+	}{
+		{"  ", []string{auth.ScopeListsRead}, 0},
+		{strings.Repeat("я", 65), []string{auth.ScopeListsRead}, 0},
+		{"bot", nil, 0},
+		{"bot", []string{"lists:delete"}, 0},
+		{"bot", []string{auth.ScopeListsRead}, -1},
+	} {
+		if _, _, err := svc.CreateAPIToken(userID, tc.name, tc.scopes, tc.days); err == nil {
+			t.Errorf("token %q with scopes %v for %d days is created", tc.name, tc.scopes, tc.days)
+		}
+	}
+
+	for i := 0; i < maxAPITokensPerUser; i++ {
+		if _, _, err := svc.CreateAPIToken(userID, "bot", []string{auth.ScopeListsRead}, 0); err != nil {
+			t.Fatalf("token %d: %v", i, err)
+		}
+	}
+	if _, _, err := svc.CreateAPIToken(userID, "bot", []string{auth.ScopeListsRead}, 0); !errors.Is(err, ErrTooManyAPITokens) {
+		t.Fatalf("token over the limit: got %v, want %v", err, ErrTooManyAPITokens)
+	}
+}
+
+func TestResolveAPIToken(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newTestService(t, repo, mailer.NewMemoryMailer())
+	user := createUser(t, repo, "bot@example.com")
+	scopes := []string{auth.ScopeListsRead, auth.ScopeProfileRead}
+
+	token, plain, err := svc.CreateAPIToken(user.ID.String(), "bot", scopes, 30)
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if !strings.HasPrefix(plain, auth.APITokenPrefix) || token.TokenHash == plain || !strings.HasPrefix(plain, token.Prefix) {
+		t.Fatalf("token %+v for %q", token, plain)
+	}
+
+	principal, err := svc.ResolveAPIToken(plain)
+	if err != nil {
+		t.Fatalf("ResolveAPIToken: %v", err)
+	}
+	if principal.UserID != user.ID || !principal.IsAPIToken() || !principal.HasScopes(scopes...) || principal.HasScopes(auth.ScopeListsWrite) {
+		t.Fatalf("principal = %+v", principal)
+	}
+	if stored, _ := repo.FindAPITokenByHash(token.TokenHash); stored.LastUsedAt == nil {
+		t.Fatal("token usage is not recorded")
+	}
+
+	// чужой пользователь отозвать токен не может
+	if err := svc.RevokeAPIToken(uuid.New().String(), token.ID.String()); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Fatalf("revoke by another user: got %v, want %v", err, ErrAPITokenNotFound)
+	}
+	if err := svc.RevokeAPIToken(user.ID.String(), token.ID.String()); err != nil {
+		t.Fatalf("RevokeAPIToken: %v", err)
+	}
+	if _, err := svc.ResolveAPIToken(plain); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Fatalf("revoked token: got %v, want %v", err, auth.ErrInvalidToken)
+	}
+
+	_, expired, _ := svc.CreateAPIToken(user.ID.String(), "bot", scopes, 1)
+	stored, _ := repo.FindAPITokenByHash(hashToken(expired))
+	past := time.Now().Add(-time.Minute)
+	stored.ExpiresAt = &past
+	repo.CreateAPIToken(stored)
+	if _, err := svc.ResolveAPIToken(expired); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Fatalf("expired token: got %v, want %v", err, auth.ErrInvalidToken)
+	}
+}
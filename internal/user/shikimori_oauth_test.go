@@ -0,0 +1,249 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/pkg/mailer"
+	"github.com/Zipklas/anime-site-backend/pkg/secret"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func testTokenBox(t *testing.T) *secret.Box {
+	t.Helper()
+	box, err := secret.NewBox(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return box
+}
+
+// fakeShikimoriOAuth отвечает как /oauth/token и /api/users/whoami Shikimori.
+// Код "code-<id>" выдает токены пользователя Shikimori с этим id.
+type fakeShikimoriOAuth struct {
+	*httptest.Server
+	// access-токен -> пользователь Shikimori
+	profiles map[string]shikimori.Profile
+	// refresh-токен -> новая пара токенов
+	refreshes map[string]shikimori.OAuthToken
+}
+
+func newFakeShikimoriOAuth(t *testing.T) *fakeShikimoriOAuth {
+	f := &fakeShikimoriOAuth{
+		profiles: map[string]shikimori.Profile{
+			"access-victim":   {ID: 100, Nickname: "victim"},
+			"access-attacker": {ID: 200, Nickname: "attacker"},
+		},
+		refreshes: map[string]shikimori.OAuthToken{
+			"refresh-victim": {AccessToken: "access-victim-2", RefreshToken: "refresh-victim-2", ExpiresIn: 86400},
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("client_id") != "client" || r.FormValue("client_secret") != "secret" {
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+		var token shikimori.OAuthToken
+		switch r.FormValue("grant_type") {
+		case "authorization_code":
+			name, ok := strings.CutPrefix(r.FormValue("code"), "code-")
+			if !ok {
+				http.Error(w, "invalid_grant", http.StatusBadRequest)
+				return
+			}
+			token = shikimori.OAuthToken{AccessToken: "access-" + name, RefreshToken: "refresh-" + name, ExpiresIn: 86400}
+		case "refresh_token":
+			refreshed, ok := f.refreshes[r.FormValue("refresh_token")]
+			if !ok {
+				http.Error(w, "invalid_grant", http.StatusBadRequest)
+				return
+			}
+			token = refreshed
+		}
+		token.CreatedAt = time.Now().Unix()
+		json.NewEncoder(w).Encode(token)
+	})
+	mux.HandleFunc("/api/users/whoami", func(w http.ResponseWriter, r *http.Request) {
+		profile, ok := f.profiles[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(profile)
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newOAuthTestService(t *testing.T, repo *fakeRepository) *service {
+	t.Helper()
+	svc := newTestService(t, repo, mailer.NewMemoryMailer())
+	fake := newFakeShikimoriOAuth(t)
+	svc.shikimoriOAuth = shikimori.NewOAuthClient(shikimori.OAuthConfig{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  testBaseURL + "/auth/shikimori/callback",
+		BaseURL:      fake.URL,
+	})
+	return svc
+}
+
+// authorize начинает OAuth и возвращает state из ссылки и nonce для cookie.
+func authorize(t *testing.T, svc *service, linkUserID string) (string, string) {
+	t.Helper()
+	authURL, nonce, err := svc.ShikimoriAuthURL(linkUserID)
+	if err != nil {
+		t.Fatalf("ShikimoriAuthURL: %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Query().Get("state"), nonce
+}
+
+func TestShikimoriCallbackCreatesUserWithEncryptedTokens(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newOAuthTestService(t, repo)
+	ctx := context.Background()
+
+	state, nonce := authorize(t, svc, "")
+	result, err := svc.ShikimoriCallback(ctx, "code-victim", state, nonce, ClientInfo{})
+	if err != nil {
+		t.Fatalf("ShikimoriCallback: %v", err)
+	}
+	if result.Login == nil || result.Login.AccessToken == "" {
+		t.Fatalf("callback did not log in: %+v", result)
+	}
+
+	account, err := repo.FindShikimoriAccountByShikimoriID(100)
+	if err != nil {
+		t.Fatalf("account is not saved: %v", err)
+	}
+	for name, value := range map[string]string{"access": account.AccessToken, "refresh": account.RefreshToken} {
+		if !secret.IsSealed(value) || strings.Contains(value, "victim") {
+			t.Errorf("%s token is stored in plaintext: %q", name, value)
+		}
+	}
+	token, err := svc.ShikimoriAccessToken(ctx, account.UserID)
+	if err != nil || token != "access-victim" {
+		t.Fatalf("ShikimoriAccessToken = %q, %v", token, err)
+	}
+}
+
+func TestShikimoriCallbackRequiresBrowserNonce(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newOAuthTestService(t, repo)
+	ctx := context.Background()
+
+	attacker := &User{ID: uuid.New(), Email: "attacker@example.com", Role: auth.RoleUser}
+	if err := repo.Create(attacker); err != nil {
+		t.Fatal(err)
+	}
+	// Атакующий начинает привязку и подсовывает ссылку жертве; у браузера
+	// жертвы своя cookie от собственного входа или нет никакой.
+	attackerState, attackerNonce := authorize(t, svc, attacker.ID.String())
+	_, victimNonce := authorize(t, svc, "")
+
+	for name, nonce := range map[string]string{"no cookie": "", "victim cookie": victimNonce} {
+		_, err := svc.ShikimoriCallback(ctx, "code-victim", attackerState, nonce, ClientInfo{})
+		if !errors.Is(err, ErrInvalidActionToken) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidActionToken)
+		}
+	}
+	if _, err := repo.FindShikimoriAccount(attacker.ID); err == nil {
+		t.Fatal("victim's Shikimori account linked to the attacker")
+	}
+
+	result, err := svc.ShikimoriCallback(ctx, "code-attacker", attackerState, attackerNonce, ClientInfo{})
+	if err != nil || !result.Linked {
+		t.Fatalf("link from the same browser: %+v, %v", result, err)
+	}
+	account, err := repo.FindShikimoriAccount(attacker.ID)
+	if err != nil || account.ShikimoriUserID != 200 {
+		t.Fatalf("linked account = %+v, %v", account, err)
+	}
+}
+
+func TestShikimoriAccessTokenRefreshesExpiredToken(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newOAuthTestService(t, repo)
+	ctx := context.Background()
+
+	state, nonce := authorize(t, svc, "")
+	if _, err := svc.ShikimoriCallback(ctx, "code-victim", state, nonce, ClientInfo{}); err != nil {
+		t.Fatalf("ShikimoriCallback: %v", err)
+	}
+	account, _ := repo.FindShikimoriAccountByShikimoriID(100)
+	account.TokenExpiresAt = time.Now().Add(-time.Hour)
+	repo.SaveShikimoriAccount(account)
+
+	token, err := svc.ShikimoriAccessToken(ctx, account.UserID)
+	if err != nil || token != "access-victim-2" {
+		t.Fatalf("ShikimoriAccessToken = %q, %v", token, err)
+	}
+	account, _ = repo.FindShikimoriAccount(account.UserID)
+	if time.Until(account.TokenExpiresAt) < time.Hour {
+		t.Fatalf("expiry is not updated: %v", account.TokenExpiresAt)
+	}
+	refresh, err := svc.tokenBox.Open(account.RefreshToken)
+	if err != nil || refresh != "refresh-victim-2" {
+		t.Fatalf("stored refresh token = %q, %v", refresh, err)
+	}
+}
+
+func TestShikimoriLoginSetsNonceCookie(t *testing.T) {
+	repo := newFakeRepository()
+	svc := newOAuthTestService(t, repo)
+	e := echo.New()
+	h := NewHandler(svc)
+	e.GET("/auth/shikimori", h.ShikimoriLogin)
+	e.GET("/auth/shikimori/callback", h.ShikimoriCallback)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/shikimori", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != shikimoriNonceCookie {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+	cookie := cookies[0]
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/auth/shikimori" || cookie.Value == "" {
+		t.Fatalf("nonce cookie = %+v", cookie)
+	}
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	state := location.Query().Get("state")
+
+	callback := "/auth/shikimori/callback?" + url.Values{"code": {"code-victim"}, "state": {state}}.Encode()
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, callback, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback without cookie: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback with cookie: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if cleared := rec.Result().Cookies(); len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+		t.Fatalf("nonce cookie is not cleared: %+v", cleared)
+	}
+}
@@ -4,7 +4,16 @@ import (
 	"log"
 	"os"
 
+	"github.com/Zipklas/anime-site-backend/internal/collection"
 	"github.com/Zipklas/anime-site-backend/internal/comment"
+	"github.com/Zipklas/anime-site-backend/internal/listimport"
+	"github.com/Zipklas/anime-site-backend/internal/lockout"
+	"github.com/Zipklas/anime-site-backend/internal/notification"
+	"github.com/Zipklas/anime-site-backend/internal/rating"
+	"github.com/Zipklas/anime-site-backend/internal/recommendation"
+	"github.com/Zipklas/anime-site-backend/internal/review"
+	"github.com/Zipklas/anime-site-backend/internal/schedule"
+	"github.com/Zipklas/anime-site-backend/internal/shikisync"
 	"github.com/Zipklas/anime-site-backend/internal/user"
 
 	"gorm.io/driver/postgres"
@@ -18,7 +27,26 @@ func InitPostgres() *gorm.DB {
 		log.Fatal("Failed to connect:", err)
 	}
 
-	_ = db.AutoMigrate(&user.User{})
+	_ = db.AutoMigrate(&user.User{}, &user.Session{}, &user.RefreshToken{}, &user.ActionToken{}, &user.RecoveryCode{}, &user.Setting{}, &user.ShikimoriAccount{}, &user.APIToken{})
+	_ = db.AutoMigrate(&user.AnimeEntry{}, &user.EpisodeProgress{})
+	if err := user.MigrateLegacyAnimeLists(db); err != nil {
+		log.Fatal("Failed to migrate anime lists:", err)
+	}
+	if err := user.BackfillFavoritePositions(db); err != nil {
+		log.Fatal("Failed to number favorites:", err)
+	}
+	_ = db.AutoMigrate(&rating.Score{})
+	if err := rating.Backfill(db); err != nil {
+		log.Fatal("Failed to build community scores:", err)
+	}
 	_ = db.AutoMigrate(&comment.Comment{}, &comment.CommentVote{})
+	_ = db.AutoMigrate(&lockout.LoginAttempt{})
+	_ = db.AutoMigrate(&listimport.Job{})
+	_ = db.AutoMigrate(&shikisync.State{}, &shikisync.RateLink{})
+	_ = db.AutoMigrate(&collection.Collection{}, &collection.Item{}, &collection.Like{})
+	_ = db.AutoMigrate(&review.Review{}, &review.Vote{})
+	_ = db.AutoMigrate(&notification.Notification{}, &notification.Mute{}, &notification.EpisodeSource{})
+	_ = db.AutoMigrate(&schedule.Feed{})
+	_ = db.AutoMigrate(&recommendation.Similarity{})
 	return db
 }
@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNewFromEnvRequiresMailInProduction(t *testing.T) {
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("MAIL_DIR", "")
+
+	t.Setenv("APP_ENV", "production")
+	if _, err := NewFromEnv(); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("production without SMTP_HOST: got %v, want %v", err, ErrNotConfigured)
+	}
+
+	t.Setenv("APP_ENV", "")
+	m, err := NewFromEnv()
+	if err != nil {
+		t.Fatalf("development without SMTP_HOST: %v", err)
+	}
+	if _, ok := m.(*MemoryMailer); !ok {
+		t.Fatalf("development fallback is %T, want *MemoryMailer", m)
+	}
+
+	t.Setenv("MAIL_DIR", t.TempDir())
+	t.Setenv("APP_ENV", "production")
+	if m, err := NewFromEnv(); err != nil || m == nil {
+		t.Fatalf("production with MAIL_DIR: mailer %T, err %v", m, err)
+	}
+}
+
+func TestMemoryMailerKeepsLastMessages(t *testing.T) {
+	m := NewMemoryMailer()
+	for i := 0; i < memoryMailerLimit+10; i++ {
+		if err := m.Send(context.Background(), Message{To: fmt.Sprintf("user%d@example.com", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := m.Messages()
+	if len(messages) != memoryMailerLimit {
+		t.Fatalf("kept %d messages, want %d", len(messages), memoryMailerLimit)
+	}
+	if messages[0].To != "user10@example.com" {
+		t.Fatalf("oldest kept message is for %s, want user10@example.com", messages[0].To)
+	}
+}
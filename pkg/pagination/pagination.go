@@ -0,0 +1,33 @@
+// Package pagination разбирает параметры page и limit списковых запросов.
+package pagination
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// FromQuery возвращает page (с 1) и limit из query. Некорректные значения и
+// limit больше maxLimit заменяются на 1 и defaultLimit. page ограничен так,
+// чтобы смещение (page-1)*limit помещалось в int32 и не переполнялось в БД.
+func FromQuery(c echo.Context, defaultLimit, maxLimit int) (page, limit int) {
+	page, limit = 1, Limit(c, defaultLimit, maxLimit)
+	if p, err := strconv.Atoi(c.QueryParam("page")); err == nil && p > 0 {
+		page = min(p, MaxPage(maxLimit))
+	}
+	return page, limit
+}
+
+// Limit возвращает limit из query для списков без страниц.
+func Limit(c echo.Context, defaultLimit, maxLimit int) int {
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= maxLimit {
+		return l
+	}
+	return defaultLimit
+}
+
+// MaxPage - последняя допустимая страница при limit до maxLimit.
+func MaxPage(maxLimit int) int {
+	return math.MaxInt32 / maxLimit
+}
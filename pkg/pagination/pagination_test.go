@@ -0,0 +1,44 @@
+package pagination
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestFromQuery(t *testing.T) {
+	e := echo.New()
+	for query, want := range map[string][2]int{
+		"":                           {1, 20},
+		"?page=3&limit=50":           {3, 50},
+		"?page=0&limit=0":            {1, 20},
+		"?page=-2&limit=-1":          {1, 20},
+		"?page=x&limit=y":            {1, 20},
+		"?limit=101":                 {1, 20},
+		"?limit=100":                 {1, 100},
+		"?page=92233720368547759":    {MaxPage(100), 20},
+		"?page=99999999999999999999": {1, 20},
+	} {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/"+query, nil), httptest.NewRecorder())
+		page, limit := FromQuery(c, 20, 100)
+		if page != want[0] || limit != want[1] {
+			t.Errorf("%q: page %d, limit %d, want %v", query, page, limit, want)
+		}
+		if offset := (page - 1) * 100; offset < 0 || offset > math.MaxInt32 {
+			t.Errorf("%q: offset %d does not fit int32", query, offset)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	e := echo.New()
+	for query, want := range map[string]int{"": 10, "?limit=30": 30, "?limit=31": 10, "?limit=0": 10} {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/"+query, nil), httptest.NewRecorder())
+		if got := Limit(c, 10, 30); got != want {
+			t.Errorf("%q: limit %d, want %d", query, got, want)
+		}
+	}
+}
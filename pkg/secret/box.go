@@ -0,0 +1,100 @@
+// Package secret шифрует чувствительные значения перед записью в БД
+// (AES-256-GCM со случайным nonce на каждое значение).
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// prefix отличает зашифрованные значения от старых открытых и оставляет
+// место для смены алгоритма или ключа.
+const prefix = "enc:v1:"
+
+var ErrMalformed = errors.New("malformed encrypted value")
+
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox создает Box с 32-байтовым ключом.
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// NewFromEnv берет ключ из TOKEN_ENCRYPTION_KEY (32 байта в base64). Если он
+// не задан, ключ выводится из fallback (JWT_SECRET): тогда смена JWT_SECRET
+// сделает сохраненные значения нечитаемыми.
+func NewFromEnv(fallback string) (*Box, error) {
+	if encoded := os.Getenv("TOKEN_ENCRYPTION_KEY"); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("TOKEN_ENCRYPTION_KEY is not valid base64: %w", err)
+		}
+		return NewBox(key)
+	}
+	if fallback == "" {
+		return nil, errors.New("TOKEN_ENCRYPTION_KEY is not set")
+	}
+	log.Println("TOKEN_ENCRYPTION_KEY is not set, deriving the token encryption key from JWT_SECRET")
+	key := sha256.Sum256([]byte("anime-site token encryption\x00" + fallback))
+	return NewBox(key[:])
+}
+
+// Seal шифрует value. Пустая строка остается пустой.
+func (b *Box) Seal(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), nil)
+	return prefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open расшифровывает результат Seal.
+func (b *Box) Open(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	encoded, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return "", ErrMalformed
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", ErrMalformed
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plain, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrMalformed
+	}
+	return string(plain), nil
+}
+
+// IsSealed сообщает, зашифровано ли значение (для миграции старых записей).
+func IsSealed(value string) bool {
+	return value == "" || strings.HasPrefix(value, prefix)
+}
@@ -0,0 +1,58 @@
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	box, err := NewBox(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := box.Seal("access-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "access-token") || !IsSealed(sealed) {
+		t.Fatalf("value is not encrypted: %q", sealed)
+	}
+	again, _ := box.Seal("access-token")
+	if again == sealed {
+		t.Fatal("same value sealed twice gives the same ciphertext")
+	}
+
+	plain, err := box.Open(sealed)
+	if err != nil || plain != "access-token" {
+		t.Fatalf("Open = %q, %v", plain, err)
+	}
+
+	other, _ := NewBox(bytes.Repeat([]byte{2}, 32))
+	if _, err := other.Open(sealed); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("Open with another key: got %v, want %v", err, ErrMalformed)
+	}
+	if _, err := box.Open("access-token"); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("Open of a plaintext value: got %v, want %v", err, ErrMalformed)
+	}
+	if sealed, _ := box.Seal(""); sealed != "" {
+		t.Fatalf("empty value sealed to %q", sealed)
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	t.Setenv("TOKEN_ENCRYPTION_KEY", "")
+	if _, err := NewFromEnv(""); err == nil {
+		t.Fatal("no key and no fallback must fail")
+	}
+	if _, err := NewFromEnv("jwt-secret"); err != nil {
+		t.Fatalf("fallback key: %v", err)
+	}
+
+	t.Setenv("TOKEN_ENCRYPTION_KEY", "c2hvcnQ=")
+	if _, err := NewFromEnv("jwt-secret"); err == nil {
+		t.Fatal("short key must fail")
+	}
+}
@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Секрет из RFC 6238, Appendix B (SHA1): ASCII "12345678901234567890".
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// В RFC коды из 8 цифр, у нас 6 - сравниваем последние шесть.
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		got, err := Code(rfcSecret, Counter(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatalf("%d: %v", tc.unix, err)
+		}
+		if want := tc.code[len(tc.code)-Digits:]; got != want {
+			t.Errorf("%d: code %s, want %s", tc.unix, got, want)
+		}
+	}
+}
+
+func TestValidateSkewWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Counter(now)
+
+	for shift := int64(-3); shift <= 3; shift++ {
+		code, err := Code(rfcSecret, current+shift)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counter, ok := Validate(rfcSecret, code, now)
+		if want := shift >= -skew && shift <= skew; ok != want {
+			t.Errorf("step %+d: valid = %v, want %v", shift, ok, want)
+		}
+		if ok && counter != current+shift {
+			t.Errorf("step %+d: counter %d, want %d", shift, counter, current+shift)
+		}
+	}
+
+	code, _ := Code(rfcSecret, current)
+	if _, ok := Validate(rfcSecret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("code with a space is rejected")
+	}
+	if _, ok := Validate(rfcSecret, code[1:], now); ok {
+		t.Error("code of a wrong length is accepted")
+	}
+}